@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Modes of accepting a joining cluster, selected by accept_mode
+const (
+	// acceptModeCSR approves the agent CSR and sets hubAcceptsClient through
+	// the hub API
+	acceptModeCSR = "csr"
+	// acceptModeClusteradm runs clusteradm accept, which sets
+	// hubAcceptsClient on the ManagedCluster, once the CSR is approved
+	acceptModeClusteradm = "clusteradm"
+)
+
+// defaultApprovalTimeout bounds how long an onboarding waits for an operator
+const defaultApprovalTimeout = 24 * time.Hour
+
+// approvalStep pauses an onboarding until an operator approves the cluster
+var approvalStep = pipelineStep{"await-approval", "Cluster approved by an operator", StateAwaitingApproval}
+
+// approvalGate holds the onboardings waiting for manual approval
+type approvalGate struct {
+	pending map[string]chan string
+	mutex   sync.Mutex
+}
+
+func newApprovalGate() *approvalGate {
+	return &approvalGate{pending: make(map[string]chan string)}
+}
+
+// wait blocks until the cluster is approved or ctx is done and returns the
+// approver
+func (g *approvalGate) wait(ctx context.Context, clusterName string) (string, error) {
+	approved := make(chan string, 1)
+	g.mutex.Lock()
+	g.pending[clusterName] = approved
+	g.mutex.Unlock()
+	defer func() {
+		g.mutex.Lock()
+		if g.pending[clusterName] == approved {
+			delete(g.pending, clusterName)
+		}
+		g.mutex.Unlock()
+	}()
+
+	select {
+	case approver := <-approved:
+		return approver, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// approve releases the onboarding waiting for the cluster, reporting whether
+// there was one
+func (g *approvalGate) approve(clusterName, approver string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	approved, ok := g.pending[clusterName]
+	if !ok {
+		return false
+	}
+	delete(g.pending, clusterName)
+	approved <- approver
+	return true
+}
+
+// acceptMode returns the configured accept_mode
+func (cp *ClusterOpsPlugin) acceptMode() string {
+	return cp.settings().AcceptMode
+}
+
+// awaitApproval waits for POST /clusters/:name/approve
+func (cp *ClusterOpsPlugin) awaitApproval(ctx context.Context, operationID, clusterName string) error {
+	cp.logStepEvent(operationID, clusterName, approvalStep.name, "info", fmt.Sprintf("Waiting for an operator to approve cluster %s", clusterName), 0)
+	approver, err := cp.approvals.wait(ctx, clusterName)
+	if err != nil {
+		return fmt.Errorf("cluster was not approved: %w", err)
+	}
+	cp.logStepEvent(operationID, clusterName, approvalStep.name, "info", fmt.Sprintf("Cluster %s approved by %s", clusterName, approver), 0)
+	return nil
+}
+
+// acceptCluster accepts a joining cluster once its agent's CSR has been
+// approved according to csr_approval_policy. With accept_mode clusteradm
+// the cluster is then accepted by clusteradm accept; otherwise, or when
+// clusteradm is not installed, hubAcceptsClient is set through the hub API.
+// clusteradm accept approves every pending CSR of the cluster, so it only
+// runs after the policy has approved one.
+func (cp *ClusterOpsPlugin) acceptCluster(ctx context.Context, clusterName string) error {
+	if err := cp.approveClusterCSR(ctx, clusterName); err != nil {
+		return err
+	}
+	if cp.acceptMode() == acceptModeClusteradm {
+		if _, err := cp.runner.LookPath("clusteradm"); err == nil {
+			_, err := cp.clusteradmHub(ctx, "accept", "--clusters", clusterName, "--wait")
+			return err
+		}
+	}
+	_, err := cp.patchManagedCluster(ctx, clusterName, map[string]interface{}{
+		"spec": map[string]interface{}{"hubAcceptsClient": true},
+	})
+	return err
+}
+
+// clusteradmHub runs clusteradm against the hub selected in ctx
+func (cp *ClusterOpsPlugin) clusteradmHub(ctx context.Context, args ...string) (out []byte, err error) {
+	ctx, span := startChildSpan(ctx, "clusteradm "+args[0], spanKindClient)
+	span.SetAttribute("clusteradm.args", strings.Join(args, " "))
+	defer func() { span.End(err) }()
+
+	out, err = cp.hub.Clusteradm(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("clusteradm %s failed: %v", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+func (cp *ClusterOpsPlugin) ApproveClusterHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	record, ok := cp.clusters.Get(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse(codeClusterNotFound, "Cluster not found", nil))
+		return
+	}
+	approver := cp.requestActor(c)
+	if record.State != StateAwaitingApproval || !cp.approvals.approve(name, approver) {
+		c.JSON(http.StatusConflict, errorResponse(codeConflict, fmt.Sprintf("Cluster %s is not awaiting approval", name), fmt.Sprintf("Cluster is in state %s", record.State)))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     fmt.Sprintf("Cluster %s approved", name),
+		"clusterName": name,
+		"approvedBy":  approver,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
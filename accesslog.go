@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessLogged writes one structured access log line per request. Failed
+// requests are always logged; successful ones are sampled according to
+// access_log_sample_percent.
+func (cp *ClusterOpsPlugin) accessLogged(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		handler(c)
+
+		settings := cp.settings()
+		if !settings.AccessLog {
+			return
+		}
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest && rand.Intn(100) >= settings.AccessLogSamplePercent {
+			return
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latencyMs", time.Since(start).Milliseconds(),
+			"actor", cp.requestActor(c),
+			"sourceIp", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		}
+		if route := c.FullPath(); route != "" {
+			attrs = append(attrs, "route", route)
+		}
+		if id := c.GetString("operationId"); id != "" {
+			attrs = append(attrs, "operationId", id)
+		}
+		cp.logger.Log(c.Request.Context(), level, "HTTP request", attrs...)
+	}
+}
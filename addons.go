@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// addonInstallNamespace is where addon agents are installed on the spoke
+const addonInstallNamespace = "open-cluster-management-agent-addon"
+
+// supportedAddons lists the OCM addons that can be enabled through the
+// plugin, keyed by the name requested by users and mapped to the name of
+// the ClusterManagementAddOn registered on the hub
+var supportedAddons = map[string]string{
+	"application-manager":    "application-manager",
+	"governance-policy":      "governance-policy-framework",
+	"cluster-proxy":          "cluster-proxy",
+	"managed-serviceaccount": "managed-serviceaccount",
+}
+
+// AddonStatus is the readiness of an addon on a managed cluster
+type AddonStatus struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Degraded  bool   `json:"degraded"`
+	Message   string `json:"message,omitempty"`
+}
+
+// AddonsRequest is the payload of POST /clusters/:name/addons
+type AddonsRequest struct {
+	Addons []string `json:"addons"`
+}
+
+// validateAddons checks that every requested addon is supported
+func validateAddons(addons []string) []string {
+	var problems []string
+	for _, addon := range addons {
+		if _, ok := supportedAddons[addon]; !ok {
+			problems = append(problems, fmt.Sprintf("unsupported addon %q", addon))
+		}
+	}
+	return problems
+}
+
+// supportedAddonNames returns the names accepted in addon requests
+func supportedAddonNames() []string {
+	names := make([]string, 0, len(supportedAddons))
+	for name := range supportedAddons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enableAddon creates the ManagedClusterAddOn of an addon in the cluster namespace
+func (cp *ClusterOpsPlugin) enableAddon(ctx context.Context, clusterName, addon string) error {
+	return cp.applyHubObject(ctx, map[string]interface{}{
+		"apiVersion": "addon.open-cluster-management.io/v1alpha1",
+		"kind":       "ManagedClusterAddOn",
+		"metadata": map[string]interface{}{
+			"name":      supportedAddons[addon],
+			"namespace": clusterName,
+		},
+		"spec": map[string]interface{}{
+			"installNamespace": addonInstallNamespace,
+		},
+	})
+}
+
+// disableAddon deletes the ManagedClusterAddOn of an addon from the cluster
+// namespace, which uninstalls its agent from the spoke
+func (cp *ClusterOpsPlugin) disableAddon(ctx context.Context, clusterName, addon string) error {
+	_, err := cp.kubectlHub(ctx, "delete", "managedclusteraddon", supportedAddons[addon], "-n", clusterName, "--ignore-not-found")
+	return err
+}
+
+// listAddonStatuses returns the readiness of every addon of a cluster
+func (cp *ClusterOpsPlugin) listAddonStatuses(ctx context.Context, clusterName string) ([]AddonStatus, error) {
+	out, err := cp.kubectlHub(ctx, "get", "managedclusteraddons", "-n", clusterName, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []clusterCondition `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode ManagedClusterAddOn list: %v", err)
+	}
+
+	statuses := make([]AddonStatus, 0, len(list.Items))
+	for _, item := range list.Items {
+		status := AddonStatus{Name: item.Metadata.Name}
+		for _, condition := range item.Status.Conditions {
+			switch condition.Type {
+			case "Available":
+				status.Available = condition.Status == "True"
+				status.Message = condition.Message
+			case "Degraded":
+				status.Degraded = condition.Status == "True"
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (cp *ClusterOpsPlugin) EnableClusterAddonsHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	var req AddonsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if len(req.Addons) == 0 {
+		c.JSON(http.StatusBadRequest, withFields(errorResponse(codeInvalidRequest, "Missing required field: addons", nil), gin.H{
+			"supported": supportedAddonNames(),
+		}))
+		return
+	}
+	if problems := validateAddons(req.Addons); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, withFields(errorResponse(codeInvalidRequest, "Invalid addons", problems), gin.H{
+			"supported": supportedAddonNames(),
+		}))
+		return
+	}
+
+	results := make([]gin.H, 0, len(req.Addons))
+	var enabled []string
+	for _, addon := range req.Addons {
+		if err := cp.enableAddon(c.Request.Context(), name, addon); err != nil {
+			cp.logEvent(name, "addons", "failed", fmt.Sprintf("Failed to enable addon %s: %v", addon, err))
+			results = append(results, gin.H{"addon": addon, "enabled": false, "error": err.Error()})
+			continue
+		}
+		cp.logEvent(name, "addons", "success", fmt.Sprintf("Addon %s enabled", addon))
+		results = append(results, gin.H{"addon": addon, "enabled": true})
+		enabled = append(enabled, addon)
+	}
+
+	cp.clusters.Update(name, func(record *ClusterRecord) {
+		record.Addons = mergeAddons(record.Addons, enabled)
+	})
+
+	status := http.StatusOK
+	if len(enabled) < len(req.Addons) {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"clusterName": name,
+		"results":     results,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) GetClusterAddonsHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	statuses, err := cp.listAddonStatuses(c.Request.Context(), name)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to read addons from the hub", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName": name,
+		"addons":      statuses,
+		"count":       len(statuses),
+		"plugin":      "cluster-ops-plugin",
+	})
+}
+
+// mergeAddons returns the sorted union of two addon lists
+func mergeAddons(existing, added []string) []string {
+	merged := append([]string{}, existing...)
+	for _, addon := range added {
+		if !slices.Contains(merged, addon) {
+			merged = append(merged, addon)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// aksAPIVersion is the Microsoft.ContainerService API version the plugin calls
+const aksAPIVersion = "2024-02-01"
+
+// Kubeconfigs an AKS cluster hands out
+const (
+	aksAdminCredential = "admin"
+	aksUserCredential  = "user"
+)
+
+// aksClusterName matches the names AKS accepts for clusters
+var aksClusterName = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?$`)
+
+// AKSClusterRef identifies an AKS cluster to import. Subscription defaults
+// to AZURE_SUBSCRIPTION_ID and Name to the clusterName of the request.
+// Credential selects the admin kubeconfig, the default, or the user one;
+// on clusters with Microsoft Entra ID integration the user kubeconfig runs
+// kubelogin, which must then be listed in exec_allowed_commands.
+type AKSClusterRef struct {
+	Subscription  string `json:"subscription,omitempty"`
+	ResourceGroup string `json:"resourceGroup"`
+	Name          string `json:"name,omitempty"`
+	Credential    string `json:"credential,omitempty"`
+}
+
+func (ref *AKSClusterRef) validate() error {
+	if ref.Subscription == "" {
+		return fmt.Errorf("aks requires a subscription when AZURE_SUBSCRIPTION_ID is not set")
+	}
+	if ref.ResourceGroup == "" {
+		return fmt.Errorf("aks requires a resourceGroup")
+	}
+	if !aksClusterName.MatchString(ref.Name) {
+		return fmt.Errorf("invalid AKS cluster name %q", ref.Name)
+	}
+	switch ref.Credential {
+	case aksAdminCredential, aksUserCredential:
+	default:
+		return fmt.Errorf("unsupported credential %q, expected admin or user", ref.Credential)
+	}
+	return nil
+}
+
+// ambientAzureSubscription returns the subscription configured in the
+// environment
+func ambientAzureSubscription() string {
+	return os.Getenv("AZURE_SUBSCRIPTION_ID")
+}
+
+// aksKubeconfig fetches the admin or user kubeconfig of an AKS cluster
+// through Azure Resource Manager with the plugin's ambient credentials
+func aksKubeconfig(ctx context.Context, ref AKSClusterRef) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudImportTimeout)
+	defer cancel()
+
+	token, err := azureToken(ctx, azureManagementResource)
+	if err != nil {
+		return "", err
+	}
+
+	action := "listClusterAdminCredential"
+	if ref.Credential == aksUserCredential {
+		action = "listClusterUserCredential"
+	}
+	endpoint := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ContainerService/managedClusters/%s/%s?api-version=%s",
+		azureManagementResource, url.PathEscape(ref.Subscription), url.PathEscape(ref.ResourceGroup), url.PathEscape(ref.Name), action, aksAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Kubeconfigs []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"kubeconfigs"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return "", fmt.Errorf("failed to get %s credentials of AKS cluster %s: %v", ref.Credential, ref.Name, err)
+	}
+	if len(resp.Kubeconfigs) == 0 {
+		return "", fmt.Errorf("AKS cluster %s returned no %s kubeconfig", ref.Name, ref.Credential)
+	}
+	kubeconfig, err := base64.StdEncoding.DecodeString(resp.Kubeconfigs[0].Value)
+	if err != nil {
+		return "", fmt.Errorf("AKS cluster %s returned an invalid kubeconfig: %v", ref.Name, err)
+	}
+	return string(kubeconfig), nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in the code field of every error response and in the
+// errorCode field of failed operations. Codes are part of the API: clients
+// branch on them, so existing codes must never be renamed or reused.
+const (
+	codeInvalidRequest        = "INVALID_REQUEST"
+	codeKubeconfigInvalid     = "KUBECONFIG_INVALID"
+	codeUnauthenticated       = "UNAUTHENTICATED"
+	codeForbidden             = "FORBIDDEN"
+	codeNotFound              = "NOT_FOUND"
+	codeClusterNotFound       = "CLUSTER_NOT_FOUND"
+	codeOperationNotFound     = "OPERATION_NOT_FOUND"
+	codeConflict              = "CONFLICT"
+	codePayloadTooLarge       = "PAYLOAD_TOO_LARGE"
+	codeRateLimited           = "RATE_LIMITED"
+	codeInternal              = "INTERNAL"
+	codeHubUnreachable        = "HUB_UNREACHABLE"
+	codeKubeconfigUnavailable = "KUBECONFIG_UNAVAILABLE"
+	codeClusterUnreachable    = "CLUSTER_UNREACHABLE"
+	codeJoinFailed            = "JOIN_FAILED"
+	codeCSRTimeout            = "CSR_TIMEOUT"
+	codeApprovalTimeout       = "APPROVAL_TIMEOUT"
+	codeStepTimeout           = "STEP_TIMEOUT"
+	codeOperationInterrupted  = "OPERATION_INTERRUPTED"
+	codeOperationCancelled    = "OPERATION_CANCELLED"
+	codeOperationFailed       = "OPERATION_FAILED"
+)
+
+// errorCodeInfo documents an error code. Retryable codes describe transient
+// conditions: the same request may succeed when sent again later.
+type errorCodeInfo struct {
+	description string
+	retryable   bool
+}
+
+// errorCatalog is the documented list of error codes, published in the
+// OpenAPI Error schema
+var errorCatalog = map[string]errorCodeInfo{
+	codeInvalidRequest:        {"The request is malformed or has invalid fields", false},
+	codeKubeconfigInvalid:     {"The kubeconfig cannot be decoded or has no usable context", false},
+	codeUnauthenticated:       {"No valid credentials were presented", false},
+	codeForbidden:             {"The caller lacks the permission the endpoint requires", false},
+	codeNotFound:              {"The requested resource does not exist", false},
+	codeClusterNotFound:       {"The cluster is not tracked by the plugin", false},
+	codeOperationNotFound:     {"The operation does not exist", false},
+	codeConflict:              {"The resource is not in a state that allows the request", false},
+	codePayloadTooLarge:       {"The request body exceeds the configured limit", false},
+	codeRateLimited:           {"Too many mutating requests; retry after the advertised delay", true},
+	codeInternal:              {"The plugin failed unexpectedly", false},
+	codeHubUnreachable:        {"The hub could not be reached or rejected the command", true},
+	codeKubeconfigUnavailable: {"The kubeconfig could not be fetched from its source", true},
+	codeClusterUnreachable:    {"The cluster could not be reached with its kubeconfig", true},
+	codeJoinFailed:            {"The join command failed on the cluster", true},
+	codeCSRTimeout:            {"No certificate signing request of the cluster was approved in time", true},
+	codeApprovalTimeout:       {"The onboarding was not approved by an operator", false},
+	codeStepTimeout:           {"A pipeline step did not finish within its timeout", true},
+	codeOperationInterrupted:  {"The replica running the operation stopped", true},
+	codeOperationCancelled:    {"The operation was cancelled", false},
+	codeOperationFailed:       {"A pipeline step failed", false},
+}
+
+// errorResponse builds the error envelope returned by every handler. The error
+// field repeats the message for clients written before codes existed.
+func errorResponse(code, message string, details interface{}) gin.H {
+	body := gin.H{
+		"code":      code,
+		"message":   message,
+		"retryable": errorCatalog[code].retryable,
+		"error":     message,
+	}
+	if details != nil {
+		body["details"] = details
+	}
+	return body
+}
+
+// withFields adds endpoint specific fields to an error envelope
+func withFields(body gin.H, fields gin.H) gin.H {
+	for key, value := range fields {
+		body[key] = value
+	}
+	return body
+}
+
+// operationErrorCode classifies the error that ended an operation from the
+// step it failed in
+func operationErrorCode(step string, err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codeOperationCancelled
+	case step == "csr":
+		return codeCSRTimeout
+	case step == approvalStep.name:
+		return codeApprovalTimeout
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeStepTimeout
+	case step == "validate":
+		return codeClusterUnreachable
+	case step == "join":
+		return codeJoinFailed
+	}
+	return codeOperationFailed
+}
+
+// errorCatalogDescription renders the catalog as a markdown list for the
+// OpenAPI document
+func errorCatalogDescription() (string, []string) {
+	codes := make([]string, 0, len(errorCatalog))
+	for code := range errorCatalog {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	var b strings.Builder
+	b.WriteString("Error codes:\n\n")
+	for _, code := range codes {
+		info := errorCatalog[code]
+		retry := ""
+		if info.retryable {
+			retry = " (retryable)"
+		}
+		fmt.Fprintf(&b, "- `%s`: %s%s\n", code, info.description, retry)
+	}
+	return b.String(), codes
+}
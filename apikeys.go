@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API key permissions
+const (
+	apiKeyReadOnly  = "read-only"
+	apiKeyReadWrite = "read-write"
+)
+
+// apiKeySecretRefresh is how often keys are reloaded from the hub Secret so
+// rotated keys take effect without restarting the plugin
+const apiKeySecretRefresh = time.Minute
+
+// apiKeySecretField is the Secret data field holding the key list
+const apiKeySecretField = "keys"
+
+// apiKeyConfig is a key as configured in api_keys or the hub Secret
+type apiKeyConfig struct {
+	Name       string `json:"name"`
+	Key        string `json:"key"`
+	Permission string `json:"permission"`
+}
+
+type apiKey struct {
+	name       string
+	hash       [32]byte
+	permission string
+	source     string
+}
+
+// APIKeyUsage reports a configured key and how often it was used
+type APIKeyUsage struct {
+	Name       string     `json:"name"`
+	Permission string     `json:"permission"`
+	Source     string     `json:"source"`
+	Requests   int64      `json:"requests"`
+	Rejected   int64      `json:"rejected"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// apiKeyStore holds the keys accepted in the X-API-Key header. Only hashes
+// of the keys are kept.
+type apiKeyStore struct {
+	keys  []apiKey
+	usage map[string]*APIKeyUsage
+	mutex sync.RWMutex
+}
+
+func newAPIKeyStore() *apiKeyStore {
+	return &apiKeyStore{usage: make(map[string]*APIKeyUsage)}
+}
+
+// parseAPIKeys validates a key list, normalising empty permissions to read-only
+func parseAPIKeys(entries []apiKeyConfig, source string) ([]apiKey, error) {
+	keys := make([]apiKey, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "" || entry.Key == "" {
+			return nil, fmt.Errorf("api keys need a name and a key")
+		}
+		switch entry.Permission {
+		case "":
+			entry.Permission = apiKeyReadOnly
+		case apiKeyReadOnly, apiKeyReadWrite:
+		default:
+			return nil, fmt.Errorf("api key %s has unsupported permission %q, expected %s or %s", entry.Name, entry.Permission, apiKeyReadOnly, apiKeyReadWrite)
+		}
+		keys = append(keys, apiKey{
+			name:       entry.Name,
+			hash:       sha256.Sum256([]byte(entry.Key)),
+			permission: entry.Permission,
+			source:     source,
+		})
+	}
+	return keys, nil
+}
+
+// apiKeysFromConfig decodes the api_keys configuration value
+func apiKeysFromConfig(config map[string]interface{}) ([]apiKey, error) {
+	raw, ok := config["api_keys"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var entries []apiKeyConfig
+	if err := json.Unmarshal(encoded, &entries); err != nil {
+		return nil, fmt.Errorf("invalid api_keys: %v", err)
+	}
+	return parseAPIKeys(entries, "config")
+}
+
+// Replace swaps the keys loaded from a source, keeping the others. Usage of
+// keys that are still configured is preserved.
+func (s *apiKeyStore) Replace(source string, keys []apiKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	retained := make([]apiKey, 0, len(s.keys)+len(keys))
+	for _, key := range s.keys {
+		if key.source != source {
+			retained = append(retained, key)
+		}
+	}
+	s.keys = append(retained, keys...)
+
+	usage := make(map[string]*APIKeyUsage, len(s.keys))
+	for _, key := range s.keys {
+		entry, ok := s.usage[key.name]
+		if !ok {
+			entry = &APIKeyUsage{}
+		}
+		entry.Name = key.name
+		entry.Permission = key.permission
+		entry.Source = key.source
+		usage[key.name] = entry
+	}
+	s.usage = usage
+}
+
+// Enabled reports whether any key is configured
+func (s *apiKeyStore) Enabled() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.keys) > 0
+}
+
+// Authenticate looks up a presented key and counts its use. It returns false
+// if the key is unknown.
+func (s *apiKeyStore) Authenticate(presented string) (apiKey, bool) {
+	hash := sha256.Sum256([]byte(presented))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare(key.hash[:], hash[:]) == 1 {
+			now := time.Now()
+			usage := s.usage[key.name]
+			usage.Requests++
+			usage.LastUsedAt = &now
+			return key, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// RecordRejected counts a request refused for lack of permission
+func (s *apiKeyStore) RecordRejected(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if usage, ok := s.usage[name]; ok {
+		usage.Rejected++
+	}
+}
+
+// Usage returns the usage of every configured key sorted by name
+func (s *apiKeyStore) Usage() []APIKeyUsage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	usage := make([]APIKeyUsage, 0, len(s.usage))
+	for _, entry := range s.usage {
+		usage = append(usage, *entry)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Name < usage[j].Name
+	})
+	return usage
+}
+
+// loadAPIKeySecret reads the key list from the hub Secret named in
+// api_keys_secret as namespace/name. Its "keys" field holds a JSON list of
+// {"name", "key", "permission"} objects.
+func (cp *ClusterOpsPlugin) loadAPIKeySecret(ctx context.Context, ref string) error {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("invalid api_keys_secret %q, expected namespace/name", ref)
+	}
+
+	out, err := cp.kubectlHub(ctx, "get", "secret", name, "-n", namespace, "-o", "json")
+	if err != nil {
+		return err
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(out, &secret); err != nil {
+		return fmt.Errorf("failed to decode Secret %s: %v", ref, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(secret.Data[apiKeySecretField])
+	if err != nil {
+		return fmt.Errorf("failed to decode field %s of Secret %s: %v", apiKeySecretField, ref, err)
+	}
+
+	var entries []apiKeyConfig
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid key list in Secret %s: %v", ref, err)
+	}
+	keys, err := parseAPIKeys(entries, "secret")
+	if err != nil {
+		return err
+	}
+	cp.apiKeys.Replace("secret", keys)
+	return nil
+}
+
+// refreshAPIKeys reloads keys from the hub Secret until ctx is cancelled
+func (cp *ClusterOpsPlugin) refreshAPIKeys(ctx context.Context, ref string) {
+	for {
+		if err := cp.loadAPIKeySecret(ctx, ref); err != nil && ctx.Err() == nil {
+			cp.logger.Warn("Failed to load API keys from the hub", "secret", ref, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(apiKeySecretRefresh):
+		}
+	}
+}
+
+// authenticateAPIKey checks the X-API-Key header of a request. Read-only keys
+// may only be used for GET requests.
+func (cp *ClusterOpsPlugin) authenticateAPIKey(c *gin.Context, presented string) bool {
+	key, ok := cp.apiKeys.Authenticate(presented)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse(codeUnauthenticated, "Unauthorized", "invalid API key"))
+		return false
+	}
+	if key.permission == apiKeyReadOnly && c.Request.Method != http.MethodGet {
+		cp.apiKeys.RecordRejected(key.name)
+		c.JSON(http.StatusForbidden, errorResponse(codeForbidden, "Forbidden", fmt.Sprintf("API key %s is read-only", key.name)))
+		return false
+	}
+
+	c.Set("subject", "apikey:"+key.name)
+	c.Set("apiKeyPermission", key.permission)
+	return true
+}
+
+func (cp *ClusterOpsPlugin) ListAPIKeysHandler(c *gin.Context) {
+	usage := cp.apiKeys.Usage()
+
+	c.JSON(http.StatusOK, gin.H{
+		"apiKeys": usage,
+		"count":   len(usage),
+		"plugin":  "cluster-ops-plugin",
+	})
+}
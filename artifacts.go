@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// defaultArtifactRetention is how long files in kubeconfig_dir are kept
+	// unless artifact_retention is configured
+	defaultArtifactRetention = 24 * time.Hour
+	// artifactSweepInterval is how often stale files are purged
+	artifactSweepInterval = 10 * time.Minute
+	// spokeKubeconfigFile is the spoke kubeconfig in an operation directory
+	spokeKubeconfigFile = "spoke.kubeconfig"
+)
+
+// operationDir is the working directory of an onboarding under
+// kubeconfig_dir. It holds the spoke kubeconfig the join runs with and,
+// when the onboarding fails and keep_failed_artifacts is set, the operation
+// and its events for debugging.
+func (cp *ClusterOpsPlugin) operationDir(operationID string) string {
+	return filepath.Join(cp.settings().KubeconfigDir, "operations", operationID)
+}
+
+// createOperationDir writes the spoke kubeconfig of an onboarding to its
+// working directory, readable only by the plugin
+func (cp *ClusterOpsPlugin) createOperationDir(operationID, kubeconfig string) (string, error) {
+	dir := cp.operationDir(operationID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, spokeKubeconfigFile), []byte(kubeconfig), 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// releaseOperationDir removes the working directory of a finished
+// onboarding. With keep_failed_artifacts the directory of a failed one is
+// kept until the retention sweeper purges it, with operation.json and
+// events.json written next to the kubeconfig.
+func (cp *ClusterOpsPlugin) releaseOperationDir(dir, operationID string, failed bool) {
+	if dir == "" {
+		return
+	}
+	if !failed || !cp.settings().KeepFailedArtifacts {
+		if err := os.RemoveAll(dir); err != nil {
+			cp.logger.Warn("Failed to remove operation directory", "dir", dir, "error", err)
+		}
+		return
+	}
+
+	op, _ := cp.operations.Get(operationID)
+	var events []OnboardingEvent
+	for _, event := range cp.events.List(op.ClusterName) {
+		if !event.Timestamp.Before(op.CreatedAt) {
+			events = append(events, event)
+		}
+	}
+	for name, value := range map[string]interface{}{"operation.json": op, "events.json": events} {
+		data, _ := json.MarshalIndent(value, "", "  ")
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			cp.logger.Warn("Failed to write operation artifact", "dir", dir, "file", name, "error", err)
+		}
+	}
+	cp.logger.Info("Kept artifacts of failed onboarding", "operation", operationID, "dir", dir)
+}
+
+// sweepArtifacts periodically purges operation directories and discovered
+// hub kubeconfigs older than artifact_retention. Kubeconfigs of hubs that
+// are still in use are kept whatever their age.
+func (cp *ClusterOpsPlugin) sweepArtifacts(ctx context.Context) {
+	ticker := time.NewTicker(artifactSweepInterval)
+	defer ticker.Stop()
+	for {
+		cp.purgeArtifacts(time.Now().Add(-cp.settings().ArtifactRetention))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeArtifacts removes the files last modified before cutoff
+func (cp *ClusterOpsPlugin) purgeArtifacts(cutoff time.Time) {
+	root := cp.settings().KubeconfigDir
+	inUse := make(map[string]bool)
+	for _, hub := range cp.hubList() {
+		if hub.Kubeconfig != "" {
+			inUse[filepath.Clean(hub.Kubeconfig)] = true
+		}
+	}
+	active := make(map[string]bool)
+	for _, op := range cp.operations.List() {
+		if op.CompletedAt == nil {
+			active[op.ID] = true
+		}
+	}
+
+	removed := 0
+	for _, sub := range []string{"operations", "hubs"} {
+		entries, err := os.ReadDir(filepath.Join(root, sub))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(root, sub, entry.Name())
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().Before(cutoff) || inUse[path] || (sub == "operations" && active[entry.Name()]) {
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				cp.logger.Warn("Failed to purge stale artifact", "path", path, "error", err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		cp.logger.Info("Purged stale artifacts", "dir", root, "removed", removed)
+	}
+}
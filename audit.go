@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAuditEntries bounds the in-memory audit trail; the oldest entries are
+// dropped once it is reached
+const maxAuditEntries = 10000
+
+// maxAuditPayload is the largest request body recorded in an audit entry
+const maxAuditPayload = 1 << 20
+
+// redactedValue replaces sensitive values in recorded payloads
+const redactedValue = "[REDACTED]"
+
+// sensitiveFields are request fields whose values never reach the audit
+// trail. Registration codes stay valid until redeemed, so they count too.
+var sensitiveFields = []string{"kubeconfig", "token", "secret", "password", "api_key", "routing_key", "webhook_url", "redis_url", "clientkey", "client_key", "privatekey", "private_key", "code"}
+
+// AuditEntry records a single mutating request handled by the plugin
+type AuditEntry struct {
+	ID          int64       `json:"id"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Actor       string      `json:"actor"`
+	SourceIP    string      `json:"sourceIp,omitempty"`
+	Action      string      `json:"action"`
+	ClusterName string      `json:"clusterName,omitempty"`
+	OperationID string      `json:"operationId,omitempty"`
+	RequestID   string      `json:"requestId,omitempty"`
+	Payload     interface{} `json:"payload,omitempty"`
+	Commands    []string    `json:"commands"`
+	StatusCode  int         `json:"statusCode"`
+	Outcome     string      `json:"outcome"`
+}
+
+// auditStore is an append-only trail of mutating requests
+type auditStore struct {
+	entries []AuditEntry
+	nextID  int64
+	mutex   sync.RWMutex
+}
+
+func newAuditStore() *auditStore {
+	return &auditStore{nextID: 1}
+}
+
+// Append assigns the entry an ID and adds it to the trail
+func (s *auditStore) Append(entry AuditEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry.ID = s.nextID
+	s.nextID++
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > maxAuditEntries {
+		s.entries = append([]AuditEntry(nil), s.entries[len(s.entries)-maxAuditEntries:]...)
+	}
+}
+
+// List returns the entries matching the filters, oldest first. Empty filters
+// and zero times are ignored.
+func (s *auditStore) List(clusterName, actor string, since, until time.Time) []AuditEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries := make([]AuditEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if clusterName != "" && entry.ClusterName != clusterName {
+			continue
+		}
+		if actor != "" && entry.Actor != actor {
+			continue
+		}
+		if !since.IsZero() && entry.Timestamp.Before(since) {
+			continue
+		}
+		if !until.IsZero() && entry.Timestamp.After(until) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// commandRecorder collects the commands run on behalf of a request
+type commandRecorder struct {
+	commands []string
+	mutex    sync.Mutex
+}
+
+type commandRecorderKey struct{}
+
+// recordCommand adds a command to the recorder carried by ctx, if any
+func recordCommand(ctx context.Context, command string) {
+	recorder, ok := ctx.Value(commandRecorderKey{}).(*commandRecorder)
+	if !ok {
+		return
+	}
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.commands = append(recorder.commands, command)
+}
+
+// audited wraps a mutating handler so every request it serves is recorded in
+// the audit trail together with the commands it ran and its outcome
+func (cp *ClusterOpsPlugin) audited(action string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxAuditPayload))
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		recorder := &commandRecorder{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), commandRecorderKey{}, recorder))
+
+		handler(c)
+
+		payload := redactPayload(body)
+		entry := AuditEntry{
+			Timestamp:   time.Now(),
+			Actor:       cp.requestActor(c),
+			SourceIP:    c.ClientIP(),
+			Action:      action,
+			ClusterName: auditClusterName(c, payload),
+			OperationID: c.GetString("operationId"),
+			RequestID:   c.GetString("requestId"),
+			Payload:     payload,
+			StatusCode:  c.Writer.Status(),
+			Outcome:     "success",
+		}
+		if entry.StatusCode >= http.StatusBadRequest {
+			entry.Outcome = "failure"
+		}
+		if entry.OperationID == "" {
+			entry.OperationID = c.Param("id")
+		}
+		if entry.ClusterName == "" && entry.OperationID != "" {
+			if op, ok := cp.operations.Get(entry.OperationID); ok {
+				entry.ClusterName = op.ClusterName
+			}
+		}
+		recorder.mutex.Lock()
+		entry.Commands = append([]string{}, recorder.commands...)
+		recorder.mutex.Unlock()
+
+		cp.audit.Append(entry)
+	}
+}
+
+// requestActor identifies the caller of a request from the subject of its
+// token, the identity set by the host's authentication middleware, or one
+// forwarded by a proxy. Forwarded identities are only taken when
+// rbac_trust_proxy_header says an authenticating proxy sets them, since any
+// client could send the headers.
+func (cp *ClusterOpsPlugin) requestActor(c *gin.Context) string {
+	for _, key := range []string{"subject", "username", "user"} {
+		if actor := c.GetString(key); actor != "" {
+			return actor
+		}
+	}
+	if !cp.settings().RBACTrustProxyHeader {
+		return "anonymous"
+	}
+	for _, header := range []string{"X-Remote-User", "X-Forwarded-User"} {
+		if actor := c.GetHeader(header); actor != "" {
+			return actor
+		}
+	}
+	return "anonymous"
+}
+
+func auditClusterName(c *gin.Context, payload interface{}) string {
+	if name := c.Param("name"); name != "" {
+		return name
+	}
+	if fields, ok := payload.(map[string]interface{}); ok {
+		if name, ok := fields["clusterName"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// redactPayload decodes a JSON request body with sensitive values replaced.
+// Bodies that are not JSON are omitted.
+func redactPayload(body []byte) interface{} {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return redactValue(payload)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if isSensitiveField(key) {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactValue(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return value
+}
+
+func isSensitiveField(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range sensitiveFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cp *ClusterOpsPlugin) ListAuditHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid limit: must be an integer between 1 and 1000", nil))
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid offset: must be a non-negative integer", nil))
+		return
+	}
+
+	var since, until time.Time
+	for param, target := range map[string]*time.Time{"since": &since, "until": &until} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid "+param+" timestamp: expected RFC3339", err.Error()))
+			return
+		}
+		*target = parsed
+	}
+
+	entries := cp.audit.List(c.Query("cluster"), c.Query("actor"), since, until)
+	total := len(entries)
+
+	start := min(offset, total)
+	end := min(start+limit, total)
+	page := entries[start:end]
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": page,
+		"count":   len(page),
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"hasMore": end < total,
+		"plugin":  "cluster-ops-plugin",
+	})
+}
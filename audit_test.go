@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newSimulatedPlugin returns an initialized plugin in simulation mode
+func newSimulatedPlugin(t *testing.T) *ClusterOpsPlugin {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cp, err := NewPlugin().(*ClusterOpsPlugin).newSelfTestPlugin(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+// testClientCertificate returns a self-signed client certificate and its
+// key in PEM form
+func testClientCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestAuditRedactsClientKey(t *testing.T) {
+	cp := newSimulatedPlugin(t)
+	cert, key := testClientCertificate(t)
+	body, _ := json.Marshal(map[string]string{
+		"clusterName": "audited",
+		"server":      "https://audited.invalid:6443",
+		"clientCert":  cert,
+		"clientKey":   key,
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/onboard", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	cp.audited("onboard", cp.OnboardClusterHandler)(c)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("onboarding returned %d: %s", w.Code, w.Body)
+	}
+
+	entries := cp.audit.List("audited", "", time.Time{}, time.Time{})
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	payload, _ := entries[0].Payload.(map[string]interface{})
+	if payload["clientKey"] != redactedValue {
+		t.Errorf("clientKey recorded as %v, want %s", payload["clientKey"], redactedValue)
+	}
+	recorded, _ := json.Marshal(entries)
+	if strings.Contains(string(recorded), "PRIVATE KEY") {
+		t.Errorf("audit entry contains the private key: %s", recorded)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := waitForOperation(ctx, cp.operations, entries[0].OperationID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedactPayload(t *testing.T) {
+	for body, field := range map[string]string{
+		`{"code":"K7QX-M2PD-9WZR"}`:                     "code",
+		`{"clusterName":"a","token":"sha256~abc"}`:      "token",
+		`{"notifiers":[{"webhook_url":"https://x/y"}]}`: "webhook_url",
+	} {
+		recorded, _ := json.Marshal(redactPayload([]byte(body)))
+		if !strings.Contains(string(recorded), `"`+field+`":"`+redactedValue+`"`) {
+			t.Errorf("%s was recorded as %s", body, recorded)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// maxBatchSize caps the number of clusters in a single batch
+const maxBatchSize = 100
+
+// Batch statuses, derived from the operations of a batch
+const (
+	BatchRunning   = "running"
+	BatchSucceeded = "succeeded"
+	BatchPartial   = "partial"
+	BatchFailed    = "failed"
+)
+
+// batchRejected is the status of a batch item that never started
+const batchRejected = "rejected"
+
+// BatchOnboardRequest is the payload of POST /onboard/batch. Clusters lists
+// full onboarding specs; alternatively every context of Kubeconfig is
+// onboarded as a cluster named after the context, with Defaults supplying
+// the remaining fields.
+type BatchOnboardRequest struct {
+	Clusters   []ClusterOnboardRequest `json:"clusters,omitempty"`
+	Kubeconfig string                  `json:"kubeconfig,omitempty"`
+	Defaults   *ClusterOnboardRequest  `json:"defaults,omitempty"`
+}
+
+// BatchItem is the outcome of one cluster of a batch
+type BatchItem struct {
+	ClusterName string `json:"clusterName"`
+	OperationID string `json:"operationId,omitempty"`
+	Status      string `json:"status"`
+	CurrentStep string `json:"currentStep,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Batch groups the operations started by one batch request
+type Batch struct {
+	ID string `json:"id"`
+	// Kind is onboard or detach
+	Kind      string         `json:"kind"`
+	Status    string         `json:"status"`
+	Items     []BatchItem    `json:"items"`
+	Summary   map[string]int `json:"summary"`
+	CreatedBy string         `json:"createdBy"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// batchStore keeps every batch so its progress can be polled
+type batchStore struct {
+	batches map[string]*Batch
+	mutex   sync.RWMutex
+}
+
+func newBatchStore() *batchStore {
+	return &batchStore{batches: make(map[string]*Batch)}
+}
+
+func (s *batchStore) Add(batch Batch) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.batches[batch.ID] = &batch
+}
+
+func (s *batchStore) Get(id string) (Batch, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return Batch{}, false
+	}
+	copied := *batch
+	copied.Items = append([]BatchItem(nil), batch.Items...)
+	return copied, true
+}
+
+// message flattens a rejection into a single line for a batch item
+func (e *requestError) message() string {
+	message := fmt.Sprint(e.body["error"])
+	if details, ok := e.body["details"]; ok {
+		message += fmt.Sprintf(": %v", details)
+	}
+	return message
+}
+
+// batchProgress fills the items of a batch from their operations and
+// derives the batch status
+func (cp *ClusterOpsPlugin) batchProgress(batch Batch) Batch {
+	batch.Summary = map[string]int{}
+	running, succeeded := false, 0
+	for i, item := range batch.Items {
+		if op, ok := cp.operations.Get(item.OperationID); ok {
+			item.Status = op.Status
+			item.Error = op.Error
+			item.CurrentStep = ""
+			for _, step := range op.Steps {
+				if step.Status == OperationRunning {
+					item.CurrentStep = step.Name
+				}
+			}
+			batch.Items[i] = item
+		}
+		batch.Summary[item.Status]++
+		switch item.Status {
+		case OperationPending, OperationRunning:
+			running = true
+		case OperationSucceeded:
+			succeeded++
+		}
+	}
+
+	switch {
+	case running:
+		batch.Status = BatchRunning
+	case succeeded == len(batch.Items):
+		batch.Status = BatchSucceeded
+	case succeeded > 0:
+		batch.Status = BatchPartial
+	default:
+		batch.Status = BatchFailed
+	}
+	return batch
+}
+
+// splitKubeconfigContexts returns a single-context kubeconfig for every
+// context of a kubeconfig, in order, keyed by context name
+func splitKubeconfigContexts(kubeconfig string) ([]string, map[string]string, error) {
+	var config map[string]interface{}
+	if err := yaml.Unmarshal([]byte(kubeconfig), &config); err != nil {
+		return nil, nil, fmt.Errorf("invalid kubeconfig: %v", err)
+	}
+	named := func(key string) map[string]interface{} {
+		entries := map[string]interface{}{}
+		list, _ := config[key].([]interface{})
+		for _, entry := range list {
+			if m, ok := entry.(map[string]interface{}); ok {
+				if name, _ := m["name"].(string); name != "" {
+					entries[name] = m
+				}
+			}
+		}
+		return entries
+	}
+	clusters, users := named("clusters"), named("users")
+
+	var names []string
+	split := map[string]string{}
+	contexts, _ := config["contexts"].([]interface{})
+	for _, entry := range contexts {
+		context, _ := entry.(map[string]interface{})
+		name, _ := context["name"].(string)
+		spec, _ := context["context"].(map[string]interface{})
+		if name == "" || spec == nil {
+			continue
+		}
+		clusterName, _ := spec["cluster"].(string)
+		userName, _ := spec["user"].(string)
+		cluster, ok := clusters[clusterName]
+		if !ok {
+			return nil, nil, fmt.Errorf("context %s refers to unknown cluster %q", name, clusterName)
+		}
+		single := map[string]interface{}{
+			"apiVersion":      "v1",
+			"kind":            "Config",
+			"current-context": name,
+			"contexts":        []interface{}{context},
+			"clusters":        []interface{}{cluster},
+		}
+		if user, ok := users[userName]; ok {
+			single["users"] = []interface{}{user}
+		}
+		out, err := yaml.Marshal(single)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		split[name] = string(out)
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("kubeconfig has no contexts")
+	}
+	return names, split, nil
+}
+
+func (cp *ClusterOpsPlugin) BatchOnboardHandler(c *gin.Context) {
+	var req BatchOnboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if (len(req.Clusters) > 0) == (req.Kubeconfig != "") {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Provide exactly one of clusters or kubeconfig", nil))
+		return
+	}
+
+	specs := req.Clusters
+	if req.Kubeconfig != "" {
+		kubeconfig, err := cp.prepareKubeconfig(c.Request.Context(), req.Kubeconfig)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Unable to decode kubeconfig", err.Error()))
+			return
+		}
+		names, split, err := splitKubeconfigContexts(kubeconfig)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Invalid kubeconfig", err.Error()))
+			return
+		}
+		for _, name := range names {
+			spec := ClusterOnboardRequest{}
+			if req.Defaults != nil {
+				spec = *req.Defaults
+			}
+			spec.ClusterName = name
+			spec.Kubeconfig = split[name]
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("A batch may onboard at most %d clusters, got %d", maxBatchSize, len(specs)), nil))
+		return
+	}
+
+	batch := Batch{
+		ID:        "batch-" + strings.TrimPrefix(newOperationID(), "op-"),
+		Kind:      "onboard",
+		CreatedBy: cp.requestActor(c),
+		CreatedAt: time.Now(),
+	}
+	for _, spec := range specs {
+		item := BatchItem{ClusterName: spec.ClusterName}
+		if spec.ClusterName != "" && !namespacePattern.MatchString(spec.ClusterName) {
+			item.Status = batchRejected
+			item.Error = fmt.Sprintf("invalid cluster name %q", spec.ClusterName)
+			batch.Items = append(batch.Items, item)
+			continue
+		}
+		op, rejected := cp.beginOnboarding(c.Request.Context(), c.GetHeader("traceparent"), spec)
+		if rejected != nil {
+			item.Status = batchRejected
+			item.Error = rejected.message()
+		} else {
+			item.OperationID = op.ID
+			item.Status = op.Status
+		}
+		batch.Items = append(batch.Items, item)
+	}
+	cp.batches.Add(batch)
+	batch = cp.batchProgress(batch)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        fmt.Sprintf("Batch onboarding of %d clusters started", len(batch.Items)-batch.Summary[batchRejected]),
+		"batch":          batch,
+		"statusEndpoint": fmt.Sprintf("%s/onboard/batch/%s", pluginAPIBase, batch.ID),
+		"plugin":         "cluster-ops-plugin",
+	})
+}
+
+// writeBatch responds with the progress of a batch of the given kind
+func (cp *ClusterOpsPlugin) writeBatch(c *gin.Context, kind string) {
+	batch, ok := cp.batches.Get(c.Param("id"))
+	if !ok || batch.Kind != kind {
+		c.JSON(http.StatusNotFound, errorResponse(codeNotFound, "Batch not found", nil))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"batch":  cp.batchProgress(batch),
+		"plugin": "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) GetBatchHandler(c *gin.Context) {
+	cp.writeBatch(c, "onboard")
+}
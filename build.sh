@@ -15,14 +15,31 @@ mkdir -p "${BUILD_DIR}"
 echo "📦 Compiling Go plugin..."
 cd "${SCRIPT_DIR}"
 
+# Stamp the commit and build date reported by GET /version. The plugin's
+# main package links under its module path, the executable's under main.
+GIT_COMMIT="$(git rev-parse --short HEAD 2>/dev/null || echo unknown)"
+BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+MODULE="$(go list -m)"
+LDFLAGS="-w -s"
+for pkg in main "${MODULE}"; do
+    LDFLAGS="${LDFLAGS} -X ${pkg}.gitCommit=${GIT_COMMIT} -X ${pkg}.buildDate=${BUILD_DATE}"
+done
+
 # Build with optimizations for production
 go build -buildmode=plugin \
-    -ldflags='-w -s' \
+    -ldflags="${LDFLAGS}" \
     -o "${BUILD_DIR}/${PLUGIN_NAME}.so" \
-    main.go
+    .
 
 echo "✅ Plugin built successfully: ${BUILD_DIR}/${PLUGIN_NAME}.so"
 
+# The same package also builds as an executable for hosts that run the
+# plugin out of process through go-plugin
+echo "📦 Compiling out-of-process plugin binary..."
+go build -ldflags="${LDFLAGS}" -o "${BUILD_DIR}/${PLUGIN_NAME}" .
+
+echo "✅ Plugin binary built successfully: ${BUILD_DIR}/${PLUGIN_NAME}"
+
 # Copy manifest file
 echo "📋 Copying plugin manifest..."
 cp plugin.yaml "${BUILD_DIR}/${PLUGIN_NAME}.yaml"
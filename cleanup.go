@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// removeManagedCluster deletes the ManagedCluster of a cluster from the hub.
+// A forced detachment does not wait for the deletion to finish, since the
+// ManagedCluster may be stuck in Terminating until its finalizers are removed.
+func (cp *ClusterOpsPlugin) removeManagedCluster(ctx context.Context, clusterName string, force bool) error {
+	return cp.deleteManagedCluster(ctx, clusterName, !force)
+}
+
+// removeFinalizers clears the finalizers of a ManagedCluster so one stuck in
+// Terminating is deleted. A ManagedCluster that is already gone needs nothing.
+func (cp *ClusterOpsPlugin) removeFinalizers(ctx context.Context, operationID, clusterName string) error {
+	_, err := cp.getManagedCluster(ctx, clusterName)
+	if apierrors.IsNotFound(err) {
+		cp.logStepEvent(operationID, clusterName, "remove-finalizers", "info", fmt.Sprintf("ManagedCluster %s is already gone", clusterName), 0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = cp.patchManagedCluster(ctx, clusterName, map[string]interface{}{
+		"metadata": map[string]interface{}{"finalizers": nil},
+	})
+	return err
+}
+
+// deleteHubObjects deletes the objects of a resource in namespace, or of a
+// cluster-scoped resource when namespace is empty, that match selector,
+// logging an event for each of them. Every object is attempted; the error
+// reports those that could not be deleted.
+func (cp *ClusterOpsPlugin) deleteHubObjects(ctx context.Context, operationID, clusterName, step, resource, namespace, selector string) error {
+	var scope []string
+	if namespace != "" {
+		scope = []string{"-n", namespace}
+	}
+	args := append([]string{"get", resource, "-o", "json"}, scope...)
+	if selector != "" {
+		args = append(args, "-l", selector)
+	}
+	out, err := cp.kubectlHub(ctx, args...)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return fmt.Errorf("failed to decode %s list: %v", resource, err)
+	}
+
+	var failed []error
+	for _, item := range list.Items {
+		name := item.Metadata.Name
+		if _, err := cp.kubectlHub(ctx, append([]string{"delete", resource, name, "--ignore-not-found"}, scope...)...); err != nil {
+			failed = append(failed, err)
+			cp.logStepEvent(operationID, clusterName, step, "warning", fmt.Sprintf("Failed to delete %s %s: %v", resource, name, err), 0)
+			continue
+		}
+		cp.logStepEvent(operationID, clusterName, step, "info", fmt.Sprintf("Deleted %s %s", resource, name), 0)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d %s could not be deleted: %w", len(failed), len(list.Items), resource, errors.Join(failed...))
+	}
+	return nil
+}
+
+// deleteClusterNamespace deletes the namespace of a cluster from the hub,
+// along with anything left in it
+func (cp *ClusterOpsPlugin) deleteClusterNamespace(ctx context.Context, operationID, clusterName, step string) error {
+	out, err := cp.kubectlHub(ctx, "delete", "namespace", clusterName, "--ignore-not-found")
+	if err != nil {
+		return err
+	}
+	if len(out) > 0 {
+		cp.logStepEvent(operationID, clusterName, step, "info", fmt.Sprintf("Deleted namespace %s", clusterName), 0)
+	}
+	return nil
+}
+
+// detachActions perform the hub-side steps of a detachment: removal, the
+// forceSteps and the cleanupSteps
+func (cp *ClusterOpsPlugin) detachActions(operationID, clusterName string, opts detachOptions) map[string]func(ctx context.Context) error {
+	return map[string]func(ctx context.Context) error{
+		"remove": func(ctx context.Context) error {
+			return cp.removeManagedCluster(ctx, clusterName, opts.force)
+		},
+		"remove-finalizers": func(ctx context.Context) error {
+			return cp.removeFinalizers(ctx, operationID, clusterName)
+		},
+		"cleanup-manifestworks": func(ctx context.Context) error {
+			return cp.deleteHubObjects(ctx, operationID, clusterName, "cleanup-manifestworks", "manifestwork", clusterName, "")
+		},
+		"cleanup-addons": func(ctx context.Context) error {
+			return cp.deleteHubObjects(ctx, operationID, clusterName, "cleanup-addons", "managedclusteraddon", clusterName, "")
+		},
+		"cleanup-csrs": func(ctx context.Context) error {
+			return cp.deleteHubObjects(ctx, operationID, clusterName, "cleanup-csrs", "csr", "", csrClusterLabel+"="+clusterName)
+		},
+		"cleanup-namespace": func(ctx context.Context) error {
+			return cp.deleteClusterNamespace(ctx, operationID, clusterName, "cleanup-namespace")
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CloudEvents types emitted for the cluster lifecycle
+const (
+	cloudEventOnboardingStarted   = "cluster.onboarding.started"
+	cloudEventOnboardingSucceeded = "cluster.onboarding.succeeded"
+	cloudEventOnboardingFailed    = "cluster.onboarding.failed"
+	cloudEventDetached            = "cluster.detached"
+)
+
+const cloudEventTimeout = 10 * time.Second
+
+// cloudEventReasons are the Kubernetes Event reasons of each CloudEvents type
+var cloudEventReasons = map[string]string{
+	cloudEventOnboardingStarted:   "OnboardingStarted",
+	cloudEventOnboardingSucceeded: "OnboardingSucceeded",
+	cloudEventOnboardingFailed:    "OnboardingFailed",
+	cloudEventDetached:            "Detached",
+}
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON mode
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            LifecycleEvent `json:"data"`
+}
+
+// cloudEventType maps a lifecycle event to the CloudEvents type it is emitted
+// as, or "" when it is not part of the published lifecycle
+func cloudEventType(event LifecycleEvent) string {
+	switch {
+	case event.Type == lifecycleStateChanged && event.State == StatePending:
+		return cloudEventOnboardingStarted
+	case event.Type == lifecycleOperationCompleted && event.Operation != nil:
+		switch {
+		case event.Operation.Type == "onboard" && event.Operation.Status == OperationSucceeded:
+			return cloudEventOnboardingSucceeded
+		case event.Operation.Type == "onboard":
+			return cloudEventOnboardingFailed
+		case event.Operation.Type == "detach" && event.Operation.Status == OperationSucceeded:
+			return cloudEventDetached
+		}
+	}
+	return ""
+}
+
+// emitCloudEvent sends a lifecycle event as a CloudEvent to the HTTP sink in
+// cloudevents_sink and, when cloudevents_hub_events is set, records it as a
+// Kubernetes Event on the hub. Delivery is asynchronous and best effort.
+func (cp *ClusterOpsPlugin) emitCloudEvent(event LifecycleEvent) {
+	eventType := cloudEventType(event)
+	if eventType == "" {
+		return
+	}
+	settings := cp.settings()
+	sink := settings.CloudEventsSink
+	hubEvents := settings.CloudEventsHub
+	if sink == "" && !hubEvents {
+		return
+	}
+
+	ce := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              newWebhookID("ce-"),
+		Source:          pluginAPIBase,
+		Type:            eventType,
+		Subject:         event.ClusterName,
+		Time:            event.Timestamp,
+		DataContentType: "application/json",
+		Data:            event,
+	}
+
+	go func() {
+		hub, err := cp.lookupHub(event.Hub)
+		if event.Hub == "" || err != nil {
+			hub = cp.clusterHub(event.ClusterName)
+		}
+		ctx, cancel := context.WithTimeout(withHub(context.Background(), hub), cloudEventTimeout)
+		defer cancel()
+
+		if sink != "" {
+			if err := postCloudEvent(ctx, sink, ce); err != nil {
+				cp.logger.Warn("Failed to send CloudEvent", "cluster", ce.Subject, "type", ce.Type, "error", err)
+			}
+		}
+		if hubEvents {
+			if err := cp.createHubEvent(ctx, ce); err != nil {
+				cp.logger.Warn("Failed to record Kubernetes Event on the hub", "cluster", ce.Subject, "type", ce.Type, "error", err)
+			}
+		}
+	}()
+}
+
+func postCloudEvent(ctx context.Context, sink string, ce CloudEvent) error {
+	payload, err := json.Marshal(ce)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// createHubEvent records a CloudEvent as a Kubernetes Event about the
+// ManagedCluster, so it shows up with kubectl describe on the hub
+func (cp *ClusterOpsPlugin) createHubEvent(ctx context.Context, ce CloudEvent) error {
+	eventType := "Normal"
+	if ce.Type == cloudEventOnboardingFailed {
+		eventType = "Warning"
+	}
+
+	event := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"generateName": ce.Subject + ".",
+			"namespace":    "default",
+		},
+		"involvedObject": map[string]interface{}{
+			"apiVersion": "cluster.open-cluster-management.io/v1",
+			"kind":       "ManagedCluster",
+			"name":       ce.Subject,
+		},
+		"reason":             cloudEventReasons[ce.Type],
+		"message":            ce.Data.Message,
+		"type":               eventType,
+		"firstTimestamp":     ce.Time.Format(time.RFC3339),
+		"lastTimestamp":      ce.Time.Format(time.RFC3339),
+		"count":              1,
+		"source":             map[string]interface{}{"component": "cluster-ops-plugin"},
+		"reportingComponent": "cluster-ops-plugin",
+		"reportingInstance":  ce.ID,
+	}
+	manifest, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = cp.kubectlHubWithInput(ctx, manifest, "create", "-f", "-")
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func (cp *ClusterOpsPlugin) GetClusterDetailsHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	record, tracked := cp.clusters.Get(name)
+	mc, hubErr := cp.getManagedCluster(c.Request.Context(), name)
+	if !tracked && apierrors.IsNotFound(hubErr) {
+		c.JSON(http.StatusNotFound, errorResponse(codeClusterNotFound, "Cluster not found", hubErr.Error()))
+		return
+	}
+	if !tracked && hubErr != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to read the cluster from the hub", hubErr.Error()))
+		return
+	}
+
+	response := gin.H{
+		"clusterName": name,
+		"tracked":     tracked,
+		"plugin":      "cluster-ops-plugin",
+	}
+
+	if tracked {
+		cluster := gin.H{
+			"status":         record.State,
+			"message":        record.Message,
+			"type":           record.Type,
+			"labels":         record.Labels,
+			"annotations":    record.Annotations,
+			"addons":         record.Addons,
+			"completedSteps": record.CompletedSteps,
+			"allowedActions": allowedActions(record.State),
+			"updatedAt":      record.UpdatedAt.Format(time.RFC3339),
+			"onboardedAt":    nil,
+		}
+		if record.OnboardedAt != nil {
+			cluster["onboardedAt"] = record.OnboardedAt.Format(time.RFC3339)
+		}
+		response["cluster"] = cluster
+	}
+
+	if hubErr != nil {
+		response["hub"] = nil
+		response["hubError"] = hubErr.Error()
+	} else {
+		claims := make(map[string]string, len(mc.Status.ClusterClaims))
+		for _, claim := range mc.Status.ClusterClaims {
+			claims[claim.Name] = claim.Value
+		}
+		taints := mc.Spec.Taints
+		if taints == nil {
+			taints = []clusterTaint{}
+		}
+		response["hub"] = gin.H{
+			"joined":            mc.conditionTrue("ManagedClusterJoined"),
+			"available":         mc.available(),
+			"hubAcceptsClient":  mc.Spec.HubAcceptsClient,
+			"kubernetesVersion": mc.Status.Version.Kubernetes,
+			"claims":            claims,
+			"conditions":        mc.Status.Conditions,
+			"labels":            mc.Metadata.Labels,
+			"taints":            taints,
+			"createdAt":         mc.Metadata.CreationTimestamp.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultClusterProxyURL is the in-cluster address of the user server of the
+// OCM cluster-proxy addon
+const defaultClusterProxyURL = "https://cluster-proxy-addon-user.open-cluster-management-cluster-proxy:9092"
+
+// clusterProxyAddon is the addon that tunnels hub requests to NAT'd spokes
+const clusterProxyAddon = "cluster-proxy"
+
+// NodeSummary is the state of a single spoke node
+type NodeSummary struct {
+	Name           string   `json:"name"`
+	Ready          bool     `json:"ready"`
+	Roles          []string `json:"roles"`
+	KubeletVersion string   `json:"kubeletVersion"`
+}
+
+// viaClusterProxy points a spoke client at the cluster-proxy user server,
+// which forwards each request and its bearer token to the spoke's API server
+// over the tunnel the addon agent opened from the spoke
+func (sc *spokeClient) viaClusterProxy(proxyURL, clusterName string, caPEM []byte) error {
+	if sc.token == "" {
+		return fmt.Errorf("cluster-proxy requires a token-based kubeconfig")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("cluster_proxy_ca contains no valid PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	sc.server = strings.TrimSuffix(proxyURL, "/") + "/" + clusterName
+	sc.insecure = false
+	sc.httpClient = &http.Client{
+		Timeout:   spokeRequestTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	return nil
+}
+
+// spokeClientFor returns a client for a tracked cluster built from its stored
+// kubeconfig. Clusters with the cluster-proxy addon are reached through it,
+// since their API servers are usually not routable from the hub.
+// Clusters with the managed-serviceaccount addon authenticate with its
+// short-lived token instead of a stored kubeconfig.
+func (cp *ClusterOpsPlugin) spokeClientFor(ctx context.Context, clusterName string) (*spokeClient, bool, error) {
+	record, _ := cp.clusters.Get(clusterName)
+
+	var client *spokeClient
+	var err error
+	if slices.Contains(record.Addons, managedServiceAccountAddon) {
+		client, err = cp.managedServiceAccountClient(ctx, clusterName)
+	} else {
+		var stored StoredKubeconfig
+		if stored, err = cp.loadKubeconfig(ctx, clusterName); err == nil {
+			client, err = newSpokeClient(stored.Kubeconfig, cp.spokeTLSOptions())
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !slices.Contains(record.Addons, clusterProxyAddon) {
+		return client, false, nil
+	}
+	settings := cp.settings()
+	if err := client.viaClusterProxy(settings.ClusterProxyURL, clusterName, []byte(settings.ClusterProxyCA)); err != nil {
+		return nil, true, err
+	}
+	return client, true, nil
+}
+
+// listNodes returns a summary of every node of a spoke
+func listNodes(ctx context.Context, client *spokeClient) ([]NodeSummary, error) {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []clusterCondition `json:"conditions"`
+				NodeInfo   struct {
+					KubeletVersion string `json:"kubeletVersion"`
+				} `json:"nodeInfo"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := client.get(ctx, "/api/v1/nodes", &list); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]NodeSummary, 0, len(list.Items))
+	for _, item := range list.Items {
+		node := NodeSummary{Name: item.Metadata.Name, Roles: []string{}, KubeletVersion: item.Status.NodeInfo.KubeletVersion}
+		for _, condition := range item.Status.Conditions {
+			if condition.Type == "Ready" {
+				node.Ready = condition.Status == "True"
+			}
+		}
+		for label := range item.Metadata.Labels {
+			if role, ok := strings.CutPrefix(label, "node-role.kubernetes.io/"); ok && role != "" {
+				node.Roles = append(node.Roles, role)
+			}
+		}
+		slices.Sort(node.Roles)
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (cp *ClusterOpsPlugin) ListClusterNodesHandler(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := cp.clusters.Get(name); !ok {
+		c.JSON(http.StatusNotFound, errorResponse(codeClusterNotFound, "Cluster not found", nil))
+		return
+	}
+
+	client, viaProxy, err := cp.spokeClientFor(c.Request.Context(), name)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeClusterUnreachable, "Unable to build a client for the cluster", err.Error()))
+		return
+	}
+	nodes, err := listNodes(c.Request.Context(), client)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeClusterUnreachable, "Failed to list nodes of the cluster", err.Error()))
+		return
+	}
+
+	ready := 0
+	for _, node := range nodes {
+		if node.Ready {
+			ready++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName":  name,
+		"nodes":        nodes,
+		"count":        len(nodes),
+		"ready":        ready,
+		"clusterProxy": viaProxy,
+		"plugin":       "cluster-ops-plugin",
+	})
+}
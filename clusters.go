@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClusterState is the lifecycle state of a cluster tracked by the plugin
+type ClusterState string
+
+const (
+	StatePending     ClusterState = "Pending"
+	StateJoining     ClusterState = "Joining"
+	StateAwaitingCSR ClusterState = "AwaitingCSR"
+	// StateAwaitingApproval is a joined cluster waiting for an operator to accept it
+	StateAwaitingApproval ClusterState = "AwaitingApproval"
+	// StateRegistered is a cluster reserved in the plugin whose kubeconfig
+	// has not been supplied yet
+	StateRegistered       ClusterState = "Registered"
+	StateVerifying        ClusterState = "Verifying"
+	StateOnboarded        ClusterState = "Onboarded"
+	StateDetaching        ClusterState = "Detaching"
+	StateFailed           ClusterState = "Failed"
+	StateDetachmentFailed ClusterState = "DetachmentFailed"
+	// StateCancelled is a cluster whose onboarding or detachment was cancelled
+	StateCancelled ClusterState = "Cancelled"
+	// StateUnavailable is an onboarded cluster whose agent stopped reporting to the hub
+	StateUnavailable ClusterState = "Unavailable"
+)
+
+// stateUntracked is the state of a cluster the plugin does not know about
+const stateUntracked ClusterState = ""
+
+// clusterTransitions lists the states each state may move to
+var clusterTransitions = map[ClusterState][]ClusterState{
+	stateUntracked:        {StatePending, StateRegistered},
+	StateRegistered:       {StatePending, StateDetaching},
+	StatePending:          {StateJoining, StateOnboarded, StateFailed, StateCancelled},
+	StateJoining:          {StateAwaitingCSR, StateAwaitingApproval, StateFailed, StateCancelled},
+	StateAwaitingApproval: {StateAwaitingCSR, StateFailed, StateCancelled},
+	StateAwaitingCSR:      {StateVerifying, StateFailed, StateCancelled},
+	StateVerifying:        {StateOnboarded, StateFailed, StateCancelled},
+	StateOnboarded:        {StateDetaching, StateUnavailable},
+	StateUnavailable:      {StateOnboarded, StateDetaching},
+	StateFailed:           {StatePending, StateDetaching},
+	StateDetaching:        {StateDetachmentFailed, StateCancelled},
+	StateDetachmentFailed: {StateDetaching},
+	StateCancelled:        {StatePending, StateDetaching},
+}
+
+// clusterActions lists the API actions available to a cluster in each state
+var clusterActions = map[ClusterState][]string{
+	stateUntracked:        {"onboard", "register"},
+	StateRegistered:       {"onboard", "detach"},
+	StatePending:          {"cancel"},
+	StateJoining:          {"cancel"},
+	StateAwaitingCSR:      {"cancel"},
+	StateAwaitingApproval: {"approve", "cancel"},
+	StateVerifying:        {"cancel"},
+	StateOnboarded:        {"detach"},
+	StateUnavailable:      {"detach"},
+	StateFailed:           {"onboard", "detach"},
+	StateDetaching:        {"cancel"},
+	StateDetachmentFailed: {"detach"},
+	StateCancelled:        {"onboard", "detach"},
+}
+
+// canTransition reports whether a cluster may move from one state to another
+func canTransition(from, to ClusterState) bool {
+	for _, allowed := range clusterTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedActions returns the actions available to a cluster in a state
+func allowedActions(state ClusterState) []string {
+	actions := clusterActions[state]
+	if actions == nil {
+		return []string{}
+	}
+	return append([]string{}, actions...)
+}
+
+// ClusterRecord is the plugin's view of a cluster
+type ClusterRecord struct {
+	Name    string       `json:"name"`
+	State   ClusterState `json:"state"`
+	Message string       `json:"message,omitempty"`
+	// Remediation suggests how to fix the failure that left the cluster in
+	// its current state
+	Remediation string `json:"remediation,omitempty"`
+	// Hub is the name of the hub the cluster is registered with
+	Hub string `json:"hub,omitempty"`
+	// Owner is the team or person responsible for the cluster
+	Owner string `json:"owner,omitempty"`
+	// Type is the kind of cluster, e.g. EKS or Kind, when known
+	Type        string            `json:"type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Addons      []string          `json:"addons,omitempty"`
+	// Klusterlet holds the agent customizations requested at onboarding
+	Klusterlet *KlusterletOptions `json:"klusterlet,omitempty"`
+	// CompletedSteps lists pipeline steps finished by the latest onboarding
+	// attempt, allowing a failed onboarding to be resumed
+	CompletedSteps []string `json:"completedSteps,omitempty"`
+	// OnboardingStartedAt is when the latest onboarding was requested
+	OnboardingStartedAt *time.Time `json:"onboardingStartedAt,omitempty"`
+	// OnboardedAt is when the cluster finished onboarding, or when its
+	// ManagedCluster was created for clusters discovered on the hub
+	OnboardedAt *time.Time `json:"onboardedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// onboardingDuration returns how long the latest onboarding took, or zero if
+// it is unknown or still in progress
+func (r *ClusterRecord) onboardingDuration() time.Duration {
+	if r.OnboardingStartedAt == nil || r.OnboardedAt == nil || r.OnboardedAt.Before(*r.OnboardingStartedAt) {
+		return 0
+	}
+	return r.OnboardedAt.Sub(*r.OnboardingStartedAt)
+}
+
+func (r *ClusterRecord) snapshot() ClusterRecord {
+	copied := *r
+	copied.CompletedSteps = append([]string(nil), r.CompletedSteps...)
+	copied.Addons = append([]string(nil), r.Addons...)
+	copied.Labels = copyStringMap(r.Labels)
+	copied.Annotations = copyStringMap(r.Annotations)
+	return copied
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// clusterStore tracks the state of every cluster the plugin manages
+type clusterStore struct {
+	clusters map[string]*ClusterRecord
+	// onTransition is called with the store locked after a cluster changes
+	// state, so it must not block or use the store
+	onTransition func(record ClusterRecord, from ClusterState)
+	// onChange is called with the store locked whenever a record is created,
+	// changed or deleted, so it must not block or use the store
+	onChange func(name string)
+	mutex    sync.RWMutex
+}
+
+func newClusterStore() *clusterStore {
+	return &clusterStore{
+		clusters: make(map[string]*ClusterRecord),
+	}
+}
+
+// Transition moves a cluster to a new state, creating its record when it is
+// not tracked yet. Transitions missing from clusterTransitions are rejected.
+func (s *clusterStore) Transition(name string, to ClusterState, message string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.clusters[name]
+	from := stateUntracked
+	if ok {
+		from = record.State
+	}
+	if !canTransition(from, to) {
+		return fmt.Errorf("cluster %s cannot move from %s to %s", name, describeState(from), to)
+	}
+
+	now := time.Now()
+	if !ok {
+		record = &ClusterRecord{Name: name, CreatedAt: now}
+		s.clusters[name] = record
+	}
+	switch {
+	case to == StatePending:
+		record.OnboardingStartedAt = &now
+		record.OnboardedAt = nil
+	case to == StateOnboarded && (from == StateVerifying || from == StatePending):
+		record.OnboardedAt = &now
+	}
+	record.State = to
+	record.Message = message
+	record.Remediation = ""
+	record.UpdatedAt = now
+	if s.onTransition != nil {
+		s.onTransition(record.snapshot(), from)
+	}
+	s.changed(name)
+	return nil
+}
+
+// Seed starts tracking a cluster observed outside the plugin, such as one
+// discovered on the hub. It returns false without changes if the cluster is
+// already tracked.
+func (s *clusterStore) Seed(record ClusterRecord) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.clusters[record.Name]; ok {
+		return false
+	}
+	now := time.Now()
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	s.clusters[record.Name] = &record
+	s.changed(record.Name)
+	return true
+}
+
+// Get returns a copy of a cluster record
+func (s *clusterStore) Get(name string) (ClusterRecord, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, ok := s.clusters[name]
+	if !ok {
+		return ClusterRecord{}, false
+	}
+	return record.snapshot(), true
+}
+
+// List returns copies of all cluster records sorted by name
+func (s *clusterStore) List() []ClusterRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	records := make([]ClusterRecord, 0, len(s.clusters))
+	for _, record := range s.clusters {
+		records = append(records, record.snapshot())
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// Update applies fn to the record of a tracked cluster. It returns false if
+// the cluster is not tracked.
+func (s *clusterStore) Update(name string, fn func(record *ClusterRecord)) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.clusters[name]
+	if !ok {
+		return false
+	}
+	fn(record)
+	record.UpdatedAt = time.Now()
+	s.changed(name)
+	return true
+}
+
+// MarkStepCompleted records that a pipeline step finished for a cluster
+func (s *clusterStore) MarkStepCompleted(name, step string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if record, ok := s.clusters[name]; ok {
+		record.CompletedSteps = append(record.CompletedSteps, step)
+		s.changed(name)
+	}
+}
+
+// ResetSteps forgets the completed steps of a cluster
+func (s *clusterStore) ResetSteps(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if record, ok := s.clusters[name]; ok {
+		record.CompletedSteps = nil
+		s.changed(name)
+	}
+}
+
+// Delete stops tracking a cluster
+func (s *clusterStore) Delete(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.clusters, name)
+	s.changed(name)
+}
+
+// Restore stores a record received from another replica. onChange is not
+// called, as the change did not originate here.
+func (s *clusterStore) Restore(record ClusterRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clusters[record.Name] = &record
+}
+
+// Forget stops tracking a cluster deleted by another replica
+func (s *clusterStore) Forget(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.clusters, name)
+}
+
+func (s *clusterStore) changed(name string) {
+	if s.onChange != nil {
+		s.onChange(name)
+	}
+}
+
+func describeState(state ClusterState) string {
+	if state == stateUntracked {
+		return "untracked"
+	}
+	return string(state)
+}
+
+// labelRequirement is a single equality-based label selector term
+type labelRequirement struct {
+	key      string
+	value    string
+	operator string
+}
+
+// parseLabelSelector parses an equality-based label selector such as
+// "env=prod,tier!=edge,gpu,!legacy"
+func parseLabelSelector(selector string) ([]labelRequirement, error) {
+	var requirements []labelRequirement
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], operator: "!="}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], operator: "="}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], operator: "="}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: term[1:], operator: "!exists"}
+		default:
+			req = labelRequirement{key: term, operator: "exists"}
+		}
+
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if req.key == "" || strings.ContainsAny(req.key, " ()") || strings.ContainsAny(req.value, " ()") {
+			return nil, fmt.Errorf("unsupported label selector term %q", term)
+		}
+		requirements = append(requirements, req)
+	}
+	return requirements, nil
+}
+
+// matchLabels reports whether labels satisfy every requirement
+func matchLabels(requirements []labelRequirement, labels map[string]string) bool {
+	for _, req := range requirements {
+		value, ok := labels[req.key]
+		switch req.operator {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
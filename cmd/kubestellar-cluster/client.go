@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pluginAPIBase is where the KubeStellar backend mounts the plugin routes
+const pluginAPIBase = "/api/plugins/cluster-ops-plugin"
+
+// client calls the HTTP API of the plugin
+type client struct {
+	server string
+	token  string
+	apiKey string
+	http   *http.Client
+}
+
+// apiError is an error response of the plugin
+type apiError struct {
+	Status  int         `json:"-"`
+	Message string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	if e.Details != nil {
+		return fmt.Sprintf("%s (HTTP %d): %v", e.Message, e.Status, e.Details)
+	}
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+func newClient(server, token, apiKey string, timeout time.Duration) *client {
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		apiKey: apiKey,
+		http:   &http.Client{Timeout: timeout},
+	}
+}
+
+// do sends a request to a plugin path and decodes the JSON response into out
+func (c *client) do(method, path string, query url.Values, body, out interface{}) error {
+	target := c.server + pluginAPIBase + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &apiError{Status: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// operationStep is a step of an operation
+type operationStep struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// operation is an onboarding or detachment job of the plugin
+type operation struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	ClusterName string          `json:"clusterName"`
+	Status      string          `json:"status"`
+	Steps       []operationStep `json:"steps"`
+	Result      string          `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+func (op *operation) terminal() bool {
+	return op.Status == "succeeded" || op.Status == "failed" || op.Status == "cancelled"
+}
+
+// waitOperation polls an operation until it finishes, reporting each step as
+// it completes
+func (c *client) waitOperation(id string, progress io.Writer) (*operation, error) {
+	reported := map[string]bool{}
+	for {
+		var response struct {
+			Operation operation `json:"operation"`
+		}
+		if err := c.do(http.MethodGet, "/operations/"+url.PathEscape(id), nil, nil, &response); err != nil {
+			return nil, err
+		}
+		op := response.Operation
+		for _, step := range op.Steps {
+			if (step.Status == "succeeded" || step.Status == "failed" || step.Status == "skipped") && !reported[step.Name] {
+				reported[step.Name] = true
+				fmt.Fprintf(progress, "  %-28s %s\n", step.Name, step.Status)
+			}
+		}
+		if op.terminal() {
+			return &op, nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
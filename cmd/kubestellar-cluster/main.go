@@ -0,0 +1,365 @@
+// Command kubestellar-cluster onboards, detaches and inspects clusters
+// through the HTTP API of the cluster operations plugin, for terminal users
+// and CI pipelines.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// options are the flags shared by every command
+type options struct {
+	server  string
+	token   string
+	apiKey  string
+	timeout time.Duration
+	output  string
+}
+
+func (o *options) client() *client {
+	return newClient(o.server, o.token, o.apiKey, o.timeout)
+}
+
+// print writes v as indented JSON when JSON output was requested and returns
+// true, leaving table output to the caller otherwise
+func (o *options) print(v interface{}) (bool, error) {
+	if o.output != "json" {
+		return false, nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return true, encoder.Encode(v)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	o := &options{}
+	root := &cobra.Command{
+		Use:          "kubestellar-cluster",
+		Short:        "Onboard and manage KubeStellar clusters",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if o.output != "table" && o.output != "json" {
+				return fmt.Errorf("invalid --output %q: must be table or json", o.output)
+			}
+			return nil
+		},
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&o.server, "server", envOr("KUBESTELLAR_SERVER", "http://localhost:4000"), "URL of the KubeStellar backend (env KUBESTELLAR_SERVER)")
+	flags.StringVar(&o.token, "token", os.Getenv("KUBESTELLAR_TOKEN"), "bearer token for the plugin API (env KUBESTELLAR_TOKEN)")
+	flags.StringVar(&o.apiKey, "api-key", os.Getenv("KUBESTELLAR_API_KEY"), "API key for the plugin API (env KUBESTELLAR_API_KEY)")
+	flags.DurationVar(&o.timeout, "request-timeout", 60*time.Second, "timeout of a single API request")
+	flags.StringVarP(&o.output, "output", "o", "table", "output format: table or json")
+
+	root.AddCommand(
+		newOnboardCommand(o),
+		newDetachCommand(o),
+		newListCommand(o),
+		newStatusCommand(o),
+		newLogsCommand(o),
+	)
+	return root
+}
+
+// parseLabels turns key=value flags into a label map
+func parseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", value)
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
+
+// finish waits for an operation when requested and turns its failure into
+// the command error
+func finish(o *options, cmd *cobra.Command, opID string, wait bool) error {
+	if !wait {
+		return nil
+	}
+	op, err := o.client().waitOperation(opID, cmd.ErrOrStderr())
+	if err != nil {
+		return err
+	}
+	if printed, err := o.print(op); printed || err != nil {
+		if err == nil && op.Status != "succeeded" {
+			err = fmt.Errorf("operation %s %s", op.ID, op.Status)
+		}
+		return err
+	}
+	if op.Status != "succeeded" {
+		return fmt.Errorf("operation %s %s: %s", op.ID, op.Status, op.Error)
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), op.Result)
+	return nil
+}
+
+func newOnboardCommand(o *options) *cobra.Command {
+	var (
+		kubeconfigPath string
+		hub            string
+		clusterType    string
+		labels         []string
+		addons         []string
+		resume         bool
+		wait           bool
+	)
+	cmd := &cobra.Command{
+		Use:   "onboard NAME --kubeconfig FILE",
+		Short: "Onboard a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeconfig, err := os.ReadFile(kubeconfigPath)
+			if err != nil {
+				return fmt.Errorf("failed to read kubeconfig: %w", err)
+			}
+			labelMap, err := parseLabels(labels)
+			if err != nil {
+				return err
+			}
+			request := map[string]interface{}{
+				"clusterName": args[0],
+				"kubeconfig":  string(kubeconfig),
+				"hub":         hub,
+				"type":        clusterType,
+				"labels":      labelMap,
+				"addons":      addons,
+				"resume":      resume,
+			}
+
+			var response struct {
+				Message     string `json:"message"`
+				OperationID string `json:"operationId"`
+			}
+			if err := o.client().do(http.MethodPost, "/onboard", nil, request, &response); err != nil {
+				return err
+			}
+			if !wait {
+				if printed, err := o.print(response); printed || err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s (operation %s)\n", response.Message, response.OperationID)
+			return finish(o, cmd, response.OperationID, wait)
+		},
+	}
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", "", "kubeconfig of the cluster to onboard")
+	cmd.Flags().StringVar(&hub, "hub", "", "hub to join; empty uses the default hub")
+	cmd.Flags().StringVar(&clusterType, "type", "", "kind of cluster, e.g. eks or kind")
+	cmd.Flags().StringArrayVarP(&labels, "label", "l", nil, "label to set on the cluster as key=value; repeatable")
+	cmd.Flags().StringSliceVar(&addons, "addon", nil, "addon to enable; repeatable or comma separated")
+	cmd.Flags().BoolVar(&resume, "resume", false, "skip the steps completed by a previous, failed attempt")
+	cmd.Flags().BoolVarP(&wait, "wait", "w", false, "wait for the onboarding to finish")
+	cmd.MarkFlagRequired("kubeconfig")
+	return cmd
+}
+
+func newDetachCommand(o *options) *cobra.Command {
+	var (
+		hub     string
+		cleanup bool
+		force   bool
+		wait    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "detach NAME",
+		Short: "Detach a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			request := map[string]interface{}{
+				"clusterName": args[0],
+				"hub":         hub,
+				"cleanup":     cleanup,
+				"force":       force,
+			}
+			var response struct {
+				Message     string `json:"message"`
+				OperationID string `json:"operationId"`
+			}
+			if err := o.client().do(http.MethodPost, "/detach", nil, request, &response); err != nil {
+				return err
+			}
+			if !wait {
+				if printed, err := o.print(response); printed || err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s (operation %s)\n", response.Message, response.OperationID)
+			return finish(o, cmd, response.OperationID, wait)
+		},
+	}
+	cmd.Flags().StringVar(&hub, "hub", "", "hub the cluster is expected to be registered with")
+	cmd.Flags().BoolVar(&cleanup, "cleanup", false, "remove the agent from the cluster")
+	cmd.Flags().BoolVar(&force, "force", false, "continue past failing steps")
+	cmd.Flags().BoolVarP(&wait, "wait", "w", false, "wait for the detachment to finish")
+	return cmd
+}
+
+func newListCommand(o *options) *cobra.Command {
+	var (
+		selector string
+		status   string
+		hub      string
+		limit    int
+	)
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List clusters",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{"limit": {fmt.Sprint(limit)}}
+			for key, value := range map[string]string{"labelSelector": selector, "status": status, "hub": hub} {
+				if value != "" {
+					query.Set(key, value)
+				}
+			}
+			var response struct {
+				Clusters []struct {
+					Name      string `json:"name"`
+					Hub       string `json:"hub"`
+					Status    string `json:"status"`
+					Type      string `json:"type"`
+					UpdatedAt string `json:"updatedAt"`
+				} `json:"clusters"`
+				Total int `json:"total"`
+			}
+			if err := o.client().do(http.MethodGet, "/clusters", query, nil, &response); err != nil {
+				return err
+			}
+			if printed, err := o.print(response); printed || err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tHUB\tSTATUS\tTYPE\tUPDATED")
+			for _, cluster := range response.Clusters {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", cluster.Name, cluster.Hub, cluster.Status, cluster.Type, cluster.UpdatedAt)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVarP(&selector, "selector", "l", "", "label selector, e.g. env=prod,tier!=edge")
+	cmd.Flags().StringVar(&status, "status", "", "only list clusters in this state")
+	cmd.Flags().StringVar(&hub, "hub", "", "only list clusters of this hub")
+	cmd.Flags().IntVar(&limit, "limit", 1000, "maximum number of clusters to list")
+	return cmd
+}
+
+func newStatusCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status NAME",
+		Short: "Show the status of a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var response map[string]interface{}
+			if err := o.client().do(http.MethodGet, "/status/"+url.PathEscape(args[0]), nil, nil, &response); err != nil {
+				return err
+			}
+			if printed, err := o.print(response); printed || err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			for _, key := range []string{"clusterName", "hub", "status", "message", "updatedAt", "lastSeen", "allowedActions"} {
+				if value, ok := response[key]; ok && value != nil && value != "" {
+					fmt.Fprintf(w, "%s:\t%v\n", key, value)
+				}
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newLogsCommand(o *options) *cobra.Command {
+	var (
+		level  string
+		since  time.Duration
+		follow bool
+	)
+	cmd := &cobra.Command{
+		Use:   "logs NAME",
+		Short: "Show the event log of a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			type logEntry struct {
+				Timestamp string `json:"timestamp"`
+				Level     string `json:"level"`
+				Type      string `json:"type"`
+				Status    string `json:"status"`
+				Message   string `json:"message"`
+			}
+			query := url.Values{"limit": {"1000"}}
+			if level != "" {
+				query.Set("level", level)
+			}
+			if since > 0 {
+				query.Set("since", time.Now().Add(-since).UTC().Format(time.RFC3339))
+			}
+
+			seen := map[string]bool{}
+			for {
+				var response struct {
+					Logs []logEntry `json:"logs"`
+				}
+				if err := o.client().do(http.MethodGet, "/logs/"+url.PathEscape(args[0]), query, nil, &response); err != nil {
+					return err
+				}
+				for _, entry := range response.Logs {
+					// Timestamps have a resolution of a second, so entries
+					// are deduplicated when following
+					key := entry.Timestamp + entry.Type + entry.Status + entry.Message
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+					if o.output == "json" {
+						line, _ := json.Marshal(entry)
+						fmt.Fprintln(cmd.OutOrStdout(), string(line))
+						continue
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "%s %-5s %-12s %s\n", entry.Timestamp, entry.Level, entry.Type, entry.Message)
+				}
+				if !follow {
+					return nil
+				}
+				if n := len(response.Logs); n > 0 {
+					query.Set("since", response.Logs[n-1].Timestamp)
+				}
+				time.Sleep(2 * time.Second)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&level, "level", "", "minimum level: info, warn or error")
+	cmd.Flags().DurationVar(&since, "since", 0, "only show entries newer than this, e.g. 1h")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep printing new entries")
+	return cmd
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Command is an external program run by the plugin
+type Command struct {
+	Name string
+	Args []string
+	// Stdin is passed to the command when not nil
+	Stdin []byte
+	// Env is added to the environment of the plugin process
+	Env []string
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// CommandRunner runs the CLIs the plugin drives: kubectl, clusteradm, sops
+// and kubeconfig exec credential plugins
+type CommandRunner interface {
+	// Run runs a command to completion and returns its stdout. A failed
+	// command returns an error that includes its stderr.
+	Run(ctx context.Context, cmd Command) ([]byte, error)
+	// Stream starts a long-running command and returns its stdout. The
+	// command stops when ctx is cancelled; wait reaps it and returns its
+	// exit error.
+	Stream(ctx context.Context, cmd Command) (stdout io.Reader, wait func() error, err error)
+	// LookPath reports where a command is installed
+	LookPath(name string) (string, error)
+}
+
+// HubClient runs kubectl and clusteradm against the hub selected in ctx,
+// adding the flags that address it
+type HubClient interface {
+	Kubectl(ctx context.Context, input []byte, args ...string) ([]byte, error)
+	Clusteradm(ctx context.Context, args ...string) ([]byte, error)
+	// Watch starts a kubectl watch; see CommandRunner.Stream
+	Watch(ctx context.Context, args ...string) (io.Reader, func() error, error)
+}
+
+// execRunner runs commands as processes of the plugin host
+type execRunner struct{}
+
+func (execRunner) command(ctx context.Context, c Command) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	if env := append(kubeToolEnv(c.Name), c.Env...); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if c.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(c.Stdin)
+	}
+	return cmd
+}
+
+func (r execRunner) Run(ctx context.Context, c Command) ([]byte, error) {
+	cmd := r.command(ctx, c)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (r execRunner) Stream(ctx context.Context, c Command) (io.Reader, func() error, error) {
+	cmd := r.command(ctx, c)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	return stdout, cmd.Wait, nil
+}
+
+func (execRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// cliHubClient is the HubClient backed by the kubectl and clusteradm CLIs
+type cliHubClient struct {
+	runner CommandRunner
+	// flags returns the flags addressing the hub selected in ctx
+	flags func(ctx context.Context) []string
+}
+
+func (h *cliHubClient) Kubectl(ctx context.Context, input []byte, args ...string) ([]byte, error) {
+	cmd := Command{Name: "kubectl", Args: append(h.flags(ctx), args...), Stdin: input}
+	recordCommand(ctx, cmd.String())
+	return h.runner.Run(ctx, cmd)
+}
+
+func (h *cliHubClient) Clusteradm(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := Command{Name: "clusteradm", Args: append(args, h.flags(ctx)...)}
+	recordCommand(ctx, cmd.String())
+	return h.runner.Run(ctx, cmd)
+}
+
+func (h *cliHubClient) Watch(ctx context.Context, args ...string) (io.Reader, func() error, error) {
+	cmd := Command{Name: "kubectl", Args: append(h.flags(ctx), args...)}
+	recordCommand(ctx, cmd.String())
+	return h.runner.Stream(ctx, cmd)
+}
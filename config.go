@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// configEnvPrefix starts the environment variables that override
+// configuration keys: KS_PLUGIN_ITS_CONTEXT sets its_context
+const configEnvPrefix = "KS_PLUGIN_"
+
+// PluginConfig is the typed form of the scalar configuration keys. It is
+// parsed and validated by Initialize, so a mistyped or out-of-range value
+// fails initialization instead of silently falling back to its default.
+// Structured keys such as hubs and api_keys are validated by their own
+// parsers.
+type PluginConfig struct {
+	Timeout             time.Duration
+	Retries             int
+	ValidateSSL         bool
+	ClusterNamespace    string
+	ITSContext          string
+	KubeconfigDir       string
+	KeepFailedArtifacts bool
+	ArtifactRetention   time.Duration
+	LogLevel            string
+	ConfigFile          string
+	StateBackend        string
+	StateRedisURL       string
+	StateSyncInterval   time.Duration
+	GRPCPort            int
+	EventRetention      time.Duration
+	EventBufferSize     int
+	GRPCTLSCertFile     string
+	GRPCTLSKeyFile      string
+
+	OnboardConcurrency            int
+	AcceptMode                    string
+	ManualApproval                bool
+	ApprovalTimeout               time.Duration
+	CSRTimeout                    time.Duration
+	CSRApprovalPolicy             string
+	JoinTokenTTL                  time.Duration
+	RegistrationCodeTTL           time.Duration
+	PrecreatedClusterTTL          time.Duration
+	ManagedServiceAccountValidity time.Duration
+	ControllerMode                bool
+	SimulationMode                bool
+	AccessLog                     bool
+	AccessLogSamplePercent        int
+	CSRBootstrapUsers             []string
+	OCMVersion                    string
+	ImageRegistry                 string
+	ImagePullSecret               string
+	SpokeHTTPSProxy               string
+	SpokeNoProxy                  []string
+	SpokeProxyCA                  string
+	ExecAllowedCommands           []string
+	ManagedServiceAccountRole     string
+	KubeFlexContext               string
+	KubeFlexInCluster             bool
+	KubeFlexDiscovery             bool
+
+	VaultAddr             string
+	VaultToken            string
+	VaultRole             string
+	VaultAuthMount        string
+	VaultKVVersion        int
+	EncryptionKeySecret   string
+	SOPSAgeKeyFile        string
+	SOPSGnuPGHome         string
+	GCPProject            string
+	GCPCredentialsFile    string
+	ClusterProxyURL       string
+	ClusterProxyCA        string
+	PublicURL             string
+	MessageBus            string
+	MessageBusTopic       string
+	NATSURL               string
+	KafkaRESTProxyURL     string
+	CloudEventsSink       string
+	CloudEventsHub        bool
+	SlackWebhookURL       string
+	TeamsWebhookURL       string
+	NotifyOn              []string
+	PagerDutyRoutingKey   string
+	OpsgenieAPIKey        string
+	OpsgenieAPIURL        string
+	OTLPEndpoint          string
+	OTelServiceName       string
+	JWTJWKSURL            string
+	JWTIssuer             string
+	JWTAudience           string
+	RBACEnabled           bool
+	RBACPermissionsClaim  string
+	RBACPermissionsHeader string
+	RBACTrustProxyHeader  bool
+	RateLimitPerMinute    int
+	RateLimitBurst        int
+	MaxRequestBytes       int
+	CORSAllowedOrigins    []string
+	CORSAllowedMethods    []string
+	CORSAllowedHeaders    []string
+	CORSAllowCredentials  bool
+	CORSMaxAge            int
+	EnablePprof           bool
+}
+
+// configParser reads typed values from the raw configuration, collecting a
+// problem for every value that has the wrong type or is out of range
+type configParser struct {
+	config   map[string]interface{}
+	problems []string
+}
+
+func (p *configParser) problem(key string, format string, args ...interface{}) {
+	p.problems = append(p.problems, key+": "+fmt.Sprintf(format, args...))
+}
+
+func (p *configParser) str(key, defaultValue string) string {
+	switch value := p.config[key].(type) {
+	case nil:
+	case string:
+		if value != "" {
+			return value
+		}
+	default:
+		p.problem(key, "expected a string, got %v", value)
+	}
+	return defaultValue
+}
+
+func (p *configParser) boolean(key string, defaultValue bool) bool {
+	switch value := p.config[key].(type) {
+	case nil:
+	case bool:
+		return value
+	case string:
+		parsed, err := strconv.ParseBool(value)
+		if err == nil {
+			return parsed
+		}
+		p.problem(key, "expected true or false, got %q", value)
+	default:
+		p.problem(key, "expected true or false, got %v", value)
+	}
+	return defaultValue
+}
+
+// integer reads an integer in [min, max]; max < min means unbounded
+func (p *configParser) integer(key string, defaultValue, min, max int) int {
+	parsed := defaultValue
+	switch value := p.config[key].(type) {
+	case nil:
+		return defaultValue
+	case int:
+		parsed = value
+	case int64:
+		parsed = int(value)
+	case float64:
+		if value != float64(int(value)) {
+			p.problem(key, "expected an integer, got %v", value)
+			return defaultValue
+		}
+		parsed = int(value)
+	case string:
+		var err error
+		if parsed, err = strconv.Atoi(value); err != nil {
+			p.problem(key, "expected an integer, got %q", value)
+			return defaultValue
+		}
+	default:
+		p.problem(key, "expected an integer, got %v", value)
+		return defaultValue
+	}
+	if parsed < min || (max >= min && parsed > max) {
+		if max >= min {
+			p.problem(key, "must be between %d and %d, got %d", min, max, parsed)
+		} else {
+			p.problem(key, "must be at least %d, got %d", min, parsed)
+		}
+		return defaultValue
+	}
+	return parsed
+}
+
+// duration reads a positive duration such as "90s"
+func (p *configParser) duration(key string, defaultValue time.Duration) time.Duration {
+	raw := p.str(key, "")
+	if raw == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		p.problem(key, "invalid duration %q, expected a value such as 90s or 5m", raw)
+		return defaultValue
+	}
+	if parsed <= 0 {
+		p.problem(key, "must be positive, got %s", raw)
+		return defaultValue
+	}
+	return parsed
+}
+
+func (p *configParser) oneOf(key, defaultValue string, allowed ...string) string {
+	value := p.str(key, defaultValue)
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	p.problem(key, "unsupported value %q, expected one of %s", value, strings.Join(allowed, ", "))
+	return defaultValue
+}
+
+// url reads an absolute URL with one of the given schemes
+func (p *configParser) url(key, defaultValue string, schemes ...string) string {
+	value := p.str(key, defaultValue)
+	if value == "" {
+		return value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" || !containsFold(schemes, parsed.Scheme) {
+		p.problem(key, "invalid URL %q, expected %s://host", value, strings.Join(schemes, " or "))
+		return defaultValue
+	}
+	return value
+}
+
+func (p *configParser) list(key string, defaultValue []string) []string {
+	var values []string
+	switch value := p.config[key].(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				p.problem(key, "expected a list of strings, got item %v", item)
+				continue
+			}
+			if strings.TrimSpace(s) != "" {
+				values = append(values, strings.TrimSpace(s))
+			}
+		}
+	case []string:
+		values = append(values, value...)
+	case string:
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) != "" {
+				values = append(values, strings.TrimSpace(item))
+			}
+		}
+	default:
+		p.problem(key, "expected a list or a comma-separated string, got %v", value)
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
+// require records a problem when key is empty although the setting named
+// by reason needs it
+func (p *configParser) require(key, value, reason string) {
+	if value == "" {
+		p.problem(key, "is required when %s", reason)
+	}
+}
+
+// parsePluginConfig parses and validates the configuration passed to
+// Initialize. Every problem found is reported in a single error.
+func parsePluginConfig(config map[string]interface{}) (PluginConfig, error) {
+	p := &configParser{config: config}
+	settings := PluginConfig{
+		Timeout:             p.duration("timeout", stepTimeout),
+		Retries:             p.integer("retries", defaultWebhookRetries, 0, 10),
+		ValidateSSL:         p.boolean("validate_ssl", true),
+		ClusterNamespace:    p.str("cluster_namespace", defaultClusterNamespace),
+		ITSContext:          p.str("its_context", defaultHubContext),
+		KubeconfigDir:       p.str("kubeconfig_dir", defaultKubeconfigDir),
+		KeepFailedArtifacts: p.boolean("keep_failed_artifacts", false),
+		ArtifactRetention:   p.duration("artifact_retention", defaultArtifactRetention),
+		LogLevel:            p.str("log_level", "info"),
+		ConfigFile:          p.str("config_file", ""),
+		StateBackend:        p.oneOf("state_backend", "memory", "memory", "hub", "redis"),
+		StateRedisURL:       p.url("state_redis_url", "", "redis", "rediss"),
+		StateSyncInterval:   p.duration("state_sync_interval", defaultStateSyncInterval),
+		GRPCPort:            p.integer("grpc_port", 0, 0, 65535),
+		EventRetention:      p.duration("event_retention", defaultEventRetention),
+		EventBufferSize:     p.integer("event_buffer_size", defaultEventBufferSize, 1, 100000),
+		GRPCTLSCertFile:     p.str("grpc_tls_cert_file", ""),
+		GRPCTLSKeyFile:      p.str("grpc_tls_key_file", ""),
+
+		OnboardConcurrency:            p.integer("onboard_concurrency", defaultOnboardConcurrency, 1, 100),
+		AcceptMode:                    p.oneOf("accept_mode", acceptModeCSR, acceptModeCSR, acceptModeClusteradm),
+		ManualApproval:                p.boolean("manual_approval", false),
+		ApprovalTimeout:               p.duration("approval_timeout", defaultApprovalTimeout),
+		CSRTimeout:                    p.duration("csr_timeout", defaultCSRTimeout),
+		CSRApprovalPolicy:             p.oneOf("csr_approval_policy", csrApproveVerified, csrApproveAlways, csrApproveNever, csrApproveVerified),
+		JoinTokenTTL:                  p.duration("join_token_ttl", defaultJoinTokenTTL),
+		RegistrationCodeTTL:           p.duration("registration_code_ttl", defaultRegistrationTTL),
+		PrecreatedClusterTTL:          p.duration("precreated_cluster_ttl", defaultPrecreatedTTL),
+		ManagedServiceAccountValidity: p.duration("managed_serviceaccount_validity", defaultManagedServiceAccountValidity),
+		ControllerMode:                p.boolean("controller_mode", false),
+		SimulationMode:                p.boolean("simulation_mode", false),
+		AccessLog:                     p.boolean("access_log", true),
+		AccessLogSamplePercent:        p.integer("access_log_sample_percent", 100, 0, 100),
+		CSRBootstrapUsers:             p.list("csr_bootstrap_users", defaultCSRBootstrapUsers),
+		OCMVersion:                    p.str("ocm_version", defaultOCMVersion),
+		ImageRegistry:                 p.str("image_registry", ""),
+		ImagePullSecret:               p.str("image_pull_secret", ""),
+		SpokeHTTPSProxy:               p.str("spoke_https_proxy", ""),
+		SpokeNoProxy:                  p.list("spoke_no_proxy", nil),
+		SpokeProxyCA:                  p.str("spoke_proxy_ca", ""),
+		ExecAllowedCommands:           p.list("exec_allowed_commands", nil),
+		ManagedServiceAccountRole:     p.str("managed_serviceaccount_cluster_role", defaultManagedServiceAccountRole),
+		KubeFlexContext:               p.str("kubeflex_context", defaultKubeFlexContext),
+		KubeFlexInCluster:             p.boolean("kubeflex_in_cluster", false),
+		KubeFlexDiscovery:             p.boolean("kubeflex_discovery", false),
+
+		VaultAddr:             p.url("vault_addr", "", "http", "https"),
+		VaultToken:            p.str("vault_token", ""),
+		VaultRole:             p.str("vault_role", ""),
+		VaultAuthMount:        p.str("vault_auth_mount", defaultVaultKubernetesMount),
+		VaultKVVersion:        p.integer("vault_kv_version", 2, 1, 2),
+		EncryptionKeySecret:   p.str("encryption_key_secret", ""),
+		SOPSAgeKeyFile:        p.str("sops_age_key_file", ""),
+		SOPSGnuPGHome:         p.str("sops_gnupg_home", ""),
+		GCPProject:            p.str("gcp_project", ""),
+		GCPCredentialsFile:    p.str("gcp_credentials_file", ""),
+		ClusterProxyURL:       p.url("cluster_proxy_url", defaultClusterProxyURL, "https"),
+		ClusterProxyCA:        p.str("cluster_proxy_ca", ""),
+		PublicURL:             p.url("public_url", "", "http", "https"),
+		MessageBus:            p.oneOf("message_bus", "none", "none", "nats", "kafka"),
+		MessageBusTopic:       p.str("message_bus_topic", defaultBusSubject),
+		NATSURL:               p.url("nats_url", "", "nats"),
+		KafkaRESTProxyURL:     p.url("kafka_rest_proxy_url", "", "http", "https"),
+		CloudEventsSink:       p.url("cloudevents_sink", "", "http", "https"),
+		CloudEventsHub:        p.boolean("cloudevents_hub_events", false),
+		SlackWebhookURL:       p.url("slack_webhook_url", "", "https"),
+		TeamsWebhookURL:       p.url("teams_webhook_url", "", "https"),
+		NotifyOn:              p.list("notify_on", defaultNotifyOn),
+		PagerDutyRoutingKey:   p.str("pagerduty_routing_key", ""),
+		OpsgenieAPIKey:        p.str("opsgenie_api_key", ""),
+		OpsgenieAPIURL:        p.url("opsgenie_api_url", defaultOpsgenieAPIURL, "http", "https"),
+		OTLPEndpoint:          p.url("otlp_endpoint", "", "http", "https"),
+		OTelServiceName:       p.str("otel_service_name", "cluster-ops-plugin"),
+		JWTJWKSURL:            p.url("jwt_jwks_url", "", "https", "http"),
+		JWTIssuer:             p.str("jwt_issuer", ""),
+		JWTAudience:           p.str("jwt_audience", ""),
+		RBACEnabled:           p.boolean("rbac_enabled", false),
+		RBACPermissionsClaim:  p.str("rbac_permissions_claim", "permissions"),
+		RBACPermissionsHeader: p.str("rbac_permissions_header", ""),
+		RBACTrustProxyHeader:  p.boolean("rbac_trust_proxy_header", false),
+		RateLimitPerMinute:    p.integer("rate_limit_per_minute", defaultRateLimitPerMinute, 0, -1),
+		RateLimitBurst:        p.integer("rate_limit_burst", defaultRateLimitBurst, 0, -1),
+		MaxRequestBytes:       p.integer("max_request_body_bytes", defaultMaxRequestBytes, 1, -1),
+		CORSAllowedOrigins:    p.list("cors_allowed_origins", nil),
+		CORSAllowedMethods:    p.list("cors_allowed_methods", defaultCORSMethods),
+		CORSAllowedHeaders:    p.list("cors_allowed_headers", defaultCORSHeaders),
+		CORSAllowCredentials:  p.boolean("cors_allow_credentials", false),
+		CORSMaxAge:            p.integer("cors_max_age", 600, 0, -1),
+		EnablePprof:           p.boolean("enable_pprof", false),
+	}
+
+	if _, err := parseLogLevel(settings.LogLevel); err != nil {
+		p.problem("log_level", "%v", err)
+	}
+	for _, category := range settings.NotifyOn {
+		switch strings.ToLower(category) {
+		case notifyStarted, notifySucceeded, notifyFailed, notifyCancelled, notifyUnavailable:
+		default:
+			p.problem("notify_on", "unsupported category %q", category)
+		}
+	}
+	switch settings.MessageBus {
+	case "nats":
+		p.require("nats_url", settings.NATSURL, "message_bus is nats")
+	case "kafka":
+		p.require("kafka_rest_proxy_url", settings.KafkaRESTProxyURL, "message_bus is kafka")
+	}
+	if settings.StateBackend == "redis" {
+		p.require("state_redis_url", settings.StateRedisURL, "state_backend is redis")
+	}
+	if settings.GRPCTLSCertFile != "" || settings.GRPCTLSKeyFile != "" {
+		p.require("grpc_tls_cert_file", settings.GRPCTLSCertFile, "grpc_tls_key_file is set")
+		p.require("grpc_tls_key_file", settings.GRPCTLSKeyFile, "grpc_tls_cert_file is set")
+	}
+	if settings.CloudEventsHub {
+		p.require("cloudevents_sink", settings.CloudEventsSink, "cloudevents_hub_events is set")
+	}
+	if settings.JWTJWKSURL != "" {
+		p.require("jwt_issuer", settings.JWTIssuer, "jwt_jwks_url is set")
+		p.require("jwt_audience", settings.JWTAudience, "jwt_jwks_url is set")
+	}
+
+	if len(p.problems) > 0 {
+		return settings, fmt.Errorf("invalid configuration: %s", strings.Join(p.problems, "; "))
+	}
+	return settings, nil
+}
+
+// settings returns the typed configuration of the plugin
+func (cp *ClusterOpsPlugin) settings() PluginConfig {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	return cp.pluginConfig
+}
+
+// applyEnvOverrides returns a copy of config in which every key named by a
+// KS_PLUGIN_* variable of environ takes the value of that variable, along
+// with the overridden keys. Values are strings, which every scalar key
+// accepts; values starting with { or [ are decoded as JSON so structured
+// keys such as hubs can be set too. The host configuration is not modified.
+func applyEnvOverrides(config map[string]interface{}, environ []string) (map[string]interface{}, []string) {
+	merged := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		merged[key] = value
+	}
+
+	var overridden []string
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, configEnvPrefix) || len(name) == len(configEnvPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, configEnvPrefix))
+		merged[key] = value
+		if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(trimmed), &decoded); err == nil {
+				merged[key] = decoded
+			}
+		}
+		overridden = append(overridden, key)
+	}
+	sort.Strings(overridden)
+	return merged, overridden
+}
+
+// redactedConfig returns a copy of the effective configuration with the
+// values of credential keys replaced
+func (cp *ClusterOpsPlugin) redactedConfig() map[string]interface{} {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+
+	redacted := make(map[string]interface{}, len(cp.config))
+	for key, value := range cp.config {
+		if isSensitiveField(key) && value != nil && value != "" {
+			redacted[key] = redactedValue
+			continue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func (cp *ClusterOpsPlugin) GetConfigHandler(c *gin.Context) {
+	mutable := make([]string, 0, len(reloadableKeys))
+	for key := range reloadableKeys {
+		mutable = append(mutable, key)
+	}
+	sort.Strings(mutable)
+
+	settings := cp.settings()
+	cp.mutex.RLock()
+	overridden := append([]string{}, cp.envOverrides...)
+	cp.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"config":  cp.redactedConfig(),
+		"mutable": mutable,
+		"sources": gin.H{
+			"configFile":  settings.ConfigFile,
+			"environment": overridden,
+		},
+		"plugin": "cluster-ops-plugin",
+	})
+}
+
+// UpdateConfigHandler changes mutable settings. A null value reverts a key
+// to its default. Changes are not persisted: a restart, or a change to
+// config_file setting the same key, replaces them.
+func (cp *ClusterOpsPlugin) UpdateConfigHandler(c *gin.Context) {
+	var changes map[string]interface{}
+	if err := c.ShouldBindJSON(&changes); err != nil || len(changes) == 0 {
+		details := "no settings given"
+		if err != nil {
+			details = err.Error()
+		}
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid request body", details))
+		return
+	}
+
+	var immutable []string
+	for key := range changes {
+		if !reloadableKeys[key] {
+			immutable = append(immutable, key)
+		}
+	}
+	if len(immutable) > 0 {
+		sort.Strings(immutable)
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Settings cannot be changed at runtime", immutable))
+		return
+	}
+
+	cp.mutex.RLock()
+	updated := make(map[string]interface{}, len(cp.config)+len(changes))
+	for key, value := range cp.config {
+		updated[key] = value
+	}
+	cp.mutex.RUnlock()
+	applied := make([]string, 0, len(changes))
+	for key, value := range changes {
+		if value == nil {
+			delete(updated, key)
+		} else {
+			updated[key] = value
+		}
+		applied = append(applied, key)
+	}
+	sort.Strings(applied)
+
+	if err := cp.applyConfig(updated); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid configuration", err.Error()))
+		return
+	}
+	cp.logger.InfoContext(c.Request.Context(), "Configuration updated through the API", "actor", cp.requestActor(c), "applied", strings.Join(applied, ","))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Configuration updated",
+		"applied": applied,
+		"config":  cp.redactedConfig(),
+		"plugin":  "cluster-ops-plugin",
+	})
+}
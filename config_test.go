@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestApplyConfigRejectsInvalidConfig(t *testing.T) {
+	cp := newSimulatedPlugin(t)
+	running := cp.settings()
+
+	if err := cp.applyConfig(map[string]interface{}{"csr_timeout": "soon"}); err == nil {
+		t.Fatal("a csr_timeout that is not a duration was applied")
+	}
+	if cp.settings().CSRTimeout != running.CSRTimeout {
+		t.Errorf("csr_timeout changed to %s", cp.settings().CSRTimeout)
+	}
+
+	if err := cp.applyConfig(map[string]interface{}{"csr_timeout": "90s", "csr_approval_policy": "always"}); err != nil {
+		t.Fatal(err)
+	}
+	if cp.csrTimeout().String() != "1m30s" || cp.csrApprovalPolicy() != csrApproveAlways {
+		t.Errorf("settings not applied: csr_timeout %s, csr_approval_policy %s", cp.csrTimeout(), cp.csrApprovalPolicy())
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// onboardingGroup is the API group of the ClusterOnboarding resource
+	onboardingGroup = "clusterops.kubestellar.io"
+	// onboardingResource names ClusterOnboardings unambiguously for kubectl
+	onboardingResource = "clusteronboardings." + onboardingGroup
+	// onboardingPollInterval is how often the status of a ClusterOnboarding
+	// is refreshed from its operation
+	onboardingPollInterval = 5 * time.Second
+)
+
+// ClusterOnboarding phases
+const (
+	OnboardingPhaseOnboarding = "Onboarding"
+	OnboardingPhaseOnboarded  = "Onboarded"
+	OnboardingPhaseFailed     = "Failed"
+)
+
+// secretKeyRef points at a key of a Secret in the namespace of the
+// ClusterOnboarding
+type secretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// clusterOnboardingSpec is an onboarding request; clusterName defaults to the
+// name of the resource. KubeconfigSecretRef lets the kubeconfig come from a
+// Secret so it never has to be committed with the resource.
+type clusterOnboardingSpec struct {
+	ClusterOnboardRequest
+	KubeconfigSecretRef *secretKeyRef `json:"kubeconfigSecretRef,omitempty"`
+}
+
+// clusterOnboardingStatus reports the progress of a ClusterOnboarding
+type clusterOnboardingStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Phase              string             `json:"phase,omitempty"`
+	ClusterName        string             `json:"clusterName,omitempty"`
+	OperationID        string             `json:"operationId,omitempty"`
+	CurrentStep        string             `json:"currentStep,omitempty"`
+	Conditions         []clusterCondition `json:"conditions,omitempty"`
+}
+
+// clusterOnboarding is the ClusterOnboarding custom resource
+type clusterOnboarding struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec   clusterOnboardingSpec   `json:"spec"`
+	Status clusterOnboardingStatus `json:"status"`
+}
+
+func (co *clusterOnboarding) key() string {
+	return co.Metadata.Namespace + "/" + co.Metadata.Name
+}
+
+// onboardingController tracks the onboardings started for ClusterOnboardings
+type onboardingController struct {
+	// handled maps a resource key to the last onboarding started or
+	// rejected for it
+	handled map[string]*handledOnboarding
+	mutex   sync.Mutex
+}
+
+// handledOnboarding is the onboarding of a generation of a ClusterOnboarding
+// and the status last written for it. The informer may still deliver the
+// resource as it was before that status, so it is reconciled against this
+// record rather than its own status.
+type handledOnboarding struct {
+	operationID string
+	status      clusterOnboardingStatus
+	done        bool
+}
+
+// onboardingReconciler reconciles the ClusterOnboardings of a hub from its
+// informer
+type onboardingReconciler struct {
+	cp       *ClusterOpsPlugin
+	hub      HubConfig
+	informer cache.SharedIndexInformer
+}
+
+// onboardingCRDManifest is the ClusterOnboarding CRD. The spec is left
+// unvalidated since the plugin validates it as it would an HTTP request.
+func onboardingCRDManifest() map[string]interface{} {
+	column := func(name, path string) map[string]string {
+		return map[string]string{"name": name, "type": "string", "jsonPath": path}
+	}
+	return map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": onboardingResource},
+		"spec": map[string]interface{}{
+			"group": onboardingGroup,
+			"scope": "Namespaced",
+			"names": map[string]interface{}{
+				"kind":       "ClusterOnboarding",
+				"listKind":   "ClusterOnboardingList",
+				"plural":     "clusteronboardings",
+				"singular":   "clusteronboarding",
+				"shortNames": []string{"cob"},
+			},
+			"versions": []interface{}{map[string]interface{}{
+				"name":         "v1alpha1",
+				"served":       true,
+				"storage":      true,
+				"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+				"additionalPrinterColumns": []interface{}{
+					column("Cluster", ".status.clusterName"),
+					column("Phase", ".status.phase"),
+					column("Step", ".status.currentStep"),
+				},
+				"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{
+					"type":                                 "object",
+					"x-kubernetes-preserve-unknown-fields": true,
+				}},
+			}},
+		},
+	}
+}
+
+// runOnboardingController installs the ClusterOnboarding CRD on the default
+// hub and onboards clusters as resources are created or their spec changes,
+// until ctx is cancelled. Deleting a resource cancels its onboarding but does
+// not detach the cluster.
+func (cp *ClusterOpsPlugin) runOnboardingController(ctx context.Context) {
+	hub, err := cp.lookupHub("")
+	if err != nil {
+		cp.logger.Warn("Onboarding controller has no hub", "error", err)
+		return
+	}
+	ctx = withHub(ctx, hub)
+	if err := cp.applyHubObject(ctx, onboardingCRDManifest()); err != nil {
+		cp.logger.Warn("Installing the ClusterOnboarding CRD failed", "hub", hub.Name, "error", err)
+	}
+
+	failed := func(err error) {
+		cp.mutex.Lock()
+		defer cp.mutex.Unlock()
+		cp.metrics["onboarding_controller_error"] = err.Error()
+		cp.logger.Warn("ClusterOnboarding controller failed", "hub", hub.Name, "error", err)
+	}
+	informer, err := cp.newHubInformer(hub, clusterOnboardingGVR, metav1.NamespaceAll)
+	if err != nil {
+		failed(err)
+		return
+	}
+	informer.SetWatchErrorHandlerWithContext(func(_ context.Context, _ *cache.Reflector, err error) {
+		failed(err)
+	})
+	ctrl, err := controller.NewUnmanaged("clusteronboarding", controller.Options{
+		Reconciler:         &onboardingReconciler{cp: cp, hub: hub, informer: informer},
+		Logger:             logr.FromSlogHandler(cp.logger.Handler()),
+		SkipNameValidation: ptr.To(true),
+	})
+	if err == nil {
+		err = ctrl.Watch(&source.Informer{Informer: informer, Handler: &handler.EnqueueRequestForObject{}})
+	}
+	if err != nil {
+		failed(err)
+		return
+	}
+
+	// The informer retries until the CRD is served; the controller starts
+	// once it has listed the resources
+	go informer.RunWithContext(ctx)
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return
+	}
+	if err := ctrl.Start(ctx); err != nil {
+		failed(err)
+	}
+}
+
+// Reconcile starts the onboarding of a ClusterOnboarding unless its current
+// generation is already being or has been handled, and mirrors the progress
+// of a running onboarding into its status. Spec changes made while an
+// onboarding runs are applied once it finishes.
+func (r *onboardingReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	cp := r.cp
+	ctx = withHub(ctx, r.hub)
+	key := req.String()
+
+	obj, exists, err := r.informer.GetStore().GetByKey(key)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	cp.controller.mutex.Lock()
+	handled := cp.controller.handled[key]
+	if !exists {
+		delete(cp.controller.handled, key)
+	}
+	cp.controller.mutex.Unlock()
+	if !exists {
+		if handled != nil && !handled.done {
+			cp.operations.Cancel(handled.operationID)
+		}
+		return reconcile.Result{}, nil
+	}
+	var co clusterOnboarding
+	if err := decodeUnstructured(obj, &co); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if handled != nil && !handled.done {
+		if !cp.mirrorClusterOnboarding(ctx, &co, handled) {
+			return reconcile.Result{RequeueAfter: onboardingPollInterval}, nil
+		}
+		co.Status = handled.status
+	}
+	if handled != nil && handled.status.ObservedGeneration == co.Metadata.Generation {
+		return reconcile.Result{}, nil
+	}
+	if co.Status.ObservedGeneration == co.Metadata.Generation &&
+		(co.Status.Phase == OnboardingPhaseOnboarded || co.Status.Phase == OnboardingPhaseFailed) {
+		return reconcile.Result{}, nil
+	}
+
+	onboard := co.Spec.ClusterOnboardRequest
+	if onboard.ClusterName == "" {
+		onboard.ClusterName = co.Metadata.Name
+	}
+	status := clusterOnboardingStatus{
+		ObservedGeneration: co.Metadata.Generation,
+		ClusterName:        onboard.ClusterName,
+		Conditions:         co.Status.Conditions,
+	}
+	if record, ok := cp.clusters.Get(onboard.ClusterName); ok && record.State == StateOnboarded {
+		status.Phase = OnboardingPhaseOnboarded
+		status.Conditions = setCondition(status.Conditions, "Ready", "True", "AlreadyOnboarded", "The cluster is already onboarded")
+		cp.patchOnboardingStatus(ctx, &co, status)
+		cp.controller.track(key, &handledOnboarding{status: status, done: true})
+		return reconcile.Result{}, nil
+	}
+
+	if ref := co.Spec.KubeconfigSecretRef; ref != nil {
+		kubeconfig, err := cp.readSecretKey(ctx, co.Metadata.Namespace, ref)
+		if err != nil {
+			cp.rejectClusterOnboarding(ctx, &co, status, "KubeconfigUnavailable", err.Error())
+			return reconcile.Result{}, nil
+		}
+		onboard.Kubeconfig = kubeconfig
+	}
+	// A resource left onboarding by a previous plugin instance picks up
+	// where its operation stopped
+	onboard.Resume = onboard.Resume || co.Status.Phase == OnboardingPhaseOnboarding
+
+	op, rejected := cp.beginOnboarding(context.Background(), "", onboard)
+	if rejected != nil {
+		cp.rejectClusterOnboarding(ctx, &co, status, "Rejected", rejected.message())
+		return reconcile.Result{}, nil
+	}
+	cp.logEvent(onboard.ClusterName, "controller", "info", fmt.Sprintf("Onboarding started for ClusterOnboarding %s", key))
+
+	status.Phase = OnboardingPhaseOnboarding
+	status.OperationID = op.ID
+	status.Conditions = setCondition(status.Conditions, "Accepted", "True", "OperationStarted", fmt.Sprintf("Operation %s started", op.ID))
+	cp.patchOnboardingStatus(ctx, &co, status)
+	cp.controller.track(key, &handledOnboarding{operationID: op.ID, status: status})
+	return reconcile.Result{RequeueAfter: onboardingPollInterval}, nil
+}
+
+// track records the onboarding handled for a resource
+func (c *onboardingController) track(key string, handled *handledOnboarding) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handled[key] = handled
+}
+
+// rejectClusterOnboarding marks a ClusterOnboarding whose onboarding could not start
+func (cp *ClusterOpsPlugin) rejectClusterOnboarding(ctx context.Context, co *clusterOnboarding, status clusterOnboardingStatus, reason, message string) {
+	status.Phase = OnboardingPhaseFailed
+	status.Conditions = setCondition(status.Conditions, "Accepted", "False", reason, message)
+	status.Conditions = setCondition(status.Conditions, "Ready", "False", reason, message)
+	cp.patchOnboardingStatus(ctx, co, status)
+	cp.controller.track(co.key(), &handledOnboarding{status: status, done: true})
+	cp.logEvent(status.ClusterName, "controller", "failed", fmt.Sprintf("ClusterOnboarding %s rejected: %s", co.key(), message))
+}
+
+// mirrorClusterOnboarding writes the progress of a running onboarding into
+// the status of its ClusterOnboarding and reports whether it has finished
+func (cp *ClusterOpsPlugin) mirrorClusterOnboarding(ctx context.Context, co *clusterOnboarding, handled *handledOnboarding) bool {
+	op, ok := cp.operations.Get(handled.operationID)
+	if !ok {
+		handled.done = true
+		return true
+	}
+
+	next := handled.status
+	next.CurrentStep = ""
+	for _, step := range op.Steps {
+		if step.Status == OperationRunning {
+			next.CurrentStep = step.Name
+		}
+	}
+	switch op.Status {
+	case OperationSucceeded:
+		next.Phase = OnboardingPhaseOnboarded
+		next.Conditions = setCondition(next.Conditions, "Progressing", "False", "Completed", op.Result)
+		next.Conditions = setCondition(next.Conditions, "Ready", "True", "Onboarded", op.Result)
+	case OperationFailed, OperationCancelled:
+		reason := "Failed"
+		if op.Status == OperationCancelled {
+			reason = "Cancelled"
+		}
+		next.Phase = OnboardingPhaseFailed
+		next.Conditions = setCondition(next.Conditions, "Progressing", "False", reason, op.Error)
+		next.Conditions = setCondition(next.Conditions, "Ready", "False", reason, op.Error)
+	default:
+		message := "Waiting for a worker"
+		if next.CurrentStep != "" {
+			message = "Running step " + next.CurrentStep
+		}
+		next.Conditions = setCondition(next.Conditions, "Progressing", "True", "StepRunning", message)
+		next.Conditions = setCondition(next.Conditions, "Ready", "False", "Onboarding", message)
+	}
+
+	if !statusEqual(handled.status, next) {
+		cp.patchOnboardingStatus(ctx, co, next)
+		handled.status = next
+	}
+	handled.done = op.isTerminal()
+	return handled.done
+}
+
+// setCondition sets a condition, keeping its transition time when its status
+// does not change
+func setCondition(conditions []clusterCondition, conditionType, status, reason, message string) []clusterCondition {
+	updated := clusterCondition{Type: conditionType, Status: status, Reason: reason, Message: message, LastTransitionTime: time.Now().UTC().Truncate(time.Second)}
+	result := make([]clusterCondition, 0, len(conditions)+1)
+	found := false
+	for _, condition := range conditions {
+		if condition.Type == conditionType {
+			if condition.Status == status {
+				updated.LastTransitionTime = condition.LastTransitionTime
+			}
+			result = append(result, updated)
+			found = true
+			continue
+		}
+		result = append(result, condition)
+	}
+	if !found {
+		result = append(result, updated)
+	}
+	return result
+}
+
+func statusEqual(a, b clusterOnboardingStatus) bool {
+	left, _ := json.Marshal(a)
+	right, _ := json.Marshal(b)
+	return string(left) == string(right)
+}
+
+// patchOnboardingStatus writes the status subresource of a ClusterOnboarding
+func (cp *ClusterOpsPlugin) patchOnboardingStatus(ctx context.Context, co *clusterOnboarding, status clusterOnboardingStatus) {
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return
+	}
+	if _, err := cp.kubectlHub(ctx, "patch", onboardingResource, co.Metadata.Name, "-n", co.Metadata.Namespace,
+		"--subresource=status", "--type=merge", "-p", string(patch)); err != nil {
+		cp.logger.Warn("Updating ClusterOnboarding status failed", "resource", co.key(), "error", err)
+	}
+}
+
+// readSecretKey returns a key of a Secret on the hub; the key defaults to
+// kubeconfig
+func (cp *ClusterOpsPlugin) readSecretKey(ctx context.Context, namespace string, ref *secretKeyRef) (string, error) {
+	key := ref.Key
+	if key == "" {
+		key = "kubeconfig"
+	}
+	out, err := cp.kubectlHub(ctx, "get", "secret", ref.Name, "-n", namespace,
+		"-o", fmt.Sprintf("jsonpath={.data.%s}", strings.ReplaceAll(key, ".", `\.`)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s/%s: %w", namespace, ref.Name, err)
+	}
+	if len(out) == 0 {
+		return "", fmt.Errorf("secret %s/%s has no key %s", namespace, ref.Name, key)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(out))
+	if err != nil {
+		return "", fmt.Errorf("secret %s/%s key %s is not valid base64: %v", namespace, ref.Name, key, err)
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kubestellar/ui/dynamic_plugins"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID", "traceparent"}
+)
+
+// withPreflightEndpoints adds an OPTIONS endpoint for every path so the host
+// routes CORS preflight requests to the plugin
+func withPreflightEndpoints(endpoints []dynamic_plugins.EndpointConfig) []dynamic_plugins.EndpointConfig {
+	seen := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		if seen[endpoint.Path] || endpoint.Method == http.MethodOptions {
+			seen[endpoint.Path] = true
+			continue
+		}
+		seen[endpoint.Path] = true
+		endpoints = append(endpoints, dynamic_plugins.EndpointConfig{
+			Path:        endpoint.Path,
+			Method:      http.MethodOptions,
+			Handler:     "CORSPreflightHandler",
+			Description: "CORS preflight",
+		})
+	}
+	return endpoints
+}
+
+// corsOrigin returns the value of Access-Control-Allow-Origin for a request
+// origin, or "" when the origin is not allowed
+func (cp *ClusterOpsPlugin) corsOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	settings := cp.settings()
+	for _, allowed := range settings.CORSAllowedOrigins {
+		if allowed == "*" && !settings.CORSAllowCredentials {
+			return "*"
+		}
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// setCORSHeaders adds the CORS response headers for an allowed origin and
+// reports whether the origin is allowed
+func (cp *ClusterOpsPlugin) setCORSHeaders(c *gin.Context) bool {
+	origin := cp.corsOrigin(c.GetHeader("Origin"))
+	if origin == "" {
+		return false
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	if origin != "*" {
+		c.Header("Vary", "Origin")
+	}
+	if cp.settings().CORSAllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	c.Header("Access-Control-Expose-Headers", "Retry-After, "+requestIDHeader)
+	return true
+}
+
+// withCORS wraps a handler so its responses carry the CORS headers allowed
+// by cors_allowed_origins
+func (cp *ClusterOpsPlugin) withCORS(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.setCORSHeaders(c)
+		handler(c)
+	}
+}
+
+// CORSPreflightHandler answers CORS preflight requests with the configured
+// methods and headers. Preflights are not authenticated, as browsers send
+// them without credentials.
+func (cp *ClusterOpsPlugin) CORSPreflightHandler(c *gin.Context) {
+	if !cp.setCORSHeaders(c) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	settings := cp.settings()
+	c.Header("Access-Control-Allow-Methods", strings.Join(settings.CORSAllowedMethods, ", "))
+	c.Header("Access-Control-Allow-Headers", strings.Join(settings.CORSAllowedHeaders, ", "))
+	c.Header("Access-Control-Max-Age", strconv.Itoa(settings.CORSMaxAge))
+	c.Status(http.StatusNoContent)
+}
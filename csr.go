@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultCSRTimeout bounds how long onboarding waits for the agent's CSR
+	defaultCSRTimeout = 5 * time.Minute
+	csrInitialBackoff = 2 * time.Second
+	csrMaxBackoff     = 30 * time.Second
+	// csrClusterLabel is set by the registration agent on its CSRs
+	csrClusterLabel = "open-cluster-management.io/cluster-name"
+	// csrSignerName is the signer OCM registration agents request
+	csrSignerName = "kubernetes.io/kube-apiserver-client"
+	// managedClustersGroup is the organization of every OCM agent certificate
+	managedClustersGroup = "system:open-cluster-management:managed-clusters"
+)
+
+// CSR approval policies
+const (
+	csrApproveAlways   = "always"
+	csrApproveNever    = "never"
+	csrApproveVerified = "verified-only"
+)
+
+// defaultCSRBootstrapUsers are the identities clusteradm join uses to submit
+// the first CSR of an agent
+var defaultCSRBootstrapUsers = []string{
+	"system:serviceaccount:open-cluster-management:cluster-bootstrap",
+	"system:serviceaccount:open-cluster-management:agent-registration-bootstrap",
+}
+
+// certificateSigningRequest is the subset of a CSR the plugin reads
+type certificateSigningRequest struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Request    []byte   `json:"request"`
+		SignerName string   `json:"signerName"`
+		Username   string   `json:"username"`
+		Groups     []string `json:"groups"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []struct {
+			Type string `json:"type"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// condition reports whether the CSR has a condition of the given type
+func (csr *certificateSigningRequest) condition(conditionType string) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAgentCSR checks that a CSR was submitted by the registration agent
+// of clusterName: the client signer, a bootstrap identity or the cluster's own
+// agent identity, and a subject naming the cluster in its CN and
+// organizations. A matching label alone is easy to spoof.
+func verifyAgentCSR(csr *certificateSigningRequest, clusterName string, bootstrapUsers []string) error {
+	if csr.Metadata.Labels[csrClusterLabel] != clusterName {
+		return fmt.Errorf("label %s does not name cluster %s", csrClusterLabel, clusterName)
+	}
+	if csr.Spec.SignerName != csrSignerName {
+		return fmt.Errorf("signer %q is not %s", csr.Spec.SignerName, csrSignerName)
+	}
+
+	clusterGroup := "system:open-cluster-management:" + clusterName
+	renewal := strings.HasPrefix(csr.Spec.Username, clusterGroup+":") && slices.Contains(csr.Spec.Groups, clusterGroup)
+	if !renewal && !slices.Contains(bootstrapUsers, csr.Spec.Username) {
+		return fmt.Errorf("requester %q is neither a bootstrap identity nor an agent of cluster %s", csr.Spec.Username, clusterName)
+	}
+
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil {
+		return fmt.Errorf("request does not contain a PEM certificate request")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid certificate request: %v", err)
+	}
+	if !strings.HasPrefix(request.Subject.CommonName, clusterGroup+":") {
+		return fmt.Errorf("subject CN %q does not belong to cluster %s", request.Subject.CommonName, clusterName)
+	}
+	if !slices.Contains(request.Subject.Organization, clusterGroup) || !slices.Contains(request.Subject.Organization, managedClustersGroup) {
+		return fmt.Errorf("subject organizations %v must include %s and %s", request.Subject.Organization, clusterGroup, managedClustersGroup)
+	}
+	return nil
+}
+
+// csrApprovalPolicy returns the configured csr_approval_policy
+func (cp *ClusterOpsPlugin) csrApprovalPolicy() string {
+	return cp.settings().CSRApprovalPolicy
+}
+
+// approveClusterCSR waits for the registration CSR of a cluster and approves
+// it as soon as it appears, unless csr_approval_policy is never, in which
+// case it waits for the CSR to be approved by someone else. The watch is
+// restarted with exponential backoff when it ends early, until csr_timeout
+// elapses.
+func (cp *ClusterOpsPlugin) approveClusterCSR(ctx context.Context, clusterName string) error {
+	timeout := cp.csrTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := csrInitialBackoff
+	for {
+		err := cp.watchClusterCSRs(ctx, clusterName)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("no certificate signing request from cluster %s was approved within %s", clusterName, timeout)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		cp.logger.WarnContext(ctx, "CSR watch failed, retrying", "cluster", clusterName, "backoff", backoff.String(), "error", err)
+
+		select {
+		case <-ctx.Done():
+			continue
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, csrMaxBackoff)
+	}
+}
+
+// watchClusterCSRs watches the CSRs labelled with the cluster name and
+// returns once one of them is approved, by the plugin according to
+// csr_approval_policy or by someone else
+func (cp *ClusterOpsPlugin) watchClusterCSRs(ctx context.Context, clusterName string) error {
+	policy := cp.csrApprovalPolicy()
+	bootstrapUsers := cp.settings().CSRBootstrapUsers
+	rejected := make(map[string]bool)
+
+	stdout, wait, err := cp.hub.Watch(ctx, "get", "csr", "-l", csrClusterLabel+"="+clusterName,
+		"--watch", "--output-watch-events", "-o", "json")
+	if err != nil {
+		return fmt.Errorf("failed to start CSR watch: %v", err)
+	}
+	defer wait()
+
+	decoder := json.NewDecoder(stdout)
+	for {
+		var event struct {
+			Type   string                    `json:"type"`
+			Object certificateSigningRequest `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("CSR watch ended")
+			}
+			return fmt.Errorf("failed to decode CSR watch event: %v", err)
+		}
+		if event.Type != "ADDED" && event.Type != "MODIFIED" {
+			continue
+		}
+
+		csr := event.Object
+		switch {
+		case csr.condition("Approved"):
+			return nil
+		case csr.condition("Denied") || csr.condition("Failed"), policy == csrApproveNever, rejected[csr.Metadata.Name]:
+			continue
+		}
+		if policy == csrApproveVerified {
+			if err := verifyAgentCSR(&csr, clusterName, bootstrapUsers); err != nil {
+				rejected[csr.Metadata.Name] = true
+				cp.logEvent(clusterName, "csr", "warning", fmt.Sprintf("Not approving certificate signing request %s: %v", csr.Metadata.Name, err))
+				continue
+			}
+		}
+		if _, err := cp.kubectlHub(ctx, "certificate", "approve", csr.Metadata.Name); err != nil {
+			return err
+		}
+		cp.logEvent(clusterName, "csr", "success", fmt.Sprintf("Approved certificate signing request %s", csr.Metadata.Name))
+		return nil
+	}
+}
+
+// csrTimeout returns how long onboarding waits for a cluster's CSR
+func (cp *ClusterOpsPlugin) csrTimeout() time.Duration {
+	return cp.settings().CSRTimeout
+}
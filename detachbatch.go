@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchDetachRequest is the payload of POST /detach/batch. Clusters are
+// named explicitly or matched by LabelSelector against the ManagedClusters
+// of the hubs.
+type BatchDetachRequest struct {
+	Clusters      []string `json:"clusters,omitempty"`
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	// Hub restricts the detachment to one hub; empty selects on every hub
+	Hub     string `json:"hub,omitempty"`
+	Cleanup bool   `json:"cleanup,omitempty"`
+	Force   bool   `json:"force,omitempty"`
+	// DryRun only reports the clusters that would be detached
+	DryRun bool `json:"dryRun,omitempty"`
+	// Confirm must echo the confirmationToken of a dry run when clusters are
+	// chosen by selector, so the set cannot change between review and detach
+	Confirm string `json:"confirm,omitempty"`
+}
+
+// detachTarget is a cluster chosen by a bulk detach
+type detachTarget struct {
+	ClusterName string `json:"clusterName"`
+	Hub         string `json:"hub"`
+	State       string `json:"state"`
+}
+
+// confirmationToken identifies a set of detach targets
+func confirmationToken(targets []detachTarget) string {
+	hash := sha256.New()
+	for _, target := range targets {
+		fmt.Fprintf(hash, "%s/%s\n", target.Hub, target.ClusterName)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// selectDetachTargets resolves the clusters of a bulk detach. ManagedClusters
+// matched on a hub that the plugin does not track yet are seeded so they can
+// be detached like any other cluster.
+func (cp *ClusterOpsPlugin) selectDetachTargets(c *gin.Context, req BatchDetachRequest) ([]detachTarget, *requestError) {
+	var targets []detachTarget
+	if len(req.Clusters) > 0 {
+		seen := map[string]bool{}
+		for _, name := range req.Clusters {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			target := detachTarget{ClusterName: name, Hub: req.Hub, State: describeState(stateUntracked)}
+			if record, ok := cp.clusters.Get(name); ok {
+				target.Hub = cp.clusterHub(name).Name
+				target.State = describeState(record.State)
+			}
+			targets = append(targets, target)
+		}
+		return targets, nil
+	}
+
+	selector, err := parseLabelSelector(req.LabelSelector)
+	if err != nil {
+		return nil, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid labelSelector", err.Error())}
+	}
+	if len(selector) == 0 {
+		return nil, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "labelSelector must not be empty", nil)}
+	}
+	hubs := cp.hubList()
+	if req.Hub != "" {
+		hub, err := cp.lookupHub(req.Hub)
+		if err != nil {
+			return nil, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid hub", err.Error())}
+		}
+		hubs = []HubConfig{hub}
+	}
+
+	for _, hub := range hubs {
+		clusters, err := cp.listManagedClusters(withHub(c.Request.Context(), hub))
+		if err != nil {
+			return nil, &requestError{http.StatusBadGateway, errorResponse(codeHubUnreachable, fmt.Sprintf("Failed to list ManagedClusters on hub %s", hub.Name), err.Error())}
+		}
+		for i := range clusters {
+			mc := &clusters[i]
+			if !matchLabels(selector, mc.Metadata.Labels) {
+				continue
+			}
+			cp.clusters.Seed(recordFromManagedCluster(mc, hub.Name, "Discovered on the hub"))
+			record, _ := cp.clusters.Get(mc.Metadata.Name)
+			targets = append(targets, detachTarget{
+				ClusterName: mc.Metadata.Name,
+				Hub:         hub.Name,
+				State:       describeState(record.State),
+			})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].ClusterName != targets[j].ClusterName {
+			return targets[i].ClusterName < targets[j].ClusterName
+		}
+		return targets[i].Hub < targets[j].Hub
+	})
+	return targets, nil
+}
+
+func (cp *ClusterOpsPlugin) BatchDetachHandler(c *gin.Context) {
+	var req BatchDetachRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if (len(req.Clusters) > 0) == (strings.TrimSpace(req.LabelSelector) != "") {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Provide exactly one of clusters or labelSelector", nil))
+		return
+	}
+
+	targets, rejected := cp.selectDetachTargets(c, req)
+	if rejected != nil {
+		c.JSON(rejected.status, rejected.body)
+		return
+	}
+	token := confirmationToken(targets)
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"dryRun":            true,
+			"clusters":          targets,
+			"count":             len(targets),
+			"confirmationToken": token,
+			"plugin":            "cluster-ops-plugin",
+		})
+		return
+	}
+	if len(targets) == 0 {
+		c.JSON(http.StatusNotFound, errorResponse(codeNotFound, "No clusters match the request", nil))
+		return
+	}
+	if req.LabelSelector != "" && req.Confirm != token {
+		c.JSON(http.StatusPreconditionFailed, errorResponse(codeConflict, "Confirmation required", "Run the request with dryRun to review the matching clusters, then pass its confirmationToken as confirm"))
+		return
+	}
+
+	opts := detachOptions{cleanup: req.Cleanup, force: req.Force}
+	batch := Batch{
+		ID:        "batch-" + strings.TrimPrefix(newOperationID(), "op-"),
+		Kind:      "detach",
+		CreatedBy: cp.requestActor(c),
+		CreatedAt: time.Now(),
+	}
+	for _, target := range targets {
+		// Named clusters are checked against the requested hub, not the
+		// hub they were found on
+		hubName := target.Hub
+		if len(req.Clusters) > 0 {
+			hubName = req.Hub
+		}
+		item := BatchItem{ClusterName: target.ClusterName}
+		op, rejected := cp.beginDetach(c.Request.Context(), c.GetHeader("traceparent"), target.ClusterName, hubName, opts)
+		if rejected != nil {
+			item.Status = batchRejected
+			item.Error = rejected.message()
+		} else {
+			item.OperationID = op.ID
+			item.Status = op.Status
+		}
+		batch.Items = append(batch.Items, item)
+	}
+	cp.batches.Add(batch)
+	batch = cp.batchProgress(batch)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        fmt.Sprintf("Detachment of %d clusters started", len(batch.Items)-batch.Summary[batchRejected]),
+		"batch":          batch,
+		"statusEndpoint": fmt.Sprintf("%s/detach/batch/%s", pluginAPIBase, batch.ID),
+		"plugin":         "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) GetDetachBatchHandler(c *gin.Context) {
+	cp.writeBatch(c, "detach")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PprofHandler serves the net/http/pprof profiles when the enable_pprof
+// configuration option is set
+func (cp *ClusterOpsPlugin) PprofHandler(c *gin.Context) {
+	if !cp.settings().EnablePprof {
+		c.JSON(http.StatusNotFound, errorResponse(codeNotFound, "Profiling is disabled; set enable_pprof in the plugin configuration", nil))
+		return
+	}
+
+	// The host mounts the plugin below its own prefix, so the profile name is
+	// taken from the route parameter rather than left to pprof.Index
+	switch profile := strings.Trim(c.Param("profile"), "/"); profile {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(profile).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func (cp *ClusterOpsPlugin) RuntimeDiagnosticsHandler(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	eventClusters, events, subscribers, overwritten := cp.events.Stats()
+
+	c.JSON(http.StatusOK, gin.H{
+		"goroutines":       runtime.NumGoroutine(),
+		"activeOperations": cp.operations.CountActive(),
+		"trackedClusters":  len(cp.clusters.List()),
+		"eventStore": gin.H{
+			"clusters":    eventClusters,
+			"events":      events,
+			"subscribers": subscribers,
+			"bufferSize":  cp.settings().EventBufferSize,
+			"overwritten": overwritten,
+		},
+		"memory": gin.H{
+			"allocBytes":     mem.Alloc,
+			"heapInuseBytes": mem.HeapInuse,
+			"heapObjects":    mem.HeapObjects,
+			"sysBytes":       mem.Sys,
+			"numGC":          mem.NumGC,
+		},
+		"kubeconfig": gin.H{
+			"home":      homeDir(),
+			"paths":     kubeconfigPaths(),
+			"inCluster": inCluster(),
+		},
+		"goVersion": runtime.Version(),
+		"os":        runtime.GOOS,
+		"uptime":    time.Since(cp.uptime).String(),
+		"plugin":    "cluster-ops-plugin",
+	})
+}
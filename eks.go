@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// cloudImportTimeout bounds the cloud API calls that build the kubeconfig of
+// an imported managed cluster
+const cloudImportTimeout = 30 * time.Second
+
+// eksTokenPrefix marks a bearer token as a presigned STS request, the format
+// aws-iam-authenticator and `aws eks get-token` produce
+const eksTokenPrefix = "k8s-aws-v1."
+
+// eksClusterName matches the names EKS accepts for clusters
+var eksClusterName = regexp.MustCompile(`^[0-9A-Za-z][A-Za-z0-9\-_]{0,99}$`)
+
+// EKSClusterRef identifies an EKS cluster to import. Name defaults to the
+// clusterName of the request and Region to AWS_REGION. The plugin uses its
+// ambient AWS credentials, assuming RoleARN first when one is given.
+type EKSClusterRef struct {
+	Name    string `json:"name,omitempty"`
+	Region  string `json:"region,omitempty"`
+	RoleARN string `json:"roleArn,omitempty"`
+}
+
+func (ref *EKSClusterRef) validate() error {
+	if !eksClusterName.MatchString(ref.Name) {
+		return fmt.Errorf("invalid EKS cluster name %q", ref.Name)
+	}
+	if ref.Region == "" {
+		return fmt.Errorf("eks requires a region when AWS_REGION is not set")
+	}
+	if ref.RoleARN != "" && !strings.HasPrefix(ref.RoleARN, "arn:aws") {
+		return fmt.Errorf("roleArn must be an IAM role ARN, got %q", ref.RoleARN)
+	}
+	return nil
+}
+
+// eksKubeconfig looks up the endpoint and CA of an EKS cluster and returns a
+// kubeconfig authenticating with an IAM token minted by the plugin. The
+// token is valid for 15 minutes, which covers the join; clusters that need
+// later spoke access should also enable managedServiceAccount. The IAM
+// principal must be granted access to the cluster through an access entry
+// or the aws-auth ConfigMap.
+func (cp *ClusterOpsPlugin) eksKubeconfig(ctx context.Context, clusterName string, ref EKSClusterRef) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudImportTimeout)
+	defer cancel()
+
+	creds, err := ambientAWSCredentials(ctx, ref.Region)
+	if err != nil {
+		return "", err
+	}
+	if ref.RoleARN != "" {
+		if creds, err = assumeAWSRole(ctx, creds, ref.Region, ref.RoleARN); err != nil {
+			return "", err
+		}
+	}
+
+	endpoint, caData, err := describeEKSCluster(ctx, creds, ref)
+	if err != nil {
+		return "", err
+	}
+	token := eksToken(creds, ref.Region, ref.Name, time.Now())
+	return synthesizeKubeconfig(clusterName, endpoint, caData, map[string]string{"token": token})
+}
+
+// assumeAWSRole exchanges credentials for those of an IAM role through STS
+func assumeAWSRole(ctx context.Context, creds awsCredentials, region, roleARN string) (awsCredentials, error) {
+	form := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {roleARN},
+		"RoleSessionName": {"cluster-ops-plugin"},
+	}
+	body := []byte(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://sts.%s.amazonaws.com/", region), strings.NewReader(string(body)))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signAWSRequest(req, body, creds, region, "sts", time.Now())
+
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleResult>Credentials"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to assume role %s: %v", roleARN, err)
+	}
+	return awsCredentials{resp.Credentials.AccessKeyID, resp.Credentials.SecretAccessKey, resp.Credentials.SessionToken}, nil
+}
+
+// describeEKSCluster returns the API server endpoint and base64 CA bundle of
+// an active EKS cluster
+func describeEKSCluster(ctx context.Context, creds awsCredentials, ref EKSClusterRef) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://eks.%s.amazonaws.com/clusters/%s", ref.Region, ref.Name), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signAWSRequest(req, nil, creds, ref.Region, "eks", time.Now())
+
+	var resp struct {
+		Cluster struct {
+			Endpoint             string `json:"endpoint"`
+			Status               string `json:"status"`
+			CertificateAuthority struct {
+				Data string `json:"data"`
+			} `json:"certificateAuthority"`
+		} `json:"cluster"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return "", "", fmt.Errorf("failed to describe EKS cluster %s: %v", ref.Name, err)
+	}
+	if resp.Cluster.Status != "ACTIVE" {
+		return "", "", fmt.Errorf("EKS cluster %s is %s, not ACTIVE", ref.Name, resp.Cluster.Status)
+	}
+	if resp.Cluster.Endpoint == "" {
+		return "", "", fmt.Errorf("EKS cluster %s has no public endpoint", ref.Name)
+	}
+	return resp.Cluster.Endpoint, resp.Cluster.CertificateAuthority.Data, nil
+}
+
+// eksToken builds a bearer token for an EKS cluster: a presigned STS
+// GetCallerIdentity URL bound to the cluster name through the x-k8s-aws-id
+// header, which the cluster's authenticator replays to identify the caller
+func eksToken(creds awsCredentials, region, clusterName string, now time.Time) string {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	scope := date + "/" + region + "/sts/aws4_request"
+	host := "sts." + region + ".amazonaws.com"
+
+	query := url.Values{
+		"Action":              {"GetCallerIdentity"},
+		"Version":             {"2011-06-15"},
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {creds.accessKeyID + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {"60"},
+		"X-Amz-SignedHeaders": {"host;x-k8s-aws-id"},
+	}
+	if creds.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	payloadHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		"/",
+		canonicalQuery,
+		"host:" + host + "\nx-k8s-aws-id:" + clusterName + "\n",
+		"host;x-k8s-aws-id",
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signature := awsSignature(creds, date, region, "sts", stringToSign)
+
+	presigned := "https://" + host + "/?" + canonicalQuery + "&X-Amz-Signature=" + signature
+	return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned))
+}
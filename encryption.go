@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// encryptionKeyEnv holds the base64 AES-256 key used to encrypt new data
+	encryptionKeyEnv = "CLUSTER_OPS_ENCRYPTION_KEY"
+	// previousEncryptionKeysEnv holds comma-separated retired keys that are
+	// still accepted for decryption during a rotation
+	previousEncryptionKeysEnv = "CLUSTER_OPS_PREVIOUS_ENCRYPTION_KEYS"
+	// encryptedPrefix marks values encrypted by the plugin
+	encryptedPrefix = "enc:v1:"
+	// keyringRefresh is how long keys loaded from a hub Secret are cached
+	keyringRefresh = time.Minute
+	// kubeconfigKeyIDAnnotation records the key that encrypted a stored kubeconfig
+	kubeconfigKeyIDAnnotation = "cluster-ops.kubestellar.io/encryption-key-id"
+)
+
+// encryptionKeyring holds the key used for encryption and every key still
+// accepted for decryption, indexed by key ID
+type encryptionKeyring struct {
+	primaryID string
+	keys      map[string]cipher.AEAD
+}
+
+// keyID derives a short identifier for a key that does not reveal it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// newEncryptionKeyring builds a keyring from base64 AES-256 keys
+func newEncryptionKeyring(primary string, previous []string) (*encryptionKeyring, error) {
+	keyring := &encryptionKeyring{keys: make(map[string]cipher.AEAD)}
+	for i, encoded := range append([]string{primary}, previous...) {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption keys must be 32 bytes encoded as base64")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if i == 0 {
+			keyring.primaryID = id
+		}
+		keyring.keys[id] = aead
+	}
+	return keyring, nil
+}
+
+// Encrypt seals plaintext with the primary key
+func (k *encryptionKeyring) Encrypt(plaintext []byte) (string, error) {
+	aead := k.keys[k.primaryID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(k.primaryID))
+	return encryptedPrefix + k.primaryID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt with whichever key sealed it
+func (k *encryptionKeyring) Decrypt(value string) ([]byte, error) {
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("value was encrypted with unknown key %s", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value with key %s", id)
+	}
+	return plaintext, nil
+}
+
+// isEncrypted reports whether a value was produced by encryptionKeyring.Encrypt
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// keyringCache loads the keyring from the environment or from the hub Secret
+// named by encryption_key_secret, caching keys read from the hub
+type keyringCache struct {
+	keyring  *encryptionKeyring
+	loadedAt time.Time
+	mutex    sync.Mutex
+}
+
+// encryptionKeyring returns the configured keyring, or nil when at-rest
+// encryption is disabled. Environment keys take precedence over the Secret,
+// whose "key" field holds the primary key and "previous-keys" the retired ones.
+func (cp *ClusterOpsPlugin) encryptionKeyring(ctx context.Context) (*encryptionKeyring, error) {
+	if primary := os.Getenv(encryptionKeyEnv); primary != "" {
+		return newEncryptionKeyring(primary, strings.Split(os.Getenv(previousEncryptionKeysEnv), ","))
+	}
+	ref := cp.settings().EncryptionKeySecret
+	if ref == "" {
+		return nil, nil
+	}
+
+	cache := cp.keyrings
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.keyring != nil && time.Since(cache.loadedAt) < keyringRefresh {
+		return cache.keyring, nil
+	}
+
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid encryption_key_secret %q, expected namespace/name", ref)
+	}
+	out, err := cp.kubectlHub(ctx, "get", "secret", name, "-n", namespace, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %v", err)
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(out, &secret); err != nil {
+		return nil, fmt.Errorf("failed to decode Secret %s: %v", ref, err)
+	}
+	primary, err := base64.StdEncoding.DecodeString(secret.Data["key"])
+	if err != nil || len(primary) == 0 {
+		return nil, fmt.Errorf("Secret %s has no key field", ref)
+	}
+	previous, _ := base64.StdEncoding.DecodeString(secret.Data["previous-keys"])
+
+	keyring, err := newEncryptionKeyring(string(primary), strings.Split(string(previous), ","))
+	if err != nil {
+		return nil, err
+	}
+	cache.keyring = keyring
+	cache.loadedAt = time.Now()
+	return keyring, nil
+}
+
+// storedKubeconfigNames lists the clusters with a kubeconfig stored on the hub
+func (cp *ClusterOpsPlugin) storedKubeconfigNames(ctx context.Context) ([]string, error) {
+	out, err := cp.kubectlHub(ctx, "get", "secrets", "-n", cp.clusterNamespace(), "-l", "app.kubernetes.io/managed-by=cluster-ops-plugin", "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode Secret list: %v", err)
+	}
+
+	var names []string
+	for _, item := range list.Items {
+		if name := item.Metadata.Labels["cluster-ops.kubestellar.io/cluster"]; name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// RotateEncryptionHandler re-encrypts every stored kubeconfig with the
+// current primary key. Operators rotate by making the new key primary and
+// listing the old one as a previous key, calling this endpoint, and then
+// dropping the old key once it reports no failures.
+func (cp *ClusterOpsPlugin) RotateEncryptionHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	keyring, err := cp.encryptionKeyring(ctx)
+	if err != nil || keyring == nil {
+		details := "no encryption key is configured"
+		if err != nil {
+			details = err.Error()
+		}
+		c.JSON(http.StatusConflict, errorResponse(codeConflict, "At-rest encryption is not available", details))
+		return
+	}
+
+	names, err := cp.storedKubeconfigNames(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to list stored kubeconfigs", err.Error()))
+		return
+	}
+
+	failures := gin.H{}
+	rotated := 0
+	for _, name := range names {
+		stored, err := cp.loadKubeconfig(ctx, name)
+		if err == nil {
+			err = cp.storeKubeconfig(ctx, name, stored.Kubeconfig)
+		}
+		if err != nil {
+			failures[name] = err.Error()
+			continue
+		}
+		rotated++
+	}
+
+	status := http.StatusOK
+	if len(failures) > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"keyId":    keyring.primaryID,
+		"rotated":  rotated,
+		"failed":   len(failures),
+		"failures": failures,
+		"plugin":   "cluster-ops-plugin",
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+// eventRing holds the latest events of a cluster in a circular buffer of
+// fixed capacity. The buffer grows as events arrive; once full, every new
+// event overwrites the oldest one, so a cluster raising events in a loop
+// uses bounded memory.
+type eventRing struct {
+	buf      []OnboardingEvent
+	capacity int
+	// start is the index of the oldest event once the buffer is full
+	start int
+}
+
+func newEventRing(capacity int) *eventRing {
+	return &eventRing{capacity: capacity}
+}
+
+// push appends an event, reporting whether the oldest event was overwritten
+// to make room for it
+func (r *eventRing) push(event OnboardingEvent) bool {
+	if len(r.buf) < r.capacity {
+		r.buf = append(r.buf, event)
+		return false
+	}
+	r.buf[r.start] = event
+	r.start = (r.start + 1) % len(r.buf)
+	return true
+}
+
+func (r *eventRing) len() int {
+	return len(r.buf)
+}
+
+// list returns a copy of the events, oldest first
+func (r *eventRing) list() []OnboardingEvent {
+	events := make([]OnboardingEvent, 0, len(r.buf))
+	events = append(events, r.buf[r.start:]...)
+	return append(events, r.buf[:r.start]...)
+}
+
+// reset replaces the content of the ring with events, keeping the latest
+// ones that fit in capacity
+func (r *eventRing) reset(events []OnboardingEvent, capacity int) {
+	if len(events) > capacity {
+		events = events[len(events)-capacity:]
+	}
+	r.buf = append(make([]OnboardingEvent, 0, len(events)), events...)
+	r.capacity = capacity
+	r.start = 0
+}
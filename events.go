@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventSubscriberBuffer is the number of events buffered per live subscriber
+// before new events are dropped for that subscriber
+const eventSubscriberBuffer = 64
+
+const (
+	// defaultEventRetention is how long events are kept unless
+	// event_retention says otherwise
+	defaultEventRetention = 7 * 24 * time.Hour
+	// defaultEventBufferSize is how many events are kept per cluster unless
+	// event_buffer_size says otherwise
+	defaultEventBufferSize = 500
+	// eventSweepInterval is how often events past retention are removed
+	eventSweepInterval = 5 * time.Minute
+)
+
+// OnboardingEvent records a single step of a cluster onboarding or detachment
+type OnboardingEvent struct {
+	// ID increases with every event of a cluster, starting at 1, and
+	// serves as the cursor of event pages and streams
+	ID          int64  `json:"id"`
+	ClusterName string `json:"clusterName"`
+	Type        string `json:"type"`
+	Status      string `json:"status"`
+	// Level is info, warn or error, derived from Status
+	Level string `json:"level"`
+	// Step is the pipeline step the event belongs to, e.g. validate, join,
+	// csr or verify
+	Step        string `json:"step,omitempty"`
+	OperationID string `json:"operationId,omitempty"`
+	// DurationMs is how long the step ran, on events that finish a step
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Message    string `json:"message"`
+	// Remediation suggests how to fix a known cause of a failure
+	Remediation string `json:"remediation,omitempty"`
+	// RequestID is the X-Request-ID of the request that started the
+	// operation of the event
+	RequestID string    `json:"requestId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// eventStore keeps the per-cluster event history and fans new events out to
+// live subscribers such as WebSocket streams
+type eventStore struct {
+	events map[string]*eventRing
+	// capacity is the size of the ring buffer of each cluster
+	capacity int
+	// overwritten counts the events dropped because a buffer was full
+	overwritten int64
+	// lastIDs holds the ID of the latest event of each cluster
+	lastIDs     map[string]int64
+	subscribers map[string]map[chan OnboardingEvent]struct{}
+	// onChange is called with the store locked after an event is appended,
+	// so it must not block or use the store
+	onChange func(clusterName string)
+	mutex    sync.RWMutex
+}
+
+func newEventStore() *eventStore {
+	return &eventStore{
+		events:      make(map[string]*eventRing),
+		capacity:    defaultEventBufferSize,
+		lastIDs:     make(map[string]int64),
+		subscribers: make(map[string]map[chan OnboardingEvent]struct{}),
+	}
+}
+
+// Append assigns the next ID of its cluster to an event, stores it and
+// delivers it to every subscriber of its cluster and to the subscribers of
+// all clusters. It returns the stored event. Once the buffer of the
+// cluster is full, the oldest event is overwritten.
+// Slow subscribers never block the caller; events that do not fit in their
+// buffer are dropped for that subscriber only.
+func (s *eventStore) Append(event OnboardingEvent) OnboardingEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastIDs[event.ClusterName]++
+	event.ID = s.lastIDs[event.ClusterName]
+	ring := s.events[event.ClusterName]
+	if ring == nil {
+		ring = newEventRing(s.capacity)
+		s.events[event.ClusterName] = ring
+	}
+	if ring.push(event) {
+		s.overwritten++
+	}
+	s.deliver(event)
+	if s.onChange != nil {
+		s.onChange(event.ClusterName)
+	}
+	return event
+}
+
+// deliver sends an event to the subscribers of its cluster and of all
+// clusters without blocking
+func (s *eventStore) deliver(event OnboardingEvent) {
+	for _, key := range []string{event.ClusterName, allClusters} {
+		for ch := range s.subscribers[key] {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// Restore replaces the history of a cluster with one received from another
+// replica. Events newer than the latest known one are delivered to local
+// subscribers, so streams follow operations running elsewhere.
+func (s *eventStore) Restore(clusterName string, events []OnboardingEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ring := newEventRing(s.capacity)
+	ring.reset(events, s.capacity)
+	s.events[clusterName] = ring
+	for _, event := range events {
+		if event.ID > s.lastIDs[clusterName] {
+			s.lastIDs[clusterName] = event.ID
+			s.deliver(event)
+		}
+	}
+}
+
+// Prune removes the events older than cutoff and returns how many were
+// removed. IDs are not reused after pruning.
+func (s *eventStore) Prune(cutoff time.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for clusterName, ring := range s.events {
+		events := ring.list()
+		start := sort.Search(len(events), func(i int) bool {
+			return !events[i].Timestamp.Before(cutoff)
+		})
+		if start == 0 {
+			continue
+		}
+		removed += start
+		if start == len(events) {
+			delete(s.events, clusterName)
+		} else {
+			ring.reset(events[start:], s.capacity)
+		}
+		if s.onChange != nil {
+			s.onChange(clusterName)
+		}
+	}
+	return removed
+}
+
+// SetCapacity changes the size of the buffer of every cluster. Shrinking
+// keeps the latest events.
+func (s *eventStore) SetCapacity(capacity int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if capacity == s.capacity {
+		return
+	}
+	s.capacity = capacity
+	for _, ring := range s.events {
+		ring.reset(ring.list(), capacity)
+	}
+}
+
+// Clear removes the event history of a cluster and returns how many events
+// it held
+func (s *eventStore) Clear(clusterName string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	if ring := s.events[clusterName]; ring != nil {
+		removed = ring.len()
+	}
+	delete(s.events, clusterName)
+	if removed > 0 && s.onChange != nil {
+		s.onChange(clusterName)
+	}
+	return removed
+}
+
+// sweepEvents periodically removes the events older than event_retention
+func (cp *ClusterOpsPlugin) sweepEvents(ctx context.Context) {
+	ticker := time.NewTicker(eventSweepInterval)
+	defer ticker.Stop()
+	for {
+		if removed := cp.events.Prune(time.Now().Add(-cp.settings().EventRetention)); removed > 0 {
+			cp.logger.Info("Removed events past retention", "events", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cp *ClusterOpsPlugin) ClearClusterEventsHandler(c *gin.Context) {
+	clusterName := c.Param("name")
+	removed := cp.events.Clear(clusterName)
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName": clusterName,
+		"deleted":     removed,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
+
+// List returns a copy of the event history for a cluster
+func (s *eventStore) List(clusterName string) []OnboardingEvent {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ring := s.events[clusterName]
+	if ring == nil {
+		return []OnboardingEvent{}
+	}
+	return ring.list()
+}
+
+// allClusters subscribes to the events of every cluster; cluster names are
+// never empty
+const allClusters = ""
+
+// Subscribe returns the current history of a cluster together with a channel
+// receiving every event appended afterwards. Subscribing to allClusters
+// returns the history of every cluster ordered by time. The returned
+// function must be called to release the subscription.
+func (s *eventStore) Subscribe(clusterName string) ([]OnboardingEvent, <-chan OnboardingEvent, func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var history []OnboardingEvent
+	if ring := s.events[clusterName]; ring != nil {
+		history = ring.list()
+	}
+	if clusterName == allClusters {
+		for _, ring := range s.events {
+			history = append(history, ring.list()...)
+		}
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Timestamp.Before(history[j].Timestamp)
+		})
+	}
+
+	ch := make(chan OnboardingEvent, eventSubscriberBuffer)
+	if s.subscribers[clusterName] == nil {
+		s.subscribers[clusterName] = make(map[chan OnboardingEvent]struct{})
+	}
+	s.subscribers[clusterName][ch] = struct{}{}
+
+	unsubscribe := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.subscribers[clusterName], ch)
+		if len(s.subscribers[clusterName]) == 0 {
+			delete(s.subscribers, clusterName)
+		}
+	}
+	return history, ch, unsubscribe
+}
+
+// logEvent records an event for a cluster with the current timestamp and
+// writes it to the plugin log
+func (cp *ClusterOpsPlugin) logEvent(clusterName, eventType, status, message string) {
+	cp.logOperationEvent("", clusterName, eventType, status, message)
+}
+
+// logOperationEvent records an event raised by an operation, tagging its log
+// line with the operation ID
+func (cp *ClusterOpsPlugin) logOperationEvent(operationID, clusterName, eventType, status, message string) {
+	cp.recordEvent(OnboardingEvent{
+		ClusterName: clusterName,
+		Type:        eventType,
+		Status:      status,
+		OperationID: operationID,
+		Message:     message,
+	})
+}
+
+// logStepEvent records an event raised by a pipeline step. duration is how
+// long the step ran when the event finishes it, and zero otherwise.
+func (cp *ClusterOpsPlugin) logStepEvent(operationID, clusterName, step, status, message string, duration time.Duration) {
+	cp.recordEvent(OnboardingEvent{
+		ClusterName: clusterName,
+		Type:        step,
+		Status:      status,
+		Step:        step,
+		OperationID: operationID,
+		DurationMs:  duration.Milliseconds(),
+		Message:     message,
+	})
+}
+
+// recordEvent timestamps an event, stores it, publishes it on the message
+// bus and writes it to the plugin log
+func (cp *ClusterOpsPlugin) recordEvent(event OnboardingEvent) {
+	event.Timestamp = time.Now()
+	event.Level = eventLevel(event)
+	if event.RequestID == "" && event.OperationID != "" {
+		if op, ok := cp.operations.Get(event.OperationID); ok {
+			event.RequestID = op.RequestID
+		}
+	}
+	event = cp.events.Append(event)
+	cp.publishToBus("event", event.ClusterName, event)
+
+	attrs := []any{"cluster", event.ClusterName, "type", event.Type, "status", event.Status}
+	if event.OperationID != "" {
+		attrs = append(attrs, "operationId", event.OperationID)
+	}
+	if event.Step != "" {
+		attrs = append(attrs, "step", event.Step)
+	}
+	if event.DurationMs > 0 {
+		attrs = append(attrs, "durationMs", event.DurationMs)
+	}
+	cp.logger.Log(withRequestID(context.Background(), event.RequestID), slogLevel(event.Level), event.Message, attrs...)
+}
+
+// Event levels, ordered from least to most severe
+const (
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+var levelSeverity = map[string]int{
+	levelInfo:  0,
+	levelWarn:  1,
+	levelError: 2,
+}
+
+// eventLevel returns the level of an event, deriving it from the status of
+// events recorded before events carried one
+func eventLevel(event OnboardingEvent) string {
+	if event.Level != "" {
+		return event.Level
+	}
+	switch event.Status {
+	case "failed", "error":
+		return levelError
+	case "warning":
+		return levelWarn
+	default:
+		return levelInfo
+	}
+}
+
+// eventsAfter returns the events of a cluster history with an ID greater
+// than afterID
+func eventsAfter(events []OnboardingEvent, afterID int64) []OnboardingEvent {
+	start := sort.Search(len(events), func(i int) bool {
+		return events[i].ID > afterID
+	})
+	return events[start:]
+}
+
+// parseAfterID reads an afterId cursor; empty means from the beginning
+func parseAfterID(raw string) (int64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	afterID, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || afterID < 0 {
+		return 0, fmt.Errorf("must be a non-negative event ID")
+	}
+	return afterID, nil
+}
+
+// filterEvents returns the events at or above minLevel that happened after since.
+// An empty minLevel or zero since disables the corresponding filter.
+func filterEvents(events []OnboardingEvent, minLevel string, since time.Time) []OnboardingEvent {
+	filtered := make([]OnboardingEvent, 0, len(events))
+	for _, event := range events {
+		if minLevel != "" && levelSeverity[eventLevel(event)] < levelSeverity[minLevel] {
+			continue
+		}
+		if !since.IsZero() && !event.Timestamp.After(since) {
+			continue
+		}
+		filtered = append(filtered, event)
+	}
+	return filtered
+}
+
+// Stats reports the size of the store for diagnostics
+func (s *eventStore) Stats() (clusters, events, subscribers int, overwritten int64) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, ring := range s.events {
+		events += ring.len()
+	}
+	for _, subs := range s.subscribers {
+		subscribers += len(subs)
+	}
+	return len(s.events), events, subscribers, s.overwritten
+}
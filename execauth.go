@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// execCredentialTimeout bounds a kubeconfig exec credential plugin, which
+// would otherwise hang on interactive prompts
+const execCredentialTimeout = 20 * time.Second
+
+// execHints suggests how to obtain a token for well-known exec plugins
+var execHints = map[string]string{
+	"aws":                    "run `aws eks get-token` for a short-lived token, or create a ServiceAccount token on the cluster,",
+	"aws-iam-authenticator":  "run `aws eks get-token` for a short-lived token, or create a ServiceAccount token on the cluster,",
+	"gke-gcloud-auth-plugin": "run `gcloud auth print-access-token` for a short-lived token, or create a ServiceAccount token on the cluster,",
+	"kubelogin":              "run `kubelogin get-token` for a short-lived token, or create a ServiceAccount token on the cluster,",
+	"az":                     "run `az account get-access-token` for a short-lived token, or create a ServiceAccount token on the cluster,",
+}
+
+// kubeconfigExec is the exec section of a kubeconfig user
+type kubeconfigExec struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+	InteractiveMode string `yaml:"interactiveMode"`
+}
+
+// execCredential is the ExecCredential printed by an exec plugin
+type execCredential struct {
+	Status struct {
+		Token                 string `json:"token"`
+		ClientCertificateData string `json:"clientCertificateData"`
+		ClientKeyData         string `json:"clientKeyData"`
+	} `json:"status"`
+}
+
+// execOnboardingHint is appended to every exec related error
+func execOnboardingHint(command string) string {
+	hint, ok := execHints[filepath.Base(command)]
+	if !ok {
+		hint = "create a ServiceAccount token on the cluster"
+	}
+	return fmt.Sprintf("%s and onboard with server and token instead", hint)
+}
+
+// resolveExecCredentials replaces the exec or auth-provider section of a
+// kubeconfig user with the credentials it yields. Exec plugins only run when
+// their command is listed in exec_allowed_commands and installed on the
+// plugin host; legacy auth providers are always rejected.
+func resolveExecCredentials(user *kubeconfigUser, allowed []string, runner CommandRunner) error {
+	if user.AuthProvider != nil {
+		return fmt.Errorf("kubeconfig uses a legacy auth-provider, which the plugin does not support; create a ServiceAccount token on the cluster and onboard with server and token instead")
+	}
+	if user.Exec == nil {
+		return nil
+	}
+
+	raw, err := yaml.Marshal(user.Exec)
+	if err != nil {
+		return err
+	}
+	var spec kubeconfigExec
+	if err := yaml.Unmarshal(raw, &spec); err != nil || spec.Command == "" {
+		return fmt.Errorf("kubeconfig has an invalid exec section")
+	}
+	if spec.InteractiveMode == "Always" {
+		return fmt.Errorf("exec plugin %s requires interactive input; %s", spec.Command, execOnboardingHint(spec.Command))
+	}
+	if !containsFold(allowed, spec.Command) && !containsFold(allowed, filepath.Base(spec.Command)) {
+		return fmt.Errorf("exec plugin %s is not in exec_allowed_commands; %s", spec.Command, execOnboardingHint(spec.Command))
+	}
+	path, err := runner.LookPath(spec.Command)
+	if err != nil {
+		return fmt.Errorf("exec plugin %s is not installed on the plugin host; %s", spec.Command, execOnboardingHint(spec.Command))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execCredentialTimeout)
+	defer cancel()
+
+	apiVersion := spec.APIVersion
+	if apiVersion == "" {
+		apiVersion = "client.authentication.k8s.io/v1"
+	}
+	execInfo, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]interface{}{"interactive": false},
+	})
+	cmd := Command{Name: path, Args: spec.Args, Env: []string{"KUBERNETES_EXEC_INFO=" + string(execInfo)}}
+	for _, env := range spec.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+
+	out, err := runner.Run(ctx, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("exec plugin %s did not return within %s; %s", spec.Command, execCredentialTimeout, execOnboardingHint(spec.Command))
+	}
+	if err != nil {
+		return fmt.Errorf("exec plugin %s failed: %v", spec.Command, err)
+	}
+
+	var credential execCredential
+	if err := json.Unmarshal(out, &credential); err != nil {
+		return fmt.Errorf("exec plugin %s returned an invalid ExecCredential: %v", spec.Command, err)
+	}
+	switch {
+	case credential.Status.Token != "":
+		user.Token = credential.Status.Token
+	case credential.Status.ClientCertificateData != "" && credential.Status.ClientKeyData != "":
+		user.ClientCertificateData = base64.StdEncoding.EncodeToString([]byte(credential.Status.ClientCertificateData))
+		user.ClientKeyData = base64.StdEncoding.EncodeToString([]byte(credential.Status.ClientKeyData))
+	default:
+		return fmt.Errorf("exec plugin %s returned no credentials", spec.Command)
+	}
+	user.Exec = nil
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// fakeResponse is the scripted result of a command run by fakeRunner
+type fakeResponse struct {
+	Output []byte
+	Err    error
+}
+
+// fakeRunner is a CommandRunner that runs nothing. Commands are answered
+// from Responses, keyed by a prefix of the command line such as
+// "kubectl get csr"; the longest matching prefix wins and unmatched
+// commands succeed with no output. Every command is recorded in Calls. It
+// lets the plugin be exercised in unit tests without kubectl, clusteradm or
+// a hub.
+type fakeRunner struct {
+	Responses map[string]fakeResponse
+	// Installed lists the commands LookPath finds; nil means all of them
+	Installed []string
+	Calls     []Command
+	mutex     sync.Mutex
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{Responses: make(map[string]fakeResponse)}
+}
+
+// On scripts the response to commands starting with prefix
+func (r *fakeRunner) On(prefix string, output string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Responses[prefix] = fakeResponse{Output: []byte(output), Err: err}
+}
+
+func (r *fakeRunner) respond(cmd Command) fakeResponse {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Calls = append(r.Calls, cmd)
+
+	line := cmd.String()
+	best := ""
+	for prefix := range r.Responses {
+		if strings.HasPrefix(line, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	return r.Responses[best]
+}
+
+func (r *fakeRunner) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	resp := r.respond(cmd)
+	return resp.Output, resp.Err
+}
+
+// Stream returns the scripted output at once, followed by EOF
+func (r *fakeRunner) Stream(ctx context.Context, cmd Command) (io.Reader, func() error, error) {
+	resp := r.respond(cmd)
+	if resp.Err != nil {
+		return nil, nil, resp.Err
+	}
+	return bytes.NewReader(resp.Output), func() error { return nil }, nil
+}
+
+func (r *fakeRunner) LookPath(name string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.Installed == nil || containsFold(r.Installed, name) {
+		return "/usr/local/bin/" + name, nil
+	}
+	return "", fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+}
+
+// Commands returns the command lines run so far
+func (r *fakeRunner) Commands() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	lines := make([]string, 0, len(r.Calls))
+	for _, cmd := range r.Calls {
+		lines = append(lines, cmd.String())
+	}
+	return lines
+}
+
+// ran reports how many commands starting with prefix were run
+func (r *fakeRunner) ran(prefix string) int {
+	count := 0
+	for _, line := range r.Commands() {
+		if strings.HasPrefix(line, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// newFakeHubClient returns a HubClient that runs against runner without
+// adding hub flags, so scripted prefixes are simply "kubectl get ..."
+func newFakeHubClient(runner *fakeRunner) HubClient {
+	return &cliHubClient{
+		runner: runner,
+		flags:  func(context.Context) []string { return nil },
+	}
+}
+
+// newFakeHubAPI returns a fake dynamic client of a hub serving objects
+func newFakeHubAPI(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		managedClusterGVR: "ManagedClusterList",
+	}, objects...)
+}
+
+// withFakeHub points the plugin at runner for every command it runs and at
+// api for every hub API request
+func (cp *ClusterOpsPlugin) withFakeHub(runner *fakeRunner, api dynamic.Interface) {
+	cp.runner = runner
+	cp.hub = newFakeHubClient(runner)
+	cp.hubClients = func(HubConfig) (dynamic.Interface, error) { return api, nil }
+}
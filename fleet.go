@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FleetSpec is the complete desired fleet, the payload of PUT /clusters.
+// Clusters found on the hubs but missing from the spec are detached.
+type FleetSpec struct {
+	Clusters []DesiredCluster `json:"clusters"`
+	// Hub restricts the fleet to one hub; empty covers every hub
+	Hub string `json:"hub,omitempty"`
+	// Cleanup removes the agents from detached clusters
+	Cleanup bool `json:"cleanup,omitempty"`
+}
+
+// DesiredCluster is one cluster of a fleet spec. Nil Labels or Addons leave
+// them as they are on the hub; an empty map or list removes them all.
+type DesiredCluster struct {
+	Name       string            `json:"name"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	ClusterSet string            `json:"clusterSet,omitempty"`
+	Addons     []string          `json:"addons,omitempty"`
+	// Onboard supplies the kubeconfig source used when the cluster is not on
+	// the hub yet; its name, hub, labels and addons come from the spec
+	Onboard *ClusterOnboardRequest `json:"onboard,omitempty"`
+}
+
+// LabelChange is a label update planned for a cluster
+type LabelChange struct {
+	ClusterName string            `json:"clusterName"`
+	Hub         string            `json:"hub"`
+	Add         map[string]string `json:"add,omitempty"`
+	Remove      []string          `json:"remove,omitempty"`
+}
+
+// AddonChange is an addon update planned for a cluster
+type AddonChange struct {
+	ClusterName string   `json:"clusterName"`
+	Hub         string   `json:"hub"`
+	Enable      []string `json:"enable,omitempty"`
+	Disable     []string `json:"disable,omitempty"`
+}
+
+// FleetPlan is what converging the fleet to a spec takes
+type FleetPlan struct {
+	Onboard   []string      `json:"onboard"`
+	Detach    []string      `json:"detach"`
+	Relabel   []LabelChange `json:"relabel"`
+	Addons    []AddonChange `json:"addons"`
+	Unchanged []string      `json:"unchanged"`
+}
+
+// observedCluster is the state of a ManagedCluster relevant to a fleet spec
+type observedCluster struct {
+	Hub    string
+	Labels map[string]string
+	// Addons lists the enabled supported addons, or is nil when they were
+	// not read
+	Addons []string
+	// Record seeds the cluster store when the plugin does not track the
+	// cluster yet
+	Record ClusterRecord
+}
+
+// validateFleet checks a fleet spec, returning all problems found
+func validateFleet(spec FleetSpec) []string {
+	var problems []string
+	seen := map[string]bool{}
+	for _, desired := range spec.Clusters {
+		if !namespacePattern.MatchString(desired.Name) {
+			problems = append(problems, fmt.Sprintf("invalid cluster name %q", desired.Name))
+			continue
+		}
+		if seen[desired.Name] {
+			problems = append(problems, fmt.Sprintf("cluster %s is listed more than once", desired.Name))
+		}
+		seen[desired.Name] = true
+		for _, problem := range validateClusterMetadata(desired.Labels, nil) {
+			problems = append(problems, fmt.Sprintf("%s: %s", desired.Name, problem))
+		}
+		if desired.ClusterSet != "" && !namespacePattern.MatchString(desired.ClusterSet) {
+			problems = append(problems, fmt.Sprintf("%s: invalid clusterSet %q", desired.Name, desired.ClusterSet))
+		}
+		for _, problem := range validateAddons(desired.Addons) {
+			problems = append(problems, fmt.Sprintf("%s: %s", desired.Name, problem))
+		}
+		if desired.Onboard != nil && desired.Onboard.ClusterName != "" && desired.Onboard.ClusterName != desired.Name {
+			problems = append(problems, fmt.Sprintf("%s: onboard.clusterName must be empty or match the cluster name", desired.Name))
+		}
+	}
+	return problems
+}
+
+// observeFleet reads the ManagedClusters of the hubs covered by a spec, and
+// the addons of those whose addons the spec manages
+func (cp *ClusterOpsPlugin) observeFleet(ctx context.Context, spec FleetSpec) (map[string]observedCluster, error) {
+	hubs := cp.hubList()
+	if spec.Hub != "" {
+		hub, err := cp.lookupHub(spec.Hub)
+		if err != nil {
+			return nil, err
+		}
+		hubs = []HubConfig{hub}
+	}
+	managesAddons := map[string]bool{}
+	for _, desired := range spec.Clusters {
+		managesAddons[desired.Name] = desired.Addons != nil
+	}
+
+	observed := map[string]observedCluster{}
+	for _, hub := range hubs {
+		hubCtx := withHub(ctx, hub)
+		clusters, err := cp.listManagedClusters(hubCtx)
+		if err != nil {
+			return nil, fmt.Errorf("hub %s: %w", hub.Name, err)
+		}
+		for i := range clusters {
+			mc := &clusters[i]
+			cluster := observedCluster{
+				Hub:    hub.Name,
+				Labels: mc.Metadata.Labels,
+				Record: recordFromManagedCluster(mc, hub.Name, "Discovered on the hub"),
+			}
+			if managesAddons[mc.Metadata.Name] {
+				statuses, err := cp.listAddonStatuses(hubCtx, mc.Metadata.Name)
+				if err != nil {
+					return nil, fmt.Errorf("addons of %s on hub %s: %w", mc.Metadata.Name, hub.Name, err)
+				}
+				cluster.Addons = []string{}
+				for _, status := range statuses {
+					for name, addon := range supportedAddons {
+						if addon == status.Name {
+							cluster.Addons = append(cluster.Addons, name)
+						}
+					}
+				}
+			}
+			observed[mc.Metadata.Name] = cluster
+		}
+	}
+	return observed, nil
+}
+
+// planFleet computes the changes that converge the observed clusters to a
+// spec. Protected labels are never removed; the clusterset label is only
+// changed through ClusterSet.
+func planFleet(spec FleetSpec, observed map[string]observedCluster) FleetPlan {
+	plan := FleetPlan{Onboard: []string{}, Detach: []string{}, Relabel: []LabelChange{}, Addons: []AddonChange{}, Unchanged: []string{}}
+	desiredNames := map[string]bool{}
+	for _, desired := range spec.Clusters {
+		desiredNames[desired.Name] = true
+		current, ok := observed[desired.Name]
+		if !ok {
+			plan.Onboard = append(plan.Onboard, desired.Name)
+			continue
+		}
+
+		labels := LabelChange{ClusterName: desired.Name, Hub: current.Hub, Add: map[string]string{}}
+		if desired.Labels != nil {
+			for key, value := range desired.Labels {
+				if existing, ok := current.Labels[key]; !ok || existing != value {
+					labels.Add[key] = value
+				}
+			}
+			for key := range current.Labels {
+				if _, ok := desired.Labels[key]; !ok && !isProtectedLabel(key) {
+					labels.Remove = append(labels.Remove, key)
+				}
+			}
+			sort.Strings(labels.Remove)
+		}
+		if desired.ClusterSet != "" && current.Labels[clusterSetLabel] != desired.ClusterSet {
+			labels.Add[clusterSetLabel] = desired.ClusterSet
+		}
+
+		addons := AddonChange{ClusterName: desired.Name, Hub: current.Hub}
+		if desired.Addons != nil {
+			for _, addon := range desired.Addons {
+				if !slices.Contains(current.Addons, addon) && !slices.Contains(addons.Enable, addon) {
+					addons.Enable = append(addons.Enable, addon)
+				}
+			}
+			for _, addon := range current.Addons {
+				if !slices.Contains(desired.Addons, addon) {
+					addons.Disable = append(addons.Disable, addon)
+				}
+			}
+			sort.Strings(addons.Enable)
+			sort.Strings(addons.Disable)
+		}
+
+		changed := false
+		if len(labels.Add) > 0 || len(labels.Remove) > 0 {
+			if len(labels.Add) == 0 {
+				labels.Add = nil
+			}
+			plan.Relabel = append(plan.Relabel, labels)
+			changed = true
+		}
+		if len(addons.Enable) > 0 || len(addons.Disable) > 0 {
+			plan.Addons = append(plan.Addons, addons)
+			changed = true
+		}
+		if !changed {
+			plan.Unchanged = append(plan.Unchanged, desired.Name)
+		}
+	}
+	for name := range observed {
+		if !desiredNames[name] {
+			plan.Detach = append(plan.Detach, name)
+		}
+	}
+
+	sort.Strings(plan.Onboard)
+	sort.Strings(plan.Detach)
+	sort.Strings(plan.Unchanged)
+	sort.Slice(plan.Relabel, func(i, j int) bool { return plan.Relabel[i].ClusterName < plan.Relabel[j].ClusterName })
+	sort.Slice(plan.Addons, func(i, j int) bool { return plan.Addons[i].ClusterName < plan.Addons[j].ClusterName })
+	return plan
+}
+
+// FleetActionResult is the outcome of one change made by a fleet apply
+type FleetActionResult struct {
+	ClusterName string `json:"clusterName"`
+	Action      string `json:"action"`
+	OperationID string `json:"operationId,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+// applyFleet carries out a fleet plan. Onboardings and detachments run as
+// operations; label and addon changes are made before returning.
+func (cp *ClusterOpsPlugin) applyFleet(ctx context.Context, traceparent string, spec FleetSpec, plan FleetPlan, observed map[string]observedCluster) []FleetActionResult {
+	desired := map[string]DesiredCluster{}
+	for _, cluster := range spec.Clusters {
+		desired[cluster.Name] = cluster
+	}
+	var results []FleetActionResult
+
+	for _, name := range plan.Onboard {
+		cluster := desired[name]
+		result := FleetActionResult{ClusterName: name, Action: "onboard"}
+		if cluster.Onboard == nil {
+			result.Error = "cluster is not on the hub and the spec has no onboard source"
+			results = append(results, result)
+			continue
+		}
+		req := *cluster.Onboard
+		req.ClusterName = name
+		req.Hub = spec.Hub
+		req.Labels = cluster.Labels
+		req.Addons = cluster.Addons
+		op, rejected := cp.beginOnboarding(ctx, traceparent, req)
+		if rejected != nil {
+			result.Error = rejected.message()
+		} else {
+			result.OperationID = op.ID
+			if cluster.ClusterSet != "" {
+				result.Note = "clusterSet is set by the next apply once the cluster has joined"
+			}
+		}
+		results = append(results, result)
+	}
+
+	for _, name := range plan.Detach {
+		result := FleetActionResult{ClusterName: name, Action: "detach"}
+		cp.clusters.Seed(observed[name].Record)
+		op, rejected := cp.beginDetach(ctx, traceparent, name, spec.Hub, detachOptions{cleanup: spec.Cleanup})
+		if rejected != nil {
+			result.Error = rejected.message()
+		} else {
+			result.OperationID = op.ID
+		}
+		results = append(results, result)
+	}
+
+	for _, change := range plan.Relabel {
+		result := FleetActionResult{ClusterName: change.ClusterName, Action: "relabel"}
+		hub, err := cp.lookupHub(change.Hub)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		labels := make(map[string]interface{}, len(change.Add)+len(change.Remove))
+		for key, value := range change.Add {
+			labels[key] = value
+		}
+		for _, key := range change.Remove {
+			labels[key] = nil
+		}
+		patch := map[string]interface{}{"metadata": map[string]interface{}{"labels": labels}}
+		mc, err := cp.patchManagedCluster(withHub(ctx, hub), change.ClusterName, patch)
+		if err != nil {
+			result.Error = err.Error()
+			cp.logEvent(change.ClusterName, "labels", "failed", fmt.Sprintf("Fleet apply failed to update labels: %v", err))
+		} else {
+			cp.clusters.Update(change.ClusterName, func(record *ClusterRecord) {
+				record.Labels = mc.Metadata.Labels
+			})
+			cp.logEvent(change.ClusterName, "labels", "success", describeLabelPatch(LabelPatchRequest{Add: change.Add, Remove: change.Remove}))
+		}
+		results = append(results, result)
+	}
+
+	for _, change := range plan.Addons {
+		hub, err := cp.lookupHub(change.Hub)
+		if err != nil {
+			results = append(results, FleetActionResult{ClusterName: change.ClusterName, Action: "addons", Error: err.Error()})
+			continue
+		}
+		hubCtx := withHub(ctx, hub)
+		var enabled, disabled []string
+		for _, addon := range change.Enable {
+			result := FleetActionResult{ClusterName: change.ClusterName, Action: "enable-addon:" + addon}
+			if err := cp.enableAddon(hubCtx, change.ClusterName, addon); err != nil {
+				result.Error = err.Error()
+				cp.logEvent(change.ClusterName, "addons", "failed", fmt.Sprintf("Failed to enable addon %s: %v", addon, err))
+			} else {
+				enabled = append(enabled, addon)
+				cp.logEvent(change.ClusterName, "addons", "success", fmt.Sprintf("Addon %s enabled", addon))
+			}
+			results = append(results, result)
+		}
+		for _, addon := range change.Disable {
+			result := FleetActionResult{ClusterName: change.ClusterName, Action: "disable-addon:" + addon}
+			if err := cp.disableAddon(hubCtx, change.ClusterName, addon); err != nil {
+				result.Error = err.Error()
+				cp.logEvent(change.ClusterName, "addons", "failed", fmt.Sprintf("Failed to disable addon %s: %v", addon, err))
+			} else {
+				disabled = append(disabled, addon)
+				cp.logEvent(change.ClusterName, "addons", "success", fmt.Sprintf("Addon %s disabled", addon))
+			}
+			results = append(results, result)
+		}
+		cp.clusters.Update(change.ClusterName, func(record *ClusterRecord) {
+			record.Addons = slices.DeleteFunc(mergeAddons(record.Addons, enabled), func(addon string) bool {
+				return slices.Contains(disabled, addon)
+			})
+		})
+	}
+	return results
+}
+
+// bindFleet binds and validates a fleet spec, writing the error response
+// when it is invalid
+func bindFleet(c *gin.Context) (FleetSpec, bool) {
+	var spec FleetSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return spec, false
+	}
+	if problems := validateFleet(spec); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid fleet spec", problems))
+		return spec, false
+	}
+	return spec, true
+}
+
+func (cp *ClusterOpsPlugin) ApplyFleetHandler(c *gin.Context) {
+	spec, ok := bindFleet(c)
+	if !ok {
+		return
+	}
+	if len(spec.Clusters) == 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "The desired fleet is empty; refusing to detach every cluster", nil))
+		return
+	}
+
+	observed, err := cp.observeFleet(c.Request.Context(), spec)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to read the fleet from the hub", err.Error()))
+		return
+	}
+	plan := planFleet(spec, observed)
+	results := cp.applyFleet(c.Request.Context(), c.GetHeader("traceparent"), spec, plan, observed)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"plan":    plan,
+		"results": results,
+		"changes": len(results),
+		"failed":  failed,
+		"plugin":  "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) DiffFleetHandler(c *gin.Context) {
+	spec, ok := bindFleet(c)
+	if !ok {
+		return
+	}
+
+	observed, err := cp.observeFleet(c.Request.Context(), spec)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to read the fleet from the hub", err.Error()))
+		return
+	}
+	plan := planFleet(spec, observed)
+
+	// Report what an apply would refuse or fail on, so the plan can be fixed
+	// before it is applied
+	var warnings []string
+	if len(spec.Clusters) == 0 {
+		warnings = append(warnings, "the desired fleet is empty; apply refuses to detach every cluster")
+	}
+	desired := map[string]DesiredCluster{}
+	for _, cluster := range spec.Clusters {
+		desired[cluster.Name] = cluster
+	}
+	for _, name := range plan.Onboard {
+		if desired[name].Onboard == nil {
+			warnings = append(warnings, fmt.Sprintf("%s is not on the hub and has no onboard source", name))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan": plan,
+		"summary": gin.H{
+			"onboard":   len(plan.Onboard),
+			"detach":    len(plan.Detach),
+			"relabel":   len(plan.Relabel),
+			"addons":    len(plan.Addons),
+			"unchanged": len(plan.Unchanged),
+		},
+		"warnings": warnings,
+		"plugin":   "cluster-ops-plugin",
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// gcpScope is the OAuth scope of the access tokens the plugin requests
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gkeClusterName matches the names GKE accepts for clusters
+var gkeClusterName = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,38}[a-z0-9])?$`)
+
+// GKEClusterRef identifies a GKE cluster to import. Location is a region or
+// zone, Name defaults to the clusterName of the request and Project to
+// gcp_project.
+type GKEClusterRef struct {
+	Project  string `json:"project,omitempty"`
+	Location string `json:"location"`
+	Name     string `json:"name,omitempty"`
+}
+
+func (ref *GKEClusterRef) validate() error {
+	if ref.Project == "" {
+		return fmt.Errorf("gke requires a project when gcp_project is not set")
+	}
+	if ref.Location == "" {
+		return fmt.Errorf("gke requires a location")
+	}
+	if !gkeClusterName.MatchString(ref.Name) {
+		return fmt.Errorf("invalid GKE cluster name %q", ref.Name)
+	}
+	return nil
+}
+
+// gcpProject returns the default project for Google Cloud APIs
+func (cp *ClusterOpsPlugin) gcpProject() string {
+	if project := cp.settings().GCPProject; project != "" {
+		return project
+	}
+	return os.Getenv("GOOGLE_CLOUD_PROJECT")
+}
+
+// gcpCredentialsFile returns the credentials configured for Google Cloud
+// APIs, or "" to use the metadata server
+func (cp *ClusterOpsPlugin) gcpCredentialsFile() string {
+	if file := cp.settings().GCPCredentialsFile; file != "" {
+		return file
+	}
+	return os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+// gcpCredentials is a service account key or the authorized user written by
+// `gcloud auth application-default login`
+type gcpCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// gcpAccessToken returns an access token for Google Cloud APIs minted from
+// a credentials file, or from the workload's service account through the
+// metadata server when there is none
+func gcpAccessToken(ctx context.Context, credentialsFile string) (string, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if credentialsFile == "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		if err := httpJSON(req, &token); err != nil {
+			return "", fmt.Errorf("no GCP credentials found: %v", err)
+		}
+		return token.AccessToken, nil
+	}
+
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read GCP credentials: %v", err)
+	}
+	var creds gcpCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", fmt.Errorf("invalid GCP credentials in %s: %v", credentialsFile, err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	var form url.Values
+	switch creds.Type {
+	case "service_account":
+		assertion, err := gcpServiceAccountAssertion(creds, time.Now())
+		if err != nil {
+			return "", err
+		}
+		form = url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+	case "authorized_user":
+		form = url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		}
+	default:
+		return "", fmt.Errorf("unsupported GCP credentials type %q, expected service_account or authorized_user", creds.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := httpJSON(req, &token); err != nil {
+		return "", fmt.Errorf("GCP token exchange failed: %v", err)
+	}
+	return token.AccessToken, nil
+}
+
+// gcpServiceAccountAssertion signs the RS256 JWT a service account key
+// exchanges for an access token
+func gcpServiceAccountAssertion(creds gcpCredentials, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("GCP service account key has no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid GCP service account key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("GCP service account key is not an RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": gcpScope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// gkeKubeconfig looks up the endpoint and CA of a GKE cluster and returns a
+// kubeconfig authenticating with an access token of the configured
+// credentials. The token is valid for an hour, which covers the join;
+// clusters that need later spoke access should also enable
+// managedServiceAccount.
+func (cp *ClusterOpsPlugin) gkeKubeconfig(ctx context.Context, clusterName string, ref GKEClusterRef) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudImportTimeout)
+	defer cancel()
+
+	token, err := gcpAccessToken(ctx, cp.gcpCredentialsFile())
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := fmt.Sprintf("https://container.googleapis.com/v1/projects/%s/locations/%s/clusters/%s",
+		url.PathEscape(ref.Project), url.PathEscape(ref.Location), url.PathEscape(ref.Name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Endpoint   string `json:"endpoint"`
+		Status     string `json:"status"`
+		MasterAuth struct {
+			ClusterCACertificate string `json:"clusterCaCertificate"`
+		} `json:"masterAuth"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return "", fmt.Errorf("failed to get GKE cluster %s: %v", ref.Name, err)
+	}
+	// Clusters being upgraded or repaired still serve the API
+	switch resp.Status {
+	case "RUNNING", "RECONCILING", "DEGRADED":
+	default:
+		return "", fmt.Errorf("GKE cluster %s is %s, not RUNNING", ref.Name, resp.Status)
+	}
+	if resp.Endpoint == "" {
+		return "", fmt.Errorf("GKE cluster %s has no endpoint", ref.Name)
+	}
+	return synthesizeKubeconfig(clusterName, "https://"+resp.Endpoint, resp.MasterAuth.ClusterCACertificate, map[string]string{"token": token})
+}
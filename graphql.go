@@ -0,0 +1,895 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// graphQLMaxDepth bounds how deeply selections may nest, since clusters,
+// operations and events refer to each other
+const graphQLMaxDepth = 10
+
+// graphQLMaxFields bounds how many fields a query may resolve, counting
+// the fields of every list item, so fragments repeated across aliases
+// cannot make a small query expensive
+const graphQLMaxFields = 100000
+
+// graphQLRequest is the body of a POST /graphql request
+type graphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLHandler answers read-only GraphQL queries over clusters,
+// operations and events, so a dashboard can fetch e.g. every failed
+// cluster with its last five events in a single request:
+//
+//	{ clusters(status: "Failed") { name message events(last: 5) { type message timestamp } } }
+//
+// The root fields are clusters(status, type, hub, labelSelector),
+// cluster(name), operations(cluster, status, type, last), operation(id)
+// and events(cluster, afterId, level, since, last). Clusters nest their events and
+// operations, operations their cluster and events, and events their
+// cluster. Queries are sent as JSON on POST or as query parameters on GET;
+// results follow the GraphQL response format.
+func (cp *ClusterOpsPlugin) GraphQLHandler(c *gin.Context) {
+	var req graphQLRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, graphQLErrors(fmt.Errorf("invalid variables: %v", err)))
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, graphQLErrors(fmt.Errorf("invalid request body: %v", err)))
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		c.JSON(http.StatusBadRequest, graphQLErrors(fmt.Errorf("missing query")))
+		return
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphQLErrors(err))
+		return
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphQLErrors(err))
+		return
+	}
+
+	exec := &graphQLExecution{cp: cp, fragments: doc.fragments, variables: op.variableValues(req.Variables)}
+	data := exec.object(graphQLQuery{}, op.selections, "", 0)
+	response := gin.H{"data": data}
+	if len(exec.errors) > 0 {
+		response["errors"] = exec.errors
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// graphQLErrors rejects a whole request; the error code of the envelope
+// used by the other endpoints is carried in the extensions of the error
+func graphQLErrors(err error) gin.H {
+	return gin.H{"errors": []gin.H{{
+		"message":    err.Error(),
+		"extensions": gin.H{"code": codeInvalidRequest},
+	}}}
+}
+
+// graphQLQuery is the root object of every query
+type graphQLQuery struct{}
+
+// graphQLTypeNames names the Go types served as GraphQL objects. Values of
+// other types, such as labels or klusterlet options, are returned whole.
+var graphQLTypeNames = map[reflect.Type]string{
+	reflect.TypeOf(graphQLQuery{}):    "Query",
+	reflect.TypeOf(ClusterRecord{}):   "Cluster",
+	reflect.TypeOf(Operation{}):       "Operation",
+	reflect.TypeOf(OperationStep{}):   "OperationStep",
+	reflect.TypeOf(OnboardingEvent{}): "Event",
+}
+
+// graphQLResolver computes a field of an object from its arguments
+type graphQLResolver func(cp *ClusterOpsPlugin, parent interface{}, args graphQLArgs) (interface{}, error)
+
+// graphQLResolvers holds the fields that are not plain JSON fields of the
+// object, keyed by type name and field name. Every other field of Cluster,
+// Operation, OperationStep and Event is the JSON field of the same name in
+// the HTTP API.
+var graphQLResolvers = map[string]map[string]graphQLResolver{
+	"Query": {
+		"clusters": func(cp *ClusterOpsPlugin, _ interface{}, args graphQLArgs) (interface{}, error) {
+			selector, err := parseLabelSelector(args.string("labelSelector"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid labelSelector: %v", err)
+			}
+			status, clusterType, hubName := args.string("status"), args.string("type"), args.string("hub")
+			records := make([]ClusterRecord, 0)
+			for _, record := range cp.clusters.List() {
+				if status != "" && !strings.EqualFold(string(record.State), status) {
+					continue
+				}
+				if clusterType != "" && !strings.EqualFold(record.Type, clusterType) {
+					continue
+				}
+				if hubName != "" && cp.clusterHub(record.Name).Name != hubName {
+					continue
+				}
+				if !matchLabels(selector, record.Labels) {
+					continue
+				}
+				records = append(records, record)
+			}
+			return records, args.err
+		},
+		"cluster": func(cp *ClusterOpsPlugin, _ interface{}, args graphQLArgs) (interface{}, error) {
+			name := args.required("name")
+			if args.err != nil {
+				return nil, args.err
+			}
+			if record, ok := cp.clusters.Get(name); ok {
+				return record, nil
+			}
+			return nil, nil
+		},
+		"operations": func(cp *ClusterOpsPlugin, _ interface{}, args graphQLArgs) (interface{}, error) {
+			return cp.graphQLOperations(args.string("cluster"), args)
+		},
+		"operation": func(cp *ClusterOpsPlugin, _ interface{}, args graphQLArgs) (interface{}, error) {
+			id := args.required("id")
+			if args.err != nil {
+				return nil, args.err
+			}
+			if op, ok := cp.operations.Get(id); ok {
+				return op, nil
+			}
+			return nil, nil
+		},
+		"events": func(cp *ClusterOpsPlugin, _ interface{}, args graphQLArgs) (interface{}, error) {
+			name := args.required("cluster")
+			if args.err != nil {
+				return nil, args.err
+			}
+			return graphQLEvents(cp.events.List(name), args)
+		},
+	},
+	"Cluster": {
+		"hub": func(cp *ClusterOpsPlugin, parent interface{}, _ graphQLArgs) (interface{}, error) {
+			return cp.clusterHub(parent.(ClusterRecord).Name).Name, nil
+		},
+		"allowedActions": func(_ *ClusterOpsPlugin, parent interface{}, _ graphQLArgs) (interface{}, error) {
+			return allowedActions(parent.(ClusterRecord).State), nil
+		},
+		"events": func(cp *ClusterOpsPlugin, parent interface{}, args graphQLArgs) (interface{}, error) {
+			return graphQLEvents(cp.events.List(parent.(ClusterRecord).Name), args)
+		},
+		"operations": func(cp *ClusterOpsPlugin, parent interface{}, args graphQLArgs) (interface{}, error) {
+			return cp.graphQLOperations(parent.(ClusterRecord).Name, args)
+		},
+	},
+	"Operation": {
+		"cluster": func(cp *ClusterOpsPlugin, parent interface{}, _ graphQLArgs) (interface{}, error) {
+			if record, ok := cp.clusters.Get(parent.(Operation).ClusterName); ok {
+				return record, nil
+			}
+			return nil, nil
+		},
+		"events": func(cp *ClusterOpsPlugin, parent interface{}, args graphQLArgs) (interface{}, error) {
+			op := parent.(Operation)
+			var events []OnboardingEvent
+			for _, event := range cp.events.List(op.ClusterName) {
+				if event.OperationID != "" {
+					if event.OperationID == op.ID {
+						events = append(events, event)
+					}
+					continue
+				}
+				// Older events carry no operation ID and are matched by time
+				if !event.Timestamp.Before(op.CreatedAt) && (op.CompletedAt == nil || !event.Timestamp.After(*op.CompletedAt)) {
+					events = append(events, event)
+				}
+			}
+			return graphQLEvents(events, args)
+		},
+	},
+	"Event": {
+		"level": func(_ *ClusterOpsPlugin, parent interface{}, _ graphQLArgs) (interface{}, error) {
+			return eventLevel(parent.(OnboardingEvent)), nil
+		},
+		"cluster": func(cp *ClusterOpsPlugin, parent interface{}, _ graphQLArgs) (interface{}, error) {
+			if record, ok := cp.clusters.Get(parent.(OnboardingEvent).ClusterName); ok {
+				return record, nil
+			}
+			return nil, nil
+		},
+	},
+}
+
+// graphQLOperations lists the operations of a cluster, or of every
+// cluster when clusterName is empty, oldest first
+func (cp *ClusterOpsPlugin) graphQLOperations(clusterName string, args graphQLArgs) (interface{}, error) {
+	status, opType := args.string("status"), args.string("type")
+	operations := make([]Operation, 0)
+	for _, op := range cp.operations.List() {
+		if clusterName != "" && op.ClusterName != clusterName {
+			continue
+		}
+		if status != "" && op.Status != status {
+			continue
+		}
+		if opType != "" && op.Type != opType {
+			continue
+		}
+		operations = append(operations, op)
+	}
+	if last := args.int("last"); last > 0 && last < len(operations) {
+		operations = operations[len(operations)-last:]
+	}
+	return operations, args.err
+}
+
+// graphQLEvents filters events by the afterId, level, since and last
+// arguments
+func graphQLEvents(events []OnboardingEvent, args graphQLArgs) (interface{}, error) {
+	level := args.string("level")
+	if level != "" {
+		if _, ok := levelSeverity[level]; !ok {
+			return nil, fmt.Errorf("invalid level %q: must be info, warn or error", level)
+		}
+	}
+	var since time.Time
+	if value := args.string("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: must be an RFC 3339 timestamp")
+		}
+		since = parsed
+	}
+	events = filterEvents(eventsAfter(events, int64(args.int("afterId"))), level, since)
+	if last := args.int("last"); last > 0 && last < len(events) {
+		events = events[len(events)-last:]
+	}
+	return events, args.err
+}
+
+// graphQLArgs are the arguments of a field, with variables substituted.
+// Accessors record the first type error in err.
+type graphQLArgs struct {
+	values map[string]interface{}
+	err    error
+}
+
+func (a *graphQLArgs) string(name string) string {
+	switch value := a.values[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		a.fail("argument %s must be a String", name)
+		return ""
+	}
+}
+
+func (a *graphQLArgs) required(name string) string {
+	value := a.string(name)
+	if value == "" {
+		a.fail("argument %s is required", name)
+	}
+	return value
+}
+
+func (a *graphQLArgs) int(name string) int {
+	switch value := a.values[name].(type) {
+	case nil:
+		return 0
+	case int64:
+		return int(value)
+	case float64:
+		if value == float64(int(value)) {
+			return int(value)
+		}
+	}
+	a.fail("argument %s must be an Int", name)
+	return 0
+}
+
+func (a *graphQLArgs) fail(format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf(format, args...)
+	}
+}
+
+// graphQLExecution resolves the selections of one query, collecting field
+// errors instead of failing the whole query, as GraphQL requires
+type graphQLExecution struct {
+	cp        *ClusterOpsPlugin
+	fragments map[string]*graphQLFragment
+	variables map[string]interface{}
+	errors    []gin.H
+	// resolved counts the fields resolved so far
+	resolved int
+}
+
+func (x *graphQLExecution) fail(path string, err error) {
+	x.errors = append(x.errors, gin.H{"message": err.Error(), "path": strings.Split(path, ".")})
+}
+
+// object resolves the selections of a value of a GraphQL object type
+func (x *graphQLExecution) object(parent interface{}, selections []*graphQLSelection, path string, depth int) map[string]interface{} {
+	typeName := graphQLTypeNames[reflect.TypeOf(parent)]
+	result := make(map[string]interface{})
+	for _, field := range x.collect(typeName, selections, make(map[string]bool)) {
+		x.resolved++
+		if x.resolved > graphQLMaxFields {
+			if x.resolved == graphQLMaxFields+1 {
+				x.fail(path, fmt.Errorf("query resolves more than %d fields", graphQLMaxFields))
+			}
+			break
+		}
+		key := field.alias
+		fieldPath := strings.TrimPrefix(path+"."+key, ".")
+		if field.name == "__typename" {
+			result[key] = typeName
+			continue
+		}
+		value, err := x.field(typeName, parent, field)
+		if err != nil {
+			x.fail(fieldPath, err)
+			result[key] = nil
+			continue
+		}
+		result[key] = x.value(value, field, fieldPath, depth+1)
+	}
+	return result
+}
+
+// collect flattens the fragments of a selection set that apply to typeName.
+// As in the CollectFields algorithm of the spec, a named fragment is spread
+// once per selection set however often it appears.
+func (x *graphQLExecution) collect(typeName string, selections []*graphQLSelection, visited map[string]bool) []*graphQLSelection {
+	var fields []*graphQLSelection
+	for _, selection := range selections {
+		switch {
+		case selection.fragment != "":
+			if visited[selection.fragment] {
+				continue
+			}
+			visited[selection.fragment] = true
+			fragment := x.fragments[selection.fragment]
+			if fragment.typeCondition == typeName {
+				fields = append(fields, x.collect(typeName, fragment.selections, visited)...)
+			}
+		case selection.name == "":
+			if selection.typeCondition == "" || selection.typeCondition == typeName {
+				fields = append(fields, x.collect(typeName, selection.selections, visited)...)
+			}
+		default:
+			fields = append(fields, selection)
+		}
+	}
+	return fields
+}
+
+// field returns the unresolved value of a field: computed by a resolver,
+// or read from the JSON field of the same name
+func (x *graphQLExecution) field(typeName string, parent interface{}, field *graphQLSelection) (interface{}, error) {
+	if resolve, ok := graphQLResolvers[typeName][field.name]; ok {
+		args := graphQLArgs{values: make(map[string]interface{})}
+		for name, value := range field.arguments {
+			args.values[name] = resolveGraphQLValue(value, x.variables)
+		}
+		return resolve(x.cp, parent, args)
+	}
+	if len(field.arguments) > 0 {
+		return nil, fmt.Errorf("field %s on type %s takes no arguments", field.name, typeName)
+	}
+
+	v := reflect.ValueOf(parent)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if name == field.name {
+			return v.Field(i).Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot query field %s on type %s", field.name, typeName)
+}
+
+// value completes a resolved field value against its selections
+func (x *graphQLExecution) value(value interface{}, field *graphQLSelection, path string, depth int) interface{} {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Kind() == reflect.Slice {
+		if _, ok := graphQLTypeNames[v.Type().Elem()]; ok {
+			items := make([]interface{}, v.Len())
+			for i := range items {
+				items[i] = x.value(v.Index(i).Interface(), field, path+"."+strconv.Itoa(i), depth)
+			}
+			return items
+		}
+	}
+	if typeName, ok := graphQLTypeNames[v.Type()]; ok {
+		if len(field.selections) == 0 {
+			x.fail(path, fmt.Errorf("field %s of type %s must have a selection of subfields", field.name, typeName))
+			return nil
+		}
+		if depth > graphQLMaxDepth {
+			x.fail(path, fmt.Errorf("query is nested deeper than %d levels", graphQLMaxDepth))
+			return nil
+		}
+		return x.object(v.Interface(), field.selections, path, depth)
+	}
+	if len(field.selections) > 0 {
+		x.fail(path, fmt.Errorf("field %s is not an object and cannot have subfields", field.name))
+		return nil
+	}
+	return value
+}
+
+// graphQLDocument is a parsed query document
+type graphQLDocument struct {
+	operations []*graphQLOperation
+	fragments  map[string]*graphQLFragment
+}
+
+type graphQLOperation struct {
+	name       string
+	variables  map[string]interface{}
+	selections []*graphQLSelection
+}
+
+type graphQLFragment struct {
+	typeCondition string
+	selections    []*graphQLSelection
+}
+
+// graphQLSelection is a field, a fragment spread (fragment set) or an
+// inline fragment (name empty)
+type graphQLSelection struct {
+	alias         string
+	name          string
+	arguments     map[string]interface{}
+	selections    []*graphQLSelection
+	fragment      string
+	typeCondition string
+}
+
+// graphQLVariable is a reference to a query variable in an argument
+type graphQLVariable string
+
+// operation picks the operation to run, by name when the document has
+// several
+func (d *graphQLDocument) operation(name string) (*graphQLOperation, error) {
+	if name == "" {
+		if len(d.operations) != 1 {
+			return nil, fmt.Errorf("operationName is required when the query has several operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// variableValues merges the request variables over the declared defaults
+func (op *graphQLOperation) variableValues(values map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(op.variables))
+	for name, value := range op.variables {
+		merged[name] = value
+	}
+	for name, value := range values {
+		merged[name] = value
+	}
+	return merged
+}
+
+func resolveGraphQLValue(value interface{}, variables map[string]interface{}) interface{} {
+	switch value := value.(type) {
+	case graphQLVariable:
+		return variables[string(value)]
+	case []interface{}:
+		resolved := make([]interface{}, len(value))
+		for i, item := range value {
+			resolved[i] = resolveGraphQLValue(item, variables)
+		}
+		return resolved
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			resolved[key] = resolveGraphQLValue(item, variables)
+		}
+		return resolved
+	default:
+		return value
+	}
+}
+
+// graphQLParser is a recursive-descent parser for the query subset of the
+// GraphQL language: operations, variables, aliases, arguments and
+// fragments. Directives, mutations and subscriptions are rejected.
+type graphQLParser struct {
+	src string
+	pos int
+}
+
+func parseGraphQL(src string) (*graphQLDocument, error) {
+	p := &graphQLParser{src: src}
+	doc := &graphQLDocument{fragments: make(map[string]*graphQLFragment)}
+	for p.skip(); p.pos < len(p.src); p.skip() {
+		if p.peek('{') {
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &graphQLOperation{selections: selections})
+			continue
+		}
+
+		keyword := p.name()
+		switch keyword {
+		case "query":
+			op, err := p.operationDefinition()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			name := p.name()
+			if name == "" || p.name() != "on" {
+				return nil, p.errorf("expected fragment name and type condition")
+			}
+			fragment := &graphQLFragment{typeCondition: p.name()}
+			var err error
+			if fragment.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.fragments[name] = fragment
+		case "mutation", "subscription":
+			return nil, fmt.Errorf("%s operations are not supported; the GraphQL API is read-only", keyword)
+		default:
+			return nil, p.errorf("expected query, fragment or {")
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("query has no operations")
+	}
+	checked := make(map[string]bool)
+	for _, op := range doc.operations {
+		if err := checkGraphQLFragments(op.selections, doc.fragments, make(map[string]bool), checked); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// checkGraphQLFragments rejects spreads of undefined fragments and
+// fragments that spread themselves. spreading holds the fragments being
+// checked and checked those found valid, so each fragment is checked once
+// however often it is spread.
+func checkGraphQLFragments(selections []*graphQLSelection, fragments map[string]*graphQLFragment, spreading, checked map[string]bool) error {
+	for _, selection := range selections {
+		if name := selection.fragment; name != "" && !checked[name] {
+			fragment, ok := fragments[name]
+			if !ok {
+				return fmt.Errorf("unknown fragment %q", name)
+			}
+			if spreading[name] {
+				return fmt.Errorf("fragment %q spreads itself", name)
+			}
+			spreading[name] = true
+			err := checkGraphQLFragments(fragment.selections, fragments, spreading, checked)
+			delete(spreading, name)
+			if err != nil {
+				return err
+			}
+			checked[name] = true
+		}
+		if err := checkGraphQLFragments(selection.selections, fragments, spreading, checked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *graphQLParser) operationDefinition() (*graphQLOperation, error) {
+	op := &graphQLOperation{name: p.name(), variables: make(map[string]interface{})}
+	if p.consume('(') {
+		for !p.consume(')') {
+			if !p.consume('$') {
+				return nil, p.errorf("expected variable definition")
+			}
+			name := p.name()
+			if name == "" || !p.consume(':') {
+				return nil, p.errorf("expected variable name and type")
+			}
+			if err := p.typeReference(); err != nil {
+				return nil, err
+			}
+			if p.consume('=') {
+				value, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				op.variables[name] = value
+			}
+			p.consume(',')
+		}
+	}
+	if p.peek('@') {
+		return nil, p.errorf("directives are not supported")
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+// typeReference skips a variable type; argument types are checked when
+// fields read them
+func (p *graphQLParser) typeReference() error {
+	if p.consume('[') {
+		if err := p.typeReference(); err != nil {
+			return err
+		}
+		if !p.consume(']') {
+			return p.errorf("expected ]")
+		}
+	} else if p.name() == "" {
+		return p.errorf("expected type")
+	}
+	p.consume('!')
+	return nil
+}
+
+func (p *graphQLParser) selectionSet() ([]*graphQLSelection, error) {
+	if !p.consume('{') {
+		return nil, p.errorf("expected {")
+	}
+	var selections []*graphQLSelection
+	for !p.consume('}') {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("expected }")
+		}
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *graphQLParser) selection() (*graphQLSelection, error) {
+	if strings.HasPrefix(p.src[p.pos:], "...") {
+		p.pos += 3
+		p.skip()
+		name := p.name()
+		if name != "" && name != "on" {
+			return &graphQLSelection{fragment: name}, nil
+		}
+		selection := &graphQLSelection{}
+		if name == "on" {
+			selection.typeCondition = p.name()
+		}
+		var err error
+		selection.selections, err = p.selectionSet()
+		return selection, err
+	}
+
+	selection := &graphQLSelection{name: p.name()}
+	if selection.name == "" {
+		return nil, p.errorf("expected field name")
+	}
+	selection.alias = selection.name
+	if p.consume(':') {
+		if selection.name = p.name(); selection.name == "" {
+			return nil, p.errorf("expected field name after alias")
+		}
+	}
+	if p.consume('(') {
+		selection.arguments = make(map[string]interface{})
+		for !p.consume(')') {
+			name := p.name()
+			if name == "" || !p.consume(':') {
+				return nil, p.errorf("expected argument")
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			selection.arguments[name] = value
+			p.consume(',')
+		}
+	}
+	if p.peek('@') {
+		return nil, p.errorf("directives are not supported")
+	}
+	if p.peek('{') {
+		var err error
+		if selection.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	p.consume(',')
+	return selection, nil
+}
+
+func (p *graphQLParser) value() (interface{}, error) {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected value")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '$':
+		p.pos++
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected variable name")
+		}
+		return graphQLVariable(name), nil
+	case c == '"':
+		return p.stringValue()
+	case c == '[':
+		p.pos++
+		list := make([]interface{}, 0)
+		for !p.consume(']') {
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			p.consume(',')
+		}
+		return list, nil
+	case c == '{':
+		p.pos++
+		object := make(map[string]interface{})
+		for !p.consume('}') {
+			name := p.name()
+			if name == "" || !p.consume(':') {
+				return nil, p.errorf("expected object field")
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			object[name] = item
+			p.consume(',')
+		}
+		return object, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && strings.ContainsRune("+-.eE0123456789", rune(p.src[p.pos])) {
+			p.pos++
+		}
+		number := p.src[start:p.pos]
+		if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(number, 64); err == nil {
+			return f, nil
+		}
+		return nil, p.errorf("invalid number %q", number)
+	}
+
+	switch name := p.name(); name {
+	case "":
+		return nil, p.errorf("expected value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		// Enum values are passed to resolvers as strings
+		return name, nil
+	}
+}
+
+func (p *graphQLParser) stringValue() (interface{}, error) {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		value := p.src[p.pos+3 : p.pos+3+end]
+		p.pos += end + 6
+		return value, nil
+	}
+	for end := p.pos + 1; end < len(p.src); end++ {
+		switch p.src[end] {
+		case '\\':
+			end++
+		case '\n':
+			return nil, p.errorf("unterminated string")
+		case '"':
+			// GraphQL string escapes are a subset of JSON's
+			var value string
+			if err := json.Unmarshal([]byte(p.src[p.pos:end+1]), &value); err != nil {
+				return nil, p.errorf("invalid string")
+			}
+			p.pos = end + 1
+			return value, nil
+		}
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+// name consumes a GraphQL name, returning "" when there is none
+func (p *graphQLParser) name() string {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := rune(p.src[p.pos])
+		if c != '_' && !unicode.IsLetter(c) && !(p.pos > start && unicode.IsDigit(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *graphQLParser) peek(c byte) bool {
+	p.skip()
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+func (p *graphQLParser) consume(c byte) bool {
+	if p.peek(c) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skip advances over whitespace and comments; callers consume commas
+// where the grammar allows them
+func (p *graphQLParser) skip() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		case '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *graphQLParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	column := p.pos - strings.LastIndex(p.src[:p.pos], "\n")
+	return fmt.Errorf("syntax error at line %d, column %d: %s", line, column, fmt.Sprintf(format, args...))
+}
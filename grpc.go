@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/priyanshuharshbodhi1/github-plugin/pkg/clusteropsv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// startGRPC serves the ClusterOps gRPC API on grpc_port, with TLS when
+// grpc_tls_cert_file and grpc_tls_key_file are set
+func (cp *ClusterOpsPlugin) startGRPC(settings PluginConfig) (*grpc.Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", settings.GRPCPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on grpc_port %d: %v", settings.GRPCPort, err)
+	}
+
+	var opts []grpc.ServerOption
+	if settings.GRPCTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.GRPCTLSCertFile, settings.GRPCTLSKeyFile)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to load the gRPC TLS certificate: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})))
+	} else {
+		cp.logger.Warn("gRPC API is served without TLS; set grpc_tls_cert_file and grpc_tls_key_file outside trusted networks")
+	}
+
+	server := grpc.NewServer(opts...)
+	clusteropsv1.RegisterClusterOpsServer(server, &grpcService{cp: cp})
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			cp.logger.Warn("gRPC server stopped", "error", err)
+		}
+	}()
+	cp.logger.Info("gRPC API listening", "address", listener.Addr().String())
+	return server, nil
+}
+
+// grpcService implements the ClusterOps service on top of the same code
+// paths as the HTTP handlers of the same names
+type grpcService struct {
+	clusteropsv1.UnimplementedClusterOpsServer
+	cp *ClusterOpsPlugin
+}
+
+// authorizeRPC authenticates, authorizes and rate-limits a call exactly as
+// an HTTP request with method to the handler named handlerName would be:
+// GET for calls that only read, so read-only API keys may make them and they
+// are not charged to the rate limit of mutating requests. Credentials are
+// read from the authorization and x-api-key metadata. It returns the gin
+// context of the caller, which identifies it for the audit trail.
+func (cp *ClusterOpsPlugin) authorizeRPC(ctx context.Context, method, handlerName string) (*gin.Context, error) {
+	req, err := http.NewRequestWithContext(ctx, method, pluginAPIBase, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = req
+	var caller *gin.Context
+	cp.withRequestID(cp.authenticated(cp.authorized(handlerName, cp.limited(func(c *gin.Context) {
+		caller = c
+	}))))(c)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, c.GetString("requestId")))
+	if caller != nil {
+		return caller, nil
+	}
+
+	var body gin.H
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return nil, rpcError(recorder.Code, body)
+}
+
+// rpcError converts the status and body of a rejected HTTP request into a
+// gRPC status
+func rpcError(httpStatus int, body gin.H) error {
+	code := codes.Unknown
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		code = codes.Unavailable
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+
+	message, _ := body["error"].(string)
+	switch details := body["details"].(type) {
+	case nil:
+	case string:
+		message += ": " + details
+	default:
+		encoded, _ := json.Marshal(details)
+		message += ": " + string(encoded)
+	}
+	return status.Error(code, message)
+}
+
+// auditRPC records a mutating call in the audit trail like audited does
+// for HTTP requests
+func (cp *ClusterOpsPlugin) auditRPC(caller *gin.Context, action, clusterName string, request interface{}, recorder *commandRecorder, op Operation, rejected *requestError) {
+	body, _ := json.Marshal(request)
+	entry := AuditEntry{
+		Timestamp:   time.Now(),
+		Actor:       cp.requestActor(caller),
+		SourceIP:    caller.ClientIP(),
+		Action:      action,
+		ClusterName: clusterName,
+		OperationID: op.ID,
+		RequestID:   caller.GetString("requestId"),
+		Payload:     redactPayload(body),
+		StatusCode:  http.StatusAccepted,
+		Outcome:     "success",
+	}
+	if rejected != nil {
+		entry.StatusCode = rejected.status
+		entry.Outcome = "failure"
+	}
+	recorder.mutex.Lock()
+	entry.Commands = append([]string{}, recorder.commands...)
+	recorder.mutex.Unlock()
+	cp.audit.Append(entry)
+}
+
+func (s *grpcService) OnboardCluster(ctx context.Context, in *clusteropsv1.OnboardClusterRequest) (*clusteropsv1.Operation, error) {
+	caller, err := s.cp.authorizeRPC(ctx, http.MethodPost, "OnboardClusterHandler")
+	if err != nil {
+		return nil, err
+	}
+	req := ClusterOnboardRequest{
+		ClusterName: in.ClusterName,
+		Kubeconfig:  in.Kubeconfig,
+		Hub:         in.Hub,
+		Type:        in.Type,
+		Labels:      in.Labels,
+		Annotations: in.Annotations,
+		Addons:      in.Addons,
+		Resume:      in.Resume,
+	}
+
+	recorder := &commandRecorder{}
+	ctx = context.WithValue(caller.Request.Context(), commandRecorderKey{}, recorder)
+	op, rejected := s.cp.beginOnboarding(ctx, caller.GetHeader("traceparent"), req)
+	s.cp.auditRPC(caller, "onboard", req.ClusterName, req, recorder, op, rejected)
+	if rejected != nil {
+		return nil, rpcError(rejected.status, rejected.body)
+	}
+	return operationMessage(op), nil
+}
+
+func (s *grpcService) DetachCluster(ctx context.Context, in *clusteropsv1.DetachClusterRequest) (*clusteropsv1.Operation, error) {
+	caller, err := s.cp.authorizeRPC(ctx, http.MethodPost, "DetachClusterHandler")
+	if err != nil {
+		return nil, err
+	}
+	if in.ClusterName == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing required field: clusterName")
+	}
+
+	opts := detachOptions{cleanup: in.Cleanup, force: in.Force}
+	op, rejected := s.cp.beginDetach(caller.Request.Context(), caller.GetHeader("traceparent"), in.ClusterName, in.Hub, opts)
+	request := gin.H{"clusterName": in.ClusterName, "hub": in.Hub, "cleanup": in.Cleanup, "force": in.Force}
+	s.cp.auditRPC(caller, "detach", in.ClusterName, request, &commandRecorder{}, op, rejected)
+	if rejected != nil {
+		return nil, rpcError(rejected.status, rejected.body)
+	}
+	return operationMessage(op), nil
+}
+
+func (s *grpcService) ListClusters(ctx context.Context, in *clusteropsv1.ListClustersRequest) (*clusteropsv1.ListClustersResponse, error) {
+	if _, err := s.cp.authorizeRPC(ctx, http.MethodGet, "ListClustersHandler"); err != nil {
+		return nil, err
+	}
+	selector, err := parseLabelSelector(in.LabelSelector)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid label_selector: %v", err)
+	}
+
+	resp := &clusteropsv1.ListClustersResponse{}
+	for _, record := range s.cp.clusters.List() {
+		if in.Status != "" && !strings.EqualFold(string(record.State), in.Status) {
+			continue
+		}
+		if in.Hub != "" && s.cp.clusterHub(record.Name).Name != in.Hub {
+			continue
+		}
+		if !matchLabels(selector, record.Labels) {
+			continue
+		}
+		cluster := &clusteropsv1.Cluster{
+			Name:      record.Name,
+			State:     string(record.State),
+			Message:   record.Message,
+			Hub:       s.cp.clusterHub(record.Name).Name,
+			Type:      record.Type,
+			Labels:    record.Labels,
+			Addons:    record.Addons,
+			UpdatedAt: timestamppb.New(record.UpdatedAt),
+		}
+		if record.OnboardedAt != nil {
+			cluster.OnboardedAt = timestamppb.New(*record.OnboardedAt)
+		}
+		resp.Clusters = append(resp.Clusters, cluster)
+	}
+	return resp, nil
+}
+
+func (s *grpcService) WatchEvents(in *clusteropsv1.WatchEventsRequest, stream clusteropsv1.ClusterOps_WatchEventsServer) error {
+	if _, err := s.cp.authorizeRPC(stream.Context(), http.MethodGet, "StreamClusterEventsHandler"); err != nil {
+		return err
+	}
+	if in.ClusterName == "" {
+		return status.Error(codes.InvalidArgument, "Missing required field: cluster_name")
+	}
+	return s.cp.streamEvents(stream, in.ClusterName, "", in.IncludeHistory, in.AfterId)
+}
+
+func (s *grpcService) WatchOnboardingEvents(in *clusteropsv1.WatchOnboardingEventsRequest, stream clusteropsv1.ClusterOps_WatchOnboardingEventsServer) error {
+	if _, err := s.cp.authorizeRPC(stream.Context(), http.MethodGet, "StreamClusterEventsHandler"); err != nil {
+		return err
+	}
+	if _, ok := levelSeverity[in.MinLevel]; in.MinLevel != "" && !ok {
+		return status.Error(codes.InvalidArgument, "Invalid min_level: must be info, warn or error")
+	}
+	if in.AfterId > 0 && in.ClusterName == "" {
+		return status.Error(codes.InvalidArgument, "after_id requires cluster_name, since event IDs are per cluster")
+	}
+	return s.cp.streamEvents(stream, in.ClusterName, in.MinLevel, in.IncludeHistory, in.AfterId)
+}
+
+// streamEvents sends the events of a cluster, or of every cluster when
+// clusterName is empty, at or above minLevel until the client cancels.
+// History is sent when includeHistory is set, or from the event after
+// afterID when resuming. When the subscriber buffer overflows the stream
+// ends with ResourceExhausted, naming the last event delivered so the
+// client can resume with after_id.
+func (cp *ClusterOpsPlugin) streamEvents(stream grpc.ServerStreamingServer[clusteropsv1.Event], clusterName, minLevel string, includeHistory bool, afterID int64) error {
+	history, events, unsubscribe := cp.events.Subscribe(clusterName)
+	defer unsubscribe()
+
+	// lastIDs holds the ID of the latest event seen of each cluster
+	lastIDs := make(map[string]int64)
+	for _, event := range history {
+		lastIDs[event.ClusterName] = max(lastIDs[event.ClusterName], event.ID)
+	}
+	if includeHistory || afterID > 0 {
+		for _, event := range filterEvents(eventsAfter(history, afterID), minLevel, time.Time{}) {
+			if err := stream.Send(eventMessage(event)); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			// Event IDs of a cluster are consecutive, so a gap means the
+			// subscriber buffer overflowed
+			lastID := lastIDs[event.ClusterName]
+			if lastID > 0 && event.ID > lastID+1 {
+				return status.Errorf(codes.ResourceExhausted, "Events of cluster %s dropped, resume with after_id=%d", event.ClusterName, lastID)
+			}
+			lastIDs[event.ClusterName] = event.ID
+			if minLevel != "" && levelSeverity[eventLevel(event)] < levelSeverity[minLevel] {
+				continue
+			}
+			if err := stream.Send(eventMessage(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func operationMessage(op Operation) *clusteropsv1.Operation {
+	message := &clusteropsv1.Operation{
+		Id:          op.ID,
+		Type:        op.Type,
+		ClusterName: op.ClusterName,
+		Status:      op.Status,
+		Result:      op.Result,
+		Error:       op.Error,
+		CreatedAt:   timestamppb.New(op.CreatedAt),
+	}
+	if op.StartedAt != nil {
+		message.StartedAt = timestamppb.New(*op.StartedAt)
+	}
+	if op.CompletedAt != nil {
+		message.CompletedAt = timestamppb.New(*op.CompletedAt)
+	}
+	for _, step := range op.Steps {
+		stepMessage := &clusteropsv1.OperationStep{Name: step.Name, Status: step.Status, Message: step.Message}
+		if step.StartedAt != nil {
+			stepMessage.StartedAt = timestamppb.New(*step.StartedAt)
+		}
+		if step.CompletedAt != nil {
+			stepMessage.CompletedAt = timestamppb.New(*step.CompletedAt)
+		}
+		message.Steps = append(message.Steps, stepMessage)
+	}
+	return message
+}
+
+func eventMessage(event OnboardingEvent) *clusteropsv1.Event {
+	return &clusteropsv1.Event{
+		Id:          event.ID,
+		ClusterName: event.ClusterName,
+		Type:        event.Type,
+		Status:      event.Status,
+		Message:     event.Message,
+		Timestamp:   timestamppb.New(event.Timestamp),
+		Level:       eventLevel(event),
+		Step:        event.Step,
+		OperationId: event.OperationID,
+		DurationMs:  event.DurationMs,
+		Remediation: event.Remediation,
+		RequestId:   event.RequestID,
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/priyanshuharshbodhi1/github-plugin/pkg/clusteropsv1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCReadOnlyAPIKey(t *testing.T) {
+	cp := newSimulatedPlugin(t)
+	keys, err := parseAPIKeys([]apiKeyConfig{{Name: "viewer", Key: "viewer-key", Permission: apiKeyReadOnly}}, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.apiKeys.Replace("config", keys)
+	cp.pluginConfig.RateLimitPerMinute = 1
+	cp.pluginConfig.RateLimitBurst = 1
+	service := &grpcService{cp: cp}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "viewer-key"))
+
+	// reads are allowed and not charged to the mutating rate limit
+	for i := 0; i < 3; i++ {
+		if _, err := service.ListClusters(ctx, &clusteropsv1.ListClustersRequest{}); err != nil {
+			t.Fatalf("ListClusters call %d: %v", i+1, err)
+		}
+	}
+
+	_, err = service.DetachCluster(ctx, &clusteropsv1.DetachClusterRequest{ClusterName: "spoke"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DetachCluster with a read-only key returned %v, want PermissionDenied", err)
+	}
+}
+
+// blockingEventStream is the server side of an event stream whose client
+// takes no events until unblock is closed
+type blockingEventStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	unblock chan struct{}
+	sent    chan int64
+}
+
+func (s *blockingEventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *blockingEventStream) Send(event *clusteropsv1.Event) error {
+	<-s.unblock
+	s.sent <- event.Id
+	return nil
+}
+
+func TestStreamEventsEndsOnOverflow(t *testing.T) {
+	cp := newSimulatedPlugin(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &blockingEventStream{ctx: ctx, unblock: make(chan struct{}), sent: make(chan int64, 2*eventSubscriberBuffer)}
+	done := make(chan error)
+	go func() { done <- cp.streamEvents(stream, "watched", "", false, 0) }()
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		cp.events.mutex.RLock()
+		subscribed = len(cp.events.subscribers["watched"]) > 0
+		cp.events.mutex.RUnlock()
+	}
+
+	// the first event is taken and blocks in Send, the buffer holds the
+	// next ones and the last one is dropped
+	for i := 0; i < eventSubscriberBuffer+2; i++ {
+		cp.events.Append(OnboardingEvent{ClusterName: "watched", Type: "progress", Status: "info"})
+	}
+	close(stream.unblock)
+	for i := 1; i <= eventSubscriberBuffer+1; i++ {
+		if id := <-stream.sent; id != int64(i) {
+			t.Fatalf("event %d sent as %d", i, id)
+		}
+	}
+	cp.events.Append(OnboardingEvent{ClusterName: "watched", Type: "progress", Status: "info"})
+
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("stream ended with %v, want ResourceExhausted", err)
+		}
+		if want := fmt.Sprintf("after_id=%d", eventSubscriberBuffer+1); !strings.Contains(err.Error(), want) {
+			t.Errorf("stream ended with %q, want it to name %s", err, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("stream did not end after events were dropped")
+	}
+}
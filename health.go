@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds each dependency check of the deep health check
+const healthCheckTimeout = 5 * time.Second
+
+// Statuses of a dependency check
+const (
+	dependencyOK     = "ok"
+	dependencyFailed = "failed"
+)
+
+// DependencyHealth is the result of checking one external dependency
+type DependencyHealth struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Version   string `json:"version,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// dependencyCheck probes a dependency, returning its version when it has one
+type dependencyCheck struct {
+	name  string
+	probe func(ctx context.Context) (version, message string, err error)
+}
+
+// dependencyChecks lists the checks of the deep health check: every hub,
+// the CLIs the pipelines run, kubeconfig_dir and the state store
+func (cp *ClusterOpsPlugin) dependencyChecks() []dependencyCheck {
+	var checks []dependencyCheck
+	for _, hub := range cp.hubList() {
+		checks = append(checks, dependencyCheck{"hub/" + hub.Name, func(ctx context.Context) (string, string, error) {
+			_, err := cp.kubectlHub(withHub(ctx, hub), "get", "--raw", "/readyz")
+			return "", "context " + hub.Context, err
+		}})
+	}
+	checks = append(checks,
+		dependencyCheck{"kubectl", cp.checkKubectl},
+		dependencyCheck{"clusteradm", cp.checkClusteradm},
+		dependencyCheck{"kubeconfigDir", cp.checkKubeconfigDir},
+		dependencyCheck{"stateStore", cp.checkStateStore},
+	)
+	return checks
+}
+
+// checkDependencies runs every dependency check concurrently
+func (cp *ClusterOpsPlugin) checkDependencies(ctx context.Context) []DependencyHealth {
+	checks := cp.dependencyChecks()
+	results := make([]DependencyHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check dependencyCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			version, message, err := check.probe(ctx)
+			result := DependencyHealth{
+				Name:      check.name,
+				Status:    dependencyOK,
+				LatencyMs: time.Since(start).Milliseconds(),
+				Version:   version,
+				Message:   message,
+			}
+			if err != nil {
+				result.Status = dependencyFailed
+				result.Message = err.Error()
+			}
+			results[i] = result
+		}(i, check)
+	}
+	wg.Wait()
+	return results
+}
+
+func (cp *ClusterOpsPlugin) checkKubectl(ctx context.Context) (string, string, error) {
+	path, err := cp.runner.LookPath("kubectl")
+	if err != nil {
+		return "", "", err
+	}
+	out, err := cp.runner.Run(ctx, Command{Name: "kubectl", Args: []string{"version", "--client", "-o", "json"}})
+	if err != nil {
+		return "", path, nil
+	}
+	var version struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}
+	json.Unmarshal(out, &version)
+	return version.ClientVersion.GitVersion, path, nil
+}
+
+func (cp *ClusterOpsPlugin) checkClusteradm(ctx context.Context) (string, string, error) {
+	path, err := cp.runner.LookPath("clusteradm")
+	if err != nil {
+		return "", "", err
+	}
+	// clusteradm version also queries the cluster of the current context,
+	// so only the client line is kept and failures to reach it are ignored
+	out, _ := cp.runner.Run(ctx, Command{Name: "clusteradm", Args: []string{"version"}})
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.SplitN(line, ":", 2); len(fields) == 2 && strings.Contains(fields[0], "client") {
+			return strings.TrimSpace(fields[1]), path, nil
+		}
+	}
+	return "", path, nil
+}
+
+// checkKubeconfigDir verifies that kubeconfig_dir exists and is writable
+func (cp *ClusterOpsPlugin) checkKubeconfigDir(ctx context.Context) (string, string, error) {
+	dir := cp.settings().KubeconfigDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	file, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return "", "", err
+	}
+	file.Close()
+	return "", dir, os.Remove(file.Name())
+}
+
+func (cp *ClusterOpsPlugin) checkStateStore(ctx context.Context) (string, string, error) {
+	backend := cp.settings().StateBackend
+	if cp.state == nil {
+		return "", backend + " (local to this replica)", nil
+	}
+	return "", backend, cp.state.backend.Ping(ctx)
+}
+
+func (cp *ClusterOpsPlugin) DeepHealthCheckHandler(c *gin.Context) {
+	dependencies := cp.checkDependencies(c.Request.Context())
+	status, code := "healthy", http.StatusOK
+	for _, dependency := range dependencies {
+		if dependency.Status != dependencyOK {
+			status, code = "unhealthy", http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, gin.H{
+		"status":       status,
+		"initialized":  cp.initialized,
+		"uptime":       time.Since(cp.uptime).String(),
+		"dependencies": dependencies,
+		"plugin":       "cluster-ops-plugin",
+	})
+}
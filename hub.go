@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultHubContext is the kubeconfig context of the ITS hub when none is configured
+const defaultHubContext = "its1"
+
+// hubRequestTimeout bounds a single kubectl invocation against the hub
+const hubRequestTimeout = 30 * time.Second
+
+// managedCluster is the subset of the OCM ManagedCluster resource the plugin reads
+type managedCluster struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		ResourceVersion   string            `json:"resourceVersion"`
+		Labels            map[string]string `json:"labels"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+	} `json:"metadata"`
+	Spec struct {
+		HubAcceptsClient            bool           `json:"hubAcceptsClient"`
+		Taints                      []clusterTaint `json:"taints"`
+		ManagedClusterClientConfigs []struct {
+			URL      string `json:"url"`
+			CABundle string `json:"caBundle"`
+		} `json:"managedClusterClientConfigs"`
+	} `json:"spec"`
+	Status struct {
+		Version struct {
+			Kubernetes string `json:"kubernetes"`
+		} `json:"version"`
+		ClusterClaims []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"clusterClaims"`
+		Conditions  []clusterCondition `json:"conditions"`
+		Capacity    map[string]string  `json:"capacity"`
+		Allocatable map[string]string  `json:"allocatable"`
+	} `json:"status"`
+}
+
+// clusterCondition is a status condition of a ManagedCluster
+type clusterCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// clusterTaint is a taint on a ManagedCluster that repels placements
+type clusterTaint struct {
+	Key       string     `json:"key"`
+	Value     string     `json:"value,omitempty"`
+	Effect    string     `json:"effect"`
+	TimeAdded *time.Time `json:"timeAdded,omitempty"`
+}
+
+// conditionTrue reports whether the condition of the given type is True
+func (mc *managedCluster) conditionTrue(conditionType string) bool {
+	for _, condition := range mc.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == "True"
+		}
+	}
+	return false
+}
+
+// available reports whether the ManagedClusterConditionAvailable condition is True
+func (mc *managedCluster) available() bool {
+	return mc.conditionTrue("ManagedClusterConditionAvailable")
+}
+
+// health summarizes the ManagedClusterConditionAvailable condition as
+// healthy, unhealthy, or unknown while the agent has not reported it or the
+// hub lost contact with the agent
+func (mc *managedCluster) health() string {
+	for _, condition := range mc.Status.Conditions {
+		if condition.Type == "ManagedClusterConditionAvailable" {
+			switch condition.Status {
+			case "True":
+				return "healthy"
+			case "False":
+				return "unhealthy"
+			}
+		}
+	}
+	return "unknown"
+}
+
+// claim returns the value of a cluster claim reported by the agent
+func (mc *managedCluster) claim(name string) string {
+	for _, claim := range mc.Status.ClusterClaims {
+		if claim.Name == name {
+			return claim.Value
+		}
+	}
+	return ""
+}
+
+// recordFromManagedCluster builds the record of a cluster discovered on a hub
+func recordFromManagedCluster(mc *managedCluster, hub, message string) ClusterRecord {
+	record := ClusterRecord{
+		Name:    mc.Metadata.Name,
+		Hub:     hub,
+		State:   StateOnboarded,
+		Message: message,
+		Type:    mc.claim("product.open-cluster-management.io"),
+		Labels:  mc.Metadata.Labels,
+	}
+	if !mc.available() {
+		record.State = StateUnavailable
+	}
+	if !mc.Metadata.CreationTimestamp.IsZero() {
+		onboardedAt := mc.Metadata.CreationTimestamp
+		record.OnboardedAt = &onboardedAt
+	}
+	return record
+}
+
+// kubectlHub runs kubectl against the hub context and returns its stdout
+func (cp *ClusterOpsPlugin) kubectlHub(ctx context.Context, args ...string) ([]byte, error) {
+	return cp.kubectlHubWithInput(ctx, nil, args...)
+}
+
+// kubectlHubWithInput runs kubectl against the hub context with input on stdin
+func (cp *ClusterOpsPlugin) kubectlHubWithInput(ctx context.Context, input []byte, args ...string) (out []byte, err error) {
+	ctx, span := startChildSpan(ctx, "kubectl "+args[0], spanKindClient)
+	span.SetAttribute("kubectl.args", strings.Join(args, " "))
+	defer func() { span.End(err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, hubRequestTimeout)
+	defer cancel()
+
+	out, err = cp.hub.Kubectl(ctx, input, args...)
+	if err != nil {
+		return nil, fmt.Errorf("kubectl %s failed: %v", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// applyHubObject creates or updates an object on the hub from its JSON form
+func (cp *ClusterOpsPlugin) applyHubObject(ctx context.Context, obj interface{}) error {
+	manifest, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = cp.kubectlHubWithInput(ctx, manifest, "apply", "-f", "-")
+	return err
+}
+
+// managedClusterRequest calls the ManagedCluster API of the hub selected in
+// ctx. Errors are API errors, wrapped so apierrors can classify them.
+func (cp *ClusterOpsPlugin) managedClusterRequest(ctx context.Context, verb string, fn func(ctx context.Context, client dynamic.ResourceInterface) error) (err error) {
+	ctx, span := startChildSpan(ctx, verb+" managedcluster", spanKindClient)
+	defer func() { span.End(err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, hubRequestTimeout)
+	defer cancel()
+
+	client, err := cp.hubClient(cp.selectedHub(ctx))
+	if err != nil {
+		return err
+	}
+	return fn(ctx, client.Resource(managedClusterGVR))
+}
+
+// listManagedClusters returns every ManagedCluster registered with the hub
+func (cp *ClusterOpsPlugin) listManagedClusters(ctx context.Context) ([]managedCluster, error) {
+	var clusters []managedCluster
+	err := cp.managedClusterRequest(ctx, "list", func(ctx context.Context, client dynamic.ResourceInterface) error {
+		list, err := client.List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list ManagedClusters: %w", err)
+		}
+		clusters = make([]managedCluster, len(list.Items))
+		for i := range list.Items {
+			if err := decodeUnstructured(&list.Items[i], &clusters[i]); err != nil {
+				return fmt.Errorf("failed to decode ManagedCluster %s: %v", list.Items[i].GetName(), err)
+			}
+		}
+		return nil
+	})
+	return clusters, err
+}
+
+// reconcileClusters seeds the cluster store with the ManagedClusters already
+// joined to the hub selected by ctx so state survives plugin restarts.
+// Clusters the plugin is already tracking are left untouched.
+func (cp *ClusterOpsPlugin) reconcileClusters(ctx context.Context, hub HubConfig) error {
+	clusters, err := cp.listManagedClusters(withHub(ctx, hub))
+	if err != nil {
+		return err
+	}
+
+	for i := range clusters {
+		cp.clusters.Seed(recordFromManagedCluster(&clusters[i], hub.Name, "Discovered on the hub"))
+	}
+	return nil
+}
+
+// reconcileOnStartup reconciles with every hub and records the outcome in the
+// plugin metrics, since a missing hub must not prevent the plugin from loading
+func (cp *ClusterOpsPlugin) reconcileOnStartup() {
+	var failures []string
+	for _, hub := range cp.hubList() {
+		if err := cp.reconcileClusters(context.Background(), hub); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", hub.Name, err))
+			cp.logger.Warn("Reconciling with the hub failed", "hub", hub.Name, "error", err)
+		}
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if len(failures) > 0 {
+		cp.metrics["hub_reconcile_error"] = strings.Join(failures, "; ")
+		return
+	}
+	delete(cp.metrics, "hub_reconcile_error")
+	cp.metrics["hub_reconciled_at"] = time.Now().Format(time.RFC3339)
+}
+
+// getManagedCluster returns a single ManagedCluster from the hub
+func (cp *ClusterOpsPlugin) getManagedCluster(ctx context.Context, name string) (*managedCluster, error) {
+	var mc managedCluster
+	err := cp.managedClusterRequest(ctx, "get", func(ctx context.Context, client dynamic.ResourceInterface) error {
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get ManagedCluster %s: %w", name, err)
+		}
+		if err := decodeUnstructured(obj, &mc); err != nil {
+			return fmt.Errorf("failed to decode ManagedCluster %s: %v", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mc, nil
+}
+
+// lastHeartbeat returns when the agent of a cluster last reported to the hub.
+// It prefers the renew time of the managed cluster lease the agent keeps in
+// its cluster namespace and falls back to the last transition of the
+// Available condition.
+func (cp *ClusterOpsPlugin) lastHeartbeat(ctx context.Context, name string) (time.Time, error) {
+	out, err := cp.kubectlHub(ctx, "get", "lease", "managed-cluster-lease", "-n", name, "-o", "json")
+	if err == nil {
+		var lease struct {
+			Spec struct {
+				RenewTime time.Time `json:"renewTime"`
+			} `json:"spec"`
+		}
+		if json.Unmarshal(out, &lease) == nil && !lease.Spec.RenewTime.IsZero() {
+			return lease.Spec.RenewTime, nil
+		}
+	}
+
+	mc, err := cp.getManagedCluster(ctx, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, condition := range mc.Status.Conditions {
+		if condition.Type == "ManagedClusterConditionAvailable" && condition.Status == "True" {
+			return condition.LastTransitionTime, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cluster %s has not reported a heartbeat", name)
+}
+
+// patchManagedCluster applies a JSON merge patch to a ManagedCluster and
+// returns the updated object
+func (cp *ClusterOpsPlugin) patchManagedCluster(ctx context.Context, name string, patch interface{}) (*managedCluster, error) {
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var mc managedCluster
+	err = cp.managedClusterRequest(ctx, "patch", func(ctx context.Context, client dynamic.ResourceInterface) error {
+		obj, err := client.Patch(ctx, name, types.MergePatchType, payload, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to patch ManagedCluster %s: %w", name, err)
+		}
+		if err := decodeUnstructured(obj, &mc); err != nil {
+			return fmt.Errorf("failed to decode ManagedCluster %s: %v", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mc, nil
+}
+
+// deleteManagedCluster deletes a ManagedCluster from the hub; one that is
+// already gone is not an error. With wait set it returns once the
+// ManagedCluster is gone rather than Terminating.
+func (cp *ClusterOpsPlugin) deleteManagedCluster(ctx context.Context, name string, wait bool) error {
+	return cp.managedClusterRequest(ctx, "delete", func(ctx context.Context, client dynamic.ResourceInterface) error {
+		err := client.Delete(ctx, name, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete ManagedCluster %s: %w", name, err)
+		}
+		if !wait {
+			return nil
+		}
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			_, err := client.Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("ManagedCluster %s was not deleted in time: %w", name, ctx.Err())
+			case <-ticker.C:
+			}
+		}
+	})
+}
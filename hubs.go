@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHubName names the single hub built from its_context when no hubs
+// are configured
+const defaultHubName = "default"
+
+// Hub sources
+const (
+	hubSourceConfig   = "config"
+	hubSourceKubeFlex = "kubeflex"
+)
+
+// HubConfig is an ITS hub the plugin can onboard clusters to
+type HubConfig struct {
+	Name    string `json:"name"`
+	Context string `json:"context,omitempty"`
+	// Kubeconfig is the path of a kubeconfig written for a discovered hub;
+	// without it Context is looked up in the default kubeconfig
+	Kubeconfig string `json:"-"`
+	Source     string `json:"source"`
+	Default    bool   `json:"default"`
+}
+
+// parseHubs reads the hubs configuration, a map from hub name to either its
+// kubeconfig context or an object with a context field. default_hub selects
+// the hub used by requests that do not name one; without it the first hub by
+// name is the default. When no hubs are configured, the hub at itsContext
+// is the only hub.
+func parseHubs(config map[string]interface{}, itsContext string) ([]HubConfig, error) {
+	raw, _ := config["hubs"].(map[string]interface{})
+	if len(raw) == 0 {
+		hubContext := itsContext
+		if hubContext == "" {
+			hubContext = defaultHubContext
+		}
+		return []HubConfig{{Name: defaultHubName, Context: hubContext, Source: hubSourceConfig, Default: true}}, nil
+	}
+
+	hubs := make([]HubConfig, 0, len(raw))
+	for name, value := range raw {
+		hub := HubConfig{Name: name, Source: hubSourceConfig}
+		switch v := value.(type) {
+		case string:
+			hub.Context = v
+		case map[string]interface{}:
+			hub.Context, _ = v["context"].(string)
+		}
+		if hub.Context == "" {
+			return nil, fmt.Errorf("hub %q has no context", name)
+		}
+		hubs = append(hubs, hub)
+	}
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].Name < hubs[j].Name })
+
+	defaultHub, _ := config["default_hub"].(string)
+	if defaultHub == "" {
+		defaultHub = hubs[0].Name
+	}
+	for i := range hubs {
+		if hubs[i].Name == defaultHub {
+			hubs[i].Default = true
+			return hubs, nil
+		}
+	}
+	return nil, fmt.Errorf("default_hub %q is not one of the configured hubs", defaultHub)
+}
+
+// hubList returns the configured hubs, sorted by name
+func (cp *ClusterOpsPlugin) hubList() []HubConfig {
+	cp.mutex.RLock()
+	hubs := cp.hubs
+	config := cp.config
+	itsContext := cp.pluginConfig.ITSContext
+	cp.mutex.RUnlock()
+
+	if len(hubs) == 0 {
+		hubs, _ = parseHubs(config, itsContext)
+	}
+	return append([]HubConfig(nil), hubs...)
+}
+
+// lookupHub returns a hub by name, or the default hub for an empty name
+func (cp *ClusterOpsPlugin) lookupHub(name string) (HubConfig, error) {
+	hubs := cp.hubList()
+	for _, hub := range hubs {
+		if hub.Name == name || (name == "" && hub.Default) {
+			return hub, nil
+		}
+	}
+	return HubConfig{}, fmt.Errorf("unknown hub %q", name)
+}
+
+// clusterHub returns the hub a tracked cluster belongs to, falling back to
+// the default hub
+func (cp *ClusterOpsPlugin) clusterHub(clusterName string) HubConfig {
+	record, _ := cp.clusters.Get(clusterName)
+	if hub, err := cp.lookupHub(record.Hub); err == nil {
+		return hub
+	}
+	hub, _ := cp.lookupHub("")
+	return hub
+}
+
+type hubContextKey struct{}
+
+// withHub selects the hub that kubectl calls made with ctx run against
+func withHub(ctx context.Context, hub HubConfig) context.Context {
+	return context.WithValue(ctx, hubContextKey{}, hub)
+}
+
+// selectedHub returns the hub selected by ctx, or the default hub
+func (cp *ClusterOpsPlugin) selectedHub(ctx context.Context) HubConfig {
+	if hub, ok := ctx.Value(hubContextKey{}).(HubConfig); ok {
+		return hub
+	}
+	hub, _ := cp.lookupHub("")
+	return hub
+}
+
+// hubFlags returns the kubectl flags addressing the hub selected by ctx
+func (cp *ClusterOpsPlugin) hubFlags(ctx context.Context) []string {
+	hub := cp.selectedHub(ctx)
+	var flags []string
+	if hub.Kubeconfig != "" {
+		flags = append(flags, "--kubeconfig", hub.Kubeconfig)
+	}
+	if hub.Context != "" {
+		flags = append(flags, "--context", hub.Context)
+	}
+	return flags
+}
+
+// withRequestHub selects the hub of a request from its hub query parameter,
+// or from the cluster named in its path
+func (cp *ClusterOpsPlugin) withRequestHub(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var hub HubConfig
+		if name := c.Query("hub"); name != "" {
+			var err error
+			if hub, err = cp.lookupHub(name); err != nil {
+				c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid hub", err.Error()))
+				return
+			}
+		} else if cluster := firstNonEmpty(c.Param("name"), c.Param("cluster")); cluster != "" {
+			hub = cp.clusterHub(cluster)
+		} else {
+			hub, _ = cp.lookupHub("")
+		}
+		c.Request = c.Request.WithContext(withHub(c.Request.Context(), hub))
+		handler(c)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	pagerDutyEventsURL     = "https://events.pagerduty.com/v2/enqueue"
+	defaultOpsgenieAPIURL  = "https://api.opsgenie.com"
+	incidentTimeout        = 10 * time.Second
+	maxIncidentTrailEvents = 50
+)
+
+// incidentDedupKey keys incidents on the cluster so repeated failures of the
+// same cluster update one incident instead of opening new ones
+func incidentDedupKey(clusterName string) string {
+	return "cluster-ops-plugin/" + clusterName
+}
+
+// raiseIncident opens an incident in PagerDuty and/or Opsgenie when an
+// operation fails, and resolves it when a later operation on the same
+// cluster succeeds. Cancelled operations are not incidents.
+func (cp *ClusterOpsPlugin) raiseIncident(event LifecycleEvent) {
+	if event.Type != lifecycleOperationCompleted {
+		return
+	}
+	settings := cp.settings()
+	routingKey := settings.PagerDutyRoutingKey
+	opsgenieKey := settings.OpsgenieAPIKey
+	opsgenieURL := strings.TrimSuffix(settings.OpsgenieAPIURL, "/")
+	if routingKey == "" && opsgenieKey == "" {
+		return
+	}
+
+	op := event.Operation
+	if op.Status != OperationFailed && op.Status != OperationSucceeded {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), incidentTimeout)
+		defer cancel()
+
+		dedupKey := incidentDedupKey(op.ClusterName)
+		var err error
+		if op.Status == OperationFailed {
+			summary := fmt.Sprintf("%s of cluster %s failed: %s", op.Type, op.ClusterName, op.Error)
+			details := map[string]interface{}{
+				"operationId": op.ID,
+				"operation":   op.Type,
+				"cluster":     op.ClusterName,
+				"error":       op.Error,
+				"steps":       op.Steps,
+				"events":      cp.incidentTrail(op.ClusterName),
+			}
+			if routingKey != "" {
+				err = postJSON(ctx, pagerDutyEventsURL, map[string]interface{}{
+					"routing_key":  routingKey,
+					"event_action": "trigger",
+					"dedup_key":    dedupKey,
+					"payload": map[string]interface{}{
+						"summary":        summary,
+						"source":         op.ClusterName,
+						"severity":       "error",
+						"component":      "cluster-ops-plugin",
+						"group":          op.Type,
+						"custom_details": details,
+					},
+				})
+				cp.logIncidentError("PagerDuty", op.ClusterName, err)
+			}
+			if opsgenieKey != "" {
+				err = postOpsgenie(ctx, opsgenieURL+"/v2/alerts", opsgenieKey, map[string]interface{}{
+					"message":     truncate(summary, 130),
+					"alias":       dedupKey,
+					"description": summary,
+					"details":     map[string]string{"operationId": op.ID, "operation": op.Type, "cluster": op.ClusterName},
+					"note":        describeTrail(cp.incidentTrail(op.ClusterName)),
+					"source":      "cluster-ops-plugin",
+					"priority":    "P2",
+					"tags":        []string{"kubestellar", op.Type},
+				})
+				cp.logIncidentError("Opsgenie", op.ClusterName, err)
+			}
+			return
+		}
+
+		if routingKey != "" {
+			err = postJSON(ctx, pagerDutyEventsURL, map[string]interface{}{
+				"routing_key":  routingKey,
+				"event_action": "resolve",
+				"dedup_key":    dedupKey,
+			})
+			cp.logIncidentError("PagerDuty", op.ClusterName, err)
+		}
+		if opsgenieKey != "" {
+			closeURL := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", opsgenieURL, url.PathEscape(dedupKey))
+			err = postOpsgenie(ctx, closeURL, opsgenieKey, map[string]interface{}{
+				"source": "cluster-ops-plugin",
+				"note":   event.Message,
+			})
+			cp.logIncidentError("Opsgenie", op.ClusterName, err)
+		}
+	}()
+}
+
+// incidentTrail returns the latest events of a cluster to attach to an incident
+func (cp *ClusterOpsPlugin) incidentTrail(clusterName string) []OnboardingEvent {
+	events := cp.events.List(clusterName)
+	if len(events) > maxIncidentTrailEvents {
+		events = events[len(events)-maxIncidentTrailEvents:]
+	}
+	return events
+}
+
+func describeTrail(events []OnboardingEvent) string {
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("%s [%s] %s: %s", event.Timestamp.Format(time.RFC3339), event.Status, event.Type, event.Message))
+	}
+	return truncate(strings.Join(lines, "\n"), 25000)
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
+
+func (cp *ClusterOpsPlugin) logIncidentError(service, clusterName string, err error) {
+	if err != nil {
+		cp.logger.Warn("Failed to update incident", "service", service, "cluster", clusterName, "error", err)
+	}
+}
+
+// postOpsgenie calls the Opsgenie Alert API, authenticating with an API key
+func postOpsgenie(ctx context.Context, endpoint, apiKey string, payload interface{}) error {
+	return postJSONWithHeaders(ctx, endpoint, payload, http.Header{"Authorization": {"GenieKey " + apiKey}})
+}
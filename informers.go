@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Resources the plugin keeps informers on
+var (
+	managedClusterGVR    = schema.GroupVersionResource{Group: "cluster.open-cluster-management.io", Version: "v1", Resource: "managedclusters"}
+	clusterOnboardingGVR = schema.GroupVersionResource{Group: onboardingGroup, Version: "v1alpha1", Resource: "clusteronboardings"}
+)
+
+// hubDynamicClient returns a client-go dynamic client for a hub, configured
+// from its kubeconfig or else from its context in the default kubeconfig, as
+// kubectl is
+func hubDynamicClient(hub HubConfig) (dynamic.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if hub.Kubeconfig != "" {
+		rules.ExplicitPath = hub.Kubeconfig
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: hub.Context}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client config of hub %s: %v", hub.Name, err)
+	}
+	return dynamic.NewForConfig(config)
+}
+
+// hubClient returns the dynamic client of a hub, or of the simulated hub in
+// simulation mode
+func (cp *ClusterOpsPlugin) hubClient(hub HubConfig) (dynamic.Interface, error) {
+	return cp.hubClients(hub)
+}
+
+// newHubInformer returns an informer on a resource of a hub in namespace, or
+// in every namespace when it is empty. The informer lists and watches once
+// it is run.
+func (cp *ClusterOpsPlugin) newHubInformer(hub HubConfig, gvr schema.GroupVersionResource, namespace string) (cache.SharedIndexInformer, error) {
+	client, err := cp.hubClient(hub)
+	if err != nil {
+		return nil, err
+	}
+	resource := client.Resource(gvr).Namespace(namespace)
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return resource.List(ctx, options)
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return resource.Watch(ctx, options)
+		},
+	}
+	return cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, 0, cache.Indexers{}), nil
+}
+
+// decodeUnstructured decodes an object delivered by an informer, including
+// the last known state of a deleted one, into the plugin's type for it
+func decodeUnstructured(obj interface{}, into interface{}) error {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
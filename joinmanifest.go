@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultImageRegistry hosts the upstream OCM images
+	defaultImageRegistry = "quay.io/open-cluster-management"
+	// defaultOCMVersion is the OCM release whose images the join manifest uses
+	defaultOCMVersion = "v0.15.0"
+	// defaultAgentNamespace is where the klusterlet runs its agents
+	defaultAgentNamespace = "open-cluster-management-agent"
+	// bootstrapKubeconfigSecret holds the kubeconfig the registration agent
+	// uses to submit its first CSR
+	bootstrapKubeconfigSecret = "bootstrap-hub-kubeconfig"
+)
+
+// hubConnection returns the API server URL and base64 CA bundle of the hub
+// selected in ctx, as seen from its kubeconfig
+func (cp *ClusterOpsPlugin) hubConnection(ctx context.Context) (string, string, error) {
+	out, err := cp.kubectlHub(ctx, "config", "view", "--raw", "--minify", "--flatten",
+		"-o", "jsonpath={.clusters[0].cluster.server} {.clusters[0].cluster.certificate-authority-data}")
+	if err != nil {
+		return "", "", err
+	}
+	server, caData, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	return server, caData, nil
+}
+
+// bootstrapKubeconfig builds the kubeconfig of the registration agent. As
+// clusteradm join does, a proxy is set as proxy-url and its CA is appended to
+// the hub CA bundle.
+func bootstrapKubeconfig(server, caData, token string, proxy *KlusterletProxy) (string, error) {
+	if proxy != nil && proxy.CAData != "" {
+		hubCA, err := base64.StdEncoding.DecodeString(caData)
+		if err != nil {
+			return "", fmt.Errorf("invalid hub CA data: %v", err)
+		}
+		caData = base64.StdEncoding.EncodeToString(append(append(hubCA, '\n'), proxy.CAData...))
+	}
+	kubeconfig, err := synthesizeKubeconfig("hub", server, caData, map[string]string{"token": token})
+	if err != nil || proxy == nil {
+		return kubeconfig, err
+	}
+
+	var config map[string]interface{}
+	if err := yaml.Unmarshal([]byte(kubeconfig), &config); err != nil {
+		return "", err
+	}
+	cluster := config["clusters"].([]interface{})[0].(map[string]interface{})["cluster"].(map[string]interface{})
+	cluster["proxy-url"] = proxy.HTTPSProxy
+	out, err := yaml.Marshal(config)
+	return string(out), err
+}
+
+// joinManifest renders the resources clusteradm join would create on a spoke,
+// so an administrator can apply them without sharing a kubeconfig with the
+// hub. The Klusterlet CRD is reduced to an unvalidated schema; the operator
+// is bound to cluster-admin since it manages the agent RBAC itself.
+func joinManifest(clusterName, version, bootstrap string, o *KlusterletOptions) ([]byte, error) {
+	registry := defaultImageRegistry
+	if o.ImageRegistry != "" {
+		registry = strings.TrimSuffix(o.ImageRegistry, "/")
+	}
+	agentNamespace := defaultAgentNamespace
+	if o.Namespace != "" {
+		agentNamespace = o.Namespace
+	}
+	operatorImage := fmt.Sprintf("%s/registration-operator:%s", registry, version)
+	labels := map[string]string{"app.kubernetes.io/managed-by": "cluster-ops-plugin"}
+
+	objects := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": klusterletOperatorNamespace, "labels": labels},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": agentNamespace, "labels": labels},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"type":       "Opaque",
+			"metadata":   map[string]interface{}{"name": bootstrapKubeconfigSecret, "namespace": agentNamespace, "labels": labels},
+			"stringData": map[string]string{"kubeconfig": bootstrap},
+		},
+	}
+
+	podSpec := map[string]interface{}{
+		"serviceAccountName": "klusterlet",
+		"containers": []interface{}{map[string]interface{}{
+			"name":  "klusterlet",
+			"image": operatorImage,
+			"args":  []string{"/registration-operator", "operator", "klusterlet"},
+		}},
+	}
+	if o.ImagePullSecret != "" {
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"type":       "kubernetes.io/dockerconfigjson",
+			"metadata":   map[string]interface{}{"name": imagePullSecretName, "namespace": klusterletOperatorNamespace, "labels": labels},
+			"stringData": map[string]string{".dockerconfigjson": o.ImagePullSecret},
+		})
+		podSpec["imagePullSecrets"] = []interface{}{map[string]string{"name": imagePullSecretName}}
+	}
+
+	objects = append(objects,
+		map[string]interface{}{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]interface{}{"name": klusterletCRD},
+			"spec": map[string]interface{}{
+				"group": "operator.open-cluster-management.io",
+				"scope": "Cluster",
+				"names": map[string]string{"kind": "Klusterlet", "listKind": "KlusterletList", "plural": "klusterlets", "singular": "klusterlet"},
+				"versions": []interface{}{map[string]interface{}{
+					"name":         "v1",
+					"served":       true,
+					"storage":      true,
+					"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+					"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{
+						"type":                                 "object",
+						"x-kubernetes-preserve-unknown-fields": true,
+					}},
+				}},
+			},
+		},
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   map[string]interface{}{"name": "klusterlet", "namespace": klusterletOperatorNamespace, "labels": labels},
+		},
+		map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]interface{}{"name": "klusterlet", "labels": labels},
+			"roleRef":    map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "cluster-admin"},
+			"subjects":   []interface{}{map[string]string{"kind": "ServiceAccount", "name": "klusterlet", "namespace": klusterletOperatorNamespace}},
+		},
+		map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "klusterlet", "namespace": klusterletOperatorNamespace, "labels": labels},
+			"spec": map[string]interface{}{
+				"replicas": 1,
+				"selector": map[string]interface{}{"matchLabels": map[string]string{"app": "klusterlet"}},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": map[string]string{"app": "klusterlet"}},
+					"spec":     podSpec,
+				},
+			},
+		},
+	)
+
+	mode := "Default"
+	if o.Singleton {
+		mode = "Singleton"
+	}
+	spec := o.klusterletPatch()["spec"].(map[string]interface{})
+	spec["clusterName"] = clusterName
+	spec["namespace"] = agentNamespace
+	spec["deployOption"] = map[string]string{"mode": mode}
+	spec["imagePullSpec"] = operatorImage
+	spec["registrationImagePullSpec"] = fmt.Sprintf("%s/registration:%s", registry, version)
+	spec["workImagePullSpec"] = fmt.Sprintf("%s/work:%s", registry, version)
+	if o.Resources != nil && (len(o.Resources.Requests) > 0 || len(o.Resources.Limits) > 0) {
+		spec["resourceRequirement"] = map[string]interface{}{"type": "ResourceRequirement", "resourceRequirements": o.Resources}
+	}
+	objects = append(objects, map[string]interface{}{
+		"apiVersion": "operator.open-cluster-management.io/v1",
+		"kind":       "Klusterlet",
+		"metadata":   map[string]interface{}{"name": "klusterlet", "labels": labels},
+		"spec":       spec,
+	})
+
+	// Objects go through JSON first so the json tags of the option types
+	// decide the field names
+	var manifest bytes.Buffer
+	for _, object := range objects {
+		raw, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		var generic interface{}
+		if err := yaml.Unmarshal(raw, &generic); err != nil {
+			return nil, err
+		}
+		out, err := yaml.Marshal(generic)
+		if err != nil {
+			return nil, err
+		}
+		manifest.WriteString("---\n")
+		manifest.Write(out)
+	}
+	return manifest.Bytes(), nil
+}
+
+// renderJoinManifest renders the join manifest of a cluster for the hub
+// selected in ctx with the configured join defaults
+func (cp *ClusterOpsPlugin) renderJoinManifest(ctx context.Context, clusterName string, singleton bool) ([]byte, error) {
+	options := cp.withJoinDefaults(&KlusterletOptions{Singleton: singleton})
+	if problems := options.validate(); len(problems) > 0 {
+		return nil, fmt.Errorf("invalid join defaults in the plugin configuration: %s", strings.Join(problems, "; "))
+	}
+	token, err := cp.joinToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a join token: %w", err)
+	}
+	server, caData, err := cp.hubConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the hub connection details: %w", err)
+	}
+	if token.HubAPIServer != "" {
+		server = token.HubAPIServer
+	}
+	bootstrap, err := bootstrapKubeconfig(server, caData, token.Token, options.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the bootstrap kubeconfig: %w", err)
+	}
+	manifest, err := joinManifest(clusterName, cp.settings().OCMVersion, bootstrap, options)
+	if err != nil {
+		return nil, err
+	}
+	cp.logEvent(clusterName, "join-manifest", "info", fmt.Sprintf("Join manifest rendered for hub %s", cp.selectedHub(ctx).Name))
+	return manifest, nil
+}
+
+func (cp *ClusterOpsPlugin) GetJoinManifestHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !namespacePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("Invalid cluster name %q", name), nil))
+		return
+	}
+
+	manifest, err := cp.renderJoinManifest(c.Request.Context(), name, c.Query("singleton") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to render the join manifest", err.Error()))
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-join.yaml", name))
+	c.Data(http.StatusOK, "application/yaml", manifest)
+}
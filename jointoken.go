@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// defaultJoinTokenTTL is assumed for join tokens that carry no expiry
+	defaultJoinTokenTTL = time.Hour
+	// joinTokenRefreshMargin is how long before expiry a cached token is
+	// replaced, so a join never starts with a token about to expire
+	joinTokenRefreshMargin = 5 * time.Minute
+)
+
+// Where the hub keeps the credentials spokes join with: bootstrap token
+// Secrets in kube-system, and the ServiceAccount clusteradm init creates for
+// hubs without one
+const (
+	bootstrapTokenNamespace          = "kube-system"
+	bootstrapTokenType               = "bootstrap.kubernetes.io/token"
+	bootstrapServiceAccount          = "cluster-bootstrap"
+	bootstrapServiceAccountNamespace = "open-cluster-management"
+)
+
+// Core resources the join token is read from
+var (
+	configMapGVR      = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretGVR         = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	serviceAccountGVR = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+)
+
+// bootstrapTokenPattern matches Kubernetes bootstrap tokens, <id>.<secret>
+var bootstrapTokenPattern = regexp.MustCompile(`^([a-z0-9]{6})\.[a-z0-9]{16}$`)
+
+// joinToken is the bootstrap token spokes use to join a hub
+type joinToken struct {
+	Token        string    `json:"-"`
+	HubAPIServer string    `json:"hubApiServer"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// joinTokenCache keeps the join token of each hub until shortly before it
+// expires, so bulk onboardings do not fetch a token per cluster
+type joinTokenCache struct {
+	tokens map[string]joinToken
+	mutex  sync.Mutex
+}
+
+func newJoinTokenCache() *joinTokenCache {
+	return &joinTokenCache{tokens: make(map[string]joinToken)}
+}
+
+// joinToken returns the join token of the hub selected in ctx, fetching a
+// new one from the hub API when none is cached or the cached one is close to
+// expiry
+func (cp *ClusterOpsPlugin) joinToken(ctx context.Context) (joinToken, error) {
+	hub := cp.selectedHub(ctx).Name
+	cache := cp.joinTokens
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if token, ok := cache.tokens[hub]; ok && time.Until(token.ExpiresAt) > joinTokenRefreshMargin {
+		return token, nil
+	}
+
+	token, err := cp.fetchJoinToken(ctx, cp.settings().JoinTokenTTL)
+	if err != nil {
+		return joinToken{}, err
+	}
+	cache.tokens[hub] = token
+	return token, nil
+}
+
+// fetchJoinToken reads the join token of the hub selected in ctx from its
+// API, as clusteradm get token does: a bootstrap token Secret created by
+// clusteradm init --use-bootstrap-token when there is one, otherwise a
+// TokenRequest of the bootstrap ServiceAccount for ttl. Bootstrap tokens
+// without an expiration are assumed to live for ttl.
+func (cp *ClusterOpsPlugin) fetchJoinToken(ctx context.Context, ttl time.Duration) (joinToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, hubRequestTimeout)
+	defer cancel()
+
+	client, err := cp.hubClient(cp.selectedHub(ctx))
+	if err != nil {
+		return joinToken{}, err
+	}
+	server, err := cp.hubAPIServer(ctx, client)
+	if err != nil {
+		return joinToken{}, err
+	}
+	token := joinToken{HubAPIServer: server, FetchedAt: time.Now()}
+
+	secret, err := bootstrapTokenSecret(ctx, client)
+	if err != nil {
+		return joinToken{}, err
+	}
+	if secret != nil {
+		token.Token = secret.token()
+		token.ExpiresAt = token.FetchedAt.Add(ttl)
+		if expiration, err := time.Parse(time.RFC3339, string(secret.Data["expiration"])); err == nil {
+			token.ExpiresAt = expiration
+		}
+		return token, nil
+	}
+
+	request := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "authentication.k8s.io/v1",
+		"kind":       "TokenRequest",
+		"metadata":   map[string]interface{}{"name": bootstrapServiceAccount},
+		"spec":       map[string]interface{}{"expirationSeconds": int64(ttl.Seconds())},
+	}}
+	obj, err := client.Resource(serviceAccountGVR).Namespace(bootstrapServiceAccountNamespace).Create(ctx, request, metav1.CreateOptions{}, "token")
+	if err != nil {
+		return joinToken{}, fmt.Errorf("hub has no bootstrap token and no token could be requested for ServiceAccount %s/%s: %w", bootstrapServiceAccountNamespace, bootstrapServiceAccount, err)
+	}
+	var response struct {
+		Status struct {
+			Token               string    `json:"token"`
+			ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+		} `json:"status"`
+	}
+	if err := decodeUnstructured(obj, &response); err != nil || response.Status.Token == "" {
+		return joinToken{}, fmt.Errorf("hub returned no token for ServiceAccount %s/%s", bootstrapServiceAccountNamespace, bootstrapServiceAccount)
+	}
+	token.Token = response.Status.Token
+	token.ExpiresAt = response.Status.ExpirationTimestamp
+	if token.ExpiresAt.IsZero() {
+		token.ExpiresAt = token.FetchedAt.Add(ttl)
+	}
+	return token, nil
+}
+
+// hubAPIServer returns the API server URL spokes join, as published in the
+// cluster-info ConfigMap of the hub, or from the hub kubeconfig when the hub
+// publishes none
+func (cp *ClusterOpsPlugin) hubAPIServer(ctx context.Context, client dynamic.Interface) (string, error) {
+	obj, err := client.Resource(configMapGVR).Namespace("kube-public").Get(ctx, "cluster-info", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to read the cluster-info ConfigMap: %w", err)
+	}
+	if err == nil {
+		var configMap struct {
+			Data struct {
+				Kubeconfig string `json:"kubeconfig"`
+			} `json:"data"`
+		}
+		var file kubeconfigFile
+		if decodeUnstructured(obj, &configMap) == nil && yaml.Unmarshal([]byte(configMap.Data.Kubeconfig), &file) == nil &&
+			len(file.Clusters) > 0 && file.Clusters[0].Cluster.Server != "" {
+			return file.Clusters[0].Cluster.Server, nil
+		}
+	}
+	server, _, err := cp.hubConnection(ctx)
+	if err == nil && server == "" {
+		err = fmt.Errorf("hub kubeconfig has no server")
+	}
+	return server, err
+}
+
+// bootstrapSecret is a bootstrap token Secret; its data is decoded from
+// base64 when it is unmarshalled
+type bootstrapSecret struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+func (s *bootstrapSecret) token() string {
+	return string(s.Data["token-id"]) + "." + string(s.Data["token-secret"])
+}
+
+// bootstrapTokenSecret returns the bootstrap token Secret spokes join with,
+// preferring the one clusteradm init labels for the cluster manager, or nil
+// when the hub has none that is valid
+func bootstrapTokenSecret(ctx context.Context, client dynamic.Interface) (*bootstrapSecret, error) {
+	list, err := client.Resource(secretGVR).Namespace(bootstrapTokenNamespace).List(ctx, metav1.ListOptions{FieldSelector: "type=" + bootstrapTokenType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bootstrap token Secrets: %w", err)
+	}
+
+	var found *bootstrapSecret
+	for i := range list.Items {
+		secret := &bootstrapSecret{}
+		if err := decodeUnstructured(&list.Items[i], secret); err != nil {
+			return nil, fmt.Errorf("failed to decode Secret %s: %v", list.Items[i].GetName(), err)
+		}
+		if string(secret.Data["usage-bootstrap-authentication"]) != "true" || !bootstrapTokenPattern.MatchString(secret.token()) {
+			continue
+		}
+		if expiration, err := time.Parse(time.RFC3339, string(secret.Data["expiration"])); err == nil && time.Until(expiration) <= joinTokenRefreshMargin {
+			continue
+		}
+		if secret.Metadata.Labels["app"] == "cluster-manager" {
+			return secret, nil
+		}
+		if found == nil {
+			found = secret
+		}
+	}
+	return found, nil
+}
+
+// forget drops the cached token of a hub
+func (c *joinTokenCache) forget(hub string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.tokens, hub)
+}
+
+// revokeJoinToken invalidates the join token of the hub selected in ctx.
+// A bootstrap token is revoked by deleting its Secret; the next join token
+// is then another bootstrap token of the hub, or a token of the bootstrap
+// ServiceAccount when there is none. Service account
+// tokens cannot be revoked one by one, so the bootstrap ServiceAccount is
+// deleted, which invalidates every token issued for it, and recreated; its
+// RBAC bindings refer to it by name and keep applying.
+func (cp *ClusterOpsPlugin) revokeJoinToken(ctx context.Context) error {
+	token, err := cp.joinToken(ctx)
+	if err != nil {
+		return err
+	}
+	defer cp.joinTokens.forget(cp.selectedHub(ctx).Name)
+
+	ctx, cancel := context.WithTimeout(ctx, hubRequestTimeout)
+	defer cancel()
+	client, err := cp.hubClient(cp.selectedHub(ctx))
+	if err != nil {
+		return err
+	}
+
+	if match := bootstrapTokenPattern.FindStringSubmatch(token.Token); match != nil {
+		err := client.Resource(secretGVR).Namespace(bootstrapTokenNamespace).Delete(ctx, "bootstrap-token-"+match[1], metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the bootstrap token Secret: %w", err)
+		}
+		return nil
+	}
+
+	segments := strings.Split(token.Token, ".")
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if len(segments) != 3 || decodeSegment(segments[1], &claims) != nil {
+		return fmt.Errorf("join token is neither a bootstrap token nor a service account token")
+	}
+	parts := strings.Split(claims.Sub, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" {
+		return fmt.Errorf("join token subject %q is not a service account", claims.Sub)
+	}
+	namespace, name := parts[2], parts[3]
+	serviceAccounts := client.Resource(serviceAccountGVR).Namespace(namespace)
+	if err := serviceAccounts.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ServiceAccount %s/%s: %w", namespace, name, err)
+	}
+	serviceAccount := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ServiceAccount",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+	}}
+	if _, err := serviceAccounts.Create(ctx, serviceAccount, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to recreate ServiceAccount %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// joinStep fetches the join token of the hub, reusing a cached one, and
+// runs clusteradm join on the spoke with it
+func (cp *ClusterOpsPlugin) joinStep(ctx context.Context, operationID, clusterName, workDir string, klusterlet *KlusterletOptions) error {
+	token, err := cp.joinToken(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a join token: %w", err)
+	}
+	cp.logStepEvent(operationID, clusterName, "join", "info", fmt.Sprintf("Joining hub %s with a token valid until %s", token.HubAPIServer, token.ExpiresAt.UTC().Format(time.RFC3339)), 0)
+	return cp.clusteradmJoin(ctx, workDir, clusterName, token, klusterlet)
+}
+
+func (cp *ClusterOpsPlugin) RotateJoinTokenHandler(c *gin.Context) {
+	hub := cp.selectedHub(c.Request.Context())
+	if err := cp.revokeJoinToken(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to revoke the join token", err.Error()))
+		return
+	}
+	token, err := cp.joinToken(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Join token revoked but a new one could not be issued", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Join token of hub %s rotated", hub.Name),
+		"hub":     hub.Name,
+		"token":   token,
+		"plugin":  "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) RevokeJoinTokenHandler(c *gin.Context) {
+	hub := cp.selectedHub(c.Request.Context())
+	if err := cp.revokeJoinToken(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to revoke the join token", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Join token of hub %s revoked; the next onboarding issues a new one", hub.Name),
+		"hub":     hub.Name,
+		"plugin":  "cluster-ops-plugin",
+	})
+}
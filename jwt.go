@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksRefreshInterval is how long fetched signing keys are trusted
+	jwksRefreshInterval = 10 * time.Minute
+	// jwksMinRefreshInterval rate-limits refetches triggered by unknown key IDs
+	jwksMinRefreshInterval = time.Minute
+	jwksTimeout            = 10 * time.Second
+	// jwtLeeway tolerates clock skew between the issuer and the plugin
+	jwtLeeway = time.Minute
+)
+
+// publicHandlers are served without authentication so the host can probe
+// the plugin, browsers can send CORS preflights, API consumers can fetch the
+// OpenAPI spec and spokes can redeem a registration code, which
+// authenticates them instead
+var publicHandlers = map[string]bool{
+	"HealthCheckHandler":        true,
+	"CORSPreflightHandler":      true,
+	"RedeemRegistrationHandler": true,
+	"OpenAPISpecHandler":        true,
+	"SwaggerUIHandler":          true,
+}
+
+// jwtVerifier validates bearer tokens signed by keys published at a JWKS URL
+type jwtVerifier struct {
+	issuer    string
+	audience  string
+	jwksURL   string
+	client    *http.Client
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// fetches lets concurrent requests share a single fetch of the key set
+	fetches singleflight.Group
+	mutex   sync.Mutex
+}
+
+// newJWTVerifier returns a verifier configured by jwt_jwks_url, jwt_issuer
+// and jwt_audience, or nil when JWT authentication is disabled
+func newJWTVerifier(settings PluginConfig) *jwtVerifier {
+	if settings.JWTJWKSURL == "" {
+		return nil
+	}
+	return &jwtVerifier{
+		issuer:   settings.JWTIssuer,
+		audience: settings.JWTAudience,
+		jwksURL:  settings.JWTJWKSURL,
+		client:   &http.Client{Timeout: jwksTimeout},
+	}
+}
+
+// Verify checks the signature and registered claims of a compact JWS token
+// and returns its claims
+func (v *jwtVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return fmt.Errorf("unexpected token issuer %q", iss)
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == v.audience {
+			return nil
+		}
+	case []interface{}:
+		for _, value := range aud {
+			if value == v.audience {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("token is not intended for audience %q", v.audience)
+}
+
+// key returns the signing key with the given ID, fetching the key set when
+// it is stale or does not contain the key yet. The key set is fetched
+// without holding the lock, so a slow JWKS endpoint only delays the
+// requests that need the new keys.
+func (v *jwtVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mutex.Lock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > jwksRefreshInterval
+	refetch := stale || time.Since(v.fetchedAt) > jwksMinRefreshInterval
+	v.mutex.Unlock()
+	if ok && !stale {
+		return key, nil
+	}
+
+	if refetch {
+		_, err, _ := v.fetches.Do(v.jwksURL, func() (interface{}, error) {
+			keys, err := v.fetchKeys()
+			if err != nil {
+				return nil, err
+			}
+			v.mutex.Lock()
+			defer v.mutex.Unlock()
+			v.keys = keys
+			v.fetchedAt = time.Now()
+			return nil, nil
+		})
+		if err != nil {
+			if ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+		}
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetchKeys fetches the signing keys published at the JWKS URL
+func (v *jwtVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint responded with status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	return keys, nil
+}
+
+// verifySignature checks a JWS signature for the RS* and ES* algorithms
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashID = sha512.New(), crypto.SHA512
+	}
+	if h == nil {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hashID, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signing algorithm %q does not match the key type", alg)
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// authenticated wraps a handler so requests must present a valid API key in
+// X-API-Key or a bearer token when either is configured. The subject of the
+// caller is stored on the gin context for the audit log and authorization.
+func (cp *ClusterOpsPlugin) authenticated(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp.mutex.RLock()
+		verifier := cp.jwt
+		cp.mutex.RUnlock()
+		apiKeysEnabled := cp.apiKeys.Enabled()
+
+		if presented := c.GetHeader("X-API-Key"); presented != "" && apiKeysEnabled {
+			if cp.authenticateAPIKey(c, presented) {
+				handler(c)
+			}
+			return
+		}
+		if verifier == nil {
+			if apiKeysEnabled {
+				c.JSON(http.StatusUnauthorized, errorResponse(codeUnauthenticated, "Unauthorized", "an API key is required"))
+				return
+			}
+			handler(c)
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.JSON(http.StatusUnauthorized, errorResponse(codeUnauthenticated, "Unauthorized", "a bearer token is required"))
+			return
+		}
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			cp.logger.WarnContext(c.Request.Context(), "Rejected request with invalid token", "path", c.Request.URL.Path, "error", err)
+			c.JSON(http.StatusUnauthorized, errorResponse(codeUnauthenticated, "Unauthorized", err.Error()))
+			return
+		}
+
+		subject, _ := claims["sub"].(string)
+		c.Set("subject", subject)
+		c.Set("claims", claims)
+		handler(c)
+	}
+}
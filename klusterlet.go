@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// klusterletOperatorNamespace is where clusteradm installs the klusterlet operator
+	klusterletOperatorNamespace = "open-cluster-management"
+	// imagePullSecretName is the pull secret the klusterlet operator uses and
+	// copies to the agent namespace
+	imagePullSecretName = "open-cluster-management-image-pull-credentials"
+)
+
+// pullSecretStep creates the image pull secret on the spoke before join so
+// the klusterlet operator image can be pulled from a private registry
+var pullSecretStep = pipelineStep{"create-pull-secret", "Image pull secret created on the spoke", ""}
+
+// configureKlusterletStep applies the Klusterlet settings clusteradm join has
+// no flags for
+var configureKlusterletStep = pipelineStep{"configure-klusterlet", "Klusterlet deployment settings applied on the spoke", ""}
+
+var (
+	// quantityPattern matches Kubernetes resource quantities such as 500m or 1Gi
+	quantityPattern  = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|k|M|G|T|Ki|Mi|Gi|Ti)?$`)
+	namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+)
+
+// KlusterletToleration is a toleration of the klusterlet agent pods
+type KlusterletToleration struct {
+	Key               string `json:"key,omitempty"`
+	Operator          string `json:"operator,omitempty"`
+	Value             string `json:"value,omitempty"`
+	Effect            string `json:"effect,omitempty"`
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty"`
+}
+
+// KlusterletResources are the requests and limits of the klusterlet agent
+type KlusterletResources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// KlusterletOptions customizes the klusterlet deployed on a spoke at join
+type KlusterletOptions struct {
+	// Singleton runs registration and work agents in a single deployment
+	Singleton    bool                   `json:"singleton,omitempty"`
+	Namespace    string                 `json:"namespace,omitempty"`
+	NodeSelector map[string]string      `json:"nodeSelector,omitempty"`
+	Tolerations  []KlusterletToleration `json:"tolerations,omitempty"`
+	Resources    *KlusterletResources   `json:"resources,omitempty"`
+	// ImageRegistry replaces quay.io/open-cluster-management for every
+	// klusterlet image, for spokes that can only reach a mirror
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// ImagePullSecret is the .dockerconfigjson content used to pull from it
+	ImagePullSecret string `json:"imagePullSecret,omitempty"`
+	// Proxy routes the klusterlet's connection to the hub through an egress
+	// proxy
+	Proxy *KlusterletProxy `json:"proxy,omitempty"`
+}
+
+// KlusterletProxy is the egress proxy a spoke uses to reach the hub
+type KlusterletProxy struct {
+	HTTPSProxy string   `json:"httpsProxy"`
+	NoProxy    []string `json:"noProxy,omitempty"`
+	// CAData is the PEM bundle of the proxy's CA, when it terminates TLS
+	CAData string `json:"caData,omitempty"`
+}
+
+// proxyCAFile is the name the proxy CA bundle is written under in the
+// operation directory for clusteradm join
+const proxyCAFile = "proxy-ca.crt"
+
+// withJoinDefaults fills the registry and proxy settings left empty in o from
+// the image_registry, image_pull_secret and spoke_https_proxy configuration
+func (cp *ClusterOpsPlugin) withJoinDefaults(o *KlusterletOptions) *KlusterletOptions {
+	settings := cp.settings()
+	registry := settings.ImageRegistry
+	pullSecret := settings.ImagePullSecret
+	proxyURL := settings.SpokeHTTPSProxy
+	if registry == "" && pullSecret == "" && proxyURL == "" {
+		return o
+	}
+
+	merged := KlusterletOptions{}
+	if o != nil {
+		merged = *o
+	}
+	if merged.ImageRegistry == "" {
+		merged.ImageRegistry = registry
+	}
+	if merged.ImagePullSecret == "" {
+		merged.ImagePullSecret = pullSecret
+	}
+	if merged.Proxy == nil && proxyURL != "" {
+		merged.Proxy = &KlusterletProxy{
+			HTTPSProxy: proxyURL,
+			NoProxy:    settings.SpokeNoProxy,
+			CAData:     settings.SpokeProxyCA,
+		}
+	}
+	return &merged
+}
+
+// redacted returns a copy of o that is safe to expose through the API and
+// events, without the pull secret or proxy credentials
+func (o *KlusterletOptions) redacted() *KlusterletOptions {
+	if o == nil {
+		return nil
+	}
+	copied := *o
+	if copied.ImagePullSecret != "" {
+		copied.ImagePullSecret = redactedValue
+	}
+	if o.Proxy != nil {
+		proxy := *o.Proxy
+		if parsed, err := url.Parse(proxy.HTTPSProxy); err == nil {
+			proxy.HTTPSProxy = parsed.Redacted()
+		}
+		copied.Proxy = &proxy
+	}
+	return &copied
+}
+
+// validate reports every problem with the klusterlet options
+func (o *KlusterletOptions) validate() []string {
+	var problems []string
+	if o.Namespace != "" && !namespacePattern.MatchString(o.Namespace) {
+		problems = append(problems, fmt.Sprintf("namespace %q is not a valid namespace name", o.Namespace))
+	}
+	for key, value := range o.NodeSelector {
+		if err := validateLabelKey(key); err != nil {
+			problems = append(problems, fmt.Sprintf("nodeSelector: %v", err))
+		} else if err := validateLabelValue(value); err != nil {
+			problems = append(problems, fmt.Sprintf("nodeSelector %s: %v", key, err))
+		}
+	}
+	for i, toleration := range o.Tolerations {
+		switch toleration.Operator {
+		case "", "Equal":
+		case "Exists":
+			if toleration.Value != "" {
+				problems = append(problems, fmt.Sprintf("tolerations[%d]: value must be empty with operator Exists", i))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("tolerations[%d]: operator must be Equal or Exists", i))
+		}
+		switch toleration.Effect {
+		case "", "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			problems = append(problems, fmt.Sprintf("tolerations[%d]: unsupported effect %q", i, toleration.Effect))
+		}
+	}
+	if o.ImageRegistry != "" && (strings.Contains(o.ImageRegistry, "://") || strings.ContainsAny(o.ImageRegistry, " @")) {
+		problems = append(problems, fmt.Sprintf("imageRegistry %q must be a registry host and optional path, without scheme or digest", o.ImageRegistry))
+	}
+	if o.ImagePullSecret != "" {
+		var dockerConfig struct {
+			Auths map[string]interface{} `json:"auths"`
+		}
+		if err := json.Unmarshal([]byte(o.ImagePullSecret), &dockerConfig); err != nil || len(dockerConfig.Auths) == 0 {
+			problems = append(problems, "imagePullSecret must be .dockerconfigjson content with at least one entry in auths")
+		}
+	}
+	if o.Proxy != nil {
+		if parsed, err := url.Parse(o.Proxy.HTTPSProxy); err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("proxy.httpsProxy %q must be an http or https URL", o.Proxy.HTTPSProxy))
+		}
+		if o.Proxy.CAData != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(o.Proxy.CAData)) {
+			problems = append(problems, "proxy.caData contains no valid PEM certificates")
+		}
+	}
+	if o.Resources != nil {
+		for kind, quantities := range map[string]map[string]string{"requests": o.Resources.Requests, "limits": o.Resources.Limits} {
+			for resource, quantity := range quantities {
+				if resource != "cpu" && resource != "memory" {
+					problems = append(problems, fmt.Sprintf("resources.%s: unsupported resource %q", kind, resource))
+				} else if !quantityPattern.MatchString(quantity) {
+					problems = append(problems, fmt.Sprintf("resources.%s.%s: invalid quantity %q", kind, resource, quantity))
+				}
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// joinFlags returns the clusteradm join flags for the options
+func (o *KlusterletOptions) joinFlags() []string {
+	var flags []string
+	if o.Singleton {
+		flags = append(flags, "--singleton")
+	}
+	if o.ImageRegistry != "" {
+		flags = append(flags, "--image-registry", strings.TrimSuffix(o.ImageRegistry, "/"))
+	}
+	if o.Proxy != nil {
+		flags = append(flags, "--proxy-url", o.Proxy.HTTPSProxy)
+		if o.Proxy.CAData != "" {
+			flags = append(flags, "--proxy-ca-file", proxyCAFile)
+		}
+	}
+	if o.Resources != nil && (len(o.Resources.Requests) > 0 || len(o.Resources.Limits) > 0) {
+		flags = append(flags, "--resource-qos-class", "ResourceRequirement")
+		if len(o.Resources.Requests) > 0 {
+			flags = append(flags, "--resource-requests", joinQuantities(o.Resources.Requests))
+		}
+		if len(o.Resources.Limits) > 0 {
+			flags = append(flags, "--resource-limits", joinQuantities(o.Resources.Limits))
+		}
+	}
+	return flags
+}
+
+// joinEnv returns the proxy environment of the clusteradm join process, so
+// clusteradm itself reaches the hub the same way the klusterlet will
+func (o *KlusterletOptions) joinEnv() []string {
+	if o.Proxy == nil {
+		return nil
+	}
+	env := []string{"HTTPS_PROXY=" + o.Proxy.HTTPSProxy}
+	if len(o.Proxy.NoProxy) > 0 {
+		env = append(env, "NO_PROXY="+strings.Join(o.Proxy.NoProxy, ","))
+	}
+	return env
+}
+
+func joinQuantities(quantities map[string]string) string {
+	pairs := make([]string, 0, len(quantities))
+	for resource, quantity := range quantities {
+		pairs = append(pairs, resource+"="+quantity)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// needsPatch reports whether the options include settings applied by
+// patching the Klusterlet after join
+func (o *KlusterletOptions) needsPatch() bool {
+	return o.Namespace != "" || len(o.NodeSelector) > 0 || len(o.Tolerations) > 0
+}
+
+// klusterletPatch returns the merge patch applied to the Klusterlet named
+// klusterlet on the spoke after join
+func (o *KlusterletOptions) klusterletPatch() map[string]interface{} {
+	spec := map[string]interface{}{}
+	if o.Namespace != "" {
+		spec["namespace"] = o.Namespace
+	}
+	if len(o.NodeSelector) > 0 || len(o.Tolerations) > 0 {
+		placement := map[string]interface{}{}
+		if len(o.NodeSelector) > 0 {
+			placement["nodeSelector"] = o.NodeSelector
+		}
+		if len(o.Tolerations) > 0 {
+			placement["tolerations"] = o.Tolerations
+		}
+		spec["nodePlacement"] = placement
+	}
+	return map[string]interface{}{"spec": spec}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// spokeRequestTimeout bounds every request made to a spoke API server
+const spokeRequestTimeout = 10 * time.Second
+
+// maxKubeconfigBytes caps the size of a decompressed kubeconfig
+const maxKubeconfigBytes = 1 << 20
+
+// kubeconfigFile is the subset of the kubeconfig format the plugin understands
+type kubeconfigFile struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string            `yaml:"name"`
+		Cluster kubeconfigCluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string         `yaml:"name"`
+		User kubeconfigUser `yaml:"user"`
+	} `yaml:"users"`
+}
+
+type kubeconfigCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+}
+
+type kubeconfigUser struct {
+	Token                 string      `yaml:"token"`
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Username              string      `yaml:"username"`
+	Password              string      `yaml:"password"`
+	Exec                  interface{} `yaml:"exec"`
+	AuthProvider          interface{} `yaml:"auth-provider"`
+}
+
+// insecureTLSWarning is reported whenever spoke certificates are not verified
+const insecureTLSWarning = "TLS verification of spoke API servers is disabled because validate_ssl is false"
+
+// spokeTLSOptions controls how the TLS and credential settings of a
+// kubeconfig are applied
+type spokeTLSOptions struct {
+	// validateSSL rejects kubeconfigs that skip TLS verification; when unset,
+	// verification is skipped for every spoke
+	validateSSL bool
+	// caBundle holds PEM certificates trusted for every spoke in addition to
+	// the certificate authority of the kubeconfig
+	caBundle []byte
+	// execAllowlist names the exec credential plugins that may run
+	execAllowlist []string
+	// runner runs the exec credential plugins
+	runner CommandRunner
+}
+
+// loadCABundle reads the additional CA certificates configured in ca_bundle
+// (inline PEM) and ca_bundle_path (a PEM file on the plugin host)
+func loadCABundle(config map[string]interface{}) ([]byte, error) {
+	var bundle []byte
+	if inline, _ := config["ca_bundle"].(string); inline != "" {
+		bundle = append(bundle, []byte(inline)...)
+		bundle = append(bundle, '\n')
+	}
+	if path, _ := config["ca_bundle_path"].(string); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_bundle_path: %v", err)
+		}
+		bundle = append(bundle, data...)
+	}
+	if len(bundle) > 0 && !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("ca_bundle contains no valid PEM certificates")
+	}
+	return bundle, nil
+}
+
+// decodeKubeconfig returns kubeconfig YAML from a payload that may be
+// base64-encoded, optionally over gzip. Plain YAML always contains a colon,
+// which is outside the base64 alphabet, so anything without one is treated as
+// encoded.
+func decodeKubeconfig(payload string) (string, error) {
+	trimmed := strings.TrimSpace(payload)
+	if strings.Contains(trimmed, ":") {
+		return payload, nil
+	}
+
+	encoded := strings.Join(strings.Fields(trimmed), "")
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "=")); err != nil {
+			return "", fmt.Errorf("kubeconfig is neither YAML nor valid base64: %v", err)
+		}
+	}
+
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("kubeconfig is base64 but not valid gzip: %v", err)
+		}
+		defer reader.Close()
+		if data, err = io.ReadAll(io.LimitReader(reader, maxKubeconfigBytes+1)); err != nil {
+			return "", fmt.Errorf("kubeconfig is base64 but not valid gzip: %v", err)
+		}
+		if len(data) > maxKubeconfigBytes {
+			return "", fmt.Errorf("decompressed kubeconfig exceeds %d bytes", maxKubeconfigBytes)
+		}
+	}
+
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("decoded kubeconfig is not text")
+	}
+	return string(data), nil
+}
+
+// serverCredentials returns the kubeconfig user for onboarding by server URL,
+// authenticated by either a bearer token or a PEM client certificate and key
+func serverCredentials(token, clientCert, clientKey string) (map[string]string, error) {
+	hasCert := clientCert != "" || clientKey != ""
+	if (token != "") == hasCert {
+		return nil, fmt.Errorf("provide either token or clientCert and clientKey with server")
+	}
+	if token != "" {
+		return map[string]string{"token": token}, nil
+	}
+	if clientCert == "" || clientKey == "" {
+		return nil, fmt.Errorf("clientCert and clientKey must be provided together")
+	}
+	if _, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey)); err != nil {
+		return nil, fmt.Errorf("invalid client certificate/key pair: %v", err)
+	}
+	return map[string]string{
+		"client-certificate-data": base64.StdEncoding.EncodeToString([]byte(clientCert)),
+		"client-key-data":         base64.StdEncoding.EncodeToString([]byte(clientKey)),
+	}, nil
+}
+
+// synthesizeKubeconfig builds a single-context kubeconfig for a server and
+// user. caData may be PEM or base64-encoded PEM.
+func synthesizeKubeconfig(clusterName, server, caData string, user map[string]string) (string, error) {
+	if parsed, err := url.Parse(server); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return "", fmt.Errorf("server must be an https URL, got %q", server)
+	}
+
+	cluster := map[string]interface{}{"server": server}
+	if caData != "" {
+		caPEM := []byte(caData)
+		if !strings.Contains(caData, "-----BEGIN") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(caData))
+			if err != nil {
+				return "", fmt.Errorf("caData is neither PEM nor base64: %v", err)
+			}
+			caPEM = decoded
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(caPEM) {
+			return "", fmt.Errorf("caData contains no valid certificates")
+		}
+		cluster["certificate-authority-data"] = base64.StdEncoding.EncodeToString(caPEM)
+	}
+
+	config := map[string]interface{}{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"current-context": clusterName,
+		"clusters":        []interface{}{map[string]interface{}{"name": clusterName, "cluster": cluster}},
+		"users":           []interface{}{map[string]interface{}{"name": clusterName, "user": user}},
+		"contexts": []interface{}{map[string]interface{}{
+			"name":    clusterName,
+			"context": map[string]string{"cluster": clusterName, "user": clusterName},
+		}},
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// prepareKubeconfig turns a kubeconfig as submitted by a client into plain
+// kubeconfig YAML, decoding and decrypting it as needed. Errors describe the
+// encoding problem; the YAML itself is validated by newSpokeClient.
+func (cp *ClusterOpsPlugin) prepareKubeconfig(ctx context.Context, payload string) (string, error) {
+	kubeconfig, err := decodeKubeconfig(payload)
+	if err != nil {
+		return "", err
+	}
+	if isSOPSEncrypted(kubeconfig) {
+		return cp.decryptSOPS(ctx, kubeconfig)
+	}
+	return kubeconfig, nil
+}
+
+// spokeClient is a minimal client for the API server of a spoke cluster built
+// from an uploaded kubeconfig
+type spokeClient struct {
+	server  string
+	context string
+	// insecure is set when the server certificate is not verified
+	insecure   bool
+	token      string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// apiError is a non-2xx response from a Kubernetes API server
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// newSpokeClient parses a kubeconfig and builds a client for its current
+// context. Credentials must be embedded in the kubeconfig; file references
+// are rejected since they would resolve against the plugin host.
+func newSpokeClient(kubeconfig string, opts spokeTLSOptions) (*spokeClient, error) {
+	var file kubeconfigFile
+	if err := yaml.Unmarshal([]byte(kubeconfig), &file); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %v", err)
+	}
+
+	contextName := file.CurrentContext
+	if contextName == "" && len(file.Contexts) == 1 {
+		contextName = file.Contexts[0].Name
+	}
+	if contextName == "" {
+		return nil, fmt.Errorf("kubeconfig has no current-context")
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, ctx := range file.Contexts {
+		if ctx.Name == contextName {
+			clusterName, userName, found = ctx.Context.Cluster, ctx.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+
+	var cluster *kubeconfigCluster
+	for i := range file.Clusters {
+		if file.Clusters[i].Name == clusterName {
+			cluster = &file.Clusters[i].Cluster
+			break
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig", clusterName)
+	}
+	if cluster.Server == "" {
+		return nil, fmt.Errorf("cluster %q has no server", clusterName)
+	}
+
+	var user kubeconfigUser
+	for _, u := range file.Users {
+		if u.Name == userName {
+			user = u.User
+			break
+		}
+	}
+
+	if err := resolveExecCredentials(&user, opts.execAllowlist, opts.runner); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := buildTLSConfig(cluster, &user, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spokeClient{
+		server:   strings.TrimSuffix(cluster.Server, "/"),
+		context:  contextName,
+		insecure: tlsConfig.InsecureSkipVerify,
+		token:    user.Token,
+		username: user.Username,
+		password: user.Password,
+		httpClient: &http.Client{
+			Timeout:   spokeRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+func buildTLSConfig(cluster *kubeconfigCluster, user *kubeconfigUser, opts spokeTLSOptions) (*tls.Config, error) {
+	if cluster.CertificateAuthority != "" || user.ClientCertificate != "" || user.ClientKey != "" {
+		return nil, fmt.Errorf("kubeconfig references certificate files; embed them as *-data fields instead")
+	}
+	if cluster.InsecureSkipTLSVerify && opts.validateSSL {
+		return nil, fmt.Errorf("kubeconfig sets insecure-skip-tls-verify, which is not allowed while validate_ssl is enabled")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cluster.TLSServerName,
+		InsecureSkipVerify: !opts.validateSSL,
+	}
+
+	if cluster.CertificateAuthorityData != "" {
+		caPEM, err := base64.StdEncoding.DecodeString(cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate-authority-data: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("certificate-authority-data contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(opts.caBundle) > 0 {
+		// Without a kubeconfig CA the bundle extends the system roots
+		if tlsConfig.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			tlsConfig.RootCAs = pool
+		}
+		tlsConfig.RootCAs.AppendCertsFromPEM(opts.caBundle)
+	}
+
+	if user.ClientCertificateData != "" || user.ClientKeyData != "" {
+		certPEM, err := base64.StdEncoding.DecodeString(user.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client-certificate-data: %v", err)
+		}
+		keyPEM, err := base64.StdEncoding.DecodeString(user.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client-key-data: %v", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// do sends a request to the API server and decodes a JSON response into out
+func (sc *spokeClient) do(ctx context.Context, method, path string, body, out interface{}) (err error) {
+	ctx, span := startChildSpan(ctx, method+" "+path, spanKindClient)
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("server.address", sc.server)
+	defer func() { span.End(err) }()
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, sc.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if traceparent := traceparentFromContext(ctx); traceparent != "" {
+		req.Header.Set("traceparent", traceparent)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if sc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+sc.token)
+	} else if sc.username != "" {
+		req.SetBasicAuth(sc.username, sc.password)
+	}
+
+	resp, err := sc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return &apiError{StatusCode: resp.StatusCode, Message: status.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// spokeTLSOptions returns the TLS options for spoke clients from the
+// validate_ssl configuration, which defaults to verifying certificates, the
+// configured CA bundle and the exec plugin allowlist
+func (cp *ClusterOpsPlugin) spokeTLSOptions() spokeTLSOptions {
+	settings := cp.settings()
+	cp.mutex.RLock()
+	caBundle := cp.caBundle
+	cp.mutex.RUnlock()
+	return spokeTLSOptions{
+		validateSSL:   settings.ValidateSSL,
+		caBundle:      caBundle,
+		execAllowlist: settings.ExecAllowedCommands,
+		runner:        cp.runner,
+	}
+}
+
+func (sc *spokeClient) get(ctx context.Context, path string, out interface{}) error {
+	return sc.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (sc *spokeClient) post(ctx context.Context, path string, body, out interface{}) error {
+	return sc.do(ctx, http.MethodPost, path, body, out)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// kubeconfigSecretPrefix prefixes the hub Secrets holding spoke kubeconfigs
+	kubeconfigSecretPrefix = "cluster-ops-kubeconfig-"
+	kubeconfigSecretKey    = "kubeconfig"
+	// kubeconfigStoredAtAnnotation records when a kubeconfig was last stored
+	kubeconfigStoredAtAnnotation = "cluster-ops.kubestellar.io/stored-at"
+	defaultClusterNamespace      = "kubestellar-system"
+)
+
+// storeKubeconfigStep persists the spoke kubeconfig for later operations
+var storeKubeconfigStep = pipelineStep{"store-kubeconfig", "Kubeconfig stored in a hub Secret", ""}
+
+// StoredKubeconfig is a spoke kubeconfig kept on the hub
+type StoredKubeconfig struct {
+	ClusterName string    `json:"clusterName"`
+	Secret      string    `json:"secret"`
+	Namespace   string    `json:"namespace"`
+	Kubeconfig  string    `json:"kubeconfig,omitempty"`
+	StoredAt    time.Time `json:"storedAt"`
+}
+
+// KubeconfigRotateRequest replaces the stored kubeconfig of a cluster
+type KubeconfigRotateRequest struct {
+	Kubeconfig string `json:"kubeconfig" binding:"required"`
+}
+
+func kubeconfigSecretName(clusterName string) string {
+	return kubeconfigSecretPrefix + clusterName
+}
+
+// clusterNamespace returns the hub namespace holding plugin resources
+func (cp *ClusterOpsPlugin) clusterNamespace() string {
+	return cp.settings().ClusterNamespace
+}
+
+// storeKubeconfig creates or replaces the hub Secret holding the kubeconfig of
+// a cluster, encrypting it when an encryption key is configured. Server-side
+// apply is used so the Secret data is not copied into a
+// last-applied-configuration annotation.
+func (cp *ClusterOpsPlugin) storeKubeconfig(ctx context.Context, clusterName, kubeconfig string) error {
+	keyring, err := cp.encryptionKeyring(ctx)
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{
+		kubeconfigStoredAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+	value := kubeconfig
+	if keyring != nil {
+		if value, err = keyring.Encrypt([]byte(kubeconfig)); err != nil {
+			return err
+		}
+		annotations[kubeconfigKeyIDAnnotation] = keyring.primaryID
+	}
+
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata": map[string]interface{}{
+			"name":      kubeconfigSecretName(clusterName),
+			"namespace": cp.clusterNamespace(),
+			"labels": map[string]string{
+				"app.kubernetes.io/managed-by":       "cluster-ops-plugin",
+				"cluster-ops.kubestellar.io/cluster": clusterName,
+			},
+			"annotations": annotations,
+		},
+		"data": map[string]string{
+			kubeconfigSecretKey: base64.StdEncoding.EncodeToString([]byte(value)),
+		},
+	}
+	manifest, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	_, err = cp.kubectlHubWithInput(ctx, manifest, "apply", "--server-side", "--force-conflicts", "--field-manager", "cluster-ops-plugin", "-f", "-")
+	return err
+}
+
+// loadKubeconfig reads the stored kubeconfig of a cluster, decrypting it when
+// it was stored encrypted
+func (cp *ClusterOpsPlugin) loadKubeconfig(ctx context.Context, clusterName string) (StoredKubeconfig, error) {
+	stored := StoredKubeconfig{
+		ClusterName: clusterName,
+		Secret:      kubeconfigSecretName(clusterName),
+		Namespace:   cp.clusterNamespace(),
+	}
+
+	out, err := cp.kubectlHub(ctx, "get", "secret", stored.Secret, "-n", stored.Namespace, "-o", "json")
+	if err != nil {
+		return stored, err
+	}
+	var secret struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(out, &secret); err != nil {
+		return stored, fmt.Errorf("failed to decode Secret %s: %v", stored.Secret, err)
+	}
+	kubeconfig, err := base64.StdEncoding.DecodeString(secret.Data[kubeconfigSecretKey])
+	if err != nil || len(kubeconfig) == 0 {
+		return stored, fmt.Errorf("Secret %s holds no kubeconfig", stored.Secret)
+	}
+	if isEncrypted(string(kubeconfig)) {
+		keyring, err := cp.encryptionKeyring(ctx)
+		if err != nil {
+			return stored, err
+		}
+		if keyring == nil {
+			return stored, fmt.Errorf("Secret %s is encrypted but no encryption key is configured", stored.Secret)
+		}
+		if kubeconfig, err = keyring.Decrypt(string(kubeconfig)); err != nil {
+			return stored, err
+		}
+	}
+
+	stored.Kubeconfig = string(kubeconfig)
+	stored.StoredAt, _ = time.Parse(time.RFC3339, secret.Metadata.Annotations[kubeconfigStoredAtAnnotation])
+	return stored, nil
+}
+
+// deleteKubeconfig removes the stored kubeconfig of a cluster, if any
+func (cp *ClusterOpsPlugin) deleteKubeconfig(ctx context.Context, clusterName string) error {
+	_, err := cp.kubectlHub(ctx, "delete", "secret", kubeconfigSecretName(clusterName), "-n", cp.clusterNamespace(), "--ignore-not-found")
+	return err
+}
+
+func (cp *ClusterOpsPlugin) GetClusterKubeconfigHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	stored, err := cp.loadKubeconfig(c.Request.Context(), name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse(codeNotFound, fmt.Sprintf("No stored kubeconfig for cluster %s", name), err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"kubeconfig": stored,
+		"plugin":     "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) RotateClusterKubeconfigHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	var req KubeconfigRotateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	record, ok := cp.clusters.Get(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse(codeClusterNotFound, fmt.Sprintf("Cluster %s is not tracked", name), nil))
+		return
+	}
+	// Supplying the kubeconfig of a pre-registered cluster joins it
+	if record.State == StateRegistered {
+		op, rejected := cp.beginOnboarding(c.Request.Context(), c.GetHeader("traceparent"), ClusterOnboardRequest{ClusterName: name, Kubeconfig: req.Kubeconfig})
+		if rejected != nil {
+			c.JSON(rejected.status, rejected.body)
+			return
+		}
+		c.Set("operationId", op.ID)
+		c.JSON(http.StatusAccepted, cp.onboardingStarted(op, false))
+		return
+	}
+	kubeconfig, err := cp.prepareKubeconfig(c.Request.Context(), req.Kubeconfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Unable to decode kubeconfig", err.Error()))
+		return
+	}
+	req.Kubeconfig = kubeconfig
+
+	if _, err := newSpokeClient(req.Kubeconfig, cp.spokeTLSOptions()); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Invalid kubeconfig", err.Error()))
+		return
+	}
+
+	if err := cp.storeKubeconfig(c.Request.Context(), name, req.Kubeconfig); err != nil {
+		cp.logEvent(name, "kubeconfig", "failed", fmt.Sprintf("Failed to rotate kubeconfig: %v", err))
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to store kubeconfig on the hub", err.Error()))
+		return
+	}
+	cp.logEvent(name, "kubeconfig", "success", "Stored kubeconfig rotated")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     fmt.Sprintf("Kubeconfig of cluster %s rotated", name),
+		"clusterName": name,
+		"secret":      kubeconfigSecretName(name),
+		"namespace":   cp.clusterNamespace(),
+		"plugin":      "cluster-ops-plugin",
+	})
+}
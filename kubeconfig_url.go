@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kubeconfigURLTimeout bounds fetching a kubeconfig from a URL
+const kubeconfigURLTimeout = 15 * time.Second
+
+// KubeconfigURLAuth holds the optional credentials and trust settings used to
+// fetch a kubeconfigURL. CACert, when set, replaces the system roots so only
+// servers issued by that CA are accepted.
+type KubeconfigURLAuth struct {
+	BearerToken string            `json:"bearerToken,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	CACert      string            `json:"caCert,omitempty"`
+}
+
+func validateKubeconfigURL(rawURL string, auth *KubeconfigURLAuth) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid kubeconfigURL %q", rawURL)
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("kubeconfigURL must use https")
+	}
+	if auth != nil && auth.BearerToken != "" && auth.Username != "" {
+		return fmt.Errorf("use either bearerToken or username/password, not both")
+	}
+	if auth != nil && auth.CACert != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(auth.CACert)) {
+		return fmt.Errorf("caCert contains no valid PEM certificates")
+	}
+	return nil
+}
+
+// fetchKubeconfigURL downloads a kubeconfig from an internal credential
+// service. Redirects are not followed so credentials never leave the
+// requested host.
+func fetchKubeconfigURL(ctx context.Context, rawURL string, auth *KubeconfigURLAuth) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubeconfigURLTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if auth != nil {
+		for name, value := range auth.Headers {
+			req.Header.Set(name, value)
+		}
+		if auth.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+auth.BearerToken)
+		}
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+		if auth.CACert != "" {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM([]byte(auth.CACert))
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKubeconfigBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s responded with status %d", req.URL.Host, resp.StatusCode)
+	}
+	if len(data) > maxKubeconfigBytes {
+		return "", fmt.Errorf("kubeconfig exceeds %d bytes", maxKubeconfigBytes)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", fmt.Errorf("%s returned an empty kubeconfig", req.URL.Host)
+	}
+	return string(data), nil
+}
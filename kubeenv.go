@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// homeDir returns the home directory kubectl and clusteradm resolve, using
+// the rules of client-go's homedir package. On Windows the first of HOME,
+// HOMEDRIVE+HOMEPATH and USERPROFILE holding a .kube\config wins, then the
+// first writable one of HOME, USERPROFILE and HOMEDRIVE+HOMEPATH. It is
+// empty in minimal containers that run without HOME.
+func homeDir() string {
+	if runtime.GOOS != "windows" {
+		return os.Getenv("HOME")
+	}
+
+	home := os.Getenv("HOME")
+	homeDriveHomePath := ""
+	if drive, path := os.Getenv("HOMEDRIVE"), os.Getenv("HOMEPATH"); drive != "" && path != "" {
+		homeDriveHomePath = drive + path
+	}
+	userProfile := os.Getenv("USERPROFILE")
+
+	for _, dir := range []string{home, homeDriveHomePath, userProfile} {
+		if dir == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".kube", "config")); err == nil {
+			return dir
+		}
+	}
+
+	firstSet, firstExisting := "", ""
+	for _, dir := range []string{home, userProfile, homeDriveHomePath} {
+		if dir == "" {
+			continue
+		}
+		if firstSet == "" {
+			firstSet = dir
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+		if firstExisting == "" {
+			firstExisting = dir
+		}
+		if info.IsDir() && info.Mode().Perm()&0o200 != 0 {
+			return dir
+		}
+	}
+	if firstExisting != "" {
+		return firstExisting
+	}
+	return firstSet
+}
+
+// kubeconfigPaths returns the files kubectl merges when a hub sets no
+// kubeconfig, following clientcmd's default loading rules: every entry of
+// KUBECONFIG, split on the OS path list separator, or else .kube/config in
+// the home directory
+func kubeconfigPaths() []string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		var paths []string
+		for _, path := range filepath.SplitList(env) {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+	if home := homeDir(); home != "" {
+		return []string{filepath.Join(home, ".kube", "config")}
+	}
+	return nil
+}
+
+// inCluster reports whether the plugin host runs in a pod, where kubectl
+// falls back to the service account when no kubeconfig is found
+func inCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
+// kubeToolEnv returns the environment kubectl and clusteradm need on this
+// host. Without a home directory they cannot create their discovery and
+// HTTP caches under ~/.kube, so the caches are moved to the temp directory.
+func kubeToolEnv(name string) []string {
+	if name != "kubectl" && name != "clusteradm" {
+		return nil
+	}
+	if homeDir() != "" || os.Getenv("KUBECACHEDIR") != "" {
+		return nil
+	}
+	return []string{"KUBECACHEDIR=" + filepath.Join(os.TempDir(), "kubestellar-kube-cache")}
+}
+
+// checkKubeconfig warns at startup when a hub relies on the default
+// kubeconfig and none of the files the loading rules name exist
+func (cp *ClusterOpsPlugin) checkKubeconfig(hubs []HubConfig) {
+	if inCluster() {
+		return
+	}
+	for _, hub := range hubs {
+		if hub.Kubeconfig != "" {
+			continue
+		}
+		paths := kubeconfigPaths()
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				return
+			}
+		}
+		cp.logger.Warn("No kubeconfig found for hub; set KUBECONFIG or the hub's kubeconfig", "hub", hub.Name, "searched", paths)
+		return
+	}
+}
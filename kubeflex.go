@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultKubeFlexContext is the kubeconfig context of the KubeFlex hosting
+	// cluster created by the KubeStellar getting-started setup
+	defaultKubeFlexContext = "kind-kubeflex"
+	// itsControlPlaneType labels KubeFlex ControlPlanes that are ITSes
+	itsControlPlaneType = "its"
+)
+
+// defaultKubeconfigDir is where hub and spoke kubeconfigs are written unless
+// kubeconfig_dir is configured
+var defaultKubeconfigDir = filepath.Join(os.TempDir(), "kubestellar-clusters")
+
+// kubeFlexControlPlane is the subset of the KubeFlex ControlPlane resource
+// the plugin reads
+type kubeFlexControlPlane struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Type           string `json:"type"`
+		PostCreateHook string `json:"postCreateHook"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []clusterCondition `json:"conditions"`
+		SecretRef  *struct {
+			Name         string `json:"name"`
+			Namespace    string `json:"namespace"`
+			Key          string `json:"key"`
+			InClusterKey string `json:"inClusterKey"`
+		} `json:"secretRef"`
+	} `json:"status"`
+}
+
+// isITS reports whether a ControlPlane is an Inventory and Transport Space,
+// which KubeStellar marks with a cptype label and an its post-create hook
+func (cp *kubeFlexControlPlane) isITS() bool {
+	return cp.Metadata.Labels["kflex.kubestellar.io/cptype"] == itsControlPlaneType ||
+		strings.HasPrefix(cp.Spec.PostCreateHook, itsControlPlaneType)
+}
+
+func (cp *kubeFlexControlPlane) ready() bool {
+	for _, condition := range cp.Status.Conditions {
+		if condition.Type == "Ready" {
+			return condition.Status == "True"
+		}
+	}
+	return false
+}
+
+// hubWatches tracks the hubs whose ManagedClusters are being watched, so hubs
+// discovered after startup get a watch of their own
+type hubWatches struct {
+	ctx     context.Context
+	watched map[string]bool
+	mutex   sync.Mutex
+}
+
+// discoverKubeFlexHubs lists the ready ITS control planes of the KubeFlex
+// hosting cluster and writes their kubeconfigs to kubeconfig_dir
+func (cp *ClusterOpsPlugin) discoverKubeFlexHubs(ctx context.Context) ([]HubConfig, error) {
+	hosting := HubConfig{Name: "kubeflex", Context: cp.settings().KubeFlexContext}
+	ctx = withHub(ctx, hosting)
+
+	out, err := cp.kubectlHub(ctx, "get", "controlplanes.tenancy.kflex.kubestellar.org", "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []kubeFlexControlPlane `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode ControlPlane list: %v", err)
+	}
+
+	dir := filepath.Join(cp.settings().KubeconfigDir, "hubs")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	inCluster := cp.settings().KubeFlexInCluster
+	var hubs []HubConfig
+	for _, controlPlane := range list.Items {
+		ref := controlPlane.Status.SecretRef
+		if !controlPlane.isITS() || !controlPlane.ready() || ref == nil {
+			continue
+		}
+		key := ref.Key
+		if inCluster && ref.InClusterKey != "" {
+			key = ref.InClusterKey
+		}
+
+		out, err := cp.kubectlHub(ctx, "get", "secret", ref.Name, "-n", ref.Namespace, "-o", "json")
+		if err != nil {
+			cp.logger.Warn("Failed to read ITS kubeconfig", "controlPlane", controlPlane.Metadata.Name, "error", err)
+			continue
+		}
+		var secret struct {
+			Data map[string]string `json:"data"`
+		}
+		kubeconfig := []byte(nil)
+		if json.Unmarshal(out, &secret) == nil {
+			kubeconfig, _ = base64.StdEncoding.DecodeString(secret.Data[key])
+		}
+		if len(kubeconfig) == 0 {
+			cp.logger.Warn("ITS kubeconfig Secret has no kubeconfig", "controlPlane", controlPlane.Metadata.Name, "key", key)
+			continue
+		}
+
+		path := filepath.Join(dir, controlPlane.Metadata.Name+".kubeconfig")
+		if err := os.WriteFile(path, kubeconfig, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write kubeconfig of %s: %v", controlPlane.Metadata.Name, err)
+		}
+		hubs = append(hubs, HubConfig{Name: controlPlane.Metadata.Name, Kubeconfig: path, Source: hubSourceKubeFlex})
+	}
+	return hubs, nil
+}
+
+// mergeDiscoveredHubs combines configured hubs with discovered ones.
+// Configured hubs win, and a discovered hub is skipped when a configured hub
+// already uses its name as context, as kflex names contexts after control
+// planes. When the only hub is the implicit its_context default and it is not
+// among the discovered control planes, the first discovered hub replaces it.
+func mergeDiscoveredHubs(configured, discovered []HubConfig, implicit bool) []HubConfig {
+	if implicit && len(configured) == 1 && len(discovered) > 0 {
+		found := false
+		for _, hub := range discovered {
+			found = found || hub.Name == configured[0].Context
+		}
+		if !found {
+			configured = nil
+			discovered[0].Default = true
+		}
+	}
+
+	merged := append([]HubConfig(nil), configured...)
+	for _, hub := range discovered {
+		duplicate := false
+		for _, existing := range configured {
+			if existing.Name == hub.Name || existing.Context == hub.Name {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, hub)
+		}
+	}
+	return merged
+}
+
+// refreshHubs re-runs KubeFlex discovery when kubeflex_discovery is enabled
+// and starts watching any hub that is not watched yet
+func (cp *ClusterOpsPlugin) refreshHubs(ctx context.Context) error {
+	cp.mutex.RLock()
+	config := cp.config
+	cp.mutex.RUnlock()
+
+	configured, err := parseHubs(config, cp.settings().ITSContext)
+	if err != nil {
+		return err
+	}
+	hubs := configured
+	var discoveryErr error
+	if cp.settings().KubeFlexDiscovery {
+		discovered, err := cp.discoverKubeFlexHubs(ctx)
+		if err != nil {
+			discoveryErr = fmt.Errorf("KubeFlex discovery failed: %v", err)
+		} else {
+			explicit, _ := config["hubs"].(map[string]interface{})
+			hubs = mergeDiscoveredHubs(configured, discovered, len(explicit) == 0)
+		}
+	}
+
+	cp.mutex.Lock()
+	cp.hubs = hubs
+	if discoveryErr != nil {
+		cp.metrics["hub_discovery_error"] = discoveryErr.Error()
+	} else {
+		delete(cp.metrics, "hub_discovery_error")
+	}
+	cp.mutex.Unlock()
+
+	cp.hubWatches.mutex.Lock()
+	defer cp.hubWatches.mutex.Unlock()
+	if cp.hubWatches.ctx != nil {
+		for _, hub := range hubs {
+			if !cp.hubWatches.watched[hub.Name] {
+				cp.hubWatches.watched[hub.Name] = true
+				go cp.watchManagedClusters(cp.hubWatches.ctx, hub)
+			}
+		}
+	}
+	return discoveryErr
+}
+
+// startHubs discovers hubs, reconciles with each of them and starts their
+// ManagedCluster watches until ctx is cancelled
+func (cp *ClusterOpsPlugin) startHubs(ctx context.Context) {
+	cp.hubWatches.mutex.Lock()
+	cp.hubWatches.ctx = ctx
+	cp.hubWatches.watched = make(map[string]bool)
+	cp.hubWatches.mutex.Unlock()
+
+	if err := cp.refreshHubs(ctx); err != nil {
+		cp.logger.Warn("Refreshing hubs failed", "error", err)
+	}
+	cp.checkKubeconfig(cp.hubList())
+	cp.reconcileOnStartup()
+}
+
+func (cp *ClusterOpsPlugin) ListHubsHandler(c *gin.Context) {
+	response := gin.H{
+		"discovery": cp.settings().KubeFlexDiscovery,
+		"plugin":    "cluster-ops-plugin",
+	}
+	if c.Query("refresh") == "true" {
+		if err := cp.refreshHubs(c.Request.Context()); err != nil {
+			response["discoveryError"] = err.Error()
+		}
+	}
+
+	hubs := cp.hubList()
+	clusters := make(map[string]int, len(hubs))
+	for _, record := range cp.clusters.List() {
+		clusters[cp.clusterHub(record.Name).Name]++
+	}
+	items := make([]gin.H, 0, len(hubs))
+	for _, hub := range hubs {
+		items = append(items, gin.H{
+			"name":     hub.Name,
+			"context":  hub.Context,
+			"source":   hub.Source,
+			"default":  hub.Default,
+			"clusters": clusters[hub.Name],
+		})
+	}
+	response["hubs"] = items
+	response["count"] = len(items)
+	c.JSON(http.StatusOK, response)
+}
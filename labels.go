@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// protectedLabelPrefixes are label namespaces managed by OCM and KubeStellar
+// that must not be changed through the plugin
+var protectedLabelPrefixes = []string{
+	"open-cluster-management.io/",
+	"cluster.open-cluster-management.io/",
+	"feature.open-cluster-management.io/",
+	"kubestellar.io/",
+}
+
+// protectedLabelKeys are individual labels that must not be changed
+var protectedLabelKeys = map[string]bool{
+	"name": true,
+}
+
+var (
+	labelNamePattern   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	labelPrefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// LabelPatchRequest is the payload of PATCH /clusters/:name/labels
+type LabelPatchRequest struct {
+	Add    map[string]string `json:"add"`
+	Remove []string          `json:"remove"`
+}
+
+// validateLabelKey checks a label key against Kubernetes syntax rules
+func validateLabelKey(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if len(prefix) > 253 || !labelPrefixPattern.MatchString(prefix) {
+			return fmt.Errorf("invalid label key %q: prefix must be a DNS subdomain", key)
+		}
+	}
+	if !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
+
+// validateLabelValue checks a label value against Kubernetes syntax rules
+func validateLabelValue(value string) error {
+	if value != "" && !labelNamePattern.MatchString(value) {
+		return fmt.Errorf("invalid label value %q", value)
+	}
+	return nil
+}
+
+// isProtectedLabel reports whether a label is reserved for OCM or KubeStellar
+func isProtectedLabel(key string) bool {
+	if protectedLabelKeys[key] {
+		return true
+	}
+	for _, prefix := range protectedLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLabelPatch checks every label in a patch, returning all problems found
+func validateLabelPatch(req LabelPatchRequest) []string {
+	var problems []string
+	for key, value := range req.Add {
+		if err := validateLabelKey(key); err != nil {
+			problems = append(problems, err.Error())
+		} else if isProtectedLabel(key) {
+			problems = append(problems, fmt.Sprintf("label %q is protected", key))
+		}
+		if err := validateLabelValue(value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, key := range req.Remove {
+		if _, ok := req.Add[key]; ok {
+			problems = append(problems, fmt.Sprintf("label %q is both added and removed", key))
+		} else if isProtectedLabel(key) {
+			problems = append(problems, fmt.Sprintf("label %q is protected", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func (cp *ClusterOpsPlugin) PatchClusterLabelsHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	var req LabelPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Nothing to change: provide labels to add or remove", nil))
+		return
+	}
+	if problems := validateLabelPatch(req); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid label changes", problems))
+		return
+	}
+
+	// A null value removes a label in a JSON merge patch
+	labels := make(map[string]interface{}, len(req.Add)+len(req.Remove))
+	for key, value := range req.Add {
+		labels[key] = value
+	}
+	for _, key := range req.Remove {
+		labels[key] = nil
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	}
+
+	mc, err := cp.patchManagedCluster(c.Request.Context(), name, patch)
+	if err != nil {
+		cp.logEvent(name, "labels", "failed", fmt.Sprintf("Failed to update labels: %v", err))
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to update labels on the hub", err.Error()))
+		return
+	}
+
+	cp.clusters.Update(name, func(record *ClusterRecord) {
+		record.Labels = mc.Metadata.Labels
+	})
+	cp.logEvent(name, "labels", "success", describeLabelPatch(req))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Cluster labels updated",
+		"clusterName": name,
+		"labels":      mc.Metadata.Labels,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
+
+// describeLabelPatch summarizes a label patch for the event log
+func describeLabelPatch(req LabelPatchRequest) string {
+	var parts []string
+	if len(req.Add) > 0 {
+		added := make([]string, 0, len(req.Add))
+		for key, value := range req.Add {
+			added = append(added, key+"="+value)
+		}
+		sort.Strings(added)
+		parts = append(parts, "set "+strings.Join(added, ", "))
+	}
+	if len(req.Remove) > 0 {
+		parts = append(parts, "removed "+strings.Join(req.Remove, ", "))
+	}
+	return "Labels updated: " + strings.Join(parts, "; ")
+}
+
+// validateClusterMetadata checks the labels and annotations requested at
+// onboarding, returning all problems found
+func validateClusterMetadata(labels, annotations map[string]string) []string {
+	problems := validateLabelPatch(LabelPatchRequest{Add: labels})
+	for key := range annotations {
+		if err := validateLabelKey(key); err != nil {
+			problems = append(problems, strings.Replace(err.Error(), "label", "annotation", 1))
+		} else if isProtectedLabel(key) {
+			problems = append(problems, fmt.Sprintf("annotation %q is protected", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// mergeStringMaps returns base with every entry of overrides applied
+func mergeStringMaps(base, overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
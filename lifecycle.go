@@ -0,0 +1,60 @@
+package main
+
+import "time"
+
+// Lifecycle event types delivered to notification sinks
+const (
+	lifecycleStateChanged       = "cluster.state.changed"
+	lifecycleOperationCompleted = "operation.completed"
+)
+
+// LifecycleEvent describes a cluster state change or the completion of an
+// operation to systems outside the plugin
+type LifecycleEvent struct {
+	Type          string       `json:"type"`
+	ClusterName   string       `json:"clusterName"`
+	Hub           string       `json:"hub,omitempty"`
+	PreviousState ClusterState `json:"previousState,omitempty"`
+	State         ClusterState `json:"state,omitempty"`
+	Message       string       `json:"message,omitempty"`
+	Operation     *Operation   `json:"operation,omitempty"`
+	Timestamp     time.Time    `json:"timestamp"`
+}
+
+// clusterTransitioned publishes the state change of a cluster
+func (cp *ClusterOpsPlugin) clusterTransitioned(record ClusterRecord, from ClusterState) {
+	cp.publishLifecycle(LifecycleEvent{
+		Type:          lifecycleStateChanged,
+		ClusterName:   record.Name,
+		Hub:           record.Hub,
+		PreviousState: from,
+		State:         record.State,
+		Message:       record.Message,
+		Timestamp:     record.UpdatedAt,
+	})
+}
+
+// operationFinished publishes the terminal result of an operation
+func (cp *ClusterOpsPlugin) operationFinished(op Operation) {
+	message := op.Result
+	if op.Error != "" {
+		message = op.Error
+	}
+	cp.publishLifecycle(LifecycleEvent{
+		Type:        lifecycleOperationCompleted,
+		ClusterName: op.ClusterName,
+		Message:     message,
+		Operation:   &op,
+		Timestamp:   time.Now(),
+	})
+}
+
+// publishLifecycle hands a lifecycle event to every notification sink. It is
+// called with plugin stores locked, so sinks deliver asynchronously.
+func (cp *ClusterOpsPlugin) publishLifecycle(event LifecycleEvent) {
+	cp.webhooks.Dispatch(event, cp.settings().Retries)
+	cp.emitCloudEvent(event)
+	cp.notifyChat(event)
+	cp.raiseIncident(event)
+	cp.publishToBus("lifecycle", event.ClusterName, event)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newLogger returns a JSON logger writing to stderr that drops records below
+// level and tags records logged with a request context with its request ID
+func newLogger(level *slog.LevelVar) *slog.Logger {
+	handler := requestIDHandler{slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})}
+	return slog.New(handler).With("plugin", "cluster-ops-plugin")
+}
+
+// parseLogLevel parses a log_level configuration value
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unsupported log_level %q, expected debug, info, warn or error", value)
+}
+
+// setLogLevel changes the level of the plugin logger at runtime
+func (cp *ClusterOpsPlugin) setLogLevel(value string) error {
+	level, err := parseLogLevel(value)
+	if err != nil {
+		return err
+	}
+	cp.logLevel.Set(level)
+	return nil
+}
+
+// slogLevel maps an event level to the logger level it is written at
+func slogLevel(level string) slog.Level {
+	switch level {
+	case levelError:
+		return slog.LevelError
+	case levelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
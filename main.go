@@ -1,30 +1,96 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kubestellar/ui/dynamic_plugins"
+	"google.golang.org/grpc"
+	"k8s.io/client-go/dynamic"
 )
 
 // ClusterOpsPlugin implements a lightweight wrapper for cluster operations
 type ClusterOpsPlugin struct {
-	config      map[string]interface{}
-	initialized bool
-	metrics     map[string]interface{}
-	uptime      time.Time
-	mutex       sync.RWMutex
+	config        map[string]interface{}
+	hostConfig    map[string]interface{}
+	envOverrides  []string
+	pluginConfig  PluginConfig
+	initialized   bool
+	metrics       map[string]interface{}
+	uptime        time.Time
+	events        *eventStore
+	operations    *operationStore
+	clusters      *clusterStore
+	audit         *auditStore
+	webhooks      *webhookStore
+	apiKeys       *apiKeyStore
+	rateLimiter   *rateLimiter
+	keyrings      *keyringCache
+	approvals     *approvalGate
+	joinTokens    *joinTokenCache
+	registrations *registrationStore
+	batches       *batchStore
+	controller    *onboardingController
+	runner        CommandRunner
+	hub           HubClient
+	hubClients    func(hub HubConfig) (dynamic.Interface, error)
+	simulator     *hubSimulator
+	state         *stateSync
+	grpcServer    *grpc.Server
+	workers       chan struct{}
+	stopWatch     context.CancelFunc
+	hubWatches    hubWatches
+	tracer        *tracer
+	jwt           *jwtVerifier
+	caBundle      []byte
+	hubs          []HubConfig
+	bus           *busPublisher
+	logger        *slog.Logger
+	logLevel      *slog.LevelVar
+	mutex         sync.RWMutex
 }
 
+// pluginAPIBase is the path prefix under which the host mounts plugin endpoints
+const pluginAPIBase = "/api/plugins/cluster-ops-plugin"
+
 // NewPlugin creates a new cluster operations plugin instance
 func NewPlugin() interface{} {
-	return &ClusterOpsPlugin{
-		metrics: make(map[string]interface{}),
-		uptime:  time.Now(),
+	logLevel := new(slog.LevelVar)
+	cp := &ClusterOpsPlugin{
+		metrics:       make(map[string]interface{}),
+		uptime:        time.Now(),
+		events:        newEventStore(),
+		operations:    newOperationStore(),
+		clusters:      newClusterStore(),
+		audit:         newAuditStore(),
+		webhooks:      newWebhookStore(),
+		apiKeys:       newAPIKeyStore(),
+		rateLimiter:   newRateLimiter(),
+		keyrings:      &keyringCache{},
+		approvals:     newApprovalGate(),
+		joinTokens:    newJoinTokenCache(),
+		registrations: newRegistrationStore(),
+		batches:       newBatchStore(),
+		controller:    &onboardingController{handled: make(map[string]*handledOnboarding)},
+		logger:        newLogger(logLevel),
+		logLevel:      logLevel,
+		runner:        execRunner{},
+		hubClients:    hubDynamicClient,
 	}
+	cp.hub = &cliHubClient{runner: cp.runner, flags: cp.hubFlags}
+	cp.pluginConfig, _ = parsePluginConfig(nil)
+	cp.clusters.onTransition = cp.clusterTransitioned
+	cp.operations.onFinish = cp.operationFinished
+	return cp
 }
 
 // Initialize implements dynamic_plugins.KubestellarPlugin interface
@@ -36,14 +102,119 @@ func (cp *ClusterOpsPlugin) Initialize(config map[string]interface{}) error {
 		return fmt.Errorf("plugin already initialized")
 	}
 
+	hostConfig := config
+	config, overridden, err := effectiveConfig(hostConfig)
+	if err != nil {
+		return err
+	}
+	settings, err := parsePluginConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := cp.setLogLevel(settings.LogLevel); err != nil {
+		return err
+	}
+
+	verifier := newJWTVerifier(settings)
+	apiKeys, err := apiKeysFromConfig(config)
+	if err != nil {
+		return err
+	}
+	caBundle, err := loadCABundle(config)
+	if err != nil {
+		return err
+	}
+	hubs, err := parseHubs(config, settings.ITSContext)
+	if err != nil {
+		return err
+	}
+	stateStore, err := cp.newStateBackend(settings)
+	if err != nil {
+		return err
+	}
+	// The bus publisher starts a goroutine, so it is created last
+	bus, err := newBusPublisher(settings)
+	if err != nil {
+		return err
+	}
+	var grpcServer *grpc.Server
+	if settings.GRPCPort > 0 {
+		if grpcServer, err = cp.startGRPC(settings); err != nil {
+			bus.Close()
+			return err
+		}
+	}
+
 	cp.config = config
+	cp.hostConfig = hostConfig
+	cp.envOverrides = overridden
+	cp.pluginConfig = settings
+	cp.workers = nil
+	cp.bus = bus
+	cp.grpcServer = grpcServer
+	cp.jwt = verifier
+	cp.caBundle = caBundle
+	cp.hubs = hubs
+	cp.apiKeys.Replace("config", apiKeys)
+	cp.events.SetCapacity(settings.EventBufferSize)
 	cp.uptime = time.Now()
 	cp.metrics = map[string]interface{}{
 		"plugin_type":    "cluster-operations",
 		"uptime_seconds": 0,
 	}
+	if settings.SimulationMode {
+		cp.simulator = newHubSimulator()
+		cp.runner = cp.simulator
+		cp.hub = &cliHubClient{runner: cp.runner, flags: cp.hubFlags}
+		cp.hubClients = cp.simulator.hubClient
+		cp.metrics["simulation_mode"] = true
+		cp.logger.Warn("Simulation mode is enabled; hubs are simulated and no kubectl or clusteradm commands are run")
+	}
+	if stateStore != nil {
+		cp.enableStateSync(newStateSync(stateStore, settings.StateSyncInterval))
+		cp.metrics["state_backend"] = settings.StateBackend
+		cp.metrics["state_replica"] = cp.state.replica
+	}
+	if grpcServer != nil {
+		cp.metrics["grpc_port"] = settings.GRPCPort
+	}
 
 	cp.initialized = true
+	cp.logger.Info("Plugin initialized", "logLevel", cp.logLevel.Level().String())
+	if len(overridden) > 0 {
+		cp.logger.Info("Configuration overridden by environment", "keys", strings.Join(overridden, ","))
+	}
+
+	// Discover hubs, pick up clusters joined to them before this plugin
+	// instance started and keep following changes made outside of the plugin
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	cp.stopWatch = stopWatch
+	if cp.state != nil {
+		// Hub reconciliation starts from the shared records once loaded
+		stateLoaded := make(chan struct{})
+		go cp.runStateSync(watchCtx, stateLoaded)
+		go func() {
+			<-stateLoaded
+			cp.startHubs(watchCtx)
+		}()
+	} else {
+		go cp.startHubs(watchCtx)
+	}
+	if ref, _ := config["api_keys_secret"].(string); ref != "" {
+		go cp.refreshAPIKeys(watchCtx, ref)
+	}
+	if settings.ControllerMode {
+		go cp.runOnboardingController(watchCtx)
+	}
+	go cp.sweepArtifacts(watchCtx)
+	go cp.sweepEvents(watchCtx)
+	if settings.ConfigFile != "" {
+		go cp.watchConfigFile(watchCtx, settings.ConfigFile)
+	}
+
+	if settings.OTLPEndpoint != "" {
+		cp.tracer = newTracer(settings.OTelServiceName, settings.OTLPEndpoint)
+	}
 	return nil
 }
 
@@ -52,17 +223,69 @@ func (cp *ClusterOpsPlugin) GetMetadata() dynamic_plugins.PluginMetadata {
 	return dynamic_plugins.PluginMetadata{
 		ID:          "cluster-ops-plugin",
 		Name:        "KubeStellar Cluster Operations",
-		Version:     "1.1.0",
+		Version:     pluginVersion,
 		Description: "Advanced cluster onboarding and detachment operations for KubeStellar",
 		Author:      "Priyanshu",
-		Endpoints: []dynamic_plugins.EndpointConfig{
+		Endpoints: withPreflightEndpoints([]dynamic_plugins.EndpointConfig{
 			{Path: "/onboard", Method: "POST", Handler: "OnboardClusterHandler", Description: "Onboard a new cluster to KubeStellar"},
+			{Path: "/onboard/batch", Method: "POST", Handler: "BatchOnboardHandler", Description: "Onboard several clusters at once"},
+			{Path: "/onboard/batch/:id", Method: "GET", Handler: "GetBatchHandler", Description: "Get the per-cluster progress of a batch onboarding"},
 			{Path: "/detach", Method: "POST", Handler: "DetachClusterHandler", Description: "Detach a cluster from KubeStellar"},
+			{Path: "/detach/batch", Method: "POST", Handler: "BatchDetachHandler", Description: "Detach clusters by name or label selector"},
+			{Path: "/detach/batch/:id", Method: "GET", Handler: "GetDetachBatchHandler", Description: "Get the per-cluster progress of a bulk detach"},
 			{Path: "/status/:cluster", Method: "GET", Handler: "GetClusterStatusHandler", Description: "Get specific cluster status"},
 			{Path: "/clusters", Method: "GET", Handler: "ListClustersHandler", Description: "List all managed clusters"},
+			{Path: "/clusters", Method: "POST", Handler: "RegisterClusterHandler", Description: "Register a cluster whose kubeconfig will be supplied later"},
+			{Path: "/clusters", Method: "PUT", Handler: "ApplyFleetHandler", Description: "Converge the fleet to a desired state"},
+			{Path: "/clusters/diff", Method: "POST", Handler: "DiffFleetHandler", Description: "Plan the changes that would converge the fleet to a desired state"},
+			{Path: "/clusters/:name", Method: "GET", Handler: "GetClusterDetailsHandler", Description: "Get cluster details with live hub data"},
+			{Path: "/clusters/:name/nodes", Method: "GET", Handler: "ListClusterNodesHandler", Description: "List the nodes of a cluster, through cluster-proxy when enabled"},
+			{Path: "/clusters/:name/kubeconfig", Method: "GET", Handler: "GetClusterKubeconfigHandler", Description: "Retrieve the stored kubeconfig of a cluster"},
+			{Path: "/clusters/:name/kubeconfig", Method: "PUT", Handler: "RotateClusterKubeconfigHandler", Description: "Replace the stored kubeconfig of a cluster, or join a registered one"},
+			{Path: "/clusters/:name/labels", Method: "PATCH", Handler: "PatchClusterLabelsHandler", Description: "Add or remove ManagedCluster labels"},
+			{Path: "/clusters/:name/addons", Method: "GET", Handler: "GetClusterAddonsHandler", Description: "Get addon readiness for a cluster"},
+			{Path: "/clusters/:name/addons", Method: "POST", Handler: "EnableClusterAddonsHandler", Description: "Enable OCM addons on a cluster"},
+			{Path: "/clusters/:name/taints", Method: "POST", Handler: "SetClusterTaintHandler", Description: "Add or update a ManagedCluster taint"},
+			{Path: "/clusters/:name/taints/:key", Method: "DELETE", Handler: "RemoveClusterTaintHandler", Description: "Remove a ManagedCluster taint"},
+			{Path: "/clusters/:name/join-manifest", Method: "GET", Handler: "GetJoinManifestHandler", Description: "Render the manifests a spoke administrator applies to join the hub"},
+			{Path: "/clusters/:name/approve", Method: "POST", Handler: "ApproveClusterHandler", Description: "Approve a cluster awaiting manual acceptance"},
+			{Path: "/clusters/:name/cordon", Method: "POST", Handler: "CordonClusterHandler", Description: "Stop new placements on a cluster"},
+			{Path: "/clusters/:name/uncordon", Method: "POST", Handler: "UncordonClusterHandler", Description: "Allow new placements on a cluster again"},
+			{Path: "/clusters/:name/events", Method: "DELETE", Handler: "ClearClusterEventsHandler", Description: "Delete the event history of a cluster"},
 			{Path: "/health", Method: "GET", Handler: "HealthCheckHandler", Description: "Plugin health check"},
-			{Path: "/events/:cluster", Method: "GET", Handler: "GetClusterEventsHandler", Description: "Get cluster onboarding events"},
-		},
+			{Path: "/health/deep", Method: "GET", Handler: "DeepHealthCheckHandler", Description: "Check the hubs, CLIs, kubeconfig directory and state store the plugin depends on"},
+			{Path: "/version", Method: "GET", Handler: "VersionHandler", Description: "Plugin version, build and the detected kubectl and clusteradm versions"},
+			{Path: "/selftest", Method: "POST", Handler: "SelfTestHandler", Description: "Onboard and detach a simulated cluster and report on every stage of the pipeline"},
+			{Path: "/openapi.json", Method: "GET", Handler: "OpenAPISpecHandler", Description: "OpenAPI specification of the plugin API"},
+			{Path: "/docs", Method: "GET", Handler: "SwaggerUIHandler", Description: "Swagger UI for exploring the plugin API"},
+			{Path: "/debug/runtime", Method: "GET", Handler: "RuntimeDiagnosticsHandler", Description: "Runtime diagnostics"},
+			{Path: "/debug/pprof/*profile", Method: "GET", Handler: "PprofHandler", Description: "Go profiling data (requires enable_pprof)"},
+			{Path: "/events/:cluster", Method: "GET", Handler: "GetClusterEventsHandler", Description: "Get cluster onboarding events filtered by since, level, step and limit"},
+			{Path: "/logs/:cluster", Method: "GET", Handler: "GetClusterLogsHandler", Description: "Get cluster event logs with paging and filtering"},
+			{Path: "/ws/:cluster", Method: "GET", Handler: "StreamClusterEventsHandler", Description: "Stream cluster events over WebSocket"},
+			{Path: "/preflight", Method: "POST", Handler: "PreflightHandler", Description: "Run preflight checks against a cluster before onboarding"},
+			{Path: "/graphql", Method: "GET", Handler: "GraphQLHandler", Description: "Query clusters, operations and events with GraphQL"},
+			{Path: "/graphql", Method: "POST", Handler: "GraphQLHandler", Description: "Query clusters, operations and events with GraphQL"},
+			{Path: "/operations", Method: "GET", Handler: "ListOperationsHandler", Description: "List onboarding and detachment operations"},
+			{Path: "/operations/:id", Method: "GET", Handler: "GetOperationHandler", Description: "Get operation progress and result"},
+			{Path: "/operations/:id/cancel", Method: "POST", Handler: "CancelOperationHandler", Description: "Cancel an in-flight operation"},
+			{Path: "/audit", Method: "GET", Handler: "ListAuditHandler", Description: "List audited mutating requests"},
+			{Path: "/webhooks", Method: "GET", Handler: "ListWebhooksHandler", Description: "List registered webhooks"},
+			{Path: "/webhooks", Method: "POST", Handler: "CreateWebhookHandler", Description: "Register a webhook for lifecycle events"},
+			{Path: "/webhooks/:id", Method: "DELETE", Handler: "DeleteWebhookHandler", Description: "Remove a webhook"},
+			{Path: "/webhooks/:id/deliveries", Method: "GET", Handler: "ListWebhookDeliveriesHandler", Description: "List recent webhook deliveries"},
+			{Path: "/api-keys", Method: "GET", Handler: "ListAPIKeysHandler", Description: "List API keys and their usage"},
+			{Path: "/hubs", Method: "GET", Handler: "ListHubsHandler", Description: "List the hubs clusters can be onboarded to"},
+			{Path: "/clusters/precreate", Method: "POST", Handler: "PrecreateClusterHandler", Description: "Create a cluster on the hub ahead of its agent and return the join command"},
+			{Path: "/registrations", Method: "GET", Handler: "ListRegistrationsHandler", Description: "List self-registration codes"},
+			{Path: "/registrations", Method: "POST", Handler: "CreateRegistrationHandler", Description: "Mint a one-time self-registration code for a cluster"},
+			{Path: "/registrations/exchange", Method: "POST", Handler: "RedeemRegistrationHandler", Description: "Exchange a registration code for the join manifest"},
+			{Path: "/hub/token/rotate", Method: "POST", Handler: "RotateJoinTokenHandler", Description: "Revoke the hub join token and issue a new one"},
+			{Path: "/hub/token", Method: "DELETE", Handler: "RevokeJoinTokenHandler", Description: "Revoke the hub join token"},
+			{Path: "/config", Method: "GET", Handler: "GetConfigHandler", Description: "Get the effective configuration with secrets redacted"},
+			{Path: "/config", Method: "PUT", Handler: "UpdateConfigHandler", Description: "Change settings that apply without a restart"},
+			{Path: "/admin/encryption/rotate", Method: "POST", Handler: "RotateEncryptionHandler", Description: "Re-encrypt stored kubeconfigs with the current key"},
+		}),
 		Permissions:  []string{"cluster.read", "cluster.write", "cluster.delete"},
 		Dependencies: []string{"kubectl", "clusteradm"},
 		Configuration: map[string]interface{}{
@@ -79,14 +302,78 @@ func (cp *ClusterOpsPlugin) GetMetadata() dynamic_plugins.PluginMetadata {
 
 // GetHandlers implements dynamic_plugins.KubestellarPlugin interface - self-contained handlers
 func (cp *ClusterOpsPlugin) GetHandlers() map[string]gin.HandlerFunc {
-	return map[string]gin.HandlerFunc{
-		"OnboardClusterHandler":   cp.OnboardClusterHandler,
-		"DetachClusterHandler":    cp.DetachClusterHandler,
-		"GetClusterStatusHandler": cp.GetClusterStatusHandler,
-		"ListClustersHandler":     cp.ListClustersHandler,
-		"HealthCheckHandler":      cp.HealthCheckHandler,
-		"GetClusterEventsHandler": cp.GetClusterEventsHandler,
+	handlers := map[string]gin.HandlerFunc{
+		"OnboardClusterHandler":          cp.audited("onboard", cp.OnboardClusterHandler),
+		"BatchOnboardHandler":            cp.audited("onboard-batch", cp.BatchOnboardHandler),
+		"GetBatchHandler":                cp.GetBatchHandler,
+		"DetachClusterHandler":           cp.audited("detach", cp.DetachClusterHandler),
+		"BatchDetachHandler":             cp.audited("detach-batch", cp.BatchDetachHandler),
+		"GetDetachBatchHandler":          cp.GetDetachBatchHandler,
+		"GetClusterStatusHandler":        cp.GetClusterStatusHandler,
+		"ListHubsHandler":                cp.ListHubsHandler,
+		"ListClustersHandler":            cp.ListClustersHandler,
+		"RegisterClusterHandler":         cp.audited("register", cp.RegisterClusterHandler),
+		"ApplyFleetHandler":              cp.audited("apply-fleet", cp.ApplyFleetHandler),
+		"DiffFleetHandler":               cp.DiffFleetHandler,
+		"GetClusterDetailsHandler":       cp.GetClusterDetailsHandler,
+		"ListClusterNodesHandler":        cp.ListClusterNodesHandler,
+		"GetClusterKubeconfigHandler":    cp.audited("read-kubeconfig", cp.GetClusterKubeconfigHandler),
+		"RotateClusterKubeconfigHandler": cp.audited("rotate-kubeconfig", cp.RotateClusterKubeconfigHandler),
+		"PatchClusterLabelsHandler":      cp.audited("update-labels", cp.PatchClusterLabelsHandler),
+		"GetClusterAddonsHandler":        cp.GetClusterAddonsHandler,
+		"EnableClusterAddonsHandler":     cp.audited("enable-addons", cp.EnableClusterAddonsHandler),
+		"SetClusterTaintHandler":         cp.audited("set-taint", cp.SetClusterTaintHandler),
+		"RemoveClusterTaintHandler":      cp.audited("remove-taint", cp.RemoveClusterTaintHandler),
+		"GetJoinManifestHandler":         cp.audited("join-manifest", cp.GetJoinManifestHandler),
+		"ApproveClusterHandler":          cp.audited("approve", cp.ApproveClusterHandler),
+		"CordonClusterHandler":           cp.audited("cordon", cp.CordonClusterHandler),
+		"UncordonClusterHandler":         cp.audited("uncordon", cp.UncordonClusterHandler),
+		"ClearClusterEventsHandler":      cp.audited("clear-events", cp.ClearClusterEventsHandler),
+		"HealthCheckHandler":             cp.HealthCheckHandler,
+		"DeepHealthCheckHandler":         cp.DeepHealthCheckHandler,
+		"VersionHandler":                 cp.VersionHandler,
+		"SelfTestHandler":                cp.audited("selftest", cp.SelfTestHandler),
+		"OpenAPISpecHandler":             cp.OpenAPISpecHandler,
+		"SwaggerUIHandler":               cp.SwaggerUIHandler,
+		"RuntimeDiagnosticsHandler":      cp.RuntimeDiagnosticsHandler,
+		"PprofHandler":                   cp.PprofHandler,
+		"GetClusterEventsHandler":        cp.GetClusterEventsHandler,
+		"GetClusterLogsHandler":          cp.GetClusterLogsHandler,
+		"StreamClusterEventsHandler":     cp.StreamClusterEventsHandler,
+		"PreflightHandler":               cp.PreflightHandler,
+		"GraphQLHandler":                 cp.GraphQLHandler,
+		"ListOperationsHandler":          cp.ListOperationsHandler,
+		"GetOperationHandler":            cp.GetOperationHandler,
+		"CancelOperationHandler":         cp.audited("cancel-operation", cp.CancelOperationHandler),
+		"ListAuditHandler":               cp.ListAuditHandler,
+		"ListWebhooksHandler":            cp.ListWebhooksHandler,
+		"CreateWebhookHandler":           cp.audited("create-webhook", cp.CreateWebhookHandler),
+		"DeleteWebhookHandler":           cp.audited("delete-webhook", cp.DeleteWebhookHandler),
+		"ListWebhookDeliveriesHandler":   cp.ListWebhookDeliveriesHandler,
+		"ListAPIKeysHandler":             cp.ListAPIKeysHandler,
+		"PrecreateClusterHandler":        cp.audited("precreate", cp.PrecreateClusterHandler),
+		"ListRegistrationsHandler":       cp.ListRegistrationsHandler,
+		"CreateRegistrationHandler":      cp.audited("create-registration", cp.CreateRegistrationHandler),
+		"RedeemRegistrationHandler":      cp.audited("redeem-registration", cp.RedeemRegistrationHandler),
+		"RotateJoinTokenHandler":         cp.audited("rotate-join-token", cp.RotateJoinTokenHandler),
+		"RevokeJoinTokenHandler":         cp.audited("revoke-join-token", cp.RevokeJoinTokenHandler),
+		"GetConfigHandler":               cp.GetConfigHandler,
+		"UpdateConfigHandler":            cp.audited("update-config", cp.UpdateConfigHandler),
+		"RotateEncryptionHandler":        cp.audited("rotate-encryption", cp.RotateEncryptionHandler),
+		"CORSPreflightHandler":           cp.CORSPreflightHandler,
 	}
+
+	for name, handler := range handlers {
+		if !publicHandlers[name] {
+			handler = cp.authenticated(cp.authorized(name, cp.limited(cp.withRequestHub(handler))))
+		} else if name == "RedeemRegistrationHandler" {
+			// Codes are redeemed without credentials, so the limit is per
+			// client IP
+			handler = cp.limited(handler)
+		}
+		handlers[name] = cp.withRequestID(cp.accessLogged(cp.withCORS(handler)))
+	}
+	return handlers
 }
 
 // Health implements dynamic_plugins.KubestellarPlugin interface
@@ -101,6 +388,18 @@ func (cp *ClusterOpsPlugin) Health() error {
 func (cp *ClusterOpsPlugin) Cleanup() error {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
+	if cp.stopWatch != nil {
+		cp.stopWatch()
+		cp.stopWatch = nil
+	}
+	cp.tracer.Shutdown()
+	cp.tracer = nil
+	cp.bus.Close()
+	cp.bus = nil
+	if cp.grpcServer != nil {
+		cp.grpcServer.Stop()
+		cp.grpcServer = nil
+	}
 	cp.initialized = false
 	return nil
 }
@@ -145,11 +444,36 @@ func (cp *ClusterOpsPlugin) HandleError(err error) dynamic_plugins.PluginError {
 	}
 }
 
-// OnConfigChange implements dynamic_plugins.KubestellarPlugin interface
+// OnConfigChange implements dynamic_plugins.KubestellarPlugin interface. The
+// new configuration is validated as at initialization and rejected, leaving
+// the running one untouched, when it is invalid. As with config_file, only
+// reloadable keys are applied; other changes take effect on restart.
 func (cp *ClusterOpsPlugin) OnConfigChange(config map[string]interface{}) error {
+	next, _, err := effectiveConfig(config)
+	if err == nil {
+		_, err = parsePluginConfig(next)
+	}
+	if err != nil {
+		return err
+	}
+
+	cp.mutex.RLock()
+	current := cp.config
+	cp.mutex.RUnlock()
+
+	updated, applied, ignored := reloadableChanges(current, next)
+	if len(applied) > 0 {
+		if err := cp.applyConfig(updated); err != nil {
+			return err
+		}
+	}
 	cp.mutex.Lock()
-	defer cp.mutex.Unlock()
-	cp.config = config
+	cp.hostConfig = config
+	cp.mutex.Unlock()
+	if len(applied) == 0 && len(ignored) == 0 {
+		return nil
+	}
+	cp.logger.Info("Host configuration changed", "applied", strings.Join(applied, ","), "requiresRestart", strings.Join(ignored, ","))
 	return nil
 }
 
@@ -163,6 +487,9 @@ func (cp *ClusterOpsPlugin) GetMetrics() map[string]interface{} {
 		metrics[k] = v
 	}
 	metrics["uptime_seconds"] = time.Since(cp.uptime).Seconds()
+	if cp.bus != nil {
+		metrics["message_bus_dropped"] = cp.bus.Dropped()
+	}
 	return metrics
 }
 
@@ -189,85 +516,459 @@ func (cp *ClusterOpsPlugin) OnUnload() error {
 
 // Self-contained handlers for cluster operations
 
+// ClusterOnboardRequest is the payload of POST /onboard
+type ClusterOnboardRequest struct {
+	ClusterName string `json:"clusterName"`
+	Kubeconfig  string `json:"kubeconfig"`
+	// VaultRef and KubeconfigRef fetch the kubeconfig from Vault or a cloud
+	// secret manager instead of the request body
+	VaultRef      *VaultSecretRef `json:"vaultRef,omitempty"`
+	KubeconfigRef *KubeconfigRef  `json:"kubeconfigRef,omitempty"`
+	// KubeconfigURL fetches the kubeconfig over https with KubeconfigURLAuth
+	KubeconfigURL     string             `json:"kubeconfigURL,omitempty"`
+	KubeconfigURLAuth *KubeconfigURLAuth `json:"kubeconfigURLAuth,omitempty"`
+	// EKS imports an EKS cluster, building its kubeconfig from the AWS APIs
+	EKS *EKSClusterRef `json:"eks,omitempty"`
+	// GKE imports a GKE cluster, building its kubeconfig from the GKE API
+	GKE *GKEClusterRef `json:"gke,omitempty"`
+	// AKS imports an AKS cluster with a kubeconfig fetched from Azure
+	AKS *AKSClusterRef `json:"aks,omitempty"`
+	// Server and CAData describe a spoke reachable with either a
+	// ServiceAccount token or an x509 client certificate and key in PEM form
+	// when no kubeconfig is available
+	Server     string `json:"server,omitempty"`
+	Token      string `json:"token,omitempty"`
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	CAData     string `json:"caData,omitempty"`
+	Type       string `json:"type,omitempty"`
+	// Hub names the hub to register the cluster with; empty uses the default
+	Hub string `json:"hub,omitempty"`
+	// Resume skips the steps completed by a previous, failed attempt
+	Resume      bool              `json:"resume,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Addons      []string          `json:"addons,omitempty"`
+	// ClusterProxy enables the cluster-proxy addon so the plugin can reach the
+	// spoke API server from the hub when it sits behind NAT or a firewall
+	ClusterProxy bool `json:"clusterProxy,omitempty"`
+	// ManagedServiceAccount enables the managed-serviceaccount addon and uses
+	// its rotating token for later spoke access instead of storing the
+	// kubeconfig
+	ManagedServiceAccount bool `json:"managedServiceAccount,omitempty"`
+	// Klusterlet customizes the agent deployed on the spoke
+	Klusterlet *KlusterletOptions `json:"klusterlet,omitempty"`
+}
+
 func (cp *ClusterOpsPlugin) OnboardClusterHandler(c *gin.Context) {
-	var requestBody map[string]interface{}
-	if err := c.ShouldBindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid JSON payload",
-			"details": err.Error(),
-		})
+	var req ClusterOnboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
 		return
 	}
 
-	// Simulate cluster onboarding process
-	clusterName := requestBody["clusterName"]
-	kubeconfig := requestBody["kubeconfig"]
-
-	if clusterName == nil || kubeconfig == nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing required fields: clusterName and kubeconfig",
-		})
+	op, rejected := cp.beginOnboarding(c.Request.Context(), c.GetHeader("traceparent"), req)
+	if rejected != nil {
+		c.JSON(rejected.status, rejected.body)
 		return
 	}
+	c.Set("operationId", op.ID)
+	c.JSON(http.StatusAccepted, cp.onboardingStarted(op, req.Resume))
+}
 
-	// Simulate successful onboarding
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Cluster onboarding completed successfully",
-		"clusterName": clusterName,
-		"status":      "onboarded",
-		"timestamp":   time.Now().Format(time.RFC3339),
-		"plugin":      "cluster-ops-plugin",
+// requestError is the response to a request that was rejected before its
+// operation started
+type requestError struct {
+	status int
+	body   gin.H
+}
+
+// beginOnboarding validates an onboarding request, resolves its kubeconfig
+// and schedules its pipeline
+func (cp *ClusterOpsPlugin) beginOnboarding(ctx context.Context, traceparent string, req ClusterOnboardRequest) (Operation, *requestError) {
+	clusterName := req.ClusterName
+	sources := 0
+	for _, set := range []bool{req.Kubeconfig != "", req.VaultRef != nil, req.KubeconfigRef != nil, req.KubeconfigURL != "", req.EKS != nil, req.GKE != nil, req.AKS != nil, req.Server != ""} {
+		if set {
+			sources++
+		}
+	}
+	if clusterName == "" || sources != 1 {
+		return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Missing required fields: clusterName and exactly one of kubeconfig, vaultRef, kubeconfigRef, kubeconfigURL, eks, gke, aks or server", nil)}
+	}
+
+	if req.Hub == "" {
+		if record, ok := cp.clusters.Get(clusterName); ok {
+			req.Hub = record.Hub
+		}
+	}
+	hub, err := cp.lookupHub(req.Hub)
+	if err != nil {
+		return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid hub", err.Error())}
+	}
+
+	if req.VaultRef != nil {
+		if err := req.VaultRef.validate(); err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid vaultRef", err.Error())}
+		}
+		kubeconfig, err := cp.fetchVaultKubeconfig(ctx, *req.VaultRef)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadGateway, errorResponse(codeKubeconfigUnavailable, "Failed to fetch kubeconfig from Vault", err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+	}
+
+	if req.KubeconfigRef != nil {
+		if err := req.KubeconfigRef.validate(); err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Invalid kubeconfigRef", err.Error())}
+		}
+		kubeconfig, err := cp.fetchSecretManagerKubeconfig(ctx, *req.KubeconfigRef)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadGateway, errorResponse(codeKubeconfigUnavailable, fmt.Sprintf("Failed to fetch kubeconfig from %s", req.KubeconfigRef.Provider), err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+	}
+
+	if req.KubeconfigURL != "" {
+		if err := validateKubeconfigURL(req.KubeconfigURL, req.KubeconfigURLAuth); err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Invalid kubeconfigURL", err.Error())}
+		}
+		kubeconfig, err := fetchKubeconfigURL(ctx, req.KubeconfigURL, req.KubeconfigURLAuth)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadGateway, errorResponse(codeKubeconfigUnavailable, "Failed to fetch kubeconfig from kubeconfigURL", err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+	}
+
+	if req.EKS != nil {
+		ref := *req.EKS
+		if ref.Name == "" {
+			ref.Name = clusterName
+		}
+		if ref.Region == "" {
+			ref.Region = ambientAWSRegion()
+		}
+		if err := ref.validate(); err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid eks", err.Error())}
+		}
+		kubeconfig, err := cp.eksKubeconfig(ctx, clusterName, ref)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadGateway, errorResponse(codeKubeconfigUnavailable, "Failed to build kubeconfig for EKS cluster", err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+		if req.Type == "" {
+			req.Type = "eks"
+		}
+	}
+
+	if req.GKE != nil {
+		ref := *req.GKE
+		if ref.Name == "" {
+			ref.Name = clusterName
+		}
+		if ref.Project == "" {
+			ref.Project = cp.gcpProject()
+		}
+		if err := ref.validate(); err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid gke", err.Error())}
+		}
+		kubeconfig, err := cp.gkeKubeconfig(ctx, clusterName, ref)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadGateway, errorResponse(codeKubeconfigUnavailable, "Failed to build kubeconfig for GKE cluster", err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+		if req.Type == "" {
+			req.Type = "gke"
+		}
+	}
+
+	if req.AKS != nil {
+		ref := *req.AKS
+		if ref.Name == "" {
+			ref.Name = clusterName
+		}
+		if ref.Subscription == "" {
+			ref.Subscription = ambientAzureSubscription()
+		}
+		if ref.Credential == "" {
+			ref.Credential = aksAdminCredential
+		}
+		if err := ref.validate(); err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid aks", err.Error())}
+		}
+		kubeconfig, err := aksKubeconfig(ctx, ref)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadGateway, errorResponse(codeKubeconfigUnavailable, "Failed to fetch kubeconfig of AKS cluster", err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+		if req.Type == "" {
+			req.Type = "aks"
+		}
+	}
+
+	if req.Server != "" {
+		user, err := serverCredentials(req.Token, req.ClientCert, req.ClientKey)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid server credentials", err.Error())}
+		}
+		kubeconfig, err := synthesizeKubeconfig(clusterName, req.Server, req.CAData, user)
+		if err != nil {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid server credentials", err.Error())}
+		}
+		req.Kubeconfig = kubeconfig
+	}
+
+	kubeconfig, err := cp.prepareKubeconfig(ctx, req.Kubeconfig)
+	if err != nil {
+		return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Unable to decode kubeconfig", err.Error())}
+	}
+	req.Kubeconfig = kubeconfig
+
+	tlsOpts := cp.spokeTLSOptions()
+	if _, err := newSpokeClient(req.Kubeconfig, tlsOpts); err != nil {
+		return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Invalid kubeconfig", err.Error())}
+	}
+
+	// A pre-registered cluster joins with the metadata it was registered with
+	if record, ok := cp.clusters.Get(clusterName); ok && record.State == StateRegistered {
+		req.Labels = mergeStringMaps(record.Labels, req.Labels)
+		req.Annotations = mergeStringMaps(record.Annotations, req.Annotations)
+		if req.Type == "" {
+			req.Type = record.Type
+		}
+	}
+	if problems := validateClusterMetadata(req.Labels, req.Annotations); len(problems) > 0 {
+		return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid labels or annotations", problems)}
+	}
+
+	if req.ClusterProxy && !slices.Contains(req.Addons, clusterProxyAddon) {
+		req.Addons = append(req.Addons, clusterProxyAddon)
+	}
+	if req.ManagedServiceAccount && !slices.Contains(req.Addons, managedServiceAccountAddon) {
+		req.Addons = append(req.Addons, managedServiceAccountAddon)
+	}
+	if problems := validateAddons(req.Addons); len(problems) > 0 {
+		return Operation{}, &requestError{http.StatusBadRequest, withFields(errorResponse(codeInvalidRequest, "Invalid addons", problems), gin.H{
+			"supported": supportedAddonNames(),
+		})}
+	}
+
+	req.Klusterlet = cp.withJoinDefaults(req.Klusterlet)
+	if req.Klusterlet != nil {
+		if problems := req.Klusterlet.validate(); len(problems) > 0 {
+			return Operation{}, &requestError{http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid klusterlet options", problems)}
+		}
+	}
+
+	opts := onboardOptions{kubeconfig: req.Kubeconfig, labels: req.Labels, annotations: req.Annotations, addons: req.Addons, klusterlet: req.Klusterlet, managedServiceAccount: req.ManagedServiceAccount}
+	opts.manualApproval = cp.settings().ManualApproval
+	if req.Resume {
+		record, ok := cp.clusters.Get(clusterName)
+		if !ok || (record.State != StateFailed && record.State != StateCancelled) {
+			return Operation{}, &requestError{http.StatusConflict, errorResponse(codeConflict, "Only a failed or cancelled onboarding can be resumed", nil)}
+		}
+		opts.completed = make(map[string]bool)
+		for _, step := range record.CompletedSteps {
+			opts.completed[step] = true
+		}
+	}
+
+	if err := cp.clusters.Transition(clusterName, StatePending, "Onboarding requested"); err != nil {
+		return Operation{}, &requestError{http.StatusConflict, errorResponse(codeConflict, "Cluster cannot be onboarded in its current state", err.Error())}
+	}
+	if !req.Resume {
+		cp.clusters.ResetSteps(clusterName)
+	}
+	if !tlsOpts.validateSSL {
+		cp.logEvent(clusterName, "tls", "warning", insecureTLSWarning)
+	}
+	cp.clusters.Update(clusterName, func(record *ClusterRecord) {
+		record.Hub = hub.Name
+		record.Klusterlet = req.Klusterlet.redacted()
+		if req.Type != "" {
+			record.Type = req.Type
+		}
+	})
+
+	steps := onboardingPlan(opts)
+	requestID := requestIDFrom(ctx)
+	runCtx, cancel := context.WithCancel(withRequestID(withHub(withRemoteParent(context.Background(), traceparent), hub), requestID))
+	op := cp.operations.Create("onboard", clusterName, requestID, steps, cancel)
+	cp.schedule(runCtx, func() {
+		cp.runOnboarding(runCtx, op.ID, clusterName, steps, opts)
 	})
+	return op, nil
+}
+
+// onboardingStarted is the response to an accepted onboarding request
+func (cp *ClusterOpsPlugin) onboardingStarted(op Operation, resume bool) gin.H {
+	return gin.H{
+		"message":           "Cluster onboarding started",
+		"clusterName":       op.ClusterName,
+		"hub":               cp.clusterHub(op.ClusterName).Name,
+		"operationId":       op.ID,
+		"status":            StatePending,
+		"resume":            resume,
+		"timestamp":         time.Now().Format(time.RFC3339),
+		"websocketEndpoint": fmt.Sprintf("%s/ws/%s", pluginAPIBase, op.ClusterName),
+		"logsEndpoint":      fmt.Sprintf("%s/logs/%s", pluginAPIBase, op.ClusterName),
+		"operationEndpoint": fmt.Sprintf("%s/operations/%s", pluginAPIBase, op.ID),
+		"plugin":            "cluster-ops-plugin",
+	}
 }
 
 func (cp *ClusterOpsPlugin) GetClusterStatusHandler(c *gin.Context) {
 	clusterName := c.Param("cluster")
+	wait := c.Query("wait") == "true"
+	timeout, err := parseWaitTimeout(c.Query("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid timeout", err.Error()))
+		return
+	}
 
-	// Mock cluster status data
-	c.JSON(http.StatusOK, gin.H{
-		"clusterName": clusterName,
-		"status":      "active",
-		"health":      "healthy",
-		"lastSeen":    time.Now().Add(-5 * time.Minute).Format(time.RFC3339),
-		"nodes":       3,
-		"pods":        15,
-		"services":    8,
-		"plugin":      "cluster-ops-plugin",
-	})
+	record, ok := cp.clusters.Get(clusterName)
+	if ok && wait {
+		record, ok = cp.waitForCluster(c.Request.Context(), clusterName, timeout)
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse(codeClusterNotFound, "Cluster not found", nil))
+		return
+	}
+
+	response := gin.H{
+		"clusterName":    clusterName,
+		"hub":            record.Hub,
+		"status":         record.State,
+		"message":        record.Message,
+		"allowedActions": allowedActions(record.State),
+		"remediation":    record.Remediation,
+		"updatedAt":      record.UpdatedAt.Format(time.RFC3339),
+		"lastSeen":       nil,
+		"health":         "unknown",
+		"plugin":         "cluster-ops-plugin",
+	}
+	if wait {
+		response["timedOut"] = !isTerminalState(record.State)
+	}
+	// Health, capacity and version are those the agent reports on the
+	// ManagedCluster
+	if mc, err := cp.getManagedCluster(c.Request.Context(), clusterName); err != nil {
+		response["healthError"] = err.Error()
+	} else {
+		response["health"] = mc.health()
+		response["conditions"] = mc.Status.Conditions
+		response["kubernetesVersion"] = mc.Status.Version.Kubernetes
+		response["capacity"] = mc.Status.Capacity
+		response["allocatable"] = mc.Status.Allocatable
+	}
+	if lastSeen, err := cp.lastHeartbeat(c.Request.Context(), clusterName); err != nil {
+		response["lastSeenError"] = err.Error()
+	} else {
+		response["lastSeen"] = lastSeen.Format(time.RFC3339)
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (cp *ClusterOpsPlugin) ListClustersHandler(c *gin.Context) {
-	// Mock cluster list data
-	clusters := []map[string]interface{}{
-		{
-			"name":     "production-east",
-			"status":   "active",
-			"health":   "healthy",
-			"region":   "us-east-1",
-			"nodes":    5,
-			"lastSeen": time.Now().Add(-2 * time.Minute).Format(time.RFC3339),
-		},
-		{
-			"name":     "staging-west",
-			"status":   "active",
-			"health":   "healthy",
-			"region":   "us-west-2",
-			"nodes":    3,
-			"lastSeen": time.Now().Add(-1 * time.Minute).Format(time.RFC3339),
-		},
-		{
-			"name":     "development",
-			"status":   "active",
-			"health":   "warning",
-			"region":   "us-central-1",
-			"nodes":    2,
-			"lastSeen": time.Now().Add(-10 * time.Minute).Format(time.RFC3339),
-		},
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid limit: must be an integer between 1 and 1000", nil))
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid offset: must be a non-negative integer", nil))
+		return
+	}
+
+	selector, err := parseLabelSelector(c.Query("labelSelector"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid labelSelector", err.Error()))
+		return
+	}
+
+	sortBy := c.DefaultQuery("sort", "name")
+	if sortBy != "name" && sortBy != "onboardedAt" {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid sort: must be name or onboardedAt", nil))
+		return
+	}
+	order := c.DefaultQuery("order", "asc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid order: must be asc or desc", nil))
+		return
+	}
+
+	status := c.Query("status")
+	clusterType := c.Query("type")
+	hubName := c.Query("hub")
+
+	records := make([]ClusterRecord, 0)
+	for _, record := range cp.clusters.List() {
+		if status != "" && !strings.EqualFold(string(record.State), status) {
+			continue
+		}
+		if clusterType != "" && !strings.EqualFold(record.Type, clusterType) {
+			continue
+		}
+		if hubName != "" && cp.clusterHub(record.Name).Name != hubName {
+			continue
+		}
+		if !matchLabels(selector, record.Labels) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	// List is sorted by name; clusters that never finished onboarding sort last by onboardedAt
+	if sortBy == "onboardedAt" {
+		sort.SliceStable(records, func(i, j int) bool {
+			a, b := records[i].OnboardedAt, records[j].OnboardedAt
+			if a == nil || b == nil {
+				return a != nil
+			}
+			return a.Before(*b)
+		})
+	}
+	if order == "desc" {
+		slices.Reverse(records)
+	}
+
+	total := len(records)
+	start := min(offset, total)
+	end := min(start+limit, total)
+
+	clusters := make([]gin.H, 0, end-start)
+	for _, record := range records[start:end] {
+		cluster := gin.H{
+			"name":           record.Name,
+			"hub":            record.Hub,
+			"status":         record.State,
+			"message":        record.Message,
+			"type":           record.Type,
+			"labels":         record.Labels,
+			"allowedActions": allowedActions(record.State),
+			"updatedAt":      record.UpdatedAt.Format(time.RFC3339),
+			"onboardedAt":    nil,
+		}
+		if record.OnboardedAt != nil {
+			cluster["onboardedAt"] = record.OnboardedAt.Format(time.RFC3339)
+		}
+		if duration := record.onboardingDuration(); duration > 0 {
+			cluster["onboardingDuration"] = duration.String()
+			cluster["onboardingDurationSeconds"] = duration.Seconds()
+		}
+		clusters = append(clusters, cluster)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"clusters": clusters,
 		"count":    len(clusters),
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"hasMore":  end < total,
 		"plugin":   "cluster-ops-plugin",
 	})
 }
@@ -275,31 +976,83 @@ func (cp *ClusterOpsPlugin) ListClustersHandler(c *gin.Context) {
 func (cp *ClusterOpsPlugin) DetachClusterHandler(c *gin.Context) {
 	var requestBody map[string]interface{}
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid JSON payload",
-			"details": err.Error(),
-		})
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
 		return
 	}
 
-	clusterName := requestBody["clusterName"]
-	if clusterName == nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing required field: clusterName",
-		})
+	clusterName, _ := requestBody["clusterName"].(string)
+	if clusterName == "" {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Missing required field: clusterName", nil))
 		return
 	}
 
-	// Simulate cluster detachment
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Cluster detached successfully",
-		"clusterName": clusterName,
-		"status":      "detached",
-		"timestamp":   time.Now().Format(time.RFC3339),
-		"plugin":      "cluster-ops-plugin",
+	opts := detachOptions{}
+	opts.cleanup, _ = requestBody["cleanup"].(bool)
+	opts.force, _ = requestBody["force"].(bool)
+	if payload, _ := requestBody["kubeconfig"].(string); payload != "" {
+		kubeconfig, err := cp.prepareKubeconfig(c.Request.Context(), payload)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Unable to decode kubeconfig", err.Error()))
+			return
+		}
+		if _, err := newSpokeClient(kubeconfig, cp.spokeTLSOptions()); err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Invalid kubeconfig", err.Error()))
+			return
+		}
+		opts.kubeconfig = kubeconfig
+		opts.unjoin = true
+	}
+	hubName, _ := requestBody["hub"].(string)
+
+	op, rejected := cp.beginDetach(c.Request.Context(), c.GetHeader("traceparent"), clusterName, hubName, opts)
+	if rejected != nil {
+		c.JSON(rejected.status, rejected.body)
+		return
+	}
+	c.Set("operationId", op.ID)
+	hub := cp.clusterHub(clusterName)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":           "Cluster detachment started",
+		"clusterName":       clusterName,
+		"hub":               hub.Name,
+		"operationId":       op.ID,
+		"status":            StateDetaching,
+		"cleanup":           opts.cleanup,
+		"force":             opts.force,
+		"unjoin":            opts.unjoin,
+		"timestamp":         time.Now().Format(time.RFC3339),
+		"websocketEndpoint": fmt.Sprintf("%s/ws/%s", pluginAPIBase, clusterName),
+		"logsEndpoint":      fmt.Sprintf("%s/logs/%s", pluginAPIBase, clusterName),
+		"operationEndpoint": fmt.Sprintf("%s/operations/%s", pluginAPIBase, op.ID),
+		"plugin":            "cluster-ops-plugin",
 	})
 }
 
+// beginDetach checks that a cluster can be detached and starts its
+// detachment pipeline
+func (cp *ClusterOpsPlugin) beginDetach(ctx context.Context, traceparent, clusterName, hubName string, opts detachOptions) (Operation, *requestError) {
+	if _, ok := cp.clusters.Get(clusterName); !ok {
+		return Operation{}, &requestError{http.StatusNotFound, errorResponse(codeClusterNotFound, "Cluster not found", nil)}
+	}
+	hub := cp.clusterHub(clusterName)
+	if hubName != "" && hubName != hub.Name {
+		return Operation{}, &requestError{http.StatusConflict, errorResponse(codeConflict, fmt.Sprintf("Cluster %s is registered with hub %s, not %s", clusterName, hub.Name, hubName), nil)}
+	}
+	if err := cp.clusters.Transition(clusterName, StateDetaching, "Detachment requested"); err != nil {
+		return Operation{}, &requestError{http.StatusConflict, errorResponse(codeConflict, "Cluster cannot be detached in its current state", err.Error())}
+	}
+
+	steps := detachmentPlan(opts)
+	requestID := requestIDFrom(ctx)
+	runCtx, cancel := context.WithCancel(withRequestID(withHub(withRemoteParent(context.Background(), traceparent), hub), requestID))
+	op := cp.operations.Create("detach", clusterName, requestID, steps, cancel)
+	cp.schedule(runCtx, func() {
+		cp.runDetachment(runCtx, op.ID, clusterName, steps, opts)
+	})
+	return op, nil
+}
+
 func (cp *ClusterOpsPlugin) HealthCheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "healthy",
@@ -312,26 +1065,140 @@ func (cp *ClusterOpsPlugin) HealthCheckHandler(c *gin.Context) {
 func (cp *ClusterOpsPlugin) GetClusterEventsHandler(c *gin.Context) {
 	clusterName := c.Param("cluster")
 
-	// Mock events data
-	events := []map[string]interface{}{
-		{
-			"timestamp": time.Now().Add(-1 * time.Hour).Format(time.RFC3339),
-			"type":      "onboard",
-			"message":   fmt.Sprintf("Cluster %s onboarded successfully", clusterName),
-			"status":    "success",
-		},
-		{
-			"timestamp": time.Now().Add(-30 * time.Minute).Format(time.RFC3339),
-			"type":      "health_check",
-			"message":   fmt.Sprintf("Health check passed for cluster %s", clusterName),
-			"status":    "success",
-		},
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid limit: must be an integer between 1 and 1000", nil))
+			return
+		}
+		limit = parsed
+	}
+
+	level := c.Query("level")
+	if _, ok := levelSeverity[level]; level != "" && !ok {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid level: must be one of info, warn, error", nil))
+		return
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid since timestamp: expected RFC3339", err.Error()))
+			return
+		}
+		since = parsed
+	}
+
+	afterID, err := parseAfterID(c.Query("afterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid afterId", err.Error()))
+		return
+	}
+
+	events := filterEvents(eventsAfter(cp.events.List(clusterName), afterID), level, since)
+	if step := c.Query("step"); step != "" {
+		matching := make([]OnboardingEvent, 0, len(events))
+		for _, event := range events {
+			// Events recorded before events carried a step were typed
+			// with the name of the step raising them
+			if strings.EqualFold(event.Step, step) || (event.Step == "" && strings.EqualFold(event.Type, step)) {
+				matching = append(matching, event)
+			}
+		}
+		events = matching
+	}
+
+	// Limited pages hold the oldest matching events; passing lastId as the
+	// afterId of the next request resumes exactly after the page
+	hasMore := limit > 0 && len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	lastID := afterID
+	if len(events) > 0 {
+		lastID = events[len(events)-1].ID
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"clusterName": clusterName,
 		"events":      events,
 		"count":       len(events),
+		"hasMore":     hasMore,
+		"lastId":      lastID,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) GetClusterLogsHandler(c *gin.Context) {
+	clusterName := c.Param("cluster")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid limit: must be an integer between 1 and 1000", nil))
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid offset: must be a non-negative integer", nil))
+		return
+	}
+
+	level := c.Query("level")
+	if _, ok := levelSeverity[level]; level != "" && !ok {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid level: must be one of info, warn, error", nil))
+		return
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid since timestamp: expected RFC3339", err.Error()))
+			return
+		}
+	}
+
+	afterID, err := parseAfterID(c.Query("afterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid afterId", err.Error()))
+		return
+	}
+
+	events := filterEvents(eventsAfter(cp.events.List(clusterName), afterID), level, since)
+	total := len(events)
+
+	start := min(offset, total)
+	end := min(start+limit, total)
+	page := events[start:end]
+
+	logs := make([]gin.H, 0, len(page))
+	for _, event := range page {
+		log := gin.H{
+			"id":        event.ID,
+			"timestamp": event.Timestamp.Format(time.RFC3339),
+			"level":     eventLevel(event),
+			"type":      event.Type,
+			"step":      event.Step,
+			"status":    event.Status,
+			"message":   event.Message,
+		}
+		if event.Remediation != "" {
+			log["remediation"] = event.Remediation
+		}
+		logs = append(logs, log)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clusterName": clusterName,
+		"logs":        logs,
+		"count":       len(logs),
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"hasMore":     end < total,
 		"plugin":      "cluster-ops-plugin",
 	})
 }
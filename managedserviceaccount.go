@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// managedServiceAccountAddon issues spoke tokens projected to the hub
+	managedServiceAccountAddon = "managed-serviceaccount"
+	// managedServiceAccountName names the ManagedServiceAccount, its spoke
+	// ServiceAccount and the hub Secret holding its token
+	managedServiceAccountName = "cluster-ops-plugin"
+	// defaultManagedServiceAccountValidity is how long each issued token lives
+	defaultManagedServiceAccountValidity = 24 * time.Hour
+	defaultManagedServiceAccountRole     = "view"
+)
+
+// managedServiceAccountStep requests a rotating spoke token and the RBAC the
+// plugin needs on the spoke
+var managedServiceAccountStep = pipelineStep{"create-managed-serviceaccount", "ManagedServiceAccount created for spoke access", ""}
+
+// createManagedServiceAccount creates the ManagedServiceAccount of a cluster
+// and a ManifestWork binding its ServiceAccount on the spoke to
+// managed_serviceaccount_cluster_role plus read access to nodes
+func (cp *ClusterOpsPlugin) createManagedServiceAccount(ctx context.Context, clusterName string) error {
+	err := cp.applyHubObject(ctx, map[string]interface{}{
+		"apiVersion": "authentication.open-cluster-management.io/v1beta1",
+		"kind":       "ManagedServiceAccount",
+		"metadata": map[string]interface{}{
+			"name":      managedServiceAccountName,
+			"namespace": clusterName,
+		},
+		"spec": map[string]interface{}{
+			"rotation": map[string]interface{}{
+				"enabled":  true,
+				"validity": cp.settings().ManagedServiceAccountValidity.String(),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	subject := []map[string]string{{
+		"kind":      "ServiceAccount",
+		"name":      managedServiceAccountName,
+		"namespace": addonInstallNamespace,
+	}}
+	manifests := []map[string]interface{}{
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   map[string]string{"name": "cluster-ops-plugin:nodes"},
+			"rules": []map[string]interface{}{{
+				"apiGroups": []string{""},
+				"resources": []string{"nodes"},
+				"verbs":     []string{"get", "list", "watch"},
+			}},
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]string{"name": "cluster-ops-plugin:nodes"},
+			"roleRef":    map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "cluster-ops-plugin:nodes"},
+			"subjects":   subject,
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]string{"name": "cluster-ops-plugin"},
+			"roleRef": map[string]string{
+				"apiGroup": "rbac.authorization.k8s.io",
+				"kind":     "ClusterRole",
+				"name":     cp.settings().ManagedServiceAccountRole,
+			},
+			"subjects": subject,
+		},
+	}
+	return cp.applyHubObject(ctx, map[string]interface{}{
+		"apiVersion": "work.open-cluster-management.io/v1",
+		"kind":       "ManifestWork",
+		"metadata": map[string]interface{}{
+			"name":      managedServiceAccountName + "-rbac",
+			"namespace": clusterName,
+		},
+		"spec": map[string]interface{}{
+			"workload": map[string]interface{}{"manifests": manifests},
+		},
+	})
+}
+
+// managedServiceAccountClient builds a spoke client from the current token
+// of the cluster's ManagedServiceAccount and the API server address the
+// agent reported to the hub, so no long-lived kubeconfig is needed
+func (cp *ClusterOpsPlugin) managedServiceAccountClient(ctx context.Context, clusterName string) (*spokeClient, error) {
+	mc, err := cp.getManagedCluster(ctx, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if len(mc.Spec.ManagedClusterClientConfigs) == 0 || mc.Spec.ManagedClusterClientConfigs[0].URL == "" {
+		return nil, fmt.Errorf("ManagedCluster %s does not report an API server URL", clusterName)
+	}
+	clientConfig := mc.Spec.ManagedClusterClientConfigs[0]
+
+	out, err := cp.kubectlHub(ctx, "get", "secret", managedServiceAccountName, "-n", clusterName, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ManagedServiceAccount token is not available yet: %v", err)
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(out, &secret); err != nil {
+		return nil, fmt.Errorf("failed to decode ManagedServiceAccount Secret: %v", err)
+	}
+	token, err := base64.StdEncoding.DecodeString(secret.Data["token"])
+	if err != nil || len(token) == 0 {
+		return nil, fmt.Errorf("ManagedServiceAccount Secret of %s holds no token", clusterName)
+	}
+
+	caData := clientConfig.CABundle
+	if caData == "" {
+		caData = secret.Data["ca.crt"]
+	}
+	kubeconfig, err := synthesizeKubeconfig(clusterName, clientConfig.URL, caData, map[string]string{"token": string(token)})
+	if err != nil {
+		return nil, err
+	}
+	return newSpokeClient(kubeconfig, cp.spokeTLSOptions())
+}
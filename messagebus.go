@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBusSubject = "kubestellar.clusters"
+	busQueueSize      = 1024
+	busDialTimeout    = 10 * time.Second
+	busWriteTimeout   = 10 * time.Second
+)
+
+// busMessage is a record produced to the message bus. Key is the cluster
+// name, which Kafka uses for partitioning so a cluster's records stay ordered.
+type busMessage struct {
+	Key  string      `json:"-"`
+	Kind string      `json:"kind"`
+	Data interface{} `json:"data"`
+}
+
+// busProducer delivers a serialized record to a message bus
+type busProducer interface {
+	Produce(key string, payload []byte) error
+	Close()
+}
+
+// busPublisher queues records and produces them from a single goroutine so
+// publishing never blocks the caller. Records are dropped when the queue is
+// full or the bus cannot be reached.
+type busPublisher struct {
+	producer busProducer
+	queue    chan busMessage
+	done     chan struct{}
+	dropped  int
+	mutex    sync.Mutex
+}
+
+// newBusPublisher creates the publisher selected by message_bus, or returns
+// nil when no message bus is configured
+func newBusPublisher(settings PluginConfig) (*busPublisher, error) {
+	subject := settings.MessageBusTopic
+
+	var producer busProducer
+	switch settings.MessageBus {
+	case "nats":
+		parsed, err := url.Parse(settings.NATSURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats_url %q: %v", settings.NATSURL, err)
+		}
+		producer = &natsProducer{url: parsed, subject: subject}
+	case "kafka":
+		producer = &kafkaRESTProducer{
+			endpoint: strings.TrimSuffix(settings.KafkaRESTProxyURL, "/") + "/topics/" + url.PathEscape(subject),
+			client:   &http.Client{Timeout: busWriteTimeout},
+		}
+	default:
+		return nil, nil
+	}
+
+	p := &busPublisher{
+		producer: producer,
+		queue:    make(chan busMessage, busQueueSize),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Publish queues a record for the bus
+func (p *busPublisher) Publish(msg busMessage) {
+	if p == nil {
+		return
+	}
+	select {
+	case p.queue <- msg:
+	default:
+		p.drop()
+	}
+}
+
+// Dropped returns the number of records that could not be delivered
+func (p *busPublisher) Dropped() int {
+	if p == nil {
+		return 0
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.dropped
+}
+
+// Close delivers the queued records and disconnects from the bus
+func (p *busPublisher) Close() {
+	if p == nil {
+		return
+	}
+	close(p.queue)
+	<-p.done
+}
+
+func (p *busPublisher) run() {
+	defer close(p.done)
+	defer p.producer.Close()
+
+	for msg := range p.queue {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			p.drop()
+			continue
+		}
+		if err := p.producer.Produce(msg.Key, payload); err != nil {
+			p.drop()
+		}
+	}
+}
+
+func (p *busPublisher) drop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dropped++
+}
+
+// natsProducer publishes over the NATS client protocol, connecting lazily and
+// reconnecting after a failed publish
+type natsProducer struct {
+	url     *url.URL
+	subject string
+	conn    net.Conn
+}
+
+func (n *natsProducer) connect() error {
+	conn, err := net.DialTimeout("tcp", n.url.Host, busDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	// The server greets with INFO before accepting CONNECT
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(busDialTimeout))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting: %q", strings.TrimSpace(line))
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "cluster-ops-plugin"}
+	if n.url.User != nil {
+		options["user"] = n.url.User.Username()
+		options["pass"], _ = n.url.User.Password()
+	}
+	connect, _ := json.Marshal(options)
+	conn.SetWriteDeadline(time.Now().Add(busWriteTimeout))
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// Answer server keepalives for as long as the connection is open
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PING") {
+				fmt.Fprint(conn, "PONG\r\n")
+			}
+		}
+	}()
+
+	n.conn = conn
+	return nil
+}
+
+func (n *natsProducer) Produce(_ string, payload []byte) error {
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+
+	n.conn.SetWriteDeadline(time.Now().Add(busWriteTimeout))
+	if _, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", n.subject, len(payload), payload); err != nil {
+		n.Close()
+		return err
+	}
+	return nil
+}
+
+func (n *natsProducer) Close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+// kafkaRESTProducer produces to a Kafka topic through a Kafka REST Proxy
+// (v2 API), which avoids speaking the Kafka wire protocol from the plugin
+type kafkaRESTProducer struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (k *kafkaRESTProducer) Produce(key string, payload []byte) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []interface{}{
+			map[string]interface{}{"key": key, "value": json.RawMessage(payload)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Post(k.endpoint, "application/vnd.kafka.json.v2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Kafka REST proxy responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (k *kafkaRESTProducer) Close() {}
+
+// publishToBus queues a record for the configured message bus, if any
+func (cp *ClusterOpsPlugin) publishToBus(kind, clusterName string, data interface{}) {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	cp.bus.Publish(busMessage{Key: clusterName, Kind: kind, Data: data})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notification categories that notify_on can select
+const (
+	notifyStarted     = "started"
+	notifySucceeded   = "succeeded"
+	notifyFailed      = "failed"
+	notifyCancelled   = "cancelled"
+	notifyUnavailable = "unavailable"
+)
+
+var defaultNotifyOn = []string{notifyFailed, notifySucceeded}
+
+const chatNotificationTimeout = 10 * time.Second
+
+// notificationCategory classifies a lifecycle event for chat notifications,
+// returning "" for events that are never announced
+func notificationCategory(event LifecycleEvent) string {
+	switch event.Type {
+	case lifecycleStateChanged:
+		switch event.State {
+		case StatePending, StateDetaching:
+			return notifyStarted
+		case StateUnavailable:
+			return notifyUnavailable
+		}
+	case lifecycleOperationCompleted:
+		switch event.Operation.Status {
+		case OperationSucceeded:
+			return notifySucceeded
+		case OperationFailed:
+			return notifyFailed
+		case OperationCancelled:
+			return notifyCancelled
+		}
+	}
+	return ""
+}
+
+// notificationText renders the one-line summary posted to chat
+func notificationText(category string, event LifecycleEvent) (title, text string) {
+	switch {
+	case event.Operation != nil:
+		title = fmt.Sprintf("Cluster %s: %s %s", event.ClusterName, event.Operation.Type, category)
+		text = fmt.Sprintf("%s (operation %s)", event.Message, event.Operation.ID)
+	default:
+		title = fmt.Sprintf("Cluster %s is %s", event.ClusterName, event.State)
+		text = event.Message
+	}
+	return title, text
+}
+
+// notifyChat posts lifecycle events selected by notify_on to the Slack and
+// Microsoft Teams incoming webhooks that are configured
+func (cp *ClusterOpsPlugin) notifyChat(event LifecycleEvent) {
+	settings := cp.settings()
+	slackURL := settings.SlackWebhookURL
+	teamsURL := settings.TeamsWebhookURL
+	if slackURL == "" && teamsURL == "" {
+		return
+	}
+
+	category := notificationCategory(event)
+	if category == "" || !containsFold(settings.NotifyOn, category) {
+		return
+	}
+	title, text := notificationText(category, event)
+
+	color := "2EB67D"
+	switch category {
+	case notifyFailed, notifyUnavailable:
+		color = "E01E5A"
+	case notifyCancelled:
+		color = "ECB22E"
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), chatNotificationTimeout)
+		defer cancel()
+
+		if slackURL != "" {
+			payload := map[string]interface{}{
+				"text": title,
+				"attachments": []interface{}{
+					map[string]interface{}{"color": "#" + color, "text": text},
+				},
+			}
+			if err := postJSON(ctx, slackURL, payload); err != nil {
+				cp.logger.Warn("Failed to send Slack notification", "cluster", event.ClusterName, "error", err)
+			}
+		}
+		if teamsURL != "" {
+			payload := map[string]interface{}{
+				"@type":      "MessageCard",
+				"@context":   "http://schema.org/extensions",
+				"themeColor": color,
+				"summary":    title,
+				"title":      title,
+				"text":       text,
+			}
+			if err := postJSON(ctx, teamsURL, payload); err != nil {
+				cp.logger.Warn("Failed to send Teams notification", "cluster", event.ClusterName, "error", err)
+			}
+		}
+	}()
+}
+
+// postJSON posts a JSON payload and fails on non-2xx responses
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	return postJSONWithHeaders(ctx, url, payload, nil)
+}
+
+// postJSONWithHeaders posts a JSON payload with extra request headers
+func postJSONWithHeaders(ctx context.Context, url string, payload interface{}, headers http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
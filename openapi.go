@@ -0,0 +1,337 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// swaggerUIAssets is where the Swagger UI page loads its scripts and styles
+const swaggerUIAssets = "https://unpkg.com/swagger-ui-dist@5"
+
+// openAPIRequests maps handlers to the JSON body they bind
+var openAPIRequests = map[string]interface{}{
+	"OnboardClusterHandler":          ClusterOnboardRequest{},
+	"BatchOnboardHandler":            BatchOnboardRequest{},
+	"DetachClusterHandler":           openAPIDetachRequest{},
+	"BatchDetachHandler":             BatchDetachRequest{},
+	"RegisterClusterHandler":         RegisterClusterRequest{},
+	"ApplyFleetHandler":              FleetSpec{},
+	"DiffFleetHandler":               FleetSpec{},
+	"RotateClusterKubeconfigHandler": KubeconfigRotateRequest{},
+	"PatchClusterLabelsHandler":      LabelPatchRequest{},
+	"EnableClusterAddonsHandler":     AddonsRequest{},
+	"SetClusterTaintHandler":         clusterTaint{},
+	"PreflightHandler":               PreflightRequest{},
+	"CreateWebhookHandler":           WebhookRequest{},
+	"PrecreateClusterHandler":        PrecreateClusterRequest{},
+	"CreateRegistrationHandler":      RegistrationRequest{},
+	"RedeemRegistrationHandler":      RegistrationExchangeRequest{},
+	"UpdateConfigHandler":            map[string]interface{}{},
+	"GraphQLHandler":                 graphQLRequest{},
+}
+
+// openAPIDetachRequest documents the body DetachClusterHandler reads
+// field by field
+type openAPIDetachRequest struct {
+	ClusterName string `json:"clusterName" binding:"required"`
+	Hub         string `json:"hub,omitempty"`
+	Cleanup     bool   `json:"cleanup,omitempty"`
+	Force       bool   `json:"force,omitempty"`
+	Kubeconfig  string `json:"kubeconfig,omitempty"`
+}
+
+// openAPIResponses lists the typed fields of the success response of
+// handlers; every response also carries the plugin name
+var openAPIResponses = map[string]map[string]interface{}{
+	"GetBatchHandler":              {"batch": Batch{}},
+	"DeepHealthCheckHandler":       {"status": "", "initialized": false, "uptime": "", "dependencies": []DependencyHealth{}},
+	"SelfTestHandler":              {"report": SelfTestReport{}},
+	"VersionHandler":               {"version": "", "gitCommit": "", "buildDate": "", "goVersion": "", "platform": "", "kubectl": "", "clusteradm": ""},
+	"ListOperationsHandler":        {"operations": []Operation{}, "count": 0},
+	"GetOperationHandler":          {"operation": Operation{}},
+	"GetClusterEventsHandler":      {"clusterName": "", "events": []OnboardingEvent{}, "count": 0, "hasMore": false, "lastId": int64(0)},
+	"GetClusterLogsHandler":        {"clusterName": "", "logs": []OnboardingEvent{}, "count": 0},
+	"ListAuditHandler":             {"entries": []AuditEntry{}, "count": 0, "total": 0, "limit": 0, "offset": 0, "hasMore": false},
+	"ListWebhooksHandler":          {"webhooks": []Webhook{}, "count": 0},
+	"CreateWebhookHandler":         {"webhook": Webhook{}},
+	"ListWebhookDeliveriesHandler": {"webhookId": "", "deliveries": []WebhookDelivery{}, "count": 0},
+	"ListAPIKeysHandler":           {"apiKeys": []APIKeyUsage{}, "count": 0},
+	"ListRegistrationsHandler":     {"registrations": []Registration{}, "count": 0},
+	"GetClusterAddonsHandler":      {"clusterName": "", "addons": []AddonStatus{}, "count": 0},
+	"PreflightHandler":             {"report": PreflightReport{}},
+	"DiffFleetHandler":             {"plan": FleetPlan{}},
+	"ListClusterNodesHandler":      {"clusterName": "", "nodes": []NodeSummary{}, "count": 0, "ready": 0, "clusterProxy": false},
+	"GetClusterKubeconfigHandler":  {"kubeconfig": StoredKubeconfig{}},
+}
+
+// openAPIQueries lists the query parameters handlers read
+var openAPIQueries = map[string][]string{
+	"ListClustersHandler":        {"limit", "offset", "labelSelector", "sort", "order", "status", "type"},
+	"ListOperationsHandler":      {"cluster", "status"},
+	"GetClusterStatusHandler":    {"wait", "timeout"},
+	"GetClusterEventsHandler":    {"since", "level", "step", "limit", "afterId"},
+	"GetClusterLogsHandler":      {"limit", "offset", "level", "since", "afterId"},
+	"StreamClusterEventsHandler": {"afterId"},
+	"ListAuditHandler":           {"limit", "offset", "cluster", "actor", "since", "until"},
+	"GetJoinManifestHandler":     {"singleton"},
+	"ListHubsHandler":            {"refresh"},
+	"GraphQLHandler":             {"query", "operationName", "variables"},
+}
+
+var openAPIPathParam = regexp.MustCompile(`[:*](\w+)`)
+
+// openAPISpec builds an OpenAPI 3.0 document from the plugin metadata.
+// Paths come from the declared endpoints, so a new endpoint is documented
+// as soon as it is declared; request and response schemas are derived
+// from the Go types of openAPIRequests and openAPIResponses.
+func (cp *ClusterOpsPlugin) openAPISpec() map[string]interface{} {
+	metadata := cp.GetMetadata()
+	errorDescription, errorCodes := errorCatalogDescription()
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":        "object",
+			"description": errorDescription,
+			"required":    []string{"code", "message", "retryable"},
+			"properties": map[string]interface{}{
+				"code":      map[string]interface{}{"type": "string", "enum": errorCodes},
+				"message":   map[string]interface{}{"type": "string"},
+				"details":   map[string]interface{}{},
+				"retryable": map[string]interface{}{"type": "boolean"},
+				"error": map[string]interface{}{
+					"type":        "string",
+					"description": "Same as message; kept for older clients",
+					"deprecated":  true,
+				},
+			},
+		},
+	}
+	builder := &openAPISchemas{schemas: schemas}
+
+	paths := make(map[string]interface{})
+	for _, endpoint := range metadata.Endpoints {
+		if endpoint.Method == "OPTIONS" {
+			continue
+		}
+		path := openAPIPathParam.ReplaceAllString(endpoint.Path, "{$1}")
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+
+		var parameters []interface{}
+		for _, match := range openAPIPathParam.FindAllStringSubmatch(endpoint.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		queries := openAPIQueries[endpoint.Handler]
+		if !publicHandlers[endpoint.Handler] {
+			// withRequestHub selects the hub of every authenticated request
+			queries = append(queries, "hub")
+		}
+		for _, name := range queries {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		success := map[string]interface{}{
+			"plugin": map[string]interface{}{"type": "string"},
+		}
+		for field, value := range openAPIResponses[endpoint.Handler] {
+			success[field] = builder.schema(reflect.TypeOf(value))
+		}
+		operation := map[string]interface{}{
+			"operationId": strings.TrimSuffix(endpoint.Handler, "Handler"),
+			"summary":     endpoint.Description,
+			"tags":        []string{openAPITag(endpoint.Path)},
+			"responses": map[string]interface{}{
+				"2XX": map[string]interface{}{
+					"description": "Success",
+					"content": map[string]interface{}{"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "object", "properties": success},
+					}},
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+					}},
+				},
+			},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if body, ok := openAPIRequests[endpoint.Handler]; ok {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{"application/json": map[string]interface{}{
+					"schema": builder.schema(reflect.TypeOf(body)),
+				}},
+			}
+		}
+		if publicHandlers[endpoint.Handler] {
+			operation["security"] = []interface{}{}
+		} else {
+			operation["x-required-permission"] = requiredPermission(endpoint.Handler)
+		}
+		item[strings.ToLower(endpoint.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       metadata.Name,
+			"version":     metadata.Version,
+			"description": metadata.Description,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": pluginAPIBase}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		},
+	}
+}
+
+// openAPITag groups endpoints by the first segment of their path
+func openAPITag(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return segment
+}
+
+// openAPISchemas derives JSON schemas from Go types, registering named
+// structs as components
+type openAPISchemas struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return b.object(t)
+		}
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if _, ok := b.schemas[name]; !ok {
+			// Register first so recursive types terminate
+			b.schemas[name] = map[string]interface{}{}
+			b.schemas[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	}
+	// interface{} and anything else accepts any JSON value
+	return map[string]interface{}{}
+}
+
+// object describes the exported fields of a struct as JSON encodes them.
+// Fields with binding:"required" are required.
+func (b *openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := b.object(field.Type)
+			for key, value := range embedded["properties"].(map[string]interface{}) {
+				properties[key] = value
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			required = append(required, name)
+		}
+	}
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+func (cp *ClusterOpsPlugin) OpenAPISpecHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, cp.openAPISpec())
+}
+
+func (cp *ClusterOpsPlugin) SwaggerUIHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.String(http.StatusOK, swaggerUIPage)
+}
+
+// swaggerUIPage renders Swagger UI for the spec served next to it
+var swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>KubeStellar Cluster Operations API</title>
+  <link rel="stylesheet" href="` + swaggerUIAssets + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + swaggerUIAssets + `/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({
+      url: "` + pluginAPIBase + `/openapi.json",
+      dom_id: "#swagger-ui",
+      deepLinking: true,
+      persistAuthorization: true
+    });
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operation and step statuses
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+	OperationCancelled = "cancelled"
+)
+
+// OperationStep tracks the progress of a single step of an operation
+type OperationStep struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Message     string     `json:"message,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// Operation is an asynchronous onboarding or detachment job
+type Operation struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	ClusterName string          `json:"clusterName"`
+	Status      string          `json:"status"`
+	Steps       []OperationStep `json:"steps"`
+	Result      string          `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	ErrorCode   string          `json:"errorCode,omitempty"`
+	// Remediation suggests how to fix a known cause of the failure
+	Remediation string `json:"remediation,omitempty"`
+	// RequestID is the X-Request-ID of the request that started the operation
+	RequestID   string     `json:"requestId,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// operationStore keeps every operation started by the plugin, including
+// finished ones, so callers can poll for terminal results
+type operationStore struct {
+	operations map[string]*Operation
+	cancels    map[string]context.CancelFunc
+	// onFinish is called with the store locked once an operation reaches a
+	// terminal status, so it must not block or use the store
+	onFinish func(op Operation)
+	// onChange is called with the store locked whenever an operation is
+	// created or updated, so it must not block or use the store
+	onChange func(id string)
+	mutex    sync.RWMutex
+}
+
+func newOperationStore() *operationStore {
+	return &operationStore{
+		operations: make(map[string]*Operation),
+		cancels:    make(map[string]context.CancelFunc),
+	}
+}
+
+var errOperationNotFound = fmt.Errorf("operation not found")
+
+// newOperationID returns a random identifier for an operation
+func newOperationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "op-" + hex.EncodeToString(b)
+}
+
+// Create registers a pending operation with the given step names. cancel is
+// invoked when the operation is cancelled and released once it finishes.
+func (s *operationStore) Create(opType, clusterName, requestID string, steps []pipelineStep, cancel context.CancelFunc) Operation {
+	op := &Operation{
+		ID:          newOperationID(),
+		Type:        opType,
+		ClusterName: clusterName,
+		RequestID:   requestID,
+		Status:      OperationPending,
+		Steps:       make([]OperationStep, len(steps)),
+		CreatedAt:   time.Now(),
+	}
+	for i, step := range steps {
+		op.Steps[i] = OperationStep{Name: step.name, Status: OperationPending}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.operations[op.ID] = op
+	s.cancels[op.ID] = cancel
+	s.changed(op.ID)
+	return op.snapshot()
+}
+
+// Get returns a copy of an operation
+func (s *operationStore) Get(id string) (Operation, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	op, ok := s.operations[id]
+	if !ok {
+		return Operation{}, false
+	}
+	return op.snapshot(), true
+}
+
+// List returns copies of all operations, oldest first
+func (s *operationStore) List() []Operation {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ops := make([]Operation, 0, len(s.operations))
+	for _, op := range s.operations {
+		ops = append(ops, op.snapshot())
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].CreatedAt.Before(ops[j].CreatedAt)
+	})
+	return ops
+}
+
+// CountActive returns the number of operations that have not finished yet
+func (s *operationStore) CountActive() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	active := 0
+	for _, op := range s.operations {
+		if !op.isTerminal() {
+			active++
+		}
+	}
+	return active
+}
+
+// Start marks an operation as running
+func (s *operationStore) Start(id string) {
+	s.update(id, func(op *Operation) {
+		now := time.Now()
+		op.Status = OperationRunning
+		op.StartedAt = &now
+	})
+}
+
+// StartStep marks a step of an operation as running
+func (s *operationStore) StartStep(id, stepName string) {
+	s.update(id, func(op *Operation) {
+		if step := op.step(stepName); step != nil {
+			now := time.Now()
+			step.Status = OperationRunning
+			step.StartedAt = &now
+		}
+	})
+}
+
+// CompleteStep marks a step of an operation as succeeded
+func (s *operationStore) CompleteStep(id, stepName, message string) {
+	s.finishStep(id, stepName, OperationSucceeded, message)
+}
+
+// FailStep marks a step of an operation as failed
+func (s *operationStore) FailStep(id, stepName, message string) {
+	s.finishStep(id, stepName, OperationFailed, message)
+}
+
+func (s *operationStore) finishStep(id, stepName, status, message string) {
+	s.update(id, func(op *Operation) {
+		if step := op.step(stepName); step != nil {
+			now := time.Now()
+			step.Status = status
+			step.Message = message
+			step.CompletedAt = &now
+		}
+	})
+}
+
+// Succeed records the terminal successful result of an operation
+func (s *operationStore) Succeed(id, result string) {
+	s.finish(id, func(op *Operation) {
+		op.Status = OperationSucceeded
+		op.Result = result
+	})
+}
+
+// Fail records the terminal error of an operation and its error code
+func (s *operationStore) Fail(id, code, errMessage string) {
+	s.FailWithRemediation(id, code, errMessage, "")
+}
+
+// FailWithRemediation is Fail for failures with a known remedy
+func (s *operationStore) FailWithRemediation(id, code, errMessage, remediation string) {
+	s.finish(id, func(op *Operation) {
+		op.Status = OperationFailed
+		op.Error = errMessage
+		op.ErrorCode = code
+		op.Remediation = remediation
+	})
+}
+
+// MarkCancelled records that an operation stopped because it was cancelled
+func (s *operationStore) MarkCancelled(id, message string) {
+	s.finish(id, func(op *Operation) {
+		op.Status = OperationCancelled
+		op.Error = message
+		op.ErrorCode = codeOperationCancelled
+	})
+}
+
+// Cancel requests cancellation of a pending or running operation
+func (s *operationStore) Cancel(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	op, ok := s.operations[id]
+	if !ok {
+		return errOperationNotFound
+	}
+	cancel, ok := s.cancels[id]
+	if op.isTerminal() {
+		return fmt.Errorf("operation %s already %s", id, op.Status)
+	}
+	if !ok {
+		return fmt.Errorf("operation %s runs on another replica", id)
+	}
+	cancel()
+	return nil
+}
+
+// finish applies a terminal update and releases the operation's context
+func (s *operationStore) finish(id string, fn func(op *Operation)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	op, ok := s.operations[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	fn(op)
+	op.CompletedAt = &now
+
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+	if s.onFinish != nil {
+		s.onFinish(op.snapshot())
+	}
+	s.changed(id)
+}
+
+func (s *operationStore) update(id string, fn func(op *Operation)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if op, ok := s.operations[id]; ok {
+		fn(op)
+		s.changed(id)
+	}
+}
+
+// Restore stores an operation received from another replica. It cannot be
+// cancelled here, and onChange is not called.
+func (s *operationStore) Restore(op Operation) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.operations[op.ID] = &op
+}
+
+func (s *operationStore) changed(id string) {
+	if s.onChange != nil {
+		s.onChange(id)
+	}
+}
+
+func (op *Operation) isTerminal() bool {
+	switch op.Status {
+	case OperationSucceeded, OperationFailed, OperationCancelled:
+		return true
+	}
+	return false
+}
+
+func (op *Operation) step(name string) *OperationStep {
+	for i := range op.Steps {
+		if op.Steps[i].Name == name {
+			return &op.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (op *Operation) snapshot() Operation {
+	copied := *op
+	copied.Steps = make([]OperationStep, len(op.Steps))
+	copy(copied.Steps, op.Steps)
+	return copied
+}
+
+func (cp *ClusterOpsPlugin) ListOperationsHandler(c *gin.Context) {
+	clusterName := c.Query("cluster")
+	status := c.Query("status")
+
+	operations := make([]Operation, 0)
+	for _, op := range cp.operations.List() {
+		if clusterName != "" && op.ClusterName != clusterName {
+			continue
+		}
+		if status != "" && op.Status != status {
+			continue
+		}
+		operations = append(operations, op)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"operations": operations,
+		"count":      len(operations),
+		"plugin":     "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) GetOperationHandler(c *gin.Context) {
+	op, ok := cp.operations.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse(codeOperationNotFound, "Operation not found", nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"operation": op,
+		"plugin":    "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) CancelOperationHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := cp.operations.Cancel(id); err != nil {
+		status, code := http.StatusConflict, codeConflict
+		if err == errOperationNotFound {
+			status, code = http.StatusNotFound, codeOperationNotFound
+		}
+		c.JSON(status, errorResponse(code, "Unable to cancel operation", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "Operation cancellation requested",
+		"operationId": id,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
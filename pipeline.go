@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stepTimeout bounds the duration of a single pipeline step unless timeout
+// is configured
+const stepTimeout = 60 * time.Second
+
+// defaultOnboardConcurrency is how many onboardings and detachments run at
+// once unless onboard_concurrency says otherwise
+const defaultOnboardConcurrency = 5
+
+// pipelineStep is a single named step of an onboarding or detachment pipeline
+type pipelineStep struct {
+	name    string
+	message string
+	// state is entered when the step starts; empty leaves the state unchanged
+	state ClusterState
+}
+
+var onboardingSteps = []pipelineStep{
+	{"validate", "Cluster connectivity validated", ""},
+	{"join", "Join command executed on cluster", StateJoining},
+	{"csr", "Certificate signing request approved", StateAwaitingCSR},
+	{"verify", "Cluster verified as managed by the hub", StateVerifying},
+}
+
+// metadataStep applies the labels and annotations requested at onboarding
+var metadataStep = pipelineStep{"apply-metadata", "Labels and annotations applied to the ManagedCluster", ""}
+
+// addonsStep enables the addons requested at onboarding
+var addonsStep = pipelineStep{"enable-addons", "Requested addons enabled on the cluster", ""}
+
+// onboardOptions controls the optional behaviour of an onboarding
+type onboardOptions struct {
+	// completed holds the steps finished by a previous attempt, which are skipped
+	completed   map[string]bool
+	kubeconfig  string
+	labels      map[string]string
+	annotations map[string]string
+	addons      []string
+	klusterlet  *KlusterletOptions
+	// manualApproval pauses the onboarding until an operator approves it
+	manualApproval bool
+	// managedServiceAccount replaces the stored kubeconfig with the token of
+	// a ManagedServiceAccount once the cluster has joined
+	managedServiceAccount bool
+}
+
+// joinFlags returns the extra clusteradm join flags of an onboarding with
+// credentials redacted, for display
+func (opts onboardOptions) joinFlags() []string {
+	if opts.klusterlet == nil {
+		return nil
+	}
+	return opts.klusterlet.redacted().joinFlags()
+}
+
+// onboardingPlan returns the steps of an onboarding with the given options
+func onboardingPlan(opts onboardOptions) []pipelineStep {
+	steps := []pipelineStep{onboardingSteps[0]}
+	if !opts.managedServiceAccount {
+		steps = append(steps, storeKubeconfigStep)
+	}
+	if opts.klusterlet != nil && opts.klusterlet.ImagePullSecret != "" {
+		steps = append(steps, pullSecretStep)
+	}
+	steps = append(steps, onboardingSteps[1])
+	if opts.manualApproval {
+		steps = append(steps, approvalStep)
+	}
+	steps = append(steps, onboardingSteps[2])
+	if opts.klusterlet != nil && opts.klusterlet.needsPatch() {
+		steps = append(steps, configureKlusterletStep)
+	}
+	steps = append(steps, onboardingSteps[3:]...)
+	if len(opts.labels) > 0 || len(opts.annotations) > 0 {
+		steps = append(steps, metadataStep)
+	}
+	if len(opts.addons) > 0 {
+		steps = append(steps, addonsStep)
+	}
+	if opts.managedServiceAccount {
+		steps = append(steps, managedServiceAccountStep)
+	}
+	return steps
+}
+
+var detachmentSteps = []pipelineStep{
+	{"remove", "ManagedCluster removed from the hub", ""},
+}
+
+// cleanupSteps remove the hub-side resources left behind by a cluster
+var cleanupSteps = []pipelineStep{
+	{"cleanup-manifestworks", "Remaining ManifestWorks deleted from the cluster namespace", ""},
+	{"cleanup-addons", "ManagedClusterAddOn resources deleted", ""},
+	{"cleanup-csrs", "Certificate signing requests of the cluster deleted", ""},
+	{"cleanup-namespace", "Cluster namespace deleted from the hub", ""},
+}
+
+// forceSteps clear a ManagedCluster stuck in Terminating and the namespace it
+// leaves behind
+var forceSteps = []pipelineStep{
+	{"remove-finalizers", "Finalizers removed from the terminating ManagedCluster", ""},
+	{"cleanup-namespace", "Orphaned cluster namespace deleted from the hub", ""},
+}
+
+// unjoinSteps remove the klusterlet agent from the spoke itself
+var unjoinSteps = []pipelineStep{
+	{"unjoin", "Klusterlet agent removed from the spoke", ""},
+	{"verify-unjoin", "open-cluster-management-agent namespace is gone from the spoke", ""},
+}
+
+// detachOptions controls the optional steps of a detachment
+type detachOptions struct {
+	cleanup bool
+	force   bool
+	// unjoin is set when a spoke kubeconfig is available to remove the agent
+	unjoin     bool
+	kubeconfig string
+}
+
+// detachmentPlan returns the steps of a detachment with the given options
+func detachmentPlan(opts detachOptions) []pipelineStep {
+	var steps []pipelineStep
+	if opts.unjoin {
+		steps = append(steps, unjoinSteps...)
+	}
+	steps = append(steps, detachmentSteps...)
+	if opts.force {
+		steps = append(steps, forceSteps[0])
+	}
+	if opts.cleanup {
+		steps = append(steps, cleanupSteps...)
+	} else if opts.force {
+		steps = append(steps, forceSteps[1])
+	}
+	return steps
+}
+
+// stepOptions controls how runSteps executes a pipeline
+type stepOptions struct {
+	// force continues past failed steps
+	force bool
+	// completed holds the steps finished by a previous attempt, which are skipped
+	completed map[string]bool
+	// actions perform steps by name; every step run needs one
+	actions map[string]func(ctx context.Context) error
+	// timeouts override the step timeout for steps that wait on other components
+	timeouts map[string]time.Duration
+}
+
+// schedule runs an onboarding or detachment once one of the
+// onboard_concurrency worker slots is free, so bulk requests never run more
+// clusteradm and kubectl processes at once. Operations wait in Pending until
+// then; one cancelled while waiting still runs and stops at its first step.
+func (cp *ClusterOpsPlugin) schedule(ctx context.Context, run func()) {
+	workers := cp.workerSlots()
+	go func() {
+		select {
+		case workers <- struct{}{}:
+			defer func() { <-workers }()
+		case <-ctx.Done():
+		}
+		run()
+	}()
+}
+
+// workerSlots returns the worker pool, sized by onboard_concurrency. When a
+// reload changes the size a new pool is started; operations already queued
+// on the old one still run there.
+func (cp *ClusterOpsPlugin) workerSlots() chan struct{} {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if cp.workers == nil {
+		cp.workers = make(chan struct{}, max(cp.pluginConfig.OnboardConcurrency, 1))
+	}
+	return cp.workers
+}
+
+// runOnboarding walks a cluster through the onboarding steps with the
+// kubeconfig written to its operation directory, recording progress on the
+// operation and logging an event for each step. Steps completed by a
+// previous attempt are skipped when resuming.
+func (cp *ClusterOpsPlugin) runOnboarding(ctx context.Context, operationID, clusterName string, steps []pipelineStep, opts onboardOptions) {
+	ctx, span := cp.getTracer().startSpan(ctx, "onboard", spanKindInternal)
+	span.SetAttribute("cluster.name", clusterName)
+	span.SetAttribute("operation.id", operationID)
+
+	cp.operations.Start(operationID)
+	cp.logOperationEvent(operationID, clusterName, "onboard", "started", fmt.Sprintf("Starting onboarding of cluster %s", clusterName))
+
+	if flags := opts.joinFlags(); len(flags) > 0 {
+		cp.logStepEvent(operationID, clusterName, "join", "info", "Joining with clusteradm flags: "+strings.Join(flags, " "), 0)
+	}
+	if opts.klusterlet != nil && opts.klusterlet.Proxy != nil {
+		cp.logStepEvent(operationID, clusterName, "join", "info", "Joining through proxy with environment "+strings.Join(opts.klusterlet.redacted().joinEnv(), " "), 0)
+	}
+	if opts.klusterlet != nil && opts.klusterlet.ImagePullSecret != "" {
+		cp.logStepEvent(operationID, clusterName, pullSecretStep.name, "info", fmt.Sprintf("Image pull secret %s will be created in namespace %s", imagePullSecretName, klusterletOperatorNamespace), 0)
+	}
+	if opts.klusterlet != nil && opts.klusterlet.needsPatch() {
+		patch, _ := json.Marshal(opts.klusterlet.klusterletPatch())
+		cp.logStepEvent(operationID, clusterName, configureKlusterletStep.name, "info", "Klusterlet will be patched with "+string(patch), 0)
+	}
+
+	workDir, err := cp.createOperationDir(operationID, opts.kubeconfig)
+	if err != nil {
+		cp.abortOperation(ctx, operationID, clusterName, "onboard", StateFailed, fmt.Errorf("failed to create working directory: %w", err))
+		span.End(err)
+		return
+	}
+	spokeKubeconfig := filepath.Join(workDir, spokeKubeconfigFile)
+
+	actions := map[string]func(ctx context.Context) error{
+		"validate": func(ctx context.Context) error {
+			return cp.validateSpoke(ctx, operationID, clusterName, spokeKubeconfig)
+		},
+		storeKubeconfigStep.name: func(ctx context.Context) error {
+			return cp.storeKubeconfig(ctx, clusterName, opts.kubeconfig)
+		},
+		"join": func(ctx context.Context) error {
+			return cp.joinStep(ctx, operationID, clusterName, workDir, opts.klusterlet)
+		},
+		pullSecretStep.name: func(ctx context.Context) error {
+			return cp.createPullSecret(ctx, spokeKubeconfig, opts.klusterlet.ImagePullSecret)
+		},
+		configureKlusterletStep.name: func(ctx context.Context) error {
+			return cp.configureKlusterlet(ctx, spokeKubeconfig, opts.klusterlet)
+		},
+		"verify": func(ctx context.Context) error {
+			return cp.verifyManagedCluster(ctx, clusterName)
+		},
+		metadataStep.name: func(ctx context.Context) error {
+			_, err := cp.patchManagedCluster(ctx, clusterName, map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": opts.labels, "annotations": opts.annotations},
+			})
+			return err
+		},
+		addonsStep.name: func(ctx context.Context) error {
+			for _, addon := range opts.addons {
+				if err := cp.enableAddon(ctx, clusterName, addon); err != nil {
+					return fmt.Errorf("failed to enable addon %s: %w", addon, err)
+				}
+				cp.logStepEvent(operationID, clusterName, addonsStep.name, "info", fmt.Sprintf("ManagedClusterAddOn %s created", supportedAddons[addon]), 0)
+			}
+			return nil
+		},
+		managedServiceAccountStep.name: func(ctx context.Context) error {
+			return cp.createManagedServiceAccount(ctx, clusterName)
+		},
+	}
+	timeouts := map[string]time.Duration{}
+	if opts.manualApproval {
+		actions[approvalStep.name] = func(ctx context.Context) error {
+			return cp.awaitApproval(ctx, operationID, clusterName)
+		}
+		timeouts[approvalStep.name] = cp.settings().ApprovalTimeout
+	}
+	actions["csr"] = func(ctx context.Context) error {
+		return cp.acceptCluster(ctx, clusterName)
+	}
+	timeouts["csr"] = cp.csrTimeout()
+	if err := cp.runSteps(ctx, operationID, clusterName, steps, stepOptions{completed: opts.completed, actions: actions, timeouts: timeouts}); err != nil {
+		cp.abortOperation(ctx, operationID, clusterName, "onboard", StateFailed, err)
+		cp.releaseOperationDir(workDir, operationID, true)
+		span.End(err)
+		return
+	}
+
+	if len(opts.labels) > 0 || len(opts.annotations) > 0 {
+		cp.clusters.Update(clusterName, func(record *ClusterRecord) {
+			record.Labels = mergeStringMaps(record.Labels, opts.labels)
+			record.Annotations = mergeStringMaps(record.Annotations, opts.annotations)
+		})
+	}
+	if len(opts.addons) > 0 {
+		cp.clusters.Update(clusterName, func(record *ClusterRecord) {
+			record.Addons = mergeAddons(record.Addons, opts.addons)
+		})
+	}
+
+	result := fmt.Sprintf("Cluster %s onboarded successfully", clusterName)
+	cp.setClusterState(clusterName, StateOnboarded, result)
+	cp.logOperationEvent(operationID, clusterName, "onboard", "success", result)
+	cp.operations.Succeed(operationID, result)
+	cp.releaseOperationDir(workDir, operationID, false)
+	span.End(nil)
+}
+
+// runDetachment walks a cluster through the detachment steps
+func (cp *ClusterOpsPlugin) runDetachment(ctx context.Context, operationID, clusterName string, steps []pipelineStep, opts detachOptions) {
+	ctx, span := cp.getTracer().startSpan(ctx, "detach", spanKindInternal)
+	span.SetAttribute("cluster.name", clusterName)
+	span.SetAttribute("operation.id", operationID)
+
+	cp.operations.Start(operationID)
+	cp.logOperationEvent(operationID, clusterName, "detach", "started", fmt.Sprintf("Starting detachment of cluster %s", clusterName))
+
+	actions := cp.detachActions(operationID, clusterName, opts)
+	var workDir string
+	if opts.unjoin {
+		var err error
+		if workDir, err = cp.createOperationDir(operationID, opts.kubeconfig); err != nil {
+			cp.abortOperation(ctx, operationID, clusterName, "detach", StateDetachmentFailed, fmt.Errorf("failed to create working directory: %w", err))
+			span.End(err)
+			return
+		}
+		spokeKubeconfig := filepath.Join(workDir, spokeKubeconfigFile)
+		actions["unjoin"] = func(ctx context.Context) error {
+			return cp.clusteradmUnjoin(ctx, spokeKubeconfig, clusterName)
+		}
+		actions["verify-unjoin"] = func(ctx context.Context) error {
+			return cp.verifyUnjoined(ctx, spokeKubeconfig)
+		}
+	}
+	if err := cp.runSteps(ctx, operationID, clusterName, steps, stepOptions{force: opts.force, actions: actions}); err != nil {
+		cp.abortOperation(ctx, operationID, clusterName, "detach", StateDetachmentFailed, err)
+		cp.releaseOperationDir(workDir, operationID, true)
+		span.End(err)
+		return
+	}
+	cp.releaseOperationDir(workDir, operationID, false)
+
+	if err := cp.deleteKubeconfig(ctx, clusterName); err != nil {
+		cp.logOperationEvent(operationID, clusterName, "kubeconfig", "warning", fmt.Sprintf("Failed to delete stored kubeconfig: %v", err))
+	}
+
+	result := fmt.Sprintf("Cluster %s detached successfully", clusterName)
+	cp.clusters.Delete(clusterName)
+	cp.logOperationEvent(operationID, clusterName, "detach", "success", result)
+	cp.operations.Succeed(operationID, result)
+	span.End(nil)
+}
+
+// runSteps executes steps in order, time-boxing each of them to timeout.
+// It stops at the first failure unless force is set, in which case failed
+// steps are logged as warnings and the remaining steps still run. Cancelling
+// ctx always stops the pipeline. Every finished step is recorded on the
+// cluster so a later attempt can resume after it.
+func (cp *ClusterOpsPlugin) runSteps(ctx context.Context, operationID, clusterName string, steps []pipelineStep, opts stepOptions) error {
+	for _, step := range steps {
+		cp.operations.StartStep(operationID, step.name)
+		if step.state != "" {
+			cp.setClusterState(clusterName, step.state, fmt.Sprintf("Running step %s", step.name))
+		}
+
+		if opts.completed[step.name] {
+			message := fmt.Sprintf("Skipped: step %s completed by a previous attempt", step.name)
+			cp.operations.CompleteStep(operationID, step.name, message)
+			cp.logStepEvent(operationID, clusterName, step.name, "skipped", message, 0)
+			continue
+		}
+
+		timeout := cp.settings().Timeout
+		if override, ok := opts.timeouts[step.name]; ok {
+			timeout = override
+		}
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		stepCtx, span := startChildSpan(stepCtx, "step "+step.name, spanKindInternal)
+		started := time.Now()
+		err := fmt.Errorf("no action performs step %s", step.name)
+		if run, ok := opts.actions[step.name]; ok {
+			err = run(stepCtx)
+		}
+		duration := time.Since(started)
+		span.End(err)
+		cancel()
+
+		if err != nil {
+			cp.operations.FailStep(operationID, step.name, err.Error())
+			if opts.force && ctx.Err() == nil {
+				cp.logStepEvent(operationID, clusterName, step.name, "warning", fmt.Sprintf("Step %s failed, continuing because force is set: %v", step.name, err), duration)
+				continue
+			}
+			return fmt.Errorf("step %s: %w", step.name, err)
+		}
+		cp.operations.CompleteStep(operationID, step.name, step.message)
+		cp.clusters.MarkStepCompleted(clusterName, step.name)
+		cp.logStepEvent(operationID, clusterName, step.name, "success", step.message, duration)
+	}
+	return nil
+}
+
+// abortOperation records the terminal state of an operation that stopped
+// early and moves the cluster to failedState, or to Cancelled when ctx, the
+// context of the operation, was cancelled. The event is attributed to the
+// step that stopped the operation, if any.
+func (cp *ClusterOpsPlugin) abortOperation(ctx context.Context, operationID, clusterName, opType string, failedState ClusterState, err error) {
+	event := OnboardingEvent{
+		ClusterName: clusterName,
+		Type:        opType,
+		Status:      "failed",
+		OperationID: operationID,
+		Message:     err.Error(),
+	}
+	if op, ok := cp.operations.Get(operationID); ok {
+		for _, step := range op.Steps {
+			if step.Status != OperationFailed || step.StartedAt == nil || step.CompletedAt == nil {
+				continue
+			}
+			event.Step = step.Name
+			event.DurationMs = step.CompletedAt.Sub(*step.StartedAt).Milliseconds()
+		}
+	}
+
+	if errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled) {
+		event.Status = "cancelled"
+		event.Message = fmt.Sprintf("%s operation for cluster %s cancelled", opType, clusterName)
+		cp.setClusterState(clusterName, StateCancelled, event.Message)
+		cp.recordEvent(event)
+		cp.operations.MarkCancelled(operationID, event.Message)
+		return
+	}
+
+	event.Remediation = remediationFor(event.Step, err.Error())
+	cp.setClusterState(clusterName, failedState, err.Error())
+	if event.Remediation != "" {
+		cp.clusters.Update(clusterName, func(record *ClusterRecord) {
+			record.Remediation = event.Remediation
+		})
+	}
+	cp.recordEvent(event)
+	cp.operations.FailWithRemediation(operationID, operationErrorCode(event.Step, err), err.Error(), event.Remediation)
+}
+
+// setClusterState moves a cluster to a new state from within a pipeline.
+// Rejected transitions indicate a bug in the pipeline and are logged as
+// error events rather than interrupting the operation.
+func (cp *ClusterOpsPlugin) setClusterState(clusterName string, state ClusterState, message string) {
+	if err := cp.clusters.Transition(clusterName, state, message); err != nil {
+		cp.logEvent(clusterName, "state", "error", err.Error())
+	}
+}
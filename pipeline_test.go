@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: spoke
+  cluster:
+    server: https://spoke.invalid:6443
+`
+
+// approvedCSRWatch is the output of a CSR watch that sees an approved CSR
+const approvedCSRWatch = `{"type":"ADDED","object":{"metadata":{"name":"csr-spoke"},"status":{"conditions":[{"type":"Approved"}]}}}`
+
+// joinedManagedCluster returns the ManagedCluster of a cluster whose agent
+// has joined and reports it available
+func joinedManagedCluster(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"hubAcceptsClient": false},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "ManagedClusterJoined", "status": "True"},
+				map[string]interface{}{"type": "ManagedClusterConditionAvailable", "status": "True"},
+			},
+		},
+	}}
+}
+
+// newFakePipelinePlugin returns a plugin whose commands run against a fake
+// runner scripted for a spoke that validates, joins and gets its CSR
+// approved, and whose hub API serves the ManagedCluster of clusterName
+func newFakePipelinePlugin(t *testing.T, clusterName string) (*ClusterOpsPlugin, *fakeRunner, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	cp := newSimulatedPlugin(t)
+	runner := newFakeRunner()
+	runner.On("kubectl --kubeconfig", `{"gitVersion":"v1.30.2"}`, nil)
+	runner.On("kubectl get csr", approvedCSRWatch, nil)
+	api := newFakeHubAPI(joinedManagedCluster(clusterName))
+	cp.withFakeHub(runner, api)
+
+	hub := cp.selectedHub(context.Background())
+	cp.joinTokens.tokens[hub.Name] = joinToken{
+		Token:        "abcdef.0123456789abcdef",
+		HubAPIServer: "https://hub.invalid:6443",
+		FetchedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+	return cp, runner, api
+}
+
+// onboard runs an onboarding of clusterName to completion, resuming after
+// the steps a previous attempt completed when resume is set
+func onboard(t *testing.T, ctx context.Context, cp *ClusterOpsPlugin, clusterName string, resume bool) Operation {
+	t.Helper()
+	opts := onboardOptions{kubeconfig: testKubeconfig}
+	if resume {
+		record, _ := cp.clusters.Get(clusterName)
+		opts.completed = make(map[string]bool)
+		for _, step := range record.CompletedSteps {
+			opts.completed[step] = true
+		}
+	}
+	if err := cp.clusters.Transition(clusterName, StatePending, "Onboarding requested"); err != nil {
+		t.Fatal(err)
+	}
+	steps := onboardingPlan(opts)
+	op := cp.operations.Create("onboard", clusterName, "", steps, func() {})
+	cp.runOnboarding(ctx, op.ID, clusterName, steps, opts)
+	op, _ = cp.operations.Get(op.ID)
+	return op
+}
+
+// detach runs a detachment of clusterName to completion
+func detach(t *testing.T, ctx context.Context, cp *ClusterOpsPlugin, clusterName string, opts detachOptions) Operation {
+	t.Helper()
+	if err := cp.clusters.Transition(clusterName, StateDetaching, "Detachment requested"); err != nil {
+		t.Fatal(err)
+	}
+	steps := detachmentPlan(opts)
+	op := cp.operations.Create("detach", clusterName, "", steps, func() {})
+	cp.runDetachment(ctx, op.ID, clusterName, steps, opts)
+	op, _ = cp.operations.Get(op.ID)
+	return op
+}
+
+// onboarded tracks clusterName as an onboarded cluster
+func onboarded(t *testing.T, cp *ClusterOpsPlugin, clusterName string) {
+	t.Helper()
+	for _, state := range []ClusterState{StatePending, StateOnboarded} {
+		if err := cp.clusters.Transition(clusterName, state, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitForState waits until clusterName reaches state
+func waitForState(t *testing.T, cp *ClusterOpsPlugin, clusterName string, state ClusterState) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if record, _ := cp.clusters.Get(clusterName); record.State == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cluster %s never reached state %s", clusterName, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunOnboarding(t *testing.T) {
+	cp, runner, api := newFakePipelinePlugin(t, "spoke")
+
+	op := onboard(t, context.Background(), cp, "spoke", false)
+	if op.Status != OperationSucceeded {
+		t.Fatalf("onboarding %s: %s", op.Status, op.Error)
+	}
+	if record, _ := cp.clusters.Get("spoke"); record.State != StateOnboarded {
+		t.Errorf("cluster is %s, want %s", record.State, StateOnboarded)
+	}
+	for _, prefix := range []string{"kubectl --kubeconfig", "kubectl apply --server-side", "clusteradm join --hub-token abcdef.0123456789abcdef", "kubectl get csr"} {
+		if runner.ran(prefix) != 1 {
+			t.Errorf("%q ran %d times, want once; commands: %q", prefix, runner.ran(prefix), runner.Commands())
+		}
+	}
+	mc, err := api.Resource(managedClusterGVR).Get(context.Background(), "spoke", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted, _, _ := unstructured.NestedBool(mc.Object, "spec", "hubAcceptsClient"); !accepted {
+		t.Error("ManagedCluster was not accepted")
+	}
+}
+
+func TestRunOnboardingResumesAfterFailure(t *testing.T) {
+	cp, runner, _ := newFakePipelinePlugin(t, "spoke")
+	runner.On("clusteradm join", "", errors.New("exit status 1: hub unreachable"))
+
+	op := onboard(t, context.Background(), cp, "spoke", false)
+	if op.Status != OperationFailed {
+		t.Fatalf("onboarding %s, want %s", op.Status, OperationFailed)
+	}
+	record, _ := cp.clusters.Get("spoke")
+	if record.State != StateFailed {
+		t.Errorf("cluster is %s, want %s", record.State, StateFailed)
+	}
+	if len(record.CompletedSteps) != 2 {
+		t.Errorf("completed steps %v, want validate and the kubeconfig", record.CompletedSteps)
+	}
+
+	runner.On("clusteradm join", "", nil)
+	op = onboard(t, context.Background(), cp, "spoke", true)
+	if op.Status != OperationSucceeded {
+		t.Fatalf("resumed onboarding %s: %s", op.Status, op.Error)
+	}
+	if runner.ran("kubectl --kubeconfig") != 1 || runner.ran("kubectl apply --server-side") != 1 {
+		t.Errorf("completed steps were run again: %q", runner.Commands())
+	}
+	if runner.ran("clusteradm join") != 2 {
+		t.Errorf("clusteradm join ran %d times, want twice", runner.ran("clusteradm join"))
+	}
+}
+
+func TestRunOnboardingCancelled(t *testing.T) {
+	cp, runner, _ := newFakePipelinePlugin(t, "spoke")
+	// the watch ends without a CSR, so the csr step waits until cancelled
+	runner.On("kubectl get csr", "", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Operation)
+	go func() { done <- onboard(t, ctx, cp, "spoke", false) }()
+	waitForState(t, cp, "spoke", StateAwaitingCSR)
+	cancel()
+
+	op := <-done
+	if op.Status != OperationCancelled {
+		t.Fatalf("onboarding %s, want %s", op.Status, OperationCancelled)
+	}
+	if record, _ := cp.clusters.Get("spoke"); record.State != StateCancelled {
+		t.Errorf("cluster is %s, want %s", record.State, StateCancelled)
+	}
+}
+
+func TestRunDetachment(t *testing.T) {
+	cp, runner, api := newFakePipelinePlugin(t, "spoke")
+	onboarded(t, cp, "spoke")
+	runner.On("kubectl get", `{"items":[]}`, nil)
+
+	op := detach(t, context.Background(), cp, "spoke", detachOptions{cleanup: true})
+	if op.Status != OperationSucceeded {
+		t.Fatalf("detachment %s: %s", op.Status, op.Error)
+	}
+	if _, ok := cp.clusters.Get("spoke"); ok {
+		t.Error("detached cluster is still tracked")
+	}
+	if _, err := api.Resource(managedClusterGVR).Get(context.Background(), "spoke", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("ManagedCluster was not deleted: %v", err)
+	}
+	for _, prefix := range []string{"kubectl delete namespace spoke", "kubectl delete secret " + kubeconfigSecretName("spoke")} {
+		if runner.ran(prefix) != 1 {
+			t.Errorf("%q ran %d times, want once; commands: %q", prefix, runner.ran(prefix), runner.Commands())
+		}
+	}
+}
+
+func TestRunDetachmentRetriesAfterFailure(t *testing.T) {
+	cp, _, api := newFakePipelinePlugin(t, "spoke")
+	onboarded(t, cp, "spoke")
+	forbidden := true
+	api.PrependReactor("delete", "managedclusters", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if forbidden {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: managedClusterGVR.Group, Resource: managedClusterGVR.Resource}, "spoke", errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+
+	op := detach(t, context.Background(), cp, "spoke", detachOptions{})
+	if op.Status != OperationFailed {
+		t.Fatalf("detachment %s, want %s", op.Status, OperationFailed)
+	}
+	if record, _ := cp.clusters.Get("spoke"); record.State != StateDetachmentFailed {
+		t.Errorf("cluster is %s, want %s", record.State, StateDetachmentFailed)
+	}
+
+	forbidden = false
+	op = detach(t, context.Background(), cp, "spoke", detachOptions{})
+	if op.Status != OperationSucceeded {
+		t.Fatalf("retried detachment %s: %s", op.Status, op.Error)
+	}
+	if _, ok := cp.clusters.Get("spoke"); ok {
+		t.Error("detached cluster is still tracked")
+	}
+}
+
+func TestRunDetachmentCancelled(t *testing.T) {
+	cp, _, api := newFakePipelinePlugin(t, "spoke")
+	onboarded(t, cp, "spoke")
+	// the ManagedCluster stays Terminating, so the remove step waits for it
+	api.PrependReactor("delete", "managedclusters", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Operation)
+	go func() { done <- detach(t, ctx, cp, "spoke", detachOptions{}) }()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	op := <-done
+	if op.Status != OperationCancelled {
+		t.Fatalf("detachment %s, want %s", op.Status, OperationCancelled)
+	}
+	if record, _ := cp.clusters.Get("spoke"); record.State != StateCancelled {
+		t.Errorf("cluster is %s, want %s", record.State, StateCancelled)
+	}
+}
@@ -0,0 +1,11 @@
+// Package clusteropsv1 is the gRPC API of the cluster operations plugin,
+// generated from clusterops.proto.
+//
+// A Go client connects with:
+//
+//	conn, err := grpc.NewClient("plugin-host:9090", grpc.WithTransportCredentials(creds))
+//	client := clusteropsv1.NewClusterOpsClient(conn)
+//	op, err := client.OnboardCluster(ctx, &clusteropsv1.OnboardClusterRequest{...})
+package clusteropsv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative clusterops.proto
@@ -0,0 +1,205 @@
+// Package rpcplugin runs the cluster operations plugin as a separate process
+// using HashiCorp go-plugin over net/rpc. Unlike a Go plugin .so, the host
+// and the plugin do not have to be built with the same toolchain and
+// dependency versions; they only share this protocol.
+//
+// A host starts the plugin binary, dispenses PluginName, initializes it and
+// mounts Handler for every endpoint listed in its metadata:
+//
+//	client := goplugin.NewClient(&goplugin.ClientConfig{
+//		HandshakeConfig:  rpcplugin.Handshake,
+//		Plugins:          rpcplugin.PluginMap,
+//		Cmd:              exec.Command("./cluster-ops-plugin"),
+//		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolNetRPC},
+//	})
+package rpcplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/rpc"
+
+	"github.com/gin-gonic/gin"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/kubestellar/ui/dynamic_plugins"
+)
+
+// PluginName is the name the plugin is dispensed under
+const PluginName = "cluster-ops"
+
+// Handshake must match between host and plugin. ProtocolVersion changes
+// only when the RPCs below change incompatibly.
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "KUBESTELLAR_PLUGIN",
+	MagicCookieValue: "cluster-ops-plugin",
+}
+
+// PluginMap is the plugin set for goplugin.ClientConfig
+var PluginMap = map[string]goplugin.Plugin{
+	PluginName: &RPCPlugin{},
+}
+
+// Request is an HTTP request forwarded to the plugin. URL is the request URI
+// as received by the host, including the plugin API prefix.
+type Request struct {
+	Method     string
+	URL        string
+	Header     http.Header
+	Body       []byte
+	RemoteAddr string
+}
+
+// Response is the HTTP response produced by the plugin
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Plugin is the out-of-process equivalent of the dynamic plugin interface.
+// Handle replaces GetHandlers: the plugin routes each request itself.
+type Plugin interface {
+	Initialize(config map[string]interface{}) error
+	Metadata() (dynamic_plugins.PluginMetadata, error)
+	Handle(req Request) (Response, error)
+	Health() error
+	Cleanup() error
+}
+
+// RPCPlugin implements goplugin.Plugin; Impl is only set in the plugin process
+type RPCPlugin struct {
+	Impl Plugin
+}
+
+func (p *RPCPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+	return &RPCServer{impl: p.Impl}, nil
+}
+
+func (p *RPCPlugin) Client(_ *goplugin.MuxBroker, client *rpc.Client) (interface{}, error) {
+	return &RPCClient{client: client}, nil
+}
+
+// Serve runs impl as a plugin process; it returns when the host disconnects
+func Serve(impl Plugin) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         map[string]goplugin.Plugin{PluginName: &RPCPlugin{Impl: impl}},
+	})
+}
+
+// RPCServer exposes a Plugin over net/rpc. The configuration and metadata
+// hold arbitrary values gob cannot encode without registration, so they
+// travel as JSON.
+type RPCServer struct {
+	impl Plugin
+}
+
+func (s *RPCServer) Initialize(config []byte, _ *interface{}) error {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(config, &decoded); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	return s.impl.Initialize(decoded)
+}
+
+func (s *RPCServer) Metadata(_ interface{}, resp *[]byte) error {
+	metadata, err := s.impl.Metadata()
+	if err != nil {
+		return err
+	}
+	*resp, err = json.Marshal(metadata)
+	return err
+}
+
+func (s *RPCServer) Handle(req Request, resp *Response) error {
+	var err error
+	*resp, err = s.impl.Handle(req)
+	return err
+}
+
+func (s *RPCServer) Health(_ interface{}, _ *interface{}) error {
+	return s.impl.Health()
+}
+
+func (s *RPCServer) Cleanup(_ interface{}, _ *interface{}) error {
+	return s.impl.Cleanup()
+}
+
+// RPCClient is the Plugin handed to the host
+type RPCClient struct {
+	client *rpc.Client
+}
+
+func (c *RPCClient) Initialize(config map[string]interface{}) error {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return c.client.Call("Plugin.Initialize", encoded, new(interface{}))
+}
+
+func (c *RPCClient) Metadata() (dynamic_plugins.PluginMetadata, error) {
+	var metadata dynamic_plugins.PluginMetadata
+	var encoded []byte
+	if err := c.client.Call("Plugin.Metadata", new(interface{}), &encoded); err != nil {
+		return metadata, err
+	}
+	err := json.Unmarshal(encoded, &metadata)
+	return metadata, err
+}
+
+func (c *RPCClient) Handle(req Request) (Response, error) {
+	var resp Response
+	err := c.client.Call("Plugin.Handle", req, &resp)
+	return resp, err
+}
+
+func (c *RPCClient) Health() error {
+	return c.client.Call("Plugin.Health", new(interface{}), new(interface{}))
+}
+
+func (c *RPCClient) Cleanup() error {
+	return c.client.Call("Plugin.Cleanup", new(interface{}), new(interface{}))
+}
+
+// Handler forwards requests received by the host to the plugin. Responses
+// are buffered, so streaming endpoints such as the WebSocket event stream
+// are not available over RPC.
+func Handler(p Plugin) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Failed to read request body",
+				"details": err.Error(),
+			})
+			return
+		}
+		resp, err := p.Handle(Request{
+			Method:     c.Request.Method,
+			URL:        c.Request.URL.RequestURI(),
+			Header:     c.Request.Header,
+			Body:       body,
+			RemoteAddr: c.Request.RemoteAddr,
+		})
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":   "Plugin call failed",
+				"details": err.Error(),
+			})
+			return
+		}
+		for key, values := range resp.Header {
+			if key == "Content-Type" {
+				continue
+			}
+			for _, value := range values {
+				c.Writer.Header().Add(key, value)
+			}
+		}
+		c.Data(resp.Status, resp.Header.Get("Content-Type"), resp.Body)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// clusterSetLabel assigns a ManagedCluster to a ManagedClusterSet
+	clusterSetLabel = "cluster.open-cluster-management.io/clusterset"
+	// defaultPrecreatedTTL is how long a pre-created cluster waits for its
+	// agent before it is marked failed
+	defaultPrecreatedTTL = 7 * 24 * time.Hour
+)
+
+// PrecreateClusterRequest is the payload of POST /clusters/precreate
+type PrecreateClusterRequest struct {
+	ClusterName string `json:"clusterName" binding:"required"`
+	// Hub names the hub to create the cluster on; empty uses the default
+	Hub         string            `json:"hub,omitempty"`
+	ClusterSet  string            `json:"clusterSet,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// joined reports whether the agent of the cluster has registered, which it
+// does by reporting its API server address or becoming available
+func (mc *managedCluster) joined() bool {
+	return len(mc.Spec.ManagedClusterClientConfigs) > 0 || mc.available()
+}
+
+// Expect registers a cluster created on the hub ahead of its agent, so it is
+// tracked like a cluster whose code was redeemed
+func (s *registrationStore) Expect(registration Registration) Registration {
+	now := time.Now()
+	registration.ID = "precreated-" + registration.ClusterName
+	registration.Precreated = true
+	registration.RedeemedAt = &now
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.registrations[registration.ID] = &registration
+	return registration
+}
+
+// precreatedCluster returns the ManagedCluster and namespace that declare a
+// cluster on the hub before its agent joins. hubAcceptsClient is set up
+// front since creating the cluster is the approval.
+func precreatedCluster(req PrecreateClusterRequest) []map[string]interface{} {
+	labels := copyStringMap(req.Labels)
+	if req.ClusterSet != "" {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[clusterSetLabel] = req.ClusterSet
+	}
+	return []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": req.ClusterName},
+		},
+		{
+			"apiVersion": "cluster.open-cluster-management.io/v1",
+			"kind":       "ManagedCluster",
+			"metadata": map[string]interface{}{
+				"name":        req.ClusterName,
+				"labels":      labels,
+				"annotations": req.Annotations,
+			},
+			"spec": map[string]interface{}{"hubAcceptsClient": true},
+		},
+	}
+}
+
+func (cp *ClusterOpsPlugin) PrecreateClusterHandler(c *gin.Context) {
+	var req PrecreateClusterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if !namespacePattern.MatchString(req.ClusterName) {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("Invalid cluster name %q", req.ClusterName), nil))
+		return
+	}
+	problems := validateClusterMetadata(req.Labels, req.Annotations)
+	if req.ClusterSet != "" && !namespacePattern.MatchString(req.ClusterSet) {
+		problems = append(problems, fmt.Sprintf("invalid clusterSet %q", req.ClusterSet))
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid labels, annotations or clusterSet", problems))
+		return
+	}
+	hub, err := cp.lookupHub(req.Hub)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid hub", err.Error()))
+		return
+	}
+	ctx := withHub(c.Request.Context(), hub)
+
+	cp.expireRegistrations()
+	if err := cp.clusters.Transition(req.ClusterName, StatePending, "Created on the hub, awaiting the agent"); err != nil {
+		c.JSON(http.StatusConflict, errorResponse(codeConflict, "Cluster cannot be created in its current state", err.Error()))
+		return
+	}
+	cp.clusters.ResetSteps(req.ClusterName)
+	cp.clusters.Update(req.ClusterName, func(record *ClusterRecord) {
+		record.Hub = hub.Name
+		record.Labels = mergeStringMaps(record.Labels, req.Labels)
+		record.Annotations = mergeStringMaps(record.Annotations, req.Annotations)
+	})
+
+	for _, object := range precreatedCluster(req) {
+		if err := cp.applyHubObject(ctx, object); err != nil {
+			cp.setClusterState(req.ClusterName, StateFailed, err.Error())
+			c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to create the cluster on the hub", err.Error()))
+			return
+		}
+	}
+	now := time.Now()
+	registration := cp.registrations.Expect(Registration{
+		ClusterName: req.ClusterName,
+		Hub:         hub.Name,
+		CreatedBy:   cp.requestActor(c),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(cp.settings().PrecreatedClusterTTL),
+	})
+	cp.logEvent(req.ClusterName, "precreate", "success", fmt.Sprintf("ManagedCluster %s created on hub %s ahead of its agent", req.ClusterName, hub.Name))
+
+	response := gin.H{
+		"message":      fmt.Sprintf("Cluster %s created on hub %s", req.ClusterName, hub.Name),
+		"clusterName":  req.ClusterName,
+		"hub":          hub.Name,
+		"status":       StatePending,
+		"registration": registration,
+		"plugin":       "cluster-ops-plugin",
+	}
+	token, err := cp.joinToken(ctx)
+	if err != nil {
+		response["joinCommandError"] = err.Error()
+		c.JSON(http.StatusCreated, response)
+		return
+	}
+	command := []string{"clusteradm", "join", "--hub-token", token.Token, "--hub-apiserver", token.HubAPIServer, "--cluster-name", req.ClusterName}
+	if options := cp.withJoinDefaults(nil); options != nil {
+		command = append(command, options.joinFlags()...)
+	}
+	response["joinCommand"] = strings.Join(command, " ")
+	response["tokenExpiresAt"] = token.ExpiresAt
+	c.JSON(http.StatusCreated, response)
+}
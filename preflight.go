@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minKubernetesMinor is the oldest Kubernetes 1.x minor release the plugin
+// supports onboarding
+const minKubernetesMinor = 19
+
+// Preflight check results
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// klusterletCRD is installed on a spoke by clusteradm join
+const klusterletCRD = "klusterlets.operator.open-cluster-management.io"
+
+// PreflightRequest is the payload of POST /preflight
+type PreflightRequest struct {
+	Kubeconfig string `json:"kubeconfig" binding:"required"`
+	HubServer  string `json:"hubServer,omitempty"`
+}
+
+// PreflightCheck is the outcome of a single preflight check
+type PreflightCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// PreflightReport aggregates the results of every preflight check
+type PreflightReport struct {
+	Passed    bool             `json:"passed"`
+	Context   string           `json:"context,omitempty"`
+	Checks    []PreflightCheck `json:"checks"`
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+func (r *PreflightReport) add(name string, start time.Time, status, message string) {
+	r.Checks = append(r.Checks, PreflightCheck{
+		Name:       name,
+		Status:     status,
+		Message:    message,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	if status == checkFail {
+		r.Passed = false
+	}
+}
+
+// runPreflight validates that a cluster can be onboarded. Checks that depend
+// on API access are skipped once connectivity has failed.
+func runPreflight(ctx context.Context, req PreflightRequest, tlsOpts spokeTLSOptions) PreflightReport {
+	report := PreflightReport{Passed: true, Timestamp: time.Now()}
+
+	start := time.Now()
+	client, err := newSpokeClient(req.Kubeconfig, tlsOpts)
+	if err != nil {
+		report.add("kubeconfig", start, checkFail, err.Error())
+		for _, name := range []string{"connectivity", "kubernetes-version", "hub-reachability", "rbac", "crds"} {
+			report.add(name, time.Now(), checkSkip, "Skipped: kubeconfig is invalid")
+		}
+		return report
+	}
+	report.Context = client.context
+	report.add("kubeconfig", start, checkPass, fmt.Sprintf("Using context %s", client.context))
+	if client.insecure {
+		report.add("tls", time.Now(), checkWarn, insecureTLSWarning)
+	}
+
+	start = time.Now()
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+		Major      string `json:"major"`
+		Minor      string `json:"minor"`
+	}
+	if err := client.get(ctx, "/version", &version); err != nil {
+		report.add("connectivity", start, checkFail, fmt.Sprintf("Cannot reach API server %s: %v", client.server, err))
+		for _, name := range []string{"kubernetes-version", "hub-reachability", "rbac", "crds"} {
+			report.add(name, time.Now(), checkSkip, "Skipped: API server is unreachable")
+		}
+		return report
+	}
+	report.add("connectivity", start, checkPass, fmt.Sprintf("Connected to %s", client.server))
+
+	start = time.Now()
+	status, message := checkKubernetesVersion(version.Major, version.Minor, version.GitVersion)
+	report.add("kubernetes-version", start, status, message)
+
+	start = time.Now()
+	status, message = checkHubReachability(ctx, req.HubServer)
+	report.add("hub-reachability", start, status, message)
+
+	start = time.Now()
+	status, message = checkJoinPermissions(ctx, client)
+	report.add("rbac", start, status, message)
+
+	start = time.Now()
+	status, message = checkKlusterletCRD(ctx, client)
+	report.add("crds", start, status, message)
+
+	return report
+}
+
+func checkKubernetesVersion(major, minor, gitVersion string) (string, string) {
+	minorNumber, err := strconv.Atoi(strings.TrimSuffix(minor, "+"))
+	if major != "1" || err != nil {
+		return checkWarn, fmt.Sprintf("Unable to interpret Kubernetes version %q", gitVersion)
+	}
+	if minorNumber < minKubernetesMinor {
+		return checkFail, fmt.Sprintf("Kubernetes %s is not supported; 1.%d or later is required", gitVersion, minKubernetesMinor)
+	}
+	return checkPass, fmt.Sprintf("Kubernetes %s is supported", gitVersion)
+}
+
+// checkHubReachability dials the hub API server. The dial originates from the
+// plugin host, so it only approximates reachability from the spoke itself.
+func checkHubReachability(ctx context.Context, hubServer string) (string, string) {
+	if hubServer == "" {
+		return checkSkip, "Skipped: no hubServer provided"
+	}
+
+	hubURL, err := url.Parse(hubServer)
+	if err != nil || hubURL.Host == "" {
+		return checkFail, fmt.Sprintf("Invalid hubServer %q", hubServer)
+	}
+	host := hubURL.Host
+	if hubURL.Port() == "" {
+		host = net.JoinHostPort(hubURL.Hostname(), "443")
+	}
+
+	dialer := net.Dialer{Timeout: spokeRequestTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return checkFail, fmt.Sprintf("Cannot reach hub at %s: %v", host, err)
+	}
+	conn.Close()
+	return checkPass, fmt.Sprintf("Hub %s is reachable from the plugin host", host)
+}
+
+// checkJoinPermissions verifies the kubeconfig identity can create the
+// resources clusteradm join installs on the spoke
+func checkJoinPermissions(ctx context.Context, client *spokeClient) (string, string) {
+	required := []struct{ group, resource string }{
+		{"apiextensions.k8s.io", "customresourcedefinitions"},
+		{"", "namespaces"},
+		{"rbac.authorization.k8s.io", "clusterroles"},
+		{"rbac.authorization.k8s.io", "clusterrolebindings"},
+		{"apps", "deployments"},
+	}
+
+	var denied []string
+	for _, r := range required {
+		review := map[string]interface{}{
+			"apiVersion": "authorization.k8s.io/v1",
+			"kind":       "SelfSubjectAccessReview",
+			"spec": map[string]interface{}{
+				"resourceAttributes": map[string]interface{}{
+					"verb":     "create",
+					"group":    r.group,
+					"resource": r.resource,
+				},
+			},
+		}
+		var result struct {
+			Status struct {
+				Allowed bool `json:"allowed"`
+			} `json:"status"`
+		}
+		if err := client.post(ctx, "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", review, &result); err != nil {
+			return checkFail, fmt.Sprintf("Unable to review permissions: %v", err)
+		}
+		if !result.Status.Allowed {
+			denied = append(denied, r.resource)
+		}
+	}
+
+	if len(denied) > 0 {
+		return checkFail, fmt.Sprintf("Missing create permission for: %s", strings.Join(denied, ", "))
+	}
+	return checkPass, "Identity can create all resources required to join"
+}
+
+// checkKlusterletCRD verifies the CRD API is served and warns when a
+// klusterlet is already installed, which usually means the spoke is joined
+// to another hub
+func checkKlusterletCRD(ctx context.Context, client *spokeClient) (string, string) {
+	err := client.get(ctx, "/apis/apiextensions.k8s.io/v1/customresourcedefinitions/"+klusterletCRD, nil)
+	if err == nil {
+		return checkWarn, fmt.Sprintf("CRD %s already exists; the cluster may already be joined to a hub", klusterletCRD)
+	}
+	if apiErr, ok := err.(*apiError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return checkPass, "No existing klusterlet installation found"
+	}
+	return checkFail, fmt.Sprintf("Unable to query CRDs: %v", err)
+}
+
+func (cp *ClusterOpsPlugin) PreflightHandler(c *gin.Context) {
+	var req PreflightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+
+	kubeconfig, err := cp.prepareKubeconfig(c.Request.Context(), req.Kubeconfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeKubeconfigInvalid, "Unable to decode kubeconfig", err.Error()))
+		return
+	}
+	req.Kubeconfig = kubeconfig
+
+	ctx, span := cp.getTracer().startSpan(withRemoteParent(c.Request.Context(), c.GetHeader("traceparent")), "preflight", spanKindServer)
+	tlsOpts := cp.spokeTLSOptions()
+	if !tlsOpts.validateSSL {
+		cp.logger.WarnContext(ctx, insecureTLSWarning)
+	}
+	report := runPreflight(ctx, req, tlsOpts)
+	span.SetAttribute("preflight.passed", strconv.FormatBool(report.Passed))
+	span.End(nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+		"plugin": "cluster-ops-plugin",
+	})
+}
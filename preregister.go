@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterClusterRequest is the payload of POST /clusters, which reserves a
+// cluster before its kubeconfig is available
+type RegisterClusterRequest struct {
+	ClusterName string            `json:"clusterName" binding:"required"`
+	Owner       string            `json:"owner,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Hub names the hub the cluster will join; empty uses the default
+	Hub string `json:"hub,omitempty"`
+}
+
+func (cp *ClusterOpsPlugin) RegisterClusterHandler(c *gin.Context) {
+	var req RegisterClusterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if !namespacePattern.MatchString(req.ClusterName) {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("Invalid cluster name %q", req.ClusterName), nil))
+		return
+	}
+	if problems := validateClusterMetadata(req.Labels, req.Annotations); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid labels or annotations", problems))
+		return
+	}
+	hub, err := cp.lookupHub(req.Hub)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid hub", err.Error()))
+		return
+	}
+
+	if err := cp.clusters.Transition(req.ClusterName, StateRegistered, "Registered, awaiting kubeconfig"); err != nil {
+		c.JSON(http.StatusConflict, errorResponse(codeConflict, "Cluster is already tracked", err.Error()))
+		return
+	}
+	cp.clusters.Update(req.ClusterName, func(record *ClusterRecord) {
+		record.Hub = hub.Name
+		record.Owner = req.Owner
+		record.Type = req.Type
+		record.Labels = req.Labels
+		record.Annotations = req.Annotations
+	})
+	record, _ := cp.clusters.Get(req.ClusterName)
+	cp.logEvent(req.ClusterName, "register", "success", fmt.Sprintf("Cluster %s registered for hub %s", req.ClusterName, hub.Name))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":            fmt.Sprintf("Cluster %s registered; supply its kubeconfig to join it", req.ClusterName),
+		"cluster":            record,
+		"kubeconfigEndpoint": fmt.Sprintf("%s/clusters/%s/kubeconfig", pluginAPIBase, req.ClusterName),
+		"plugin":             "cluster-ops-plugin",
+	})
+}
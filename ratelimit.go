@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRateLimitPerMinute = 30
+	defaultRateLimitBurst     = 10
+	defaultMaxRequestBytes    = 5 << 20
+	// maxRateLimitBuckets bounds the number of tracked clients; idle clients
+	// with full buckets are forgotten beyond it
+	maxRateLimitBuckets = 10000
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-client token bucket limiter
+type rateLimiter struct {
+	buckets map[string]*tokenBucket
+	mutex   sync.Mutex
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token from the bucket of a client refilled at perMinute
+// tokens a minute up to burst. When the bucket is empty it returns false and
+// how long until the next token is available.
+func (l *rateLimiter) Allow(client string, perMinute, burst int) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	rate := float64(perMinute) / 60
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.prune(now, rate, burst)
+		}
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+// prune forgets clients whose buckets have refilled completely
+func (l *rateLimiter) prune(now time.Time, rate float64, burst int) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*rate >= float64(burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// limited wraps a handler with the request body limit from
+// max_request_body_bytes and, for mutating requests, the per-client rate
+// limit from rate_limit_per_minute and rate_limit_burst. Clients are told
+// when to retry with a Retry-After header.
+func (cp *ClusterOpsPlugin) limited(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := cp.settings()
+		maxBytes := int64(settings.MaxRequestBytes)
+		if maxBytes > 0 && c.Request.Body != nil {
+			if c.Request.ContentLength > maxBytes {
+				rejectBodyTooLarge(c, maxBytes)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBytes+1))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Failed to read request body", err.Error()))
+				return
+			}
+			if int64(len(body)) > maxBytes {
+				rejectBodyTooLarge(c, maxBytes)
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		perMinute := settings.RateLimitPerMinute
+		if c.Request.Method != http.MethodGet && perMinute > 0 {
+			client := c.GetString("subject")
+			if client == "" {
+				client = c.ClientIP()
+			}
+			burst := max(settings.RateLimitBurst, 1)
+			if ok, retryAfter := cp.rateLimiter.Allow(client, perMinute, burst); !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				c.Header("Retry-After", strconv.Itoa(seconds))
+				c.JSON(http.StatusTooManyRequests, errorResponse(codeRateLimited, "Too many requests", fmt.Sprintf("rate limit of %d mutating requests per minute exceeded, retry in %ds", perMinute, seconds)))
+				return
+			}
+		}
+
+		handler(c)
+	}
+}
+
+func rejectBodyTooLarge(c *gin.Context, maxBytes int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, errorResponse(codePayloadTooLarge, "Request body too large", fmt.Sprintf("request bodies are limited to %d bytes", maxBytes)))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permissions declared in the plugin metadata
+const (
+	permissionRead   = "cluster.read"
+	permissionWrite  = "cluster.write"
+	permissionDelete = "cluster.delete"
+	// The plugin configuration is guarded like a ConfigMap
+	permissionConfigRead  = "configmap.read"
+	permissionConfigWrite = "configmap.write"
+)
+
+// handlerPermissions maps each handler to the permission a caller needs.
+// Handlers missing from the map require cluster.write.
+var handlerPermissions = map[string]string{
+	"OnboardClusterHandler":          permissionWrite,
+	"BatchOnboardHandler":            permissionWrite,
+	"GetBatchHandler":                permissionRead,
+	"DetachClusterHandler":           permissionDelete,
+	"BatchDetachHandler":             permissionDelete,
+	"GetDetachBatchHandler":          permissionRead,
+	"GetClusterStatusHandler":        permissionRead,
+	"ListClustersHandler":            permissionRead,
+	"RegisterClusterHandler":         permissionWrite,
+	"ApplyFleetHandler":              permissionDelete,
+	"DiffFleetHandler":               permissionRead,
+	"GetClusterDetailsHandler":       permissionRead,
+	"ListClusterNodesHandler":        permissionRead,
+	"GetClusterKubeconfigHandler":    permissionWrite,
+	"RotateClusterKubeconfigHandler": permissionWrite,
+	"PatchClusterLabelsHandler":      permissionWrite,
+	"GetClusterAddonsHandler":        permissionRead,
+	"EnableClusterAddonsHandler":     permissionWrite,
+	"SetClusterTaintHandler":         permissionWrite,
+	"RemoveClusterTaintHandler":      permissionWrite,
+	"GetJoinManifestHandler":         permissionWrite,
+	"ApproveClusterHandler":          permissionWrite,
+	"CordonClusterHandler":           permissionWrite,
+	"UncordonClusterHandler":         permissionWrite,
+	"ClearClusterEventsHandler":      permissionDelete,
+	"RuntimeDiagnosticsHandler":      permissionRead,
+	"DeepHealthCheckHandler":         permissionRead,
+	"VersionHandler":                 permissionRead,
+	"SelfTestHandler":                permissionWrite,
+	"PprofHandler":                   permissionRead,
+	"GetClusterEventsHandler":        permissionRead,
+	"GetClusterLogsHandler":          permissionRead,
+	"StreamClusterEventsHandler":     permissionRead,
+	"PreflightHandler":               permissionRead,
+	"GraphQLHandler":                 permissionRead,
+	"ListOperationsHandler":          permissionRead,
+	"GetOperationHandler":            permissionRead,
+	"CancelOperationHandler":         permissionWrite,
+	"ListAuditHandler":               permissionRead,
+	"ListWebhooksHandler":            permissionRead,
+	"CreateWebhookHandler":           permissionWrite,
+	"DeleteWebhookHandler":           permissionDelete,
+	"ListWebhookDeliveriesHandler":   permissionRead,
+	"ListAPIKeysHandler":             permissionRead,
+	"ListHubsHandler":                permissionRead,
+	"PrecreateClusterHandler":        permissionWrite,
+	"ListRegistrationsHandler":       permissionRead,
+	"CreateRegistrationHandler":      permissionWrite,
+	"RotateJoinTokenHandler":         permissionWrite,
+	"RevokeJoinTokenHandler":         permissionDelete,
+	"RotateEncryptionHandler":        permissionWrite,
+	"GetConfigHandler":               permissionConfigRead,
+	"UpdateConfigHandler":            permissionConfigWrite,
+}
+
+// apiKeyPermissions are the permissions granted by each API key permission
+var apiKeyPermissions = map[string][]string{
+	apiKeyReadOnly:  {permissionRead, permissionConfigRead},
+	apiKeyReadWrite: {permissionRead, permissionWrite, permissionDelete, permissionConfigRead, permissionConfigWrite},
+}
+
+// requiredPermission returns the permission needed to call a handler
+func requiredPermission(handlerName string) string {
+	if permission, ok := handlerPermissions[handlerName]; ok {
+		return permission
+	}
+	return permissionWrite
+}
+
+// grantedPermissions collects the permissions of the caller from its API key
+// or the rbac_permissions_claim of its token. Only a request authenticated by
+// neither falls back to rbac_permissions_header, and only when
+// rbac_trust_proxy_header says an authenticating proxy sets it; otherwise any
+// client could grant itself permissions. Claims and headers may list
+// permissions as an array or a space- or comma-separated string.
+func (cp *ClusterOpsPlugin) grantedPermissions(c *gin.Context) []string {
+	if permission := c.GetString("apiKeyPermission"); permission != "" {
+		return apiKeyPermissions[permission]
+	}
+
+	if claims, ok := c.Get("claims"); ok {
+		var granted []string
+		claimName := cp.settings().RBACPermissionsClaim
+		switch value := claims.(map[string]interface{})[claimName].(type) {
+		case []interface{}:
+			for _, item := range value {
+				if permission, ok := item.(string); ok {
+					granted = append(granted, permission)
+				}
+			}
+		case string:
+			granted = append(granted, splitPermissions(value)...)
+		}
+		return granted
+	}
+
+	settings := cp.settings()
+	header := settings.RBACPermissionsHeader
+	if header == "" || !settings.RBACTrustProxyHeader {
+		return nil
+	}
+	return splitPermissions(c.GetHeader(header))
+}
+
+func splitPermissions(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
+
+// authorized wraps a handler so callers lacking its required permission get
+// 403 when rbac_enabled is set
+func (cp *ClusterOpsPlugin) authorized(handlerName string, handler gin.HandlerFunc) gin.HandlerFunc {
+	required := requiredPermission(handlerName)
+	return func(c *gin.Context) {
+		if !cp.settings().RBACEnabled {
+			handler(c)
+			return
+		}
+
+		for _, permission := range cp.grantedPermissions(c) {
+			if permission == required {
+				handler(c)
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, errorResponse(codeForbidden, "Forbidden", fmt.Sprintf("permission %s is required", required)))
+	}
+}
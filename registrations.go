@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultRegistrationTTL is how long a registration code stays valid
+	defaultRegistrationTTL = time.Hour
+	// maxRegistrationTTL caps the lifetime requested for a registration code
+	maxRegistrationTTL = 24 * time.Hour
+)
+
+// RegistrationRequest is the payload of POST /registrations
+type RegistrationRequest struct {
+	ClusterName string `json:"clusterName" binding:"required"`
+	// Hub names the hub to register the cluster with; empty uses the default
+	Hub string `json:"hub,omitempty"`
+	// TTL is how long the code stays valid, e.g. 30m; it defaults to
+	// registration_code_ttl
+	TTL string `json:"ttl,omitempty"`
+	// Singleton runs the agents of the spoke in a single deployment
+	Singleton bool `json:"singleton,omitempty"`
+}
+
+// RegistrationExchangeRequest is the payload a spoke sends to redeem a code
+type RegistrationExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Registration is a registration code minted for a cluster. The code itself
+// is only returned when it is minted; the store keeps its hash.
+type Registration struct {
+	ID          string     `json:"id"`
+	ClusterName string     `json:"clusterName"`
+	Hub         string     `json:"hub"`
+	Singleton   bool       `json:"singleton,omitempty"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	RedeemedAt  *time.Time `json:"redeemedAt,omitempty"`
+	// Precreated marks a cluster created on the hub ahead of its agent
+	// rather than through a code
+	Precreated bool `json:"precreated,omitempty"`
+	// Registered is set once the ManagedCluster of the spoke appears
+	Registered bool `json:"registered"`
+	// redeeming is set while the join manifest of a code is rendered
+	redeeming bool
+}
+
+// registrationStore holds registration codes by the hash of the code
+type registrationStore struct {
+	registrations map[string]*Registration
+	mutex         sync.Mutex
+}
+
+func newRegistrationStore() *registrationStore {
+	return &registrationStore{registrations: make(map[string]*Registration)}
+}
+
+func registrationHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Mint creates a one-time code for a cluster
+func (s *registrationStore) Mint(registration Registration) (string, Registration, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", registration, err
+	}
+	code := base64.RawURLEncoding.EncodeToString(secret)
+	hash := registrationHash(code)
+	registration.ID = hash[:12]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.registrations[hash] = &registration
+	return code, registration, nil
+}
+
+// Claim returns the registration of a code and holds the code while its
+// join manifest is rendered. Codes are valid once and only until they
+// expire; Finish marks a claimed code as used or releases it.
+func (s *registrationStore) Claim(code string) (Registration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	registration, ok := s.registrations[registrationHash(code)]
+	switch {
+	case !ok:
+		return Registration{}, fmt.Errorf("unknown registration code")
+	case registration.RedeemedAt != nil:
+		return Registration{}, fmt.Errorf("registration code was already used")
+	case registration.redeeming:
+		return Registration{}, fmt.Errorf("registration code is being redeemed")
+	case time.Now().After(registration.ExpiresAt):
+		return Registration{}, fmt.Errorf("registration code expired")
+	}
+	registration.redeeming = true
+	return *registration, nil
+}
+
+// Finish ends the claim of a code, marking it used when its join manifest
+// was handed out; otherwise the code can be redeemed again
+func (s *registrationStore) Finish(code string, redeemed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	registration, ok := s.registrations[registrationHash(code)]
+	if !ok {
+		return
+	}
+	registration.redeeming = false
+	if redeemed {
+		now := time.Now()
+		registration.RedeemedAt = &now
+	}
+}
+
+// Joined records that the cluster of a redeemed code appeared on the hub. It
+// reports whether the cluster has a redeemed code and whether this is the
+// first time it was seen.
+func (s *registrationStore) Joined(clusterName string) (bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, registration := range s.registrations {
+		if registration.ClusterName == clusterName && registration.RedeemedAt != nil {
+			first := !registration.Registered
+			registration.Registered = true
+			return true, first
+		}
+	}
+	return false, false
+}
+
+// Complete forgets the codes of a cluster once it is onboarded
+func (s *registrationStore) Complete(clusterName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for hash, registration := range s.registrations {
+		if registration.ClusterName == clusterName {
+			delete(s.registrations, hash)
+		}
+	}
+}
+
+// Expire removes codes that expired without the cluster registering and
+// returns them
+func (s *registrationStore) Expire() []Registration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var expired []Registration
+	for hash, registration := range s.registrations {
+		if !registration.Registered && time.Now().After(registration.ExpiresAt) {
+			expired = append(expired, *registration)
+			delete(s.registrations, hash)
+		}
+	}
+	return expired
+}
+
+// List returns every registration, newest first
+func (s *registrationStore) List() []Registration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]Registration, 0, len(s.registrations))
+	for _, registration := range s.registrations {
+		list = append(list, *registration)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// expireRegistrations fails the clusters whose registration code expired
+// before they joined
+func (cp *ClusterOpsPlugin) expireRegistrations() {
+	for _, registration := range cp.registrations.Expire() {
+		record, ok := cp.clusters.Get(registration.ClusterName)
+		if !ok || record.State != StatePending {
+			continue
+		}
+		message := "Registration expired before the cluster joined"
+		cp.setClusterState(registration.ClusterName, StateFailed, message)
+		cp.logEvent(registration.ClusterName, "registration", "failed", message)
+	}
+}
+
+// applyRegistration moves a self-registering or pre-created cluster forward
+// when its ManagedCluster changes. When its agent first registers the cluster
+// is accepted like one onboarded by the plugin, subject to
+// csr_approval_policy, unless manual_approval leaves that to an operator;
+// once available it is onboarded.
+func (cp *ClusterOpsPlugin) applyRegistration(hubName, clusterName string, mc *managedCluster) {
+	if !mc.joined() {
+		return
+	}
+	available := mc.available()
+	registered, first := cp.registrations.Joined(clusterName)
+	if !registered {
+		return
+	}
+	if available {
+		cp.registrations.Complete(clusterName)
+		cp.setClusterState(clusterName, StateOnboarded, "Cluster self-registered")
+		cp.logEvent(clusterName, "registration", "success", fmt.Sprintf("Cluster %s self-registered with hub %s", clusterName, hubName))
+		return
+	}
+	if !first {
+		return
+	}
+	cp.logEvent(clusterName, "registration", "info", fmt.Sprintf("Agent of cluster %s registered with hub %s", clusterName, hubName))
+	hub, err := cp.lookupHub(hubName)
+	if err != nil || cp.settings().ManualApproval {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(withHub(context.Background(), hub), cp.csrTimeout())
+		defer cancel()
+		if err := cp.acceptCluster(ctx, clusterName); err != nil {
+			cp.logEvent(clusterName, "registration", "failed", fmt.Sprintf("Failed to accept cluster %s: %v", clusterName, err))
+		}
+	}()
+}
+
+// registrationURL is the address spokes redeem codes at, from public_url or
+// else the address the request was sent to
+func (cp *ClusterOpsPlugin) registrationURL(c *gin.Context) string {
+	base := strings.TrimSuffix(cp.settings().PublicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return base + pluginAPIBase + "/registrations/exchange"
+}
+
+func (cp *ClusterOpsPlugin) CreateRegistrationHandler(c *gin.Context) {
+	var req RegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if !namespacePattern.MatchString(req.ClusterName) {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("Invalid cluster name %q", req.ClusterName), nil))
+		return
+	}
+	ttl := cp.settings().RegistrationCodeTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 || parsed > maxRegistrationTTL {
+			c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("ttl must be a positive duration of at most %s", maxRegistrationTTL), nil))
+			return
+		}
+		ttl = parsed
+	}
+	hub, err := cp.lookupHub(req.Hub)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid hub", err.Error()))
+		return
+	}
+
+	cp.expireRegistrations()
+	if err := cp.clusters.Transition(req.ClusterName, StatePending, "Awaiting self-registration"); err != nil {
+		c.JSON(http.StatusConflict, errorResponse(codeConflict, "Cluster cannot be registered in its current state", err.Error()))
+		return
+	}
+	cp.clusters.ResetSteps(req.ClusterName)
+	cp.clusters.Update(req.ClusterName, func(record *ClusterRecord) {
+		record.Hub = hub.Name
+	})
+
+	now := time.Now()
+	code, registration, err := cp.registrations.Mint(Registration{
+		ClusterName: req.ClusterName,
+		Hub:         hub.Name,
+		Singleton:   req.Singleton,
+		CreatedBy:   cp.requestActor(c),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	})
+	if err != nil {
+		cp.setClusterState(req.ClusterName, StateFailed, "Failed to mint a registration code")
+		c.JSON(http.StatusInternalServerError, errorResponse(codeInternal, "Failed to mint a registration code", err.Error()))
+		return
+	}
+	cp.logEvent(req.ClusterName, "registration", "info", fmt.Sprintf("Registration code %s minted, valid until %s", registration.ID, registration.ExpiresAt.UTC().Format(time.RFC3339)))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      fmt.Sprintf("Registration code for cluster %s created", req.ClusterName),
+		"registration": registration,
+		"code":         code,
+		"command": fmt.Sprintf("curl -fsS -X POST -H 'Content-Type: application/json' -d '{\"code\":\"%s\"}' %s | kubectl apply -f -",
+			code, cp.registrationURL(c)),
+		"plugin": "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) ListRegistrationsHandler(c *gin.Context) {
+	cp.expireRegistrations()
+	registrations := cp.registrations.List()
+	c.JSON(http.StatusOK, gin.H{
+		"registrations": registrations,
+		"count":         len(registrations),
+		"plugin":        "cluster-ops-plugin",
+	})
+}
+
+// RedeemRegistrationHandler is called from the spoke and authenticated
+// by the registration code alone
+func (cp *ClusterOpsPlugin) RedeemRegistrationHandler(c *gin.Context) {
+	var req RegistrationExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+
+	registration, err := cp.registrations.Claim(req.Code)
+	if err != nil {
+		c.JSON(http.StatusForbidden, errorResponse(codeForbidden, "Invalid registration code", err.Error()))
+		return
+	}
+	redeemed := false
+	defer func() { cp.registrations.Finish(req.Code, redeemed) }()
+
+	hub, err := cp.lookupHub(registration.Hub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(codeInternal, "Hub of the registration is no longer configured", err.Error()))
+		return
+	}
+
+	manifest, err := cp.renderJoinManifest(withHub(c.Request.Context(), hub), registration.ClusterName, registration.Singleton)
+	if err != nil {
+		cp.logEvent(registration.ClusterName, "registration", "failed", fmt.Sprintf("Registration code %s was not redeemed, the join manifest failed: %v", registration.ID, err))
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to render the join manifest", err.Error()))
+		return
+	}
+	redeemed = true
+	cp.logEvent(registration.ClusterName, "registration", "info", fmt.Sprintf("Registration code %s redeemed from %s", registration.ID, c.ClientIP()))
+	c.Data(http.StatusOK, "application/yaml", manifest)
+}
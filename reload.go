@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// configReloadDebounce collapses the burst of events an editor or a
+// ConfigMap update produces into a single reload
+const configReloadDebounce = 500 * time.Millisecond
+
+// reloadableKeys are the configuration keys a config_file change or
+// PUT /config applies without a restart. Changes to other keys are reported
+// and ignored by reloads and rejected by PUT /config.
+var reloadableKeys = map[string]bool{
+	"log_level":                 true,
+	"access_log":                true,
+	"access_log_sample_percent": true,
+	"timeout":                   true,
+	"retries":                   true,
+	"csr_timeout":               true,
+	"approval_timeout":          true,
+	"join_token_ttl":            true,
+	"registration_code_ttl":     true,
+	"onboard_concurrency":       true,
+	"rate_limit_per_minute":     true,
+	"rate_limit_burst":          true,
+	"slack_webhook_url":         true,
+	"teams_webhook_url":         true,
+	"notify_on":                 true,
+	"pagerduty_routing_key":     true,
+	"opsgenie_api_key":          true,
+	"opsgenie_api_url":          true,
+	"keep_failed_artifacts":     true,
+	"artifact_retention":        true,
+	"event_retention":           true,
+	"event_buffer_size":         true,
+}
+
+// loadConfigFile reads a YAML or JSON configuration file. Its keys may be
+// at the top level or under configuration, as in plugin.yaml.
+func loadConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config_file %s: %v", path, err)
+	}
+	if nested, ok := config["configuration"].(map[string]interface{}); ok {
+		return nested, nil
+	}
+	return config, nil
+}
+
+// effectiveConfig layers the config_file named by the host configuration
+// or the environment, then the KS_PLUGIN_* environment variables, over the
+// host configuration. It returns the keys overridden by the environment.
+func effectiveConfig(host map[string]interface{}) (map[string]interface{}, []string, error) {
+	config := host
+	withEnv, _ := applyEnvOverrides(host, os.Environ())
+	if path, _ := withEnv["config_file"].(string); path != "" {
+		file, err := loadConfigFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		config = make(map[string]interface{}, len(host)+len(file))
+		for key, value := range host {
+			config[key] = value
+		}
+		for key, value := range file {
+			config[key] = value
+		}
+	}
+	merged, overridden := applyEnvOverrides(config, os.Environ())
+	return merged, overridden, nil
+}
+
+// watchConfigFile reloads the configuration whenever config_file changes.
+// The directory is watched rather than the file so that editors replacing
+// the file and ConfigMap volumes swapping their ..data link are noticed.
+func (cp *ClusterOpsPlugin) watchConfigFile(ctx context.Context, path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		cp.logger.Warn("Watching config_file failed", "file", path, "error", err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		cp.logger.Warn("Watching config_file failed", "file", path, "error", err)
+		return
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == filepath.Clean(path) || strings.HasPrefix(filepath.Base(event.Name), "..") {
+				reload = time.After(configReloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			cp.logger.Warn("config_file watch error", "file", path, "error", err)
+		case <-reload:
+			reload = nil
+			cp.reloadConfig(path)
+		}
+	}
+}
+
+// reloadConfig re-reads config_file and applies the changed reloadable
+// keys. A file that does not parse or validate leaves the configuration
+// untouched. Every reload that changes something, or fails, is recorded in
+// the audit trail.
+func (cp *ClusterOpsPlugin) reloadConfig(path string) {
+	cp.mutex.RLock()
+	host := cp.hostConfig
+	current := cp.config
+	cp.mutex.RUnlock()
+
+	next, _, err := effectiveConfig(host)
+	if err == nil {
+		_, err = parsePluginConfig(next)
+	}
+	if err != nil {
+		cp.recordReload(path, nil, nil, err)
+		return
+	}
+
+	updated, applied, ignored := reloadableChanges(current, next)
+	if len(applied) == 0 && len(ignored) == 0 {
+		return
+	}
+	if err := cp.applyConfig(updated); err != nil {
+		cp.recordReload(path, nil, nil, err)
+		return
+	}
+	cp.recordReload(path, applied, ignored, nil)
+}
+
+// reloadableChanges returns current with the reloadable keys that differ in
+// next taken from next, along with the keys applied and the changed keys
+// that only take effect on restart
+func reloadableChanges(current, next map[string]interface{}) (map[string]interface{}, []string, []string) {
+	keys := make(map[string]bool)
+	for key := range current {
+		keys[key] = true
+	}
+	for key := range next {
+		keys[key] = true
+	}
+	updated := make(map[string]interface{}, len(current))
+	for key, value := range current {
+		updated[key] = value
+	}
+	var applied, ignored []string
+	for key := range keys {
+		value, present := next[key]
+		if reflect.DeepEqual(current[key], value) {
+			continue
+		}
+		if !reloadableKeys[key] {
+			ignored = append(ignored, key)
+			continue
+		}
+		applied = append(applied, key)
+		if present {
+			updated[key] = value
+		} else {
+			delete(updated, key)
+		}
+	}
+	sort.Strings(applied)
+	sort.Strings(ignored)
+	return updated, applied, ignored
+}
+
+// applyConfig makes a configuration the running one. A configuration that
+// does not validate is rejected and the running one is kept.
+func (cp *ClusterOpsPlugin) applyConfig(config map[string]interface{}) error {
+	settings, err := parsePluginConfig(config)
+	if err != nil {
+		return err
+	}
+	cp.mutex.Lock()
+	cp.config = config
+	if settings.OnboardConcurrency != cp.pluginConfig.OnboardConcurrency {
+		cp.workers = nil
+	}
+	cp.pluginConfig = settings
+	cp.metrics["config_reloaded_at"] = time.Now().UTC().Format(time.RFC3339)
+	cp.mutex.Unlock()
+	cp.setLogLevel(settings.LogLevel)
+	cp.events.SetCapacity(settings.EventBufferSize)
+	return nil
+}
+
+// recordReload logs a configuration reload and adds it to the audit trail.
+// Only key names are recorded, as values may be credentials.
+func (cp *ClusterOpsPlugin) recordReload(path string, applied, ignored []string, err error) {
+	entry := AuditEntry{
+		Timestamp: time.Now(),
+		Actor:     "config-file",
+		Action:    "reload-config",
+		Payload: map[string]interface{}{
+			"file":    path,
+			"applied": applied,
+			"ignored": ignored,
+		},
+		Commands: []string{},
+		Outcome:  "success",
+	}
+	if err != nil {
+		entry.Outcome = "failure"
+		entry.Payload.(map[string]interface{})["error"] = err.Error()
+		cp.logger.Warn("Configuration reload failed", "file", path, "error", err)
+	} else {
+		cp.logger.Info("Configuration reloaded", "file", path, "applied", strings.Join(applied, ","), "requiresRestart", strings.Join(ignored, ","))
+	}
+	cp.audit.Append(entry)
+}
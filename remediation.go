@@ -0,0 +1,79 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// remediationRule maps a failure signature to a suggestion for the operator.
+// A rule matches when the failed step is one of steps, if any are listed,
+// and the error message contains one of signatures, if any are listed.
+type remediationRule struct {
+	steps      []string
+	signatures []string
+	hint       string
+}
+
+// remediationRules are checked in order, so specific signatures come before
+// the fallbacks keyed on the step alone
+var remediationRules = []remediationRule{
+	{
+		signatures: []string{"executable file not found", "clusteradm: not found", "clusteradm: command not found"},
+		hint:       "clusteradm is not installed on the plugin host. Install it (curl -L https://raw.githubusercontent.com/open-cluster-management-io/clusteradm/main/install.sh | bash), make sure it is on the PATH of the plugin process, and resume the onboarding.",
+	},
+	{
+		steps:      []string{"join"},
+		signatures: []string{"token has expired", "token is expired", "token expired", "invalid bootstrap token", "unauthorized"},
+		hint:       "The hub join token has expired or was revoked. Issue a new one with POST /hub/token/rotate and resume the onboarding.",
+	},
+	{
+		signatures: []string{"x509:", "certificate signed by unknown authority", "certificate has expired", "certificate is not valid"},
+		hint:       "The API server certificate could not be verified. Check that the certificate-authority-data of the kubeconfig matches the API server and that the certificate has not expired, or onboard with validateSSL=false for test clusters only.",
+	},
+	{
+		signatures: []string{"connection refused", "no such host", "i/o timeout", "no route to host"},
+		hint:       "The API server is not reachable from the plugin. Check the server address of the kubeconfig and the network path to it, or onboard with clusterProxy enabled when the cluster sits behind NAT or a firewall.",
+	},
+	{
+		signatures: []string{"forbidden"},
+		hint:       "The kubeconfig credentials lack the permissions onboarding needs. Use credentials bound to cluster-admin on the spoke.",
+	},
+	{
+		steps: []string{"csr"},
+		hint:  "No certificate signing request from the klusterlet was approved in time. Check that the klusterlet pods in the open-cluster-management-agent namespace of the spoke are running and can reach the hub API server, then resume the onboarding.",
+	},
+	{
+		steps: []string{approvalStep.name},
+		hint:  "The onboarding was not approved by an operator within approval_timeout. Resume it and approve it with POST /clusters/:name/approve, or raise approval_timeout.",
+	},
+	{
+		steps: []string{"verify"},
+		hint:  "The cluster joined but the hub does not report it as available. Check the ManagedCluster conditions on the hub and the klusterlet logs on the spoke.",
+	},
+}
+
+// remediationFor returns the suggestion of the first rule matching a failed
+// step and its error message, or "" if none matches
+func remediationFor(step, message string) string {
+	message = strings.ToLower(message)
+	for _, rule := range remediationRules {
+		if len(rule.steps) > 0 && !slices.Contains(rule.steps, step) {
+			continue
+		}
+		if len(rule.signatures) > 0 && !containsAny(message, rule.signatures) {
+			continue
+		}
+		return rule.hint
+	}
+	return ""
+}
+
+// containsAny reports whether s contains one of substrings
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the ID that correlates a request with the log
+// lines, events and operations it produced
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs supplied by callers
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID returns a copy of ctx carrying a request ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom returns the request ID carried by ctx, or ""
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts caller supplied IDs of printable ASCII so they are
+// safe to echo in headers and log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID propagates the X-Request-ID of a request, or generates one,
+// returns it in the response and makes it available to the handler through
+// the request context
+func (cp *ClusterOpsPlugin) withRequestID(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set("requestId", id)
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), id))
+		handler(c)
+	}
+}
+
+// requestIDHandler adds the request ID of the context to every record
+// logged with one
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kubestellar/ui/dynamic_plugins"
+	"github.com/priyanshuharshbodhi1/github-plugin/pkg/rpcplugin"
+)
+
+// rpcServer adapts the plugin to rpcplugin.Plugin when it runs as a separate
+// process. Requests are routed through a gin engine built from the plugin
+// metadata, the way the host mounts the handlers of a loaded .so.
+type rpcServer struct {
+	cp     *ClusterOpsPlugin
+	engine *gin.Engine
+	mutex  sync.Mutex
+}
+
+func (s *rpcServer) Initialize(config map[string]interface{}) error {
+	return s.cp.Initialize(config)
+}
+
+func (s *rpcServer) Metadata() (dynamic_plugins.PluginMetadata, error) {
+	return s.cp.GetMetadata(), nil
+}
+
+func (s *rpcServer) router() *gin.Engine {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.engine == nil {
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		handlers := s.cp.GetHandlers()
+		for _, endpoint := range s.cp.GetMetadata().Endpoints {
+			if handler, ok := handlers[endpoint.Handler]; ok {
+				engine.Handle(endpoint.Method, pluginAPIBase+endpoint.Path, handler)
+			}
+		}
+		s.engine = engine
+	}
+	return s.engine
+}
+
+func (s *rpcServer) Handle(req rpcplugin.Request) (rpcplugin.Response, error) {
+	httpReq, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return rpcplugin.Response{}, err
+	}
+	httpReq.Header = req.Header
+	httpReq.RemoteAddr = req.RemoteAddr
+
+	recorder := httptest.NewRecorder()
+	s.router().ServeHTTP(recorder, httpReq)
+	return rpcplugin.Response{
+		Status: recorder.Code,
+		Header: recorder.Header(),
+		Body:   recorder.Body.Bytes(),
+	}, nil
+}
+
+func (s *rpcServer) Health() error {
+	return s.cp.Health()
+}
+
+func (s *rpcServer) Cleanup() error {
+	return s.cp.Cleanup()
+}
+
+// main runs the plugin out of process for hosts using go-plugin; hosts
+// loading the plugin as a .so never call it
+func main() {
+	rpcplugin.Serve(&rpcServer{cp: NewPlugin().(*ClusterOpsPlugin)})
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Secret manager providers accepted in kubeconfigRef
+const (
+	providerAWS   = "aws"
+	providerGCP   = "gcp"
+	providerAzure = "azure"
+)
+
+const secretManagerTimeout = 15 * time.Second
+
+// KubeconfigRef locates a kubeconfig in a cloud secret manager. Name is the
+// secret ID or ARN for AWS, "projects/<project>/secrets/<secret>" or a bare
+// secret name (with gcp_project) for GCP, and "<vault>/<secret>" for Azure
+// Key Vault. Version defaults to the current version.
+type KubeconfigRef struct {
+	Provider string `json:"provider"`
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+}
+
+func (ref *KubeconfigRef) validate() error {
+	if ref.Name == "" {
+		return fmt.Errorf("kubeconfigRef requires a name")
+	}
+	switch ref.Provider {
+	case providerAWS, providerGCP:
+	case providerAzure:
+		if vault, secret, ok := strings.Cut(ref.Name, "/"); !ok || vault == "" || secret == "" {
+			return fmt.Errorf("Azure kubeconfigRef names must be <vault>/<secret>")
+		}
+	default:
+		return fmt.Errorf("unsupported provider %q, expected aws, gcp or azure", ref.Provider)
+	}
+	return nil
+}
+
+// fetchSecretManagerKubeconfig resolves a kubeconfigRef with the ambient
+// credentials of the plugin host
+func (cp *ClusterOpsPlugin) fetchSecretManagerKubeconfig(ctx context.Context, ref KubeconfigRef) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretManagerTimeout)
+	defer cancel()
+
+	switch ref.Provider {
+	case providerAWS:
+		return fetchAWSSecret(ctx, ref)
+	case providerGCP:
+		return fetchGCPSecret(ctx, ref, cp.gcpProject(), cp.gcpCredentialsFile())
+	case providerAzure:
+		return fetchAzureSecret(ctx, ref)
+	}
+	return "", fmt.Errorf("unsupported provider %q", ref.Provider)
+}
+
+// httpJSON sends a request and decodes a JSON response, failing on non-2xx
+func httpJSON(req *http.Request, out interface{}) error {
+	client := &http.Client{Timeout: secretManagerTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with status %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "xml") {
+		return xml.Unmarshal(data, out)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// awsCredentials are temporary or long-lived AWS credentials
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// ambientAWSCredentials follows the usual AWS lookup order: environment
+// variables, then a web identity token (IRSA on EKS), then the EC2 instance
+// metadata service
+func ambientAWSCredentials(ctx context.Context, region string) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{id, os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	if tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); tokenFile != "" && roleARN != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("failed to read web identity token: %v", err)
+		}
+		query := url.Values{
+			"Action":           {"AssumeRoleWithWebIdentity"},
+			"Version":          {"2011-06-15"},
+			"RoleArn":          {roleARN},
+			"RoleSessionName":  {"cluster-ops-plugin"},
+			"WebIdentityToken": {strings.TrimSpace(string(token))},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://sts.%s.amazonaws.com/", region), strings.NewReader(query.Encode()))
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		var resp struct {
+			Credentials struct {
+				AccessKeyID     string `xml:"AccessKeyId"`
+				SecretAccessKey string `xml:"SecretAccessKey"`
+				SessionToken    string `xml:"SessionToken"`
+			} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		}
+		if err := httpJSON(req, &resp); err != nil {
+			return awsCredentials{}, fmt.Errorf("AssumeRoleWithWebIdentity failed: %v", err)
+		}
+		return awsCredentials{resp.Credentials.AccessKeyID, resp.Credentials.SecretAccessKey, resp.Credentials.SessionToken}, nil
+	}
+
+	// IMDSv2 requires a session token before reading credentials
+	const imds = "http://169.254.169.254/latest"
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found: %v", err)
+	}
+	imdsToken, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	get := func(path string) (string, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, imds+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", string(imdsToken))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("instance metadata responded with status %d", resp.StatusCode)
+		}
+		return strings.TrimSpace(string(body)), err
+	}
+	role, err := get("/meta-data/iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found: %v", err)
+	}
+	document, err := get("/meta-data/iam/security-credentials/" + strings.SplitN(role, "\n", 2)[0])
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found: %v", err)
+	}
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal([]byte(document), &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid instance credentials: %v", err)
+	}
+	return awsCredentials{creds.AccessKeyID, creds.SecretAccessKey, creds.Token}, nil
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-date"}
+	if creds.sessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+	if req.Header.Get("X-Amz-Target") != "" {
+		signedHeaders = append(signedHeaders, "x-amz-target")
+	}
+	var canonicalHeaders strings.Builder
+	for _, header := range signedHeaders {
+		value := req.Header.Get(header)
+		if header == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(header + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signature := awsSignature(creds, date, region, service, stringToSign)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// awsSignature signs a Signature Version 4 string to sign with the key
+// derived for a date, region and service
+func awsSignature(creds awsCredentials, date, region, service, stringToSign string) string {
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// ambientAWSRegion returns the region configured in the environment
+func ambientAWSRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// fetchAWSSecret reads a secret string from AWS Secrets Manager in AWS_REGION
+func fetchAWSSecret(ctx context.Context, ref KubeconfigRef) (string, error) {
+	region := ambientAWSRegion()
+	if region == "" {
+		return "", fmt.Errorf("AWS_REGION is not set")
+	}
+	creds, err := ambientAWSCredentials(ctx, region)
+	if err != nil {
+		return "", err
+	}
+
+	input := map[string]string{"SecretId": ref.Name}
+	if ref.Version != "" {
+		input["VersionId"] = ref.Version
+	}
+	body, _ := json.Marshal(input)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region), strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, region, "secretsmanager", time.Now())
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary string `json:"SecretBinary"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.SecretString != "" {
+		return resp.SecretString, nil
+	}
+	binary, err := base64.StdEncoding.DecodeString(resp.SecretBinary)
+	if err != nil || len(binary) == 0 {
+		return "", fmt.Errorf("AWS secret %s is empty", ref.Name)
+	}
+	return string(binary), nil
+}
+
+// fetchGCPSecret reads a secret version from GCP Secret Manager
+func fetchGCPSecret(ctx context.Context, ref KubeconfigRef, project, credentialsFile string) (string, error) {
+	name := ref.Name
+	if !strings.HasPrefix(name, "projects/") {
+		if project == "" {
+			return "", fmt.Errorf("GCP secret %q needs a project: use projects/<project>/secrets/<secret> or set gcp_project", name)
+		}
+		name = fmt.Sprintf("projects/%s/secrets/%s", project, name)
+	}
+	version := ref.Version
+	if version == "" {
+		version = "latest"
+	}
+
+	token, err := gcpAccessToken(ctx, credentialsFile)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://secretmanager.googleapis.com/v1/%s/versions/%s:access", name, version), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil || len(data) == 0 {
+		return "", fmt.Errorf("GCP secret %s is empty", name)
+	}
+	return string(data), nil
+}
+
+// Azure resources the plugin requests access tokens for
+const (
+	azureKeyVaultResource   = "https://vault.azure.net"
+	azureManagementResource = "https://management.azure.com"
+)
+
+// azureToken gets an access token for an Azure resource, following the
+// lookup order of the Azure SDKs: a service principal secret in the
+// environment, then workload identity, then the managed identity endpoint
+func azureToken(ctx context.Context, resource string) (string, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	secret, tokenFile := os.Getenv("AZURE_CLIENT_SECRET"), os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	if secret != "" || tokenFile != "" {
+		form := url.Values{
+			"grant_type": {"client_credentials"},
+			"client_id":  {os.Getenv("AZURE_CLIENT_ID")},
+			"scope":      {resource + "/.default"},
+		}
+		if secret != "" {
+			form.Set("client_secret", secret)
+		} else {
+			assertion, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return "", fmt.Errorf("failed to read federated token: %v", err)
+			}
+			form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+			form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+		}
+		authority := os.Getenv("AZURE_AUTHORITY_HOST")
+		if authority == "" {
+			authority = "https://login.microsoftonline.com/"
+		}
+		endpoint := strings.TrimSuffix(authority, "/") + "/" + os.Getenv("AZURE_TENANT_ID") + "/oauth2/v2.0/token"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := httpJSON(req, &token); err != nil {
+			return "", fmt.Errorf("Azure AD token exchange failed: %v", err)
+		}
+		return token.AccessToken, nil
+	}
+
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	if err := httpJSON(req, &token); err != nil {
+		return "", fmt.Errorf("no Azure credentials found: %v", err)
+	}
+	return token.AccessToken, nil
+}
+
+// fetchAzureSecret reads a secret from Azure Key Vault
+func fetchAzureSecret(ctx context.Context, ref KubeconfigRef) (string, error) {
+	vault, secret, _ := strings.Cut(ref.Name, "/")
+	token, err := azureToken(ctx, azureKeyVaultResource)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := fmt.Sprintf("https://%s.vault.azure.net/secrets/%s", vault, url.PathEscape(secret))
+	if ref.Version != "" {
+		endpoint += "/" + url.PathEscape(ref.Version)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?api-version=7.4", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := httpJSON(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.Value == "" {
+		return "", fmt.Errorf("Azure secret %s is empty", ref.Name)
+	}
+	return resp.Value, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// selfTestTimeout bounds a whole self-test run
+const selfTestTimeout = 2 * time.Minute
+
+// selfTestCluster is the name of the cluster the self-test onboards
+const selfTestCluster = "selftest"
+
+// selfTestStates are the states an onboarding passes through, in order
+var selfTestStates = []ClusterState{StatePending, StateJoining, StateAwaitingCSR, StateVerifying, StateOnboarded}
+
+// SelfTestCheck is the outcome of one stage of the self-test
+type SelfTestCheck struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	DurationMs int64  `json:"durationMs"`
+	Message    string `json:"message"`
+}
+
+// SelfTestReport is the diagnostic report of a self-test run. Stages stop
+// at the first failure; the states, events and operations recorded up to
+// then are included to show where the pipeline went wrong.
+type SelfTestReport struct {
+	Passed     bool              `json:"passed"`
+	DurationMs int64             `json:"durationMs"`
+	Checks     []SelfTestCheck   `json:"checks"`
+	States     []ClusterState    `json:"states"`
+	Events     []OnboardingEvent `json:"events"`
+	Operations []Operation       `json:"operations"`
+}
+
+// newSelfTestPlugin returns a plugin of its own in simulation mode, with a
+// temporary kubeconfig_dir and no bus, state store or notifications, so a
+// self-test runs the real pipeline code without touching the hubs or the
+// clusters tracked by cp
+func (cp *ClusterOpsPlugin) newSelfTestPlugin(dir string) (*ClusterOpsPlugin, error) {
+	config := map[string]interface{}{
+		"simulation_mode": true,
+		"kubeconfig_dir":  dir,
+	}
+	settings, err := parsePluginConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	hubs, err := parseHubs(config, settings.ITSContext)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch := NewPlugin().(*ClusterOpsPlugin)
+	scratch.config = config
+	scratch.pluginConfig = settings
+	scratch.hubs = hubs
+	scratch.simulator = newHubSimulator()
+	scratch.runner = scratch.simulator
+	scratch.hub = &cliHubClient{runner: scratch.runner, flags: scratch.hubFlags}
+	scratch.hubClients = scratch.simulator.hubClient
+	scratch.logger = cp.logger.With("selftest", true)
+	scratch.initialized = true
+	return scratch, nil
+}
+
+// waitForOperation polls an operation until it reaches a terminal status
+func waitForOperation(ctx context.Context, operations *operationStore, id string) (Operation, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		op, ok := operations.Get(id)
+		if !ok {
+			return op, errOperationNotFound
+		}
+		if op.isTerminal() {
+			return op, nil
+		}
+		select {
+		case <-ctx.Done():
+			return op, fmt.Errorf("operation %s still %s: %w", id, op.Status, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// runSelfTest onboards and detaches a simulated cluster on a scratch plugin,
+// checking the join token, the state transitions and the event stream
+func (cp *ClusterOpsPlugin) runSelfTest(ctx context.Context) SelfTestReport {
+	started := time.Now()
+	report := SelfTestReport{Checks: []SelfTestCheck{}}
+	record := func(name string, start time.Time, message string, err error) bool {
+		check := SelfTestCheck{Name: name, Passed: err == nil, DurationMs: time.Since(start).Milliseconds(), Message: message}
+		if err != nil {
+			check.Message = err.Error()
+		}
+		report.Checks = append(report.Checks, check)
+		return err == nil
+	}
+
+	dir, err := os.MkdirTemp("", "cluster-ops-selftest-")
+	if err != nil {
+		record("setup", started, "", err)
+		report.DurationMs = time.Since(started).Milliseconds()
+		return report
+	}
+	defer os.RemoveAll(dir)
+	scratch, err := cp.newSelfTestPlugin(dir)
+	if err != nil {
+		record("setup", started, "", err)
+		report.DurationMs = time.Since(started).Milliseconds()
+		return report
+	}
+
+	var mutex sync.Mutex
+	scratch.clusters.onTransition = func(cluster ClusterRecord, from ClusterState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		report.States = append(report.States, cluster.State)
+	}
+	// Events are delivered before the operation finishes, and an onboarding
+	// emits far fewer than eventSubscriberBuffer, so the stream is read once
+	// the onboarding is done
+	_, stream, unsubscribe := scratch.events.Subscribe(selfTestCluster)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	var onboardID string
+
+	stages := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"join-token", func() (string, error) {
+			token, err := scratch.joinToken(withHub(ctx, scratch.hubs[0]))
+			if err != nil {
+				return "", err
+			}
+			if token.Token == "" || !token.ExpiresAt.After(time.Now()) {
+				return "", fmt.Errorf("hub returned an empty or expired join token")
+			}
+			return fmt.Sprintf("Join token for %s valid until %s", token.HubAPIServer, token.ExpiresAt.UTC().Format(time.RFC3339)), nil
+		}},
+		{"onboard", func() (string, error) {
+			op, rejected := scratch.beginOnboarding(ctx, "", ClusterOnboardRequest{
+				ClusterName: selfTestCluster,
+				Server:      "https://" + selfTestCluster + ".invalid:6443",
+				Token:       "selftest",
+			})
+			if rejected != nil {
+				return "", fmt.Errorf("onboarding rejected: %s", rejected.message())
+			}
+			onboardID = op.ID
+			if op, err = waitForOperation(ctx, scratch.operations, op.ID); err != nil {
+				return "", err
+			}
+			if op.Status != OperationSucceeded {
+				return "", fmt.Errorf("onboarding %s: %s", op.Status, op.Error)
+			}
+			return op.Result, nil
+		}},
+		{"state-transitions", func() (string, error) {
+			mutex.Lock()
+			states := append([]ClusterState(nil), report.States...)
+			mutex.Unlock()
+			next := 0
+			for _, state := range states {
+				if next < len(selfTestStates) && state == selfTestStates[next] {
+					next++
+				}
+			}
+			if next < len(selfTestStates) {
+				return "", fmt.Errorf("cluster went through %v, never reaching %s", states, selfTestStates[next])
+			}
+			return fmt.Sprintf("Cluster went through %v", states), nil
+		}},
+		{"event-stream", func() (string, error) {
+			var events []OnboardingEvent
+			for drained := false; !drained; {
+				select {
+				case event := <-stream:
+					events = append(events, event)
+				default:
+					drained = true
+				}
+			}
+			if len(events) == 0 {
+				return "", fmt.Errorf("no events were streamed")
+			}
+			completed := false
+			for i, event := range events {
+				if i > 0 && event.ID != events[i-1].ID+1 {
+					return "", fmt.Errorf("event %d followed event %d", event.ID, events[i-1].ID)
+				}
+				if event.OperationID == onboardID && event.Type == "onboard" && event.Status == "success" {
+					completed = true
+				}
+			}
+			if !completed {
+				return "", fmt.Errorf("the %d streamed events do not include the completion of operation %s", len(events), onboardID)
+			}
+			return fmt.Sprintf("%d events streamed in order", len(events)), nil
+		}},
+		{"detach", func() (string, error) {
+			op, rejected := scratch.beginDetach(ctx, "", selfTestCluster, "", detachOptions{})
+			if rejected != nil {
+				return "", fmt.Errorf("detachment rejected: %s", rejected.message())
+			}
+			if op, err = waitForOperation(ctx, scratch.operations, op.ID); err != nil {
+				return "", err
+			}
+			if op.Status != OperationSucceeded {
+				return "", fmt.Errorf("detachment %s: %s", op.Status, op.Error)
+			}
+			if _, ok := scratch.clusters.Get(selfTestCluster); ok {
+				return "", fmt.Errorf("cluster is still tracked after detachment")
+			}
+			return op.Result, nil
+		}},
+	}
+
+	report.Passed = true
+	for _, stage := range stages {
+		start := time.Now()
+		message, err := stage.run()
+		if !record(stage.name, start, message, err) {
+			report.Passed = false
+			break
+		}
+	}
+
+	report.Events = scratch.events.List(selfTestCluster)
+	report.Operations = scratch.operations.List()
+	report.DurationMs = time.Since(started).Milliseconds()
+	return report
+}
+
+func (cp *ClusterOpsPlugin) SelfTestHandler(c *gin.Context) {
+	report := cp.runSelfTest(c.Request.Context())
+	status := http.StatusOK
+	if !report.Passed {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"report": report,
+		"plugin": "cluster-ops-plugin",
+	})
+}
@@ -0,0 +1,1163 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// simulatedTransitionDelay is the pause between the transitions of a
+	// simulated ManagedCluster, and how long a joining agent waits to be
+	// accepted before the simulated hub accepts it on its own
+	simulatedTransitionDelay = 3 * time.Second
+	// simulatedHubServer is the API server URL of every simulated hub
+	simulatedHubServer = "https://hub.simulated.local:6443"
+	// simulatedKubernetesVersion is reported by simulated clusters
+	simulatedKubernetesVersion = "v1.30.0"
+	// simulatedHistorySize is how many changes the simulator keeps for
+	// informer watches that resume from a resourceVersion
+	simulatedHistorySize = 1024
+)
+
+// simulatedClusterScoped are the resources the simulated hub stores without
+// a namespace
+var simulatedClusterScoped = map[string]bool{
+	"managedcluster":            true,
+	"managedclusterset":         true,
+	"certificatesigningrequest": true,
+	"clustermanagementaddon":    true,
+	"customresourcedefinition":  true,
+	"namespace":                 true,
+	"controlplane":              true,
+	"klusterlet":                true,
+}
+
+// kubectlValueFlags are the kubectl and clusteradm flags that take a
+// separate value
+var kubectlValueFlags = map[string]bool{
+	"-n": true, "--namespace": true, "-o": true, "--output": true,
+	"-l": true, "--selector": true, "-f": true, "--filename": true,
+	"-p": true, "--patch": true, "--type": true, "--context": true,
+	"--kubeconfig": true, "--field-manager": true, "--subresource": true,
+	"--clusters": true, "--cluster-name": true, "--hub-token": true,
+	"--hub-apiserver": true, "--image-registry": true, "--proxy-url": true,
+	"--proxy-ca-file": true, "--resource-qos-class": true,
+	"--resource-requests": true, "--resource-limits": true,
+	"--field-selector": true,
+}
+
+// simulatedSpokePrefix marks the address of a simulated spoke, which is
+// addressed through the kubeconfig of an onboarding instead of a hub context
+const simulatedSpokePrefix = "spoke:"
+
+// hubSimulator is the CommandRunner of simulation_mode. It answers kubectl
+// and clusteradm from in-memory hubs, one per kubeconfig context, and from
+// in-memory spokes, one per onboarding kubeconfig. It plays the
+// registration agent of every cluster that joins: its CSR and
+// ManagedCluster appear, get accepted, join and become available a few
+// seconds apart, with watch events for each change. Nothing is run on the
+// plugin host.
+type hubSimulator struct {
+	objects   map[string]map[string]interface{}
+	version   int
+	watches   map[int]*simulatedWatch
+	nextWatch int
+	agents    map[string]context.CancelFunc
+	// tokens maps the service account tokens handed out to the hub that
+	// issued them
+	tokens map[string]string
+	// seeded records the hubs given the objects clusteradm init creates
+	seeded map[string]bool
+	// history holds the latest changes, oldest first
+	history []simulatedEvent
+	// spokes maps each joined spoke to the agent it runs
+	spokes map[string]string
+	mutex  sync.Mutex
+}
+
+// simulatedEvent is a change to an object of a simulated hub
+type simulatedEvent struct {
+	hub       string
+	eventType string
+	object    map[string]interface{}
+}
+
+// simulatedWatch is a running kubectl get --watch, or the watch of an
+// informer
+type simulatedWatch struct {
+	hub       string
+	resource  string
+	namespace string
+	selector  []labelRequirement
+	events    chan []byte
+}
+
+func newHubSimulator() *hubSimulator {
+	return &hubSimulator{
+		objects: make(map[string]map[string]interface{}),
+		watches: make(map[int]*simulatedWatch),
+		agents:  make(map[string]context.CancelFunc),
+		tokens:  make(map[string]string),
+		seeded:  make(map[string]bool),
+		spokes:  make(map[string]string),
+	}
+}
+
+// kubectlCall is a parsed kubectl command line
+type kubectlCall struct {
+	args  []string
+	flags map[string]string
+}
+
+func parseKubectlArgs(args []string) kubectlCall {
+	call := kubectlCall{flags: make(map[string]string)}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			call.args = append(call.args, arg)
+			continue
+		}
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			call.flags[name] = value
+			continue
+		}
+		// --raw takes a path with get and is a plain flag with config view
+		if arg == "--raw" && i+1 < len(args) && strings.HasPrefix(args[i+1], "/") {
+			call.flags[arg] = args[i+1]
+			i++
+			continue
+		}
+		if kubectlValueFlags[arg] && i+1 < len(args) {
+			call.flags[arg] = args[i+1]
+			i++
+			continue
+		}
+		call.flags[arg] = "true"
+	}
+	return call
+}
+
+// address returns the hub or spoke a command runs against. Spokes are
+// addressed through the kubeconfig an onboarding writes to its working
+// directory and told apart by its current context.
+func (k kubectlCall) address() string {
+	path := k.flag("--kubeconfig")
+	if path == "" || filepath.Base(path) != spokeKubeconfigFile {
+		return k.flag("--context")
+	}
+	var file kubeconfigFile
+	data, _ := os.ReadFile(path)
+	yaml.Unmarshal(data, &file)
+	return simulatedSpokePrefix + firstNonEmpty(file.CurrentContext, path)
+}
+
+func (k kubectlCall) flag(names ...string) string {
+	for _, name := range names {
+		if value, ok := k.flags[name]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// target returns the resource and names of a command such as
+// "get secret a b" or "delete secret/a"
+func (k kubectlCall) target(from int) (string, []string) {
+	if len(k.args) <= from {
+		return "", nil
+	}
+	resource, name, ok := strings.Cut(k.args[from], "/")
+	if ok {
+		return simulatedResource(resource), []string{name}
+	}
+	return simulatedResource(resource), k.args[from+1:]
+}
+
+// simulatedResource normalizes a kubectl resource name, or an object kind,
+// to the singular lower-case name the simulated hub stores it under
+func simulatedResource(name string) string {
+	name = strings.ToLower(strings.SplitN(name, ".", 2)[0])
+	if name == "csr" {
+		return "certificatesigningrequest"
+	}
+	return strings.TrimSuffix(name, "s")
+}
+
+func simulatedKey(hub, resource, namespace, name string) string {
+	if simulatedClusterScoped[resource] {
+		namespace = ""
+	}
+	return hub + "|" + resource + "|" + namespace + "|" + name
+}
+
+func notFoundError(resource, name string) error {
+	return fmt.Errorf("exit status 1: Error from server (NotFound): %s %q not found", resource, name)
+}
+
+func (s *hubSimulator) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	switch cmd.Name {
+	case "kubectl":
+		return s.kubectl(parseKubectlArgs(cmd.Args), cmd.Stdin)
+	case "clusteradm":
+		return s.clusteradm(parseKubectlArgs(cmd.Args))
+	default:
+		return nil, fmt.Errorf("%s is not available in simulation mode", cmd.Name)
+	}
+}
+
+// Stream serves kubectl get --watch: the matching objects are sent as ADDED
+// events, followed by every later change, until ctx is cancelled
+func (s *hubSimulator) Stream(ctx context.Context, cmd Command) (io.Reader, func() error, error) {
+	call := parseKubectlArgs(cmd.Args)
+	if cmd.Name != "kubectl" || len(call.args) < 2 || call.args[0] != "get" || call.flag("--watch", "-w") == "" {
+		return nil, nil, fmt.Errorf("%s cannot be streamed in simulation mode", cmd)
+	}
+	resource, _ := call.target(1)
+	selector, err := parseLabelSelector(call.flag("-l", "--selector"))
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &simulatedWatch{
+		hub:      call.flag("--context"),
+		resource: resource,
+		selector: selector,
+	}
+	if call.flag("-A", "--all-namespaces") == "" {
+		w.namespace = firstNonEmpty(call.flag("-n", "--namespace"), "default")
+	}
+
+	s.mutex.Lock()
+	existing := s.list(w.hub, resource, w.namespace, selector)
+	w.events = make(chan []byte, len(existing)+256)
+	for _, obj := range existing {
+		w.events <- watchEvent("ADDED", obj)
+	}
+	id := s.nextWatch
+	s.nextWatch++
+	s.watches[id] = w
+	s.mutex.Unlock()
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watches, id)
+			s.mutex.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				writer.CloseWithError(ctx.Err())
+				return
+			case event := <-w.events:
+				if _, err := writer.Write(event); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return reader, func() error { return nil }, nil
+}
+
+func (s *hubSimulator) LookPath(name string) (string, error) {
+	if name == "kubectl" || name == "clusteradm" {
+		return "/simulated/bin/" + name, nil
+	}
+	return "", fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+}
+
+func watchEvent(eventType string, obj map[string]interface{}) []byte {
+	event, _ := json.Marshal(map[string]interface{}{"type": eventType, "object": obj})
+	return append(event, '\n')
+}
+
+// notify sends a watch event to the matching watches; it is called with the
+// simulator locked
+func (s *hubSimulator) notify(hub, eventType string, obj map[string]interface{}) {
+	s.history = append(s.history, simulatedEvent{hub: hub, eventType: eventType, object: obj})
+	if len(s.history) > simulatedHistorySize {
+		s.history = s.history[len(s.history)-simulatedHistorySize:]
+	}
+	for _, w := range s.watches {
+		if !w.matches(hub, obj) {
+			continue
+		}
+		select {
+		case w.events <- watchEvent(eventType, obj):
+		default:
+		}
+	}
+}
+
+func (w *simulatedWatch) matches(hub string, obj map[string]interface{}) bool {
+	resource := simulatedResource(stringField(obj, "kind"))
+	if w.hub != hub || w.resource != resource {
+		return false
+	}
+	namespace, _ := objectMetadata(obj)["namespace"].(string)
+	if w.namespace != "" && !simulatedClusterScoped[resource] && w.namespace != namespace {
+		return false
+	}
+	return matchLabels(w.selector, objectLabels(obj))
+}
+
+// startWatch starts an informer watch from a resourceVersion. A resourceVersion
+// older than the history fails as expired, so the informer lists again.
+func (s *hubSimulator) startWatch(ctx context.Context, w *simulatedWatch, resourceVersion string) (watch.Interface, error) {
+	from, _ := strconv.Atoi(resourceVersion)
+
+	s.mutex.Lock()
+	var replay [][]byte
+	if from > 0 && from < s.version {
+		oldest := s.version + 1
+		if len(s.history) > 0 {
+			oldest, _ = strconv.Atoi(objectMetadata(s.history[0].object)["resourceVersion"].(string))
+		}
+		if from+1 < oldest {
+			s.mutex.Unlock()
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", from, oldest-1))
+		}
+		for _, event := range s.history {
+			version, _ := strconv.Atoi(objectMetadata(event.object)["resourceVersion"].(string))
+			if version > from && w.matches(event.hub, event.object) {
+				replay = append(replay, watchEvent(event.eventType, event.object))
+			}
+		}
+	}
+	w.events = make(chan []byte, len(replay)+256)
+	for _, event := range replay {
+		w.events <- event
+	}
+	id := s.nextWatch
+	s.nextWatch++
+	s.watches[id] = w
+	s.mutex.Unlock()
+
+	results := make(chan watch.Event)
+	proxy := watch.NewProxyWatcher(results)
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watches, id)
+			s.mutex.Unlock()
+			close(results)
+		}()
+		for {
+			var data []byte
+			select {
+			case <-ctx.Done():
+				return
+			case <-proxy.StopChan():
+				return
+			case data = <-w.events:
+			}
+			var event struct {
+				Type   watch.EventType        `json:"type"`
+				Object map[string]interface{} `json:"object"`
+			}
+			if err := json.Unmarshal(data, &event); err != nil {
+				continue
+			}
+			select {
+			case results <- watch.Event{Type: event.Type, Object: &unstructured.Unstructured{Object: event.Object}}:
+			case <-ctx.Done():
+				return
+			case <-proxy.StopChan():
+				return
+			}
+		}
+	}()
+	return proxy, nil
+}
+
+// list returns the objects of a resource sorted by namespace and name; an
+// empty namespace lists all of them
+func (s *hubSimulator) list(hub, resource, namespace string, selector []labelRequirement) []map[string]interface{} {
+	prefix := hub + "|" + resource + "|"
+	if namespace != "" && !simulatedClusterScoped[resource] {
+		prefix += namespace + "|"
+	}
+	var keys []string
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) && matchLabels(selector, objectLabels(obj)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	items := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, s.objects[key])
+	}
+	return items
+}
+
+// store saves an object, assigning its resourceVersion, and notifies the
+// watches; it is called with the simulator locked
+func (s *hubSimulator) store(hub string, obj map[string]interface{}) (created bool) {
+	resource := simulatedResource(stringField(obj, "kind"))
+	metadata := objectMetadata(obj)
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	if namespace == "" && !simulatedClusterScoped[resource] {
+		namespace = "default"
+		metadata["namespace"] = namespace
+	}
+	key := simulatedKey(hub, resource, namespace, name)
+
+	existing, exists := s.objects[key]
+	if exists {
+		old := objectMetadata(existing)
+		metadata["uid"] = old["uid"]
+		metadata["creationTimestamp"] = old["creationTimestamp"]
+		if _, ok := obj["status"]; !ok && existing["status"] != nil {
+			obj["status"] = existing["status"]
+		}
+	} else {
+		metadata["uid"] = randomHex(16)
+		metadata["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	s.version++
+	metadata["resourceVersion"] = strconv.Itoa(s.version)
+	s.objects[key] = obj
+
+	eventType := "MODIFIED"
+	if !exists {
+		eventType = "ADDED"
+	}
+	s.notify(hub, eventType, obj)
+	return !exists
+}
+
+// remove deletes an object and notifies the watches. An object with
+// finalizers is only marked for deletion, and is removed once a patch
+// clears them. It is called with the simulator locked.
+func (s *hubSimulator) remove(hub, resource, namespace, name string) bool {
+	key := simulatedKey(hub, resource, namespace, name)
+	obj, ok := s.objects[key]
+	if !ok {
+		return false
+	}
+	if finalizers, _ := objectMetadata(obj)["finalizers"].([]interface{}); len(finalizers) > 0 {
+		terminating := deepCopyObject(obj).(map[string]interface{})
+		metadata := objectMetadata(terminating)
+		if metadata["deletionTimestamp"] == nil {
+			metadata["deletionTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+		}
+		s.store(hub, terminating)
+		return true
+	}
+	delete(s.objects, key)
+	deleted := deepCopyObject(obj).(map[string]interface{})
+	s.version++
+	objectMetadata(deleted)["resourceVersion"] = strconv.Itoa(s.version)
+	s.notify(hub, "DELETED", deleted)
+	return true
+}
+
+// removeNamespace deletes the objects in a namespace, as the namespace
+// controller does once the namespace is deleted; it is called with the
+// simulator locked
+func (s *hubSimulator) removeNamespace(hub, namespace string) {
+	for key, obj := range s.objects {
+		if !strings.HasPrefix(key, hub+"|") {
+			continue
+		}
+		metadata := objectMetadata(obj)
+		if metadata["namespace"] == namespace {
+			name, _ := metadata["name"].(string)
+			s.remove(hub, simulatedResource(stringField(obj, "kind")), namespace, name)
+		}
+	}
+}
+
+func (s *hubSimulator) kubectl(call kubectlCall, stdin []byte) ([]byte, error) {
+	if len(call.args) == 0 {
+		return nil, fmt.Errorf("no kubectl command")
+	}
+	hub := call.address()
+	namespace := firstNonEmpty(call.flag("-n", "--namespace"), "default")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !strings.HasPrefix(hub, simulatedSpokePrefix) {
+		s.seedHub(hub)
+	}
+
+	switch call.args[0] {
+	case "get":
+		if path := call.flag("--raw"); path != "" {
+			if path == "/version" {
+				return json.Marshal(map[string]string{"gitVersion": simulatedKubernetesVersion})
+			}
+			return []byte("ok"), nil
+		}
+		resource, names := call.target(1)
+		if len(names) > 0 {
+			obj, ok := s.objects[simulatedKey(hub, resource, namespace, names[0])]
+			if !ok {
+				if call.flag("--ignore-not-found") != "" {
+					return nil, nil
+				}
+				return nil, notFoundError(resource, names[0])
+			}
+			if output := call.flag("-o", "--output"); strings.HasPrefix(output, "jsonpath=") {
+				return []byte(simpleJSONPath(obj, output)), nil
+			}
+			return json.Marshal(obj)
+		}
+		if call.flag("-A", "--all-namespaces") != "" {
+			namespace = ""
+		}
+		selector, err := parseLabelSelector(call.flag("-l", "--selector"))
+		if err != nil {
+			return nil, err
+		}
+		items := s.list(hub, resource, namespace, selector)
+		if fields := call.flag("--field-selector"); fields != "" {
+			items = slices.DeleteFunc(items, func(obj map[string]interface{}) bool {
+				return !matchFields(fields, obj)
+			})
+		}
+		return json.Marshal(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      items,
+		})
+
+	case "apply", "create":
+		objects, err := decodeManifest(stdin)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		for _, obj := range objects {
+			metadata := objectMetadata(obj)
+			name, _ := metadata["name"].(string)
+			if name == "" {
+				if prefix, _ := metadata["generateName"].(string); prefix != "" {
+					name = prefix + randomHex(3)
+					metadata["name"] = name
+				}
+			}
+			resource := simulatedResource(stringField(obj, "kind"))
+			if resource == "" || name == "" {
+				return nil, fmt.Errorf("exit status 1: error: object has no kind or name")
+			}
+			objNamespace, _ := metadata["namespace"].(string)
+			if _, exists := s.objects[simulatedKey(hub, resource, firstNonEmpty(objNamespace, namespace), name)]; exists && call.args[0] == "create" {
+				return nil, fmt.Errorf("exit status 1: Error from server (AlreadyExists): %s %q already exists", resource, name)
+			}
+			if objNamespace == "" {
+				metadata["namespace"] = namespace
+			}
+			normalizeSecret(obj)
+			action := "configured"
+			if s.store(hub, obj) {
+				action = "created"
+			}
+			fmt.Fprintf(&out, "%s/%s %s\n", resource, name, action)
+		}
+		return []byte(out.String()), nil
+
+	case "patch":
+		resource, names := call.target(1)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("exit status 1: error: resource name may not be empty")
+		}
+		if patchType := call.flag("--type"); patchType == "json" {
+			return nil, fmt.Errorf("exit status 1: JSON patches are not supported in simulation mode")
+		}
+		var patch map[string]interface{}
+		if err := json.Unmarshal([]byte(call.flag("-p", "--patch")), &patch); err != nil {
+			return nil, fmt.Errorf("exit status 1: error: unable to parse the patch: %v", err)
+		}
+		patched, err := s.patchObject(hub, schema.GroupResource{Resource: resource}, resource, namespace, names[0], patch)
+		if err != nil {
+			return nil, kubectlError(err)
+		}
+		if call.flag("-o", "--output") == "json" {
+			return json.Marshal(patched)
+		}
+		return []byte(fmt.Sprintf("%s/%s patched\n", resource, names[0])), nil
+
+	case "delete":
+		resource, names := call.target(1)
+		var out strings.Builder
+		for _, name := range names {
+			if !s.remove(hub, resource, namespace, name) {
+				if call.flag("--ignore-not-found") != "" {
+					continue
+				}
+				return nil, notFoundError(resource, name)
+			}
+			if resource == "namespace" {
+				s.removeNamespace(hub, name)
+			}
+			fmt.Fprintf(&out, "%s %q deleted\n", resource, name)
+		}
+		return []byte(out.String()), nil
+
+	case "certificate":
+		if len(call.args) < 3 || call.args[1] != "approve" {
+			return nil, fmt.Errorf("exit status 1: unsupported certificate command")
+		}
+		for _, name := range call.args[2:] {
+			obj, ok := s.objects[simulatedKey(hub, "certificatesigningrequest", "", name)]
+			if !ok {
+				return nil, notFoundError("certificatesigningrequest", name)
+			}
+			approved := deepCopyObject(obj).(map[string]interface{})
+			approved["status"] = map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{
+					"type":   "Approved",
+					"status": "True",
+					"reason": "KubectlApprove",
+				}},
+			}
+			s.store(hub, approved)
+		}
+		return []byte("certificatesigningrequest approved\n"), nil
+
+	case "config":
+		caData := base64.StdEncoding.EncodeToString([]byte("simulated hub CA"))
+		if output := call.flag("-o", "--output"); strings.HasPrefix(output, "jsonpath=") {
+			return []byte(simulatedHubServer + " " + caData), nil
+		}
+		kubeconfig, err := synthesizeKubeconfig(firstNonEmpty(hub, "hub"), simulatedHubServer, caData, map[string]string{"token": "simulated"})
+		return []byte(kubeconfig), err
+	}
+	return nil, nil
+}
+
+func (s *hubSimulator) clusteradm(call kubectlCall) ([]byte, error) {
+	hub := call.address()
+	switch {
+	case len(call.args) >= 1 && call.args[0] == "accept":
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, name := range strings.Split(call.flag("--clusters"), ",") {
+			if !s.accept(hub, strings.TrimSpace(name)) {
+				return nil, fmt.Errorf("exit status 1: Error: no such cluster %s", name)
+			}
+		}
+		return []byte("Starting approve csrs for the cluster\nset hubAcceptsClient to true for managed cluster\n"), nil
+
+	case len(call.args) >= 1 && call.args[0] == "join":
+		return s.joinSpoke(hub, call)
+
+	case len(call.args) >= 1 && call.args[0] == "unjoin":
+		return s.unjoinSpoke(hub)
+	}
+	return nil, nil
+}
+
+// seedHub creates the objects clusteradm init leaves on a hub the first
+// time it is addressed: the cluster-info ConfigMap, a bootstrap token and the
+// bootstrap ServiceAccount. It is called with the simulator locked.
+func (s *hubSimulator) seedHub(hub string) {
+	if s.seeded[hub] {
+		return
+	}
+	s.seeded[hub] = true
+
+	kubeconfig, _ := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Config",
+		"clusters": []interface{}{map[string]interface{}{
+			"name":    "",
+			"cluster": map[string]interface{}{"server": simulatedHubServer},
+		}},
+	})
+	s.store(hub, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "cluster-info", "namespace": "kube-public"},
+		"data":       map[string]interface{}{"kubeconfig": string(kubeconfig)},
+	})
+
+	id := randomHex(3)
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       bootstrapTokenType,
+		"metadata": map[string]interface{}{
+			"name":      "bootstrap-token-" + id,
+			"namespace": bootstrapTokenNamespace,
+			"labels":    map[string]interface{}{"app": "cluster-manager"},
+		},
+		"stringData": map[string]interface{}{
+			"token-id":                       id,
+			"token-secret":                   randomHex(8),
+			"usage-bootstrap-authentication": "true",
+			"auth-extra-groups":              "system:bootstrappers:managedcluster",
+		},
+	}
+	normalizeSecret(secret)
+	s.store(hub, secret)
+
+	s.store(hub, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ServiceAccount",
+		"metadata":   map[string]interface{}{"name": bootstrapServiceAccount, "namespace": bootstrapServiceAccountNamespace},
+	})
+}
+
+// issueToken issues a token of a ServiceAccount, as a TokenRequest does.
+// The token names the ServiceAccount and its uid, so it stops being
+// accepted once the ServiceAccount is deleted. It is called with the
+// simulator locked.
+func (s *hubSimulator) issueToken(hub, namespace, name string, ttl time.Duration) (string, time.Time, bool) {
+	sa, ok := s.objects[simulatedKey(hub, "serviceaccount", namespace, name)]
+	if !ok {
+		return "", time.Time{}, false
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	header, _ := json.Marshal(map[string]string{"alg": "RS256"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"sub": "system:serviceaccount:" + namespace + ":" + name,
+		"exp": expires.Unix(),
+		"uid": objectMetadata(sa)["uid"],
+	})
+	token := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims) + "." + randomHex(16)
+	s.tokens[token] = hub
+	return token, expires, true
+}
+
+// tokenHub returns the hub that accepts a join token: the hub holding the
+// Secret of a bootstrap token, or the hub that issued a service account
+// token whose ServiceAccount still exists. It is called with the simulator
+// locked.
+func (s *hubSimulator) tokenHub(token string) (string, bool) {
+	if match := bootstrapTokenPattern.FindStringSubmatch(token); match != nil {
+		suffix := simulatedKey("", "secret", bootstrapTokenNamespace, "bootstrap-token-"+match[1])
+		for key, obj := range s.objects {
+			hub, found := strings.CutSuffix(key, suffix)
+			if !found {
+				continue
+			}
+			data, _ := obj["data"].(map[string]interface{})
+			secret, _ := data["token-secret"].(string)
+			if decoded, err := base64.StdEncoding.DecodeString(secret); err == nil && match[1]+"."+string(decoded) == token {
+				return hub, true
+			}
+		}
+		return "", false
+	}
+
+	hub, ok := s.tokens[token]
+	segments := strings.Split(token, ".")
+	if !ok || len(segments) != 3 {
+		return "", false
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+		Exp int64  `json:"exp"`
+		UID string `json:"uid"`
+	}
+	if decodeSegment(segments[1], &claims) != nil || time.Now().Unix() > claims.Exp {
+		return "", false
+	}
+	parts := strings.Split(claims.Sub, ":")
+	if len(parts) != 4 {
+		return "", false
+	}
+	sa, ok := s.objects[simulatedKey(hub, "serviceaccount", parts[2], parts[3])]
+	return hub, ok && objectMetadata(sa)["uid"] == claims.UID
+}
+
+// joinSpoke installs the klusterlet on a simulated spoke, as clusteradm
+// join does, and starts the registration agent against the hub that issued
+// the token
+func (s *hubSimulator) joinSpoke(spoke string, call kubectlCall) ([]byte, error) {
+	name := call.flag("--cluster-name")
+	if !strings.HasPrefix(spoke, simulatedSpokePrefix) || name == "" {
+		return nil, fmt.Errorf("exit status 1: Error: --kubeconfig of the spoke and --cluster-name are required")
+	}
+	if caFile := call.flag("--proxy-ca-file"); caFile != "" {
+		if _, err := os.Stat(caFile); err != nil {
+			return nil, fmt.Errorf("exit status 1: Error: failed to read the proxy CA: %v", err)
+		}
+	}
+	if call.flag("--hub-apiserver") != simulatedHubServer {
+		return nil, fmt.Errorf("exit status 1: Error: failed to reach hub API server %s", call.flag("--hub-apiserver"))
+	}
+
+	s.mutex.Lock()
+	hub, ok := s.tokenHub(call.flag("--hub-token"))
+	if !ok {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("exit status 1: Error: the bootstrap token is invalid or expired")
+	}
+	for _, namespace := range []string{klusterletOperatorNamespace, klusterletAgentNamespace} {
+		s.store(spoke, map[string]interface{}{"apiVersion": "v1", "kind": "Namespace", "metadata": map[string]interface{}{"name": namespace}})
+	}
+	s.store(spoke, map[string]interface{}{
+		"apiVersion": "operator.open-cluster-management.io/v1",
+		"kind":       "Klusterlet",
+		"metadata":   map[string]interface{}{"name": "klusterlet"},
+		"spec": map[string]interface{}{
+			"clusterName":   name,
+			"namespace":     klusterletAgentNamespace,
+			"imagePullSpec": firstNonEmpty(call.flag("--image-registry"), "quay.io/open-cluster-management") + "/registration-operator",
+		},
+	})
+	s.spokes[spoke] = hub + "|" + name
+	s.mutex.Unlock()
+
+	s.join(hub, name)
+	return []byte(fmt.Sprintf("Please log onto the hub cluster and run the following command:\n\n    clusteradm accept --clusters %s\n", name)), nil
+}
+
+// unjoinSpoke removes the klusterlet from a simulated spoke and stops its
+// registration agent, as clusteradm unjoin does
+func (s *hubSimulator) unjoinSpoke(spoke string) ([]byte, error) {
+	if !strings.HasPrefix(spoke, simulatedSpokePrefix) {
+		return nil, fmt.Errorf("exit status 1: Error: --kubeconfig of the spoke is required")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stop, ok := s.agents[s.spokes[spoke]]; ok {
+		stop()
+		delete(s.agents, s.spokes[spoke])
+	}
+	delete(s.spokes, spoke)
+	s.remove(spoke, "klusterlet", "", "klusterlet")
+	for _, namespace := range []string{klusterletAgentNamespace, klusterletOperatorNamespace} {
+		if s.remove(spoke, "namespace", "", namespace) {
+			s.removeNamespace(spoke, namespace)
+		}
+	}
+	return []byte("Remove applied resources in the managed cluster\n"), nil
+}
+
+// accept sets hubAcceptsClient on a ManagedCluster and approves its pending
+// CSRs, as clusteradm accept does; it is called with the simulator locked
+func (s *hubSimulator) accept(hub, name string) bool {
+	obj, ok := s.objects[simulatedKey(hub, "managedcluster", "", name)]
+	if !ok {
+		return false
+	}
+	accepted := deepCopyObject(obj).(map[string]interface{})
+	accepted["spec"] = mergePatch(accepted["spec"], map[string]interface{}{"hubAcceptsClient": true})
+	s.store(hub, accepted)
+
+	selector := []labelRequirement{{key: csrClusterLabel, value: name, operator: "="}}
+	for _, csr := range s.list(hub, "certificatesigningrequest", "", selector) {
+		if csr["status"] == nil {
+			approved := deepCopyObject(csr).(map[string]interface{})
+			approved["status"] = map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Approved", "status": "True", "reason": "AutoApproved"}},
+			}
+			s.store(hub, approved)
+		}
+	}
+	return true
+}
+
+// join plays the registration agent of a cluster joining a simulated hub:
+// it submits a CSR and creates the ManagedCluster, waits to be accepted and
+// then reports the cluster joined and available. A nil simulator does
+// nothing, so callers need not check for simulation_mode.
+func (s *hubSimulator) join(hub, name string) {
+	if s == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mutex.Lock()
+	if stop, ok := s.agents[hub+"|"+name]; ok {
+		stop()
+	}
+	s.agents[hub+"|"+name] = cancel
+
+	s.store(hub, simulatedCSR(name))
+	if _, exists := s.objects[simulatedKey(hub, "managedcluster", "", name)]; !exists {
+		s.store(hub, map[string]interface{}{
+			"apiVersion": "cluster.open-cluster-management.io/v1",
+			"kind":       "ManagedCluster",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec": map[string]interface{}{
+				"hubAcceptsClient": false,
+				"managedClusterClientConfigs": []interface{}{map[string]interface{}{
+					"url": fmt.Sprintf("https://%s.simulated.local:6443", name),
+				}},
+			},
+		})
+	}
+	s.mutex.Unlock()
+
+	go s.runAgent(ctx, hub, name)
+}
+
+// runAgent moves a joining ManagedCluster through its conditions
+func (s *hubSimulator) runAgent(ctx context.Context, hub, name string) {
+	deadline := time.Now().Add(simulatedTransitionDelay)
+	for {
+		s.mutex.Lock()
+		obj, ok := s.objects[simulatedKey(hub, "managedcluster", "", name)]
+		if !ok {
+			s.mutex.Unlock()
+			return
+		}
+		spec, _ := obj["spec"].(map[string]interface{})
+		accepted, _ := spec["hubAcceptsClient"].(bool)
+		if !accepted && time.Now().After(deadline) {
+			// Nobody accepted the cluster; stand in for the hub administrator
+			accepted = s.accept(hub, name)
+		}
+		s.mutex.Unlock()
+		if accepted {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	// The registration controller gives every accepted cluster a namespace
+	s.mutex.Lock()
+	if _, exists := s.objects[simulatedKey(hub, "namespace", "", name)]; !exists {
+		s.store(hub, map[string]interface{}{"apiVersion": "v1", "kind": "Namespace", "metadata": map[string]interface{}{"name": name}})
+	}
+	s.mutex.Unlock()
+
+	transitions := []struct {
+		condition, reason, message string
+	}{
+		{"HubAcceptedManagedCluster", "HubClusterAdminAccepted", "Accepted by hub cluster admin"},
+		{"ManagedClusterJoined", "ManagedClusterJoined", "Managed cluster joined"},
+		{"ManagedClusterConditionAvailable", "ManagedClusterAvailable", "Managed cluster is available"},
+	}
+	for _, transition := range transitions {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(simulatedTransitionDelay):
+		}
+
+		s.mutex.Lock()
+		obj, ok := s.objects[simulatedKey(hub, "managedcluster", "", name)]
+		if !ok {
+			s.mutex.Unlock()
+			return
+		}
+		updated := deepCopyObject(obj).(map[string]interface{})
+		status, _ := updated["status"].(map[string]interface{})
+		if status == nil {
+			status = map[string]interface{}{}
+			updated["status"] = status
+		}
+		conditions, _ := status["conditions"].([]interface{})
+		status["conditions"] = append(conditions, map[string]interface{}{
+			"type":               transition.condition,
+			"status":             "True",
+			"reason":             transition.reason,
+			"message":            transition.message,
+			"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+		})
+		if transition.condition == "ManagedClusterJoined" {
+			status["version"] = map[string]interface{}{"kubernetes": simulatedKubernetesVersion}
+			status["clusterClaims"] = []interface{}{
+				map[string]interface{}{"name": "id.k8s.io", "value": name},
+				map[string]interface{}{"name": "product.open-cluster-management.io", "value": "Kind"},
+			}
+			status["capacity"] = map[string]interface{}{"cpu": "4", "memory": "16Gi", "pods": "110"}
+			status["allocatable"] = map[string]interface{}{"cpu": "3800m", "memory": "15Gi", "pods": "110"}
+		}
+		s.store(hub, updated)
+		s.mutex.Unlock()
+	}
+}
+
+// simulatedCSR builds the registration CSR of a joining agent with a real
+// certificate request, so csr_approval_policy verified-only accepts it
+func simulatedCSR(clusterName string) map[string]interface{} {
+	clusterGroup := "system:open-cluster-management:" + clusterName
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   clusterGroup + ":agent",
+			Organization: []string{clusterGroup, managedClustersGroup},
+		},
+	}, key)
+	request := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	return map[string]interface{}{
+		"apiVersion": "certificates.k8s.io/v1",
+		"kind":       "CertificateSigningRequest",
+		"metadata": map[string]interface{}{
+			"name":   clusterName + "-" + randomHex(3),
+			"labels": map[string]interface{}{csrClusterLabel: clusterName},
+		},
+		"spec": map[string]interface{}{
+			"request":    base64.StdEncoding.EncodeToString(request),
+			"signerName": csrSignerName,
+			"username":   defaultCSRBootstrapUsers[0],
+			"groups":     []interface{}{"system:serviceaccounts", "system:authenticated"},
+		},
+	}
+}
+
+// decodeManifest reads the objects of a JSON or YAML manifest, expanding lists
+func decodeManifest(manifest []byte) ([]map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(manifest, &obj); err != nil {
+		if err := yaml.Unmarshal(manifest, &obj); err != nil {
+			return nil, fmt.Errorf("exit status 1: error: unable to decode manifest: %v", err)
+		}
+		// Round trip through JSON so nested maps have string keys
+		encoded, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		obj = nil
+		if err := json.Unmarshal(encoded, &obj); err != nil {
+			return nil, err
+		}
+	}
+	if stringField(obj, "kind") != "List" {
+		return []map[string]interface{}{obj}, nil
+	}
+	items, _ := obj["items"].([]interface{})
+	objects := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, m)
+		}
+	}
+	return objects, nil
+}
+
+// normalizeSecret moves the stringData of a Secret to data, as the API
+// server does
+func normalizeSecret(obj map[string]interface{}) {
+	stringData, ok := obj["stringData"].(map[string]interface{})
+	if !ok || stringField(obj, "kind") != "Secret" {
+		return
+	}
+	data, _ := obj["data"].(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	for key, value := range stringData {
+		data[key] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+	}
+	obj["data"] = data
+	delete(obj, "stringData")
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386)
+func mergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+			continue
+		}
+		targetMap[key] = mergePatch(targetMap[key], value)
+	}
+	return targetMap
+}
+
+// simpleJSONPath evaluates an output expression such as
+// jsonpath={.data.tls\.crt} that selects a single field
+func simpleJSONPath(obj map[string]interface{}, output string) string {
+	path := strings.TrimSuffix(strings.TrimPrefix(output, "jsonpath={."), "}")
+	var value interface{} = obj
+	for _, field := range strings.Split(strings.ReplaceAll(path, `\.`, "\x00"), ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = m[strings.ReplaceAll(field, "\x00", ".")]
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func deepCopyObject(obj interface{}) interface{} {
+	encoded, _ := json.Marshal(obj)
+	var copied interface{}
+	json.Unmarshal(encoded, &copied)
+	return copied
+}
+
+func objectMetadata(obj map[string]interface{}) map[string]interface{} {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+		obj["metadata"] = metadata
+	}
+	return metadata
+}
+
+func objectLabels(obj map[string]interface{}) map[string]string {
+	raw, _ := objectMetadata(obj)["labels"].(map[string]interface{})
+	labels := make(map[string]string, len(raw))
+	for key, value := range raw {
+		labels[key] = fmt.Sprint(value)
+	}
+	return labels
+}
+
+// matchFields reports whether an object matches a field selector of
+// comma-separated path=value terms, such as type=kubernetes.io/tls
+func matchFields(selector string, obj map[string]interface{}) bool {
+	for _, term := range strings.Split(selector, ",") {
+		path, want, _ := strings.Cut(term, "=")
+		var value interface{} = obj
+		for _, field := range strings.Split(path, ".") {
+			fields, _ := value.(map[string]interface{})
+			value = fields[field]
+		}
+		if fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+func stringField(obj map[string]interface{}, key string) string {
+	value, _ := obj[key].(string)
+	return value
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
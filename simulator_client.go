@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// simulatedClient is the client-go dynamic client of a simulated hub. It
+// serves the same objects as the simulated kubectl and fails with the API
+// errors of a real API server.
+type simulatedClient struct {
+	simulator *hubSimulator
+	hub       string
+}
+
+// hubClient returns the dynamic client of the simulated hub of a hub
+func (s *hubSimulator) hubClient(hub HubConfig) (dynamic.Interface, error) {
+	return &simulatedClient{simulator: s, hub: hub.Context}, nil
+}
+
+func (c *simulatedClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &simulatedResourceClient{simulator: c.simulator, hub: c.hub, gvr: gvr, resource: simulatedResource(gvr.Resource)}
+}
+
+// simulatedResourceClient serves a resource of a simulated hub, in namespace
+// or in every namespace when it is empty
+type simulatedResourceClient struct {
+	simulator *hubSimulator
+	hub       string
+	gvr       schema.GroupVersionResource
+	resource  string
+	namespace string
+}
+
+func (r *simulatedResourceClient) Namespace(namespace string) dynamic.ResourceInterface {
+	namespaced := *r
+	namespaced.namespace = namespace
+	return &namespaced
+}
+
+// toUnstructured copies a simulated object; a JSON round trip leaves only
+// the value types unstructured objects allow
+func toUnstructured(obj map[string]interface{}) *unstructured.Unstructured {
+	var copied map[string]interface{}
+	data, _ := json.Marshal(obj)
+	json.Unmarshal(data, &copied)
+	return &unstructured.Unstructured{Object: copied}
+}
+
+func (r *simulatedResourceClient) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	s := r.simulator
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seedHub(r.hub)
+
+	obj, ok := s.objects[simulatedKey(r.hub, r.resource, r.namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	return toUnstructured(obj), nil
+}
+
+func (r *simulatedResourceClient) List(ctx context.Context, options metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	selector, err := parseLabelSelector(options.LabelSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	s := r.simulator
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seedHub(r.hub)
+
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+	for _, obj := range s.list(r.hub, r.resource, r.namespace, selector) {
+		if options.FieldSelector != "" && !matchFields(options.FieldSelector, obj) {
+			continue
+		}
+		list.Items = append(list.Items, *toUnstructured(obj))
+	}
+	list.SetResourceVersion(strconv.Itoa(s.version))
+	return list, nil
+}
+
+// Watch replays the changes made since the resourceVersion it starts from,
+// as the API server does from its watch cache
+func (r *simulatedResourceClient) Watch(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+	selector, err := parseLabelSelector(options.LabelSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return r.simulator.startWatch(ctx, &simulatedWatch{hub: r.hub, resource: r.resource, namespace: r.namespace, selector: selector}, options.ResourceVersion)
+}
+
+// Patch applies JSON merge patches, the only kind the plugin sends
+func (r *simulatedResourceClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if pt != types.MergePatchType {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("%s patches are not supported in simulation mode", pt))
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unable to parse the patch: %v", err))
+	}
+
+	s := r.simulator
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seedHub(r.hub)
+
+	patched, err := s.patchObject(r.hub, r.gvr.GroupResource(), r.resource, r.namespace, name, patch)
+	if err != nil {
+		return nil, err
+	}
+	return toUnstructured(patched), nil
+}
+
+func (r *simulatedResourceClient) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	s := r.simulator
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seedHub(r.hub)
+
+	if !s.remove(r.hub, r.resource, r.namespace, name) {
+		return apierrors.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	if r.resource == "namespace" {
+		s.removeNamespace(r.hub, name)
+	}
+	return nil
+}
+
+// Create stores a new object, or issues a token for a TokenRequest of a
+// ServiceAccount
+func (r *simulatedResourceClient) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	s := r.simulator
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.seedHub(r.hub)
+
+	name := obj.GetName()
+	if len(subresources) > 0 {
+		if r.resource != "serviceaccount" || subresources[0] != "token" {
+			return nil, apierrors.NewMethodNotSupported(r.gvr.GroupResource(), "create "+subresources[0])
+		}
+		ttl := time.Hour
+		if seconds, found, _ := unstructured.NestedInt64(obj.Object, "spec", "expirationSeconds"); found {
+			ttl = time.Duration(seconds) * time.Second
+		}
+		token, expires, ok := s.issueToken(r.hub, r.namespace, name, ttl)
+		if !ok {
+			return nil, apierrors.NewNotFound(r.gvr.GroupResource(), name)
+		}
+		response := obj.DeepCopy()
+		response.Object["status"] = map[string]interface{}{
+			"token":               token,
+			"expirationTimestamp": expires.UTC().Format(time.RFC3339),
+		}
+		return response, nil
+	}
+
+	if _, exists := s.objects[simulatedKey(r.hub, r.resource, r.namespace, name)]; exists {
+		return nil, apierrors.NewAlreadyExists(r.gvr.GroupResource(), name)
+	}
+	created := toUnstructured(obj.Object).Object
+	if r.namespace != "" {
+		objectMetadata(created)["namespace"] = r.namespace
+	}
+	normalizeSecret(created)
+	s.store(r.hub, created)
+	return toUnstructured(created), nil
+}
+
+func (r *simulatedResourceClient) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, apierrors.NewMethodNotSupported(r.gvr.GroupResource(), "update")
+}
+
+func (r *simulatedResourceClient) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return nil, apierrors.NewMethodNotSupported(r.gvr.GroupResource(), "update")
+}
+
+func (r *simulatedResourceClient) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return apierrors.NewMethodNotSupported(r.gvr.GroupResource(), "deletecollection")
+}
+
+func (r *simulatedResourceClient) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, apierrors.NewMethodNotSupported(r.gvr.GroupResource(), "apply")
+}
+
+func (r *simulatedResourceClient) ApplyStatus(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions) (*unstructured.Unstructured, error) {
+	return nil, apierrors.NewMethodNotSupported(r.gvr.GroupResource(), "apply")
+}
+
+// patchObject merges a patch into an object, failing with a conflict when
+// the patch carries a resourceVersion other than the current one. An object
+// marked for deletion is removed once the patch clears its finalizers. It is
+// called with the simulator locked.
+func (s *hubSimulator) patchObject(hub string, gr schema.GroupResource, resource, namespace, name string, patch map[string]interface{}) (map[string]interface{}, error) {
+	obj, ok := s.objects[simulatedKey(hub, resource, namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(gr, name)
+	}
+	if version, _ := objectMetadata(patch)["resourceVersion"].(string); version != "" && version != objectMetadata(obj)["resourceVersion"] {
+		return nil, apierrors.NewConflict(gr, name, errors.New("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+	patched := mergePatch(deepCopyObject(obj), patch).(map[string]interface{})
+	s.store(hub, patched)
+	if objectMetadata(patched)["deletionTimestamp"] != nil {
+		s.remove(hub, resource, namespace, name)
+	}
+	return patched, nil
+}
+
+// kubectlError formats an API error the way kubectl reports it
+func kubectlError(err error) error {
+	return fmt.Errorf("exit status 1: Error from server (%s): %v", apierrors.ReasonForError(err), err)
+}
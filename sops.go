@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sopsDecryptTimeout bounds a single sops invocation, which may call out to
+// a KMS
+const sopsDecryptTimeout = 30 * time.Second
+
+// isSOPSEncrypted reports whether a kubeconfig is a SOPS document, which
+// carries its encryption metadata in a top-level sops key
+func isSOPSEncrypted(kubeconfig string) bool {
+	var doc struct {
+		SOPS *struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal([]byte(kubeconfig), &doc); err != nil {
+		return false
+	}
+	return doc.SOPS != nil && doc.SOPS.MAC != ""
+}
+
+// decryptSOPS decrypts a SOPS document with the sops binary. age identities
+// come from sops_age_key_file, PGP keys from the keyring in sops_gnupg_home,
+// and cloud KMS keys use the ambient credentials of the plugin host.
+func (cp *ClusterOpsPlugin) decryptSOPS(ctx context.Context, document string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sopsDecryptTimeout)
+	defer cancel()
+
+	format := "yaml"
+	if strings.HasPrefix(strings.TrimSpace(document), "{") {
+		format = "json"
+	}
+
+	cmd := Command{
+		Name:  "sops",
+		Args:  []string{"--decrypt", "--input-type", format, "--output-type", "yaml", "/dev/stdin"},
+		Stdin: []byte(document),
+	}
+	if runtime.GOOS == "windows" {
+		// Windows has no /dev/stdin, so sops reads a private temporary copy
+		file, err := os.CreateTemp("", "kubestellar-sops-*."+format)
+		if err != nil {
+			return "", fmt.Errorf("failed to stage SOPS document: %v", err)
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(document)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to stage SOPS document: %v", err)
+		}
+		cmd.Args[len(cmd.Args)-1] = file.Name()
+		cmd.Stdin = nil
+	}
+	settings := cp.settings()
+	if keyFile := settings.SOPSAgeKeyFile; keyFile != "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+keyFile)
+	}
+	if gnupgHome := settings.SOPSGnuPGHome; gnupgHome != "" {
+		cmd.Env = append(cmd.Env, "GNUPGHOME="+gnupgHome)
+	}
+
+	out, err := cp.runner.Run(ctx, cmd)
+	if err != nil {
+		return "", fmt.Errorf("sops --decrypt failed: %v", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// klusterletAgentNamespace is where the klusterlet runs the registration and
+// work agents on a spoke
+const klusterletAgentNamespace = "open-cluster-management-agent"
+
+// managedClusterPollInterval is how often the verify step reads the
+// ManagedCluster of a joining cluster
+const managedClusterPollInterval = 2 * time.Second
+
+// kubectlSpoke runs kubectl against a spoke through the kubeconfig written
+// to an operation directory
+func (cp *ClusterOpsPlugin) kubectlSpoke(ctx context.Context, kubeconfigPath string, input []byte, args ...string) (out []byte, err error) {
+	ctx, span := startChildSpan(ctx, "kubectl "+args[0], spanKindClient)
+	span.SetAttribute("kubectl.args", strings.Join(args, " "))
+	defer func() { span.End(err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, hubRequestTimeout)
+	defer cancel()
+
+	cmd := Command{Name: "kubectl", Args: append([]string{"--kubeconfig", kubeconfigPath}, args...), Stdin: input}
+	recordCommand(ctx, cmd.String())
+	out, err = cp.runner.Run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("kubectl %s failed on the spoke: %v", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// validateSpoke checks that the API server of a spoke answers with the
+// credentials of its kubeconfig
+func (cp *ClusterOpsPlugin) validateSpoke(ctx context.Context, operationID, clusterName, kubeconfigPath string) error {
+	out, err := cp.kubectlSpoke(ctx, kubeconfigPath, nil, "get", "--raw", "/version")
+	if err != nil {
+		return err
+	}
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(out, &version); err != nil || version.GitVersion == "" {
+		return fmt.Errorf("spoke API server returned no version")
+	}
+	cp.logStepEvent(operationID, clusterName, "validate", "info", fmt.Sprintf("Spoke API server runs Kubernetes %s", version.GitVersion), 0)
+	return nil
+}
+
+// clusteradmJoin runs clusteradm join on the spoke with the hub join token
+// and the klusterlet flags of the onboarding. The token never appears in
+// recorded commands or errors.
+func (cp *ClusterOpsPlugin) clusteradmJoin(ctx context.Context, workDir, clusterName string, token joinToken, klusterlet *KlusterletOptions) (err error) {
+	ctx, span := startChildSpan(ctx, "clusteradm join", spanKindClient)
+	span.SetAttribute("cluster.name", clusterName)
+	defer func() { span.End(err) }()
+
+	args := []string{"join", "--hub-token", token.Token, "--hub-apiserver", token.HubAPIServer, "--cluster-name", clusterName}
+	var env []string
+	if klusterlet != nil {
+		flags := klusterlet.joinFlags()
+		if klusterlet.Proxy != nil && klusterlet.Proxy.CAData != "" {
+			caFile := filepath.Join(workDir, proxyCAFile)
+			if err := os.WriteFile(caFile, []byte(klusterlet.Proxy.CAData), 0o600); err != nil {
+				return fmt.Errorf("failed to write the proxy CA bundle: %v", err)
+			}
+			flags[slices.Index(flags, "--proxy-ca-file")+1] = caFile
+		}
+		args = append(args, flags...)
+		env = klusterlet.joinEnv()
+	}
+	args = append(args, "--kubeconfig", filepath.Join(workDir, spokeKubeconfigFile))
+
+	cmd := Command{Name: "clusteradm", Args: args, Env: env}
+	redacted := cmd
+	redacted.Args = slices.Clone(args)
+	redacted.Args[2] = redactedValue
+	recordCommand(ctx, redacted.String())
+	if _, err := cp.runner.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("clusteradm join failed: %v", strings.ReplaceAll(err.Error(), token.Token, redactedValue))
+	}
+	return nil
+}
+
+// createPullSecret creates the namespace of the klusterlet operator and the
+// image pull secret it uses on the spoke, so clusteradm join can pull the
+// operator image from a private registry
+func (cp *ClusterOpsPlugin) createPullSecret(ctx context.Context, kubeconfigPath, dockerConfig string) error {
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items": []interface{}{
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]interface{}{"name": klusterletOperatorNamespace},
+			},
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"type":       "kubernetes.io/dockerconfigjson",
+				"metadata":   map[string]interface{}{"name": imagePullSecretName, "namespace": klusterletOperatorNamespace},
+				"stringData": map[string]string{".dockerconfigjson": dockerConfig},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cp.kubectlSpoke(ctx, kubeconfigPath, manifest, "apply", "-f", "-")
+	return err
+}
+
+// clusteradmUnjoin removes the klusterlet and its agents from a spoke
+func (cp *ClusterOpsPlugin) clusteradmUnjoin(ctx context.Context, kubeconfigPath, clusterName string) (err error) {
+	ctx, span := startChildSpan(ctx, "clusteradm unjoin", spanKindClient)
+	span.SetAttribute("cluster.name", clusterName)
+	defer func() { span.End(err) }()
+
+	cmd := Command{Name: "clusteradm", Args: []string{"unjoin", "--cluster-name", clusterName, "--kubeconfig", kubeconfigPath}}
+	recordCommand(ctx, cmd.String())
+	if _, err := cp.runner.Run(ctx, cmd); err != nil {
+		return fmt.Errorf("clusteradm unjoin failed: %v", err)
+	}
+	return nil
+}
+
+// verifyUnjoined waits until the klusterlet agent namespace is gone from a
+// spoke
+func (cp *ClusterOpsPlugin) verifyUnjoined(ctx context.Context, kubeconfigPath string) error {
+	ticker := time.NewTicker(managedClusterPollInterval)
+	defer ticker.Stop()
+	for {
+		out, err := cp.kubectlSpoke(ctx, kubeconfigPath, nil, "get", "namespace", klusterletAgentNamespace, "--ignore-not-found", "-o", "json")
+		switch {
+		case err == nil && len(bytes.TrimSpace(out)) == 0:
+			return nil
+		case ctx.Err() != nil:
+			return fmt.Errorf("namespace %s is still present on the spoke: %w", klusterletAgentNamespace, ctx.Err())
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// configureKlusterlet patches the Klusterlet on the spoke with the settings
+// clusteradm join has no flags for
+func (cp *ClusterOpsPlugin) configureKlusterlet(ctx context.Context, kubeconfigPath string, klusterlet *KlusterletOptions) error {
+	patch, err := json.Marshal(klusterlet.klusterletPatch())
+	if err != nil {
+		return err
+	}
+	_, err = cp.kubectlSpoke(ctx, kubeconfigPath, nil, "patch", "klusterlet", "klusterlet", "--type", "merge", "-p", string(patch))
+	return err
+}
+
+// verifyManagedCluster waits until the hub reports the ManagedCluster of a
+// cluster as joined and available
+func (cp *ClusterOpsPlugin) verifyManagedCluster(ctx context.Context, clusterName string) error {
+	ticker := time.NewTicker(managedClusterPollInterval)
+	defer ticker.Stop()
+	for {
+		mc, err := cp.getManagedCluster(ctx, clusterName)
+		switch {
+		case err == nil && mc.conditionTrue("ManagedClusterJoined") && mc.available():
+			return nil
+		case ctx.Err() != nil:
+			if err != nil {
+				return fmt.Errorf("ManagedCluster %s was not available in time: %v", clusterName, err)
+			}
+			return fmt.Errorf("ManagedCluster %s was not available in time: %w", clusterName, ctx.Err())
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
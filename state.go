@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStateSyncInterval = 5 * time.Second
+	// stateEventLimit caps the events shared per cluster, keeping hub
+	// ConfigMaps well below their size limit
+	stateEventLimit = 200
+	// stateReplicaExpiry is when the heartbeat of a replica that stopped
+	// without cleaning up is deleted
+	stateReplicaExpiry = time.Hour
+	// stateFlushTimeout bounds the final flush when the plugin stops
+	stateFlushTimeout = 10 * time.Second
+
+	stateKindClusters   = "clusters"
+	stateKindOperations = "operations"
+	stateKindEvents     = "events"
+	stateKindReplicas   = "replicas"
+
+	// stateKindLabel marks the hub ConfigMaps holding shared state
+	stateKindLabel = "cluster-ops.kubestellar.io/state-kind"
+	stateDataKey   = "record"
+)
+
+// stateRecord is a cluster record, operation, event history or replica
+// heartbeat as kept in the shared store. Owner is the replica that wrote it
+// last and UpdatedAt when, which decides between concurrent writes.
+type stateRecord struct {
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key"`
+	Owner     string          `json:"owner"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (r stateRecord) id() string {
+	return r.Kind + "/" + r.Key
+}
+
+// stateBackend is a store shared by the replicas of the plugin, selected by
+// state_backend
+type stateBackend interface {
+	Save(ctx context.Context, record stateRecord) error
+	Delete(ctx context.Context, kind, key string) error
+	List(ctx context.Context) ([]stateRecord, error)
+	// Ping checks that the store is reachable
+	Ping(ctx context.Context) error
+}
+
+// stateVersion is the latest write of a record this replica knows about
+type stateVersion struct {
+	owner     string
+	updatedAt time.Time
+}
+
+// stateSync shares the cluster records, operations and events of this
+// replica with the others through a stateBackend. Local changes are written
+// shortly after they happen; the records of other replicas are read every
+// state_sync_interval, so any replica answers /status, /list and
+// /operations with at most that much lag. Operations left running by a
+// replica whose heartbeat stopped are failed by the replica that notices,
+// and their clusters keep the completed steps an onboarding resumes from.
+type stateSync struct {
+	backend  stateBackend
+	replica  string
+	interval time.Duration
+	started  time.Time
+	// dirty holds the records changed locally since the last flush with
+	// the time of their latest change
+	dirty map[string]time.Time
+	kick  chan struct{}
+	mutex sync.Mutex
+	// known is only used by the sync goroutine
+	known map[string]stateVersion
+}
+
+// newStateBackend creates the backend selected by state_backend, or returns
+// nil when state is kept in memory only
+func (cp *ClusterOpsPlugin) newStateBackend(settings PluginConfig) (stateBackend, error) {
+	switch settings.StateBackend {
+	case "hub":
+		return &hubStateBackend{cp: cp}, nil
+	case "redis":
+		return newRedisStateBackend(settings.StateRedisURL)
+	default:
+		return nil, nil
+	}
+}
+
+func newStateSync(backend stateBackend, interval time.Duration) *stateSync {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "replica"
+	}
+	return &stateSync{
+		backend:  backend,
+		replica:  strings.ToLower(hostname) + "-" + strings.TrimPrefix(newOperationID(), "op-")[:6],
+		interval: interval,
+		started:  time.Now(),
+		dirty:    make(map[string]time.Time),
+		kick:     make(chan struct{}, 1),
+		known:    make(map[string]stateVersion),
+	}
+}
+
+// changed marks a record for the next flush. It is called by the stores
+// with their lock held, so it never blocks.
+func (s *stateSync) changed(kind, key string) {
+	s.mutex.Lock()
+	s.dirty[kind+"/"+key] = time.Now()
+	s.mutex.Unlock()
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// enableStateSync hooks the stores up to s. It is called by Initialize
+// before anything uses the stores.
+func (cp *ClusterOpsPlugin) enableStateSync(s *stateSync) {
+	cp.state = s
+	cp.clusters.onChange = func(name string) { s.changed(stateKindClusters, name) }
+	cp.operations.onChange = func(id string) { s.changed(stateKindOperations, id) }
+	cp.events.onChange = func(clusterName string) { s.changed(stateKindEvents, clusterName) }
+}
+
+// runStateSync loads the shared state, then keeps it in sync until ctx is
+// cancelled. ready is closed after the first load so that hub
+// reconciliation starts from the shared records.
+func (cp *ClusterOpsPlugin) runStateSync(ctx context.Context, ready chan<- struct{}) {
+	s := cp.state
+	cp.syncState(ctx, true)
+	close(ready)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush what is left and drop the heartbeat, so that other
+			// replicas take over running operations right away
+			stopCtx, cancel := context.WithTimeout(context.Background(), stateFlushTimeout)
+			cp.flushState(stopCtx)
+			s.backend.Delete(stopCtx, stateKindReplicas, s.replica)
+			cancel()
+			return
+		case <-s.kick:
+			// Let a burst of changes settle before writing it
+			time.Sleep(100 * time.Millisecond)
+			cp.flushState(ctx)
+		case <-ticker.C:
+			cp.syncState(ctx, false)
+		}
+	}
+}
+
+// syncState writes the heartbeat and local changes, then reads the records
+// of other replicas
+func (cp *ClusterOpsPlugin) syncState(ctx context.Context, initial bool) {
+	s := cp.state
+	heartbeat, _ := json.Marshal(map[string]interface{}{"startedAt": s.started})
+	err := s.backend.Save(ctx, stateRecord{Kind: stateKindReplicas, Key: s.replica, Owner: s.replica, UpdatedAt: time.Now(), Data: heartbeat})
+	if err == nil && !initial {
+		err = cp.flushState(ctx)
+	}
+	if err == nil {
+		err = cp.pullState(ctx)
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	if err != nil {
+		cp.metrics["state_sync_error"] = err.Error()
+		cp.logger.Warn("Syncing shared state failed", "backend", cp.pluginConfig.StateBackend, "error", err)
+		return
+	}
+	delete(cp.metrics, "state_sync_error")
+	cp.metrics["state_synced_at"] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// flushState writes the records changed locally. Records that fail to be
+// written stay dirty for the next attempt.
+func (cp *ClusterOpsPlugin) flushState(ctx context.Context) error {
+	s := cp.state
+	s.mutex.Lock()
+	dirty := s.dirty
+	s.dirty = make(map[string]time.Time)
+	s.mutex.Unlock()
+
+	var failed error
+	for id, at := range dirty {
+		kind, key, _ := strings.Cut(id, "/")
+		data, ok := cp.localState(kind, key)
+		var err error
+		if ok {
+			err = s.backend.Save(ctx, stateRecord{Kind: kind, Key: key, Owner: s.replica, UpdatedAt: at, Data: data})
+		} else {
+			err = s.backend.Delete(ctx, kind, key)
+		}
+		if err != nil {
+			s.mutex.Lock()
+			if _, changedAgain := s.dirty[id]; !changedAgain {
+				s.dirty[id] = at
+			}
+			s.mutex.Unlock()
+			failed = err
+			continue
+		}
+		s.known[id] = stateVersion{owner: s.replica, updatedAt: at}
+	}
+	return failed
+}
+
+// localState serializes the local copy of a record, reporting false when
+// it does not exist
+func (cp *ClusterOpsPlugin) localState(kind, key string) (json.RawMessage, bool) {
+	var value interface{}
+	switch kind {
+	case stateKindClusters:
+		record, ok := cp.clusters.Get(key)
+		if !ok {
+			return nil, false
+		}
+		value = record
+	case stateKindOperations:
+		op, ok := cp.operations.Get(key)
+		if !ok {
+			return nil, false
+		}
+		value = op
+	case stateKindEvents:
+		events := cp.events.List(key)
+		if len(events) == 0 {
+			return nil, false
+		}
+		if len(events) > stateEventLimit {
+			events = events[len(events)-stateEventLimit:]
+		}
+		value = events
+	default:
+		return nil, false
+	}
+	data, err := json.Marshal(value)
+	return data, err == nil
+}
+
+// pullState applies the records written by other replicas since the last
+// pull, forgets the ones they deleted and takes over operations whose
+// replica stopped
+func (cp *ClusterOpsPlugin) pullState(ctx context.Context) error {
+	s := cp.state
+	records, err := s.backend.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	live := map[string]bool{s.replica: true}
+	for _, record := range records {
+		if record.Kind != stateKindReplicas {
+			continue
+		}
+		switch age := now.Sub(record.UpdatedAt); {
+		case age < 3*s.interval:
+			live[record.Key] = true
+		case age > stateReplicaExpiry:
+			s.backend.Delete(ctx, stateKindReplicas, record.Key)
+		}
+	}
+
+	s.mutex.Lock()
+	dirty := make(map[string]time.Time, len(s.dirty))
+	for id, at := range s.dirty {
+		dirty[id] = at
+	}
+	s.mutex.Unlock()
+
+	seen := make(map[string]bool, len(records))
+	var orphaned []stateRecord
+	for _, record := range records {
+		if record.Kind == stateKindReplicas {
+			continue
+		}
+		id := record.id()
+		seen[id] = true
+		if record.Owner == s.replica {
+			continue
+		}
+		if record.Kind == stateKindOperations && !live[record.Owner] {
+			orphaned = append(orphaned, record)
+		}
+		if known, ok := s.known[id]; ok && !record.UpdatedAt.After(known.updatedAt) {
+			continue
+		}
+		// A newer local change wins and is written by the next flush
+		if at, ok := dirty[id]; ok && at.After(record.UpdatedAt) {
+			continue
+		}
+		if err := cp.restoreState(record); err != nil {
+			cp.logger.Warn("Ignoring invalid shared state", "record", id, "owner", record.Owner, "error", err)
+			continue
+		}
+		s.known[id] = stateVersion{owner: record.Owner, updatedAt: record.UpdatedAt}
+	}
+
+	for id, known := range s.known {
+		if known.owner == s.replica || seen[id] {
+			continue
+		}
+		delete(s.known, id)
+		if kind, key, _ := strings.Cut(id, "/"); kind == stateKindClusters {
+			cp.clusters.Forget(key)
+		}
+	}
+
+	for _, record := range orphaned {
+		cp.adoptOperation(record)
+	}
+	return nil
+}
+
+// restoreState replaces the local copy of a record with one written by
+// another replica
+func (cp *ClusterOpsPlugin) restoreState(record stateRecord) error {
+	switch record.Kind {
+	case stateKindClusters:
+		var cluster ClusterRecord
+		if err := json.Unmarshal(record.Data, &cluster); err != nil {
+			return err
+		}
+		cp.clusters.Restore(cluster)
+	case stateKindOperations:
+		var op Operation
+		if err := json.Unmarshal(record.Data, &op); err != nil {
+			return err
+		}
+		cp.operations.Restore(op)
+	case stateKindEvents:
+		var events []OnboardingEvent
+		if err := json.Unmarshal(record.Data, &events); err != nil {
+			return err
+		}
+		cp.events.Restore(record.Key, events)
+	}
+	return nil
+}
+
+// adoptOperation fails an operation left running by a replica that
+// stopped. Its cluster moves to Failed or DetachmentFailed, from where the
+// onboarding can be resumed or the detachment retried on any replica.
+func (cp *ClusterOpsPlugin) adoptOperation(record stateRecord) {
+	var op Operation
+	if err := json.Unmarshal(record.Data, &op); err != nil || op.isTerminal() {
+		return
+	}
+	if local, ok := cp.operations.Get(op.ID); ok && local.isTerminal() {
+		return
+	}
+	cp.operations.Restore(op)
+
+	message := fmt.Sprintf("Replica %s stopped while the operation was %s", record.Owner, op.Status)
+	cp.operations.Fail(op.ID, codeOperationInterrupted, message)
+	switch op.Type {
+	case "onboard":
+		cp.clusters.Transition(op.ClusterName, StateFailed, message)
+		message += "; resume it with resume=true"
+	case "detach":
+		cp.clusters.Transition(op.ClusterName, StateDetachmentFailed, message)
+		message += "; retry the detachment"
+	}
+	cp.logOperationEvent(op.ID, op.ClusterName, op.Type, "failed", message)
+	cp.logger.Warn("Took over operation of a stopped replica", "operation", op.ID, "cluster", op.ClusterName, "replica", record.Owner)
+}
+
+// hubStateBackend keeps shared state in ConfigMaps of cluster_namespace on
+// the default hub, one per record
+type hubStateBackend struct {
+	cp *ClusterOpsPlugin
+}
+
+func (b *hubStateBackend) context(ctx context.Context) (context.Context, error) {
+	hub, err := b.cp.lookupHub("")
+	if err != nil {
+		return nil, err
+	}
+	return withHub(ctx, hub), nil
+}
+
+func stateConfigMapName(kind, key string) string {
+	return "cluster-ops-state-" + kind + "-" + key
+}
+
+func (b *hubStateBackend) Save(ctx context.Context, record stateRecord) error {
+	ctx, err := b.context(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      stateConfigMapName(record.Kind, record.Key),
+			"namespace": b.cp.clusterNamespace(),
+			"labels": map[string]string{
+				"app.kubernetes.io/managed-by": "cluster-ops-plugin",
+				stateKindLabel:                 record.Kind,
+			},
+		},
+		"data": map[string]string{stateDataKey: string(data)},
+	})
+	_, err = b.cp.kubectlHubWithInput(ctx, manifest, "apply", "--server-side", "--force-conflicts", "--field-manager", "cluster-ops-plugin", "-f", "-")
+	return err
+}
+
+func (b *hubStateBackend) Delete(ctx context.Context, kind, key string) error {
+	ctx, err := b.context(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = b.cp.kubectlHub(ctx, "delete", "configmap", stateConfigMapName(kind, key), "-n", b.cp.clusterNamespace(), "--ignore-not-found")
+	return err
+}
+
+func (b *hubStateBackend) Ping(ctx context.Context) error {
+	ctx, err := b.context(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = b.cp.kubectlHub(ctx, "get", "namespace", b.cp.clusterNamespace(), "-o", "name")
+	return err
+}
+
+func (b *hubStateBackend) List(ctx context.Context) ([]stateRecord, error) {
+	ctx, err := b.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := b.cp.kubectlHub(ctx, "get", "configmaps", "-n", b.cp.clusterNamespace(), "-l", stateKindLabel, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Data map[string]string `json:"data"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode state ConfigMaps: %v", err)
+	}
+	records := make([]stateRecord, 0, len(list.Items))
+	for _, item := range list.Items {
+		var record stateRecord
+		if json.Unmarshal([]byte(item.Data[stateDataKey]), &record) == nil && record.Kind != "" {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisStateHash is the Redis hash holding shared state, one field per
+	// record
+	redisStateHash    = "cluster-ops:state"
+	redisDialTimeout  = 10 * time.Second
+	redisCallTimeout  = 10 * time.Second
+	redisDefaultPort  = "6379"
+	redisMaxBulkBytes = 64 << 20
+)
+
+// redisStateBackend keeps shared state in a Redis hash, speaking RESP
+// directly. It connects lazily and reconnects after a failed command.
+type redisStateBackend struct {
+	url    *url.URL
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+}
+
+func newRedisStateBackend(rawURL string) (*redisStateBackend, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "redis" && parsed.Scheme != "rediss") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid state_redis_url, expected redis://[:password@]host:port[/db]")
+	}
+	return &redisStateBackend{url: parsed}, nil
+}
+
+func (r *redisStateBackend) connect(ctx context.Context) error {
+	host := r.url.Host
+	if r.url.Port() == "" {
+		host = net.JoinHostPort(r.url.Hostname(), redisDefaultPort)
+	}
+	dialer := &net.Dialer{Timeout: redisDialTimeout}
+	var conn net.Conn
+	var err error
+	if r.url.Scheme == "rediss" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: r.url.Hostname()}}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+
+	if password, ok := r.url.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if username := r.url.User.Username(); username != "" {
+			args = []string{"AUTH", username, password}
+		}
+		if _, err := r.call(args...); err != nil {
+			r.close()
+			return fmt.Errorf("redis AUTH failed: %v", err)
+		}
+	}
+	if db := strings.Trim(r.url.Path, "/"); db != "" && db != "0" {
+		if _, err := r.call("SELECT", db); err != nil {
+			r.close()
+			return fmt.Errorf("redis SELECT %s failed: %v", db, err)
+		}
+	}
+	return nil
+}
+
+func (r *redisStateBackend) close() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// do runs a command, connecting first if needed
+func (r *redisStateBackend) do(ctx context.Context, args ...string) (interface{}, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.conn == nil {
+		if err := r.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.call(args...)
+	if _, isReplyErr := err.(redisError); err != nil && !isReplyErr {
+		r.close()
+	}
+	return reply, err
+}
+
+// redisError is an error reply, after which the connection stays usable
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// call writes a command as a RESP array of bulk strings and reads its reply
+func (r *redisStateBackend) call(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	r.conn.SetDeadline(time.Now().Add(redisCallTimeout))
+	if _, err := r.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return r.readReply()
+}
+
+func (r *redisStateBackend) readReply() (interface{}, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size > redisMaxBulkBytes {
+			return nil, fmt.Errorf("invalid redis bulk reply %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis array reply %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = r.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
+
+func (r *redisStateBackend) Save(ctx context.Context, record stateRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.do(ctx, "HSET", redisStateHash, record.id(), string(data))
+	return err
+}
+
+func (r *redisStateBackend) Delete(ctx context.Context, kind, key string) error {
+	_, err := r.do(ctx, "HDEL", redisStateHash, kind+"/"+key)
+	return err
+}
+
+func (r *redisStateBackend) Ping(ctx context.Context) error {
+	_, err := r.do(ctx, "PING")
+	return err
+}
+
+func (r *redisStateBackend) List(ctx context.Context) ([]stateRecord, error) {
+	reply, err := r.do(ctx, "HGETALL", redisStateHash)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	records := make([]stateRecord, 0, len(items)/2)
+	for i := 1; i < len(items); i += 2 {
+		value, _ := items[i].(string)
+		var record stateRecord
+		if json.Unmarshal([]byte(value), &record) == nil && record.Kind != "" {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cordonTaintKey marks a cluster that must not receive new placements
+const cordonTaintKey = "cluster-ops.kubestellar.io/cordoned"
+
+// protectedTaintPrefix is reserved for taints maintained by the OCM hub itself
+const protectedTaintPrefix = "cluster.open-cluster-management.io/"
+
+// validTaintEffects are the taint effects understood by OCM placements
+var validTaintEffects = map[string]bool{
+	"NoSelect":       true,
+	"PreferNoSelect": true,
+	"NoSelectIfNew":  true,
+}
+
+// validateTaint checks a taint before it is written to the hub
+func validateTaint(taint clusterTaint) error {
+	if err := validateLabelKey(taint.Key); err != nil {
+		return fmt.Errorf("invalid taint key %q", taint.Key)
+	}
+	if strings.HasPrefix(taint.Key, protectedTaintPrefix) {
+		return fmt.Errorf("taint %q is managed by the hub", taint.Key)
+	}
+	if err := validateLabelValue(taint.Value); err != nil {
+		return fmt.Errorf("invalid taint value %q", taint.Value)
+	}
+	if !validTaintEffects[taint.Effect] {
+		return fmt.Errorf("invalid taint effect %q: must be NoSelect, PreferNoSelect or NoSelectIfNew", taint.Effect)
+	}
+	return nil
+}
+
+// updateTaints applies fn to the taints of a ManagedCluster. The patch
+// carries the resourceVersion that was read so concurrent updates conflict
+// instead of being overwritten.
+func (cp *ClusterOpsPlugin) updateTaints(ctx context.Context, name string, fn func([]clusterTaint) []clusterTaint) ([]clusterTaint, error) {
+	mc, err := cp.getManagedCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	taints := fn(mc.Spec.Taints)
+	if taints == nil {
+		taints = []clusterTaint{}
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": mc.Metadata.ResourceVersion},
+		"spec":     map[string]interface{}{"taints": taints},
+	}
+
+	updated, err := cp.patchManagedCluster(ctx, name, patch)
+	if err != nil {
+		return nil, err
+	}
+	if updated.Spec.Taints == nil {
+		return []clusterTaint{}, nil
+	}
+	return updated.Spec.Taints, nil
+}
+
+// setTaint adds a taint or replaces the existing taint with the same key
+func setTaint(taints []clusterTaint, taint clusterTaint) []clusterTaint {
+	now := time.Now().UTC().Truncate(time.Second)
+	taint.TimeAdded = &now
+	for i := range taints {
+		if taints[i].Key == taint.Key {
+			taints[i] = taint
+			return taints
+		}
+	}
+	return append(taints, taint)
+}
+
+// removeTaint drops the taint with the given key
+func removeTaint(taints []clusterTaint, key string) []clusterTaint {
+	kept := make([]clusterTaint, 0, len(taints))
+	for _, taint := range taints {
+		if taint.Key != key {
+			kept = append(kept, taint)
+		}
+	}
+	return kept
+}
+
+// respondTaints writes the result of a taint update and logs it as an event
+func (cp *ClusterOpsPlugin) respondTaints(c *gin.Context, name string, taints []clusterTaint, err error, message string) {
+	if err != nil {
+		cp.logEvent(name, "taints", "failed", fmt.Sprintf("Failed to update taints: %v", err))
+		c.JSON(http.StatusBadGateway, errorResponse(codeHubUnreachable, "Failed to update taints on the hub", err.Error()))
+		return
+	}
+
+	cp.logEvent(name, "taints", "success", message)
+	c.JSON(http.StatusOK, gin.H{
+		"message":     message,
+		"clusterName": name,
+		"taints":      taints,
+		"plugin":      "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) SetClusterTaintHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	var taint clusterTaint
+	if err := c.ShouldBindJSON(&taint); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+	if err := validateTaint(taint); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid taint", err.Error()))
+		return
+	}
+
+	taints, err := cp.updateTaints(c.Request.Context(), name, func(taints []clusterTaint) []clusterTaint {
+		return setTaint(taints, taint)
+	})
+	cp.respondTaints(c, name, taints, err, fmt.Sprintf("Taint %s=%s:%s set", taint.Key, taint.Value, taint.Effect))
+}
+
+func (cp *ClusterOpsPlugin) RemoveClusterTaintHandler(c *gin.Context) {
+	name := c.Param("name")
+	key := c.Param("key")
+
+	if strings.HasPrefix(key, protectedTaintPrefix) {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, fmt.Sprintf("Taint %q is managed by the hub", key), nil))
+		return
+	}
+
+	taints, err := cp.updateTaints(c.Request.Context(), name, func(taints []clusterTaint) []clusterTaint {
+		return removeTaint(taints, key)
+	})
+	cp.respondTaints(c, name, taints, err, fmt.Sprintf("Taint %s removed", key))
+}
+
+// CordonClusterHandler stops new workloads from being placed on a cluster
+// while leaving existing placements in place
+func (cp *ClusterOpsPlugin) CordonClusterHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	taints, err := cp.updateTaints(c.Request.Context(), name, func(taints []clusterTaint) []clusterTaint {
+		return setTaint(taints, clusterTaint{Key: cordonTaintKey, Effect: "NoSelectIfNew"})
+	})
+	cp.respondTaints(c, name, taints, err, fmt.Sprintf("Cluster %s cordoned", name))
+}
+
+func (cp *ClusterOpsPlugin) UncordonClusterHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	taints, err := cp.updateTaints(c.Request.Context(), name, func(taints []clusterTaint) []clusterTaint {
+		return removeTaint(taints, cordonTaintKey)
+	})
+	cp.respondTaints(c, name, taints, err, fmt.Sprintf("Cluster %s uncordoned", name))
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	traceBatchSize     = 256
+	traceFlushInterval = 5 * time.Second
+	traceQueueSize     = 4096
+	traceExportTimeout = 10 * time.Second
+
+	// OTLP span kinds and status codes
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+	statusCodeOk     = 1
+	statusCodeError  = 2
+)
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// span is a single timed operation of a trace
+type span struct {
+	tracer     *tracer
+	context    spanContext
+	parentID   [8]byte
+	hasParent  bool
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        string
+}
+
+type activeSpanKey struct{}
+type remoteParentKey struct{}
+
+// tracer records spans and exports them in batches to an OTLP/HTTP endpoint
+// using the JSON encoding
+type tracer struct {
+	serviceName string
+	endpoint    string
+	client      *http.Client
+	queue       chan *span
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// newTracer starts a tracer exporting to the OTLP/HTTP collector at endpoint,
+// e.g. http://otel-collector:4318
+func newTracer(serviceName, endpoint string) *tracer {
+	t := &tracer{
+		serviceName: serviceName,
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client:      &http.Client{Timeout: traceExportTimeout},
+		queue:       make(chan *span, traceQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Shutdown flushes pending spans and stops the exporter
+func (t *tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*span, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			t.export(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends a batch of spans; failures drop the batch since tracing must
+// never affect cluster operations
+func (t *tracer) export(batch []*span) {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": t.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "cluster-ops-plugin"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+// startSpan starts a root span, or a child of the span or remote parent
+// carried by ctx. A nil tracer only continues traces already present in ctx.
+func (t *tracer) startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if parent, ok := ctx.Value(activeSpanKey{}).(*span); ok && parent != nil {
+		return parent.tracer.newSpan(ctx, name, kind, parent.context.traceID, parent.context.spanID, true)
+	}
+	if t == nil {
+		return ctx, nil
+	}
+	if remote, ok := ctx.Value(remoteParentKey{}).(spanContext); ok {
+		return t.newSpan(ctx, name, kind, remote.traceID, remote.spanID, true)
+	}
+
+	var traceID [16]byte
+	rand.Read(traceID[:])
+	return t.newSpan(ctx, name, kind, traceID, [8]byte{}, false)
+}
+
+func (t *tracer) newSpan(ctx context.Context, name string, kind int, traceID [16]byte, parentID [8]byte, hasParent bool) (context.Context, *span) {
+	s := &span{
+		tracer:     t,
+		context:    spanContext{traceID: traceID},
+		parentID:   parentID,
+		hasParent:  hasParent,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	rand.Read(s.context.spanID[:])
+	return context.WithValue(ctx, activeSpanKey{}, s), s
+}
+
+// startChildSpan starts a span only if ctx is already part of a trace
+func startChildSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	var t *tracer
+	return t.startSpan(ctx, name, kind)
+}
+
+// withRemoteParent records the span context of a W3C traceparent header so
+// spans started from ctx join the caller's trace
+func withRemoteParent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return ctx
+	}
+	var sc spanContext
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return ctx
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return ctx
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+// traceparentFromContext returns the W3C traceparent header of the active span
+func traceparentFromContext(ctx context.Context) string {
+	s, ok := ctx.Value(activeSpanKey{}).(*span)
+	if !ok || s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.context.traceID[:]), hex.EncodeToString(s.context.spanID[:]))
+}
+
+// SetAttribute records a string attribute on the span
+func (s *span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// End finishes the span, marking it failed when err is not nil, and queues it
+// for export. Spans are dropped when the export queue is full.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	select {
+	case s.tracer.queue <- s:
+	default:
+	}
+}
+
+func (s *span) otlp() map[string]interface{} {
+	status := map[string]interface{}{"code": statusCodeOk}
+	if s.err != "" {
+		status = map[string]interface{}{"code": statusCodeError, "message": s.err}
+	}
+	out := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.context.traceID[:]),
+		"spanId":            hex.EncodeToString(s.context.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attributes),
+		"status":            status,
+	}
+	if s.hasParent {
+		out["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	return out
+}
+
+func otlpAttributes(attributes map[string]string) []interface{} {
+	out := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		out = append(out, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": value},
+		})
+	}
+	return out
+}
+
+// getTracer returns the tracer of the plugin, or nil when tracing is disabled
+func (cp *ClusterOpsPlugin) getTracer() *tracer {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	return cp.tracer
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVaultKubernetesMount = "kubernetes"
+	serviceAccountTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	vaultRequestTimeout         = 15 * time.Second
+)
+
+// VaultSecretRef locates a kubeconfig in a Vault KV secrets engine
+type VaultSecretRef struct {
+	Mount string `json:"mount"`
+	Path  string `json:"path"`
+	// Key is the field of the secret holding the kubeconfig
+	Key string `json:"key"`
+}
+
+func (ref *VaultSecretRef) validate() error {
+	if ref.Mount == "" || ref.Path == "" || ref.Key == "" {
+		return fmt.Errorf("vaultRef requires mount, path and key")
+	}
+	return nil
+}
+
+// vaultClient reads secrets from Vault over its HTTP API
+type vaultClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// newVaultClient authenticates to the Vault at vault_addr (or VAULT_ADDR).
+// A token from vault_token or VAULT_TOKEN is used as is; otherwise the plugin
+// logs in with the Kubernetes auth method as vault_role using its service
+// account token.
+func (cp *ClusterOpsPlugin) newVaultClient(ctx context.Context) (*vaultClient, error) {
+	settings := cp.settings()
+	addr := settings.VaultAddr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("Vault is not configured: set vault_addr")
+	}
+	vc := &vaultClient{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  settings.VaultToken,
+		client: &http.Client{Timeout: vaultRequestTimeout},
+	}
+	if vc.token == "" {
+		vc.token = os.Getenv("VAULT_TOKEN")
+	}
+	if vc.token != "" {
+		return vc, nil
+	}
+
+	role := settings.VaultRole
+	if role == "" {
+		return nil, fmt.Errorf("Vault authentication is not configured: set vault_token or vault_role")
+	}
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token for Vault login: %v", err)
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	mount := settings.VaultAuthMount
+	body := map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))}
+	if err := vc.do(ctx, http.MethodPost, "/v1/auth/"+mount+"/login", body, &login); err != nil {
+		return nil, fmt.Errorf("Vault login failed: %v", err)
+	}
+	vc.token = login.Auth.ClientToken
+	return vc, nil
+}
+
+func (vc *vaultClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, vc.addr+path, reader)
+	if err != nil {
+		return err
+	}
+	if vc.token != "" {
+		req.Header.Set("X-Vault-Token", vc.token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := vc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("Vault responded with status %d: %s", resp.StatusCode, strings.Join(failure.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ReadKV returns a field of a secret from a KV engine. vault_kv_version
+// selects between the versioned (2, default) and unversioned (1) engines.
+func (vc *vaultClient) ReadKV(ctx context.Context, ref VaultSecretRef, kvVersion int) (string, error) {
+	mount := strings.Trim(ref.Mount, "/")
+	path := strings.Trim(ref.Path, "/")
+
+	var data map[string]interface{}
+	if kvVersion == 1 {
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := vc.do(ctx, http.MethodGet, "/v1/"+mount+"/"+path, nil, &resp); err != nil {
+			return "", err
+		}
+		data = resp.Data
+	} else {
+		var resp struct {
+			Data struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"data"`
+		}
+		if err := vc.do(ctx, http.MethodGet, "/v1/"+mount+"/data/"+path, nil, &resp); err != nil {
+			return "", err
+		}
+		data = resp.Data.Data
+	}
+
+	value, ok := data[ref.Key].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("Vault secret %s/%s has no field %q", mount, path, ref.Key)
+	}
+	return value, nil
+}
+
+// fetchVaultKubeconfig reads a kubeconfig referenced by an onboarding request
+func (cp *ClusterOpsPlugin) fetchVaultKubeconfig(ctx context.Context, ref VaultSecretRef) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, vaultRequestTimeout)
+	defer cancel()
+
+	vc, err := cp.newVaultClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return vc.ReadKV(ctx, ref, cp.settings().VaultKVVersion)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pluginVersion is the release of the plugin, reported in its metadata
+const pluginVersion = "1.1.0"
+
+// gitCommit and buildDate are set by build.sh through -ldflags -X. Builds
+// without them fall back to the VCS stamp of the Go toolchain.
+var (
+	gitCommit string
+	buildDate string
+)
+
+// BuildInfo describes the plugin build and the CLIs it drives
+type BuildInfo struct {
+	Version    string `json:"version"`
+	GitCommit  string `json:"gitCommit,omitempty"`
+	BuildDate  string `json:"buildDate,omitempty"`
+	GoVersion  string `json:"goVersion"`
+	Platform   string `json:"platform"`
+	Kubectl    string `json:"kubectl"`
+	Clusteradm string `json:"clusteradm"`
+}
+
+// buildStamp returns the commit and date of the build
+func buildStamp() (commit, date string) {
+	commit, date = gitCommit, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return commit, date
+	}
+	dirty := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if commit == "" {
+				commit = setting.Value
+			}
+		case "vcs.time":
+			if date == "" {
+				date = setting.Value
+			}
+		case "vcs.modified":
+			dirty = setting.Value == "true"
+		}
+	}
+	if dirty && commit != "" && commit != gitCommit {
+		commit += "-dirty"
+	}
+	return commit, date
+}
+
+// toolVersion returns the detected version of a CLI, "not installed" when it
+// is missing or "unknown" when it does not report one
+func toolVersion(ctx context.Context, check func(ctx context.Context) (string, string, error)) string {
+	version, _, err := check(ctx)
+	switch {
+	case err != nil:
+		return "not installed"
+	case version == "":
+		return "unknown"
+	}
+	return version
+}
+
+func (cp *ClusterOpsPlugin) buildInfo(ctx context.Context) BuildInfo {
+	commit, date := buildStamp()
+	info := BuildInfo{
+		Version:   pluginVersion,
+		GitCommit: commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		info.Kubectl = toolVersion(ctx, cp.checkKubectl)
+	}()
+	go func() {
+		defer wg.Done()
+		info.Clusteradm = toolVersion(ctx, cp.checkClusteradm)
+	}()
+	wg.Wait()
+	return info
+}
+
+func (cp *ClusterOpsPlugin) VersionHandler(c *gin.Context) {
+	info := cp.buildInfo(c.Request.Context())
+	c.JSON(http.StatusOK, gin.H{
+		"version":    info.Version,
+		"gitCommit":  info.GitCommit,
+		"buildDate":  info.BuildDate,
+		"goVersion":  info.GoVersion,
+		"platform":   info.Platform,
+		"kubectl":    info.Kubectl,
+		"clusteradm": info.Clusteradm,
+		"plugin":     "cluster-ops-plugin",
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Bounds of the timeout of GET /status?wait=true
+const (
+	defaultStatusWait = 60 * time.Second
+	maxStatusWait     = 10 * time.Minute
+)
+
+// isTerminalState reports whether a cluster stays in state until someone
+// starts a new operation on it
+func isTerminalState(state ClusterState) bool {
+	switch state {
+	case StateOnboarded, StateFailed, StateDetachmentFailed, StateCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseWaitTimeout reads the timeout of a long-poll request, given as a
+// duration such as 120s or as a number of seconds
+func parseWaitTimeout(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultStatusWait, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, fmt.Errorf("must be a duration such as 120s")
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout <= 0 || timeout > maxStatusWait {
+		return 0, fmt.Errorf("must be positive and at most %s", maxStatusWait)
+	}
+	return timeout, nil
+}
+
+// waitForCluster blocks until a cluster reaches a terminal state, is
+// removed, ctx is done or timeout elapses, and returns its latest record.
+// Events of the cluster wake the wait early; the periodic check covers
+// transitions that log no event and records restored from other replicas.
+func (cp *ClusterOpsPlugin) waitForCluster(ctx context.Context, clusterName string, timeout time.Duration) (ClusterRecord, bool) {
+	_, events, unsubscribe := cp.events.Subscribe(clusterName)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		record, ok := cp.clusters.Get(clusterName)
+		if !ok || isTerminalState(record.State) {
+			return record, ok
+		}
+		select {
+		case <-ctx.Done():
+			return record, ok
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
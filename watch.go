@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// managedClusterWatchEvent is a change to a ManagedCluster reported by the
+// informer of a hub
+type managedClusterWatchEvent struct {
+	Type   string
+	Object managedCluster
+}
+
+// watchManagedClusters keeps the cluster store in sync with a hub through an
+// informer on its ManagedClusters until ctx is cancelled. The informer lists
+// again and resumes watching by itself whenever its watch fails.
+func (cp *ClusterOpsPlugin) watchManagedClusters(ctx context.Context, hub HubConfig) {
+	informer, err := cp.newHubInformer(hub, managedClusterGVR, metav1.NamespaceAll)
+	if err != nil {
+		cp.recordWatchError(hub.Name, err)
+		return
+	}
+	informer.SetWatchErrorHandlerWithContext(func(_ context.Context, _ *cache.Reflector, err error) {
+		cp.recordWatchError(hub.Name, err)
+	})
+	apply := func(eventType string, obj interface{}) {
+		event := managedClusterWatchEvent{Type: eventType}
+		if err := decodeUnstructured(obj, &event.Object); err != nil {
+			cp.logger.Warn("Ignoring undecodable ManagedCluster", "hub", hub.Name, "error", err)
+			return
+		}
+		cp.applyManagedClusterEvent(hub.Name, event)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { apply("ADDED", obj) },
+		UpdateFunc: func(_, obj interface{}) { apply("MODIFIED", obj) },
+		DeleteFunc: func(obj interface{}) { apply("DELETED", obj) },
+	})
+	informer.RunWithContext(ctx)
+}
+
+// applyManagedClusterEvent updates the tracked state of a cluster from a hub
+// watch event. Clusters in the middle of a plugin operation are left to it,
+// and clusters tracked on another hub are ignored.
+func (cp *ClusterOpsPlugin) applyManagedClusterEvent(hub string, event managedClusterWatchEvent) {
+	name := event.Object.Metadata.Name
+	record, tracked := cp.clusters.Get(name)
+	if tracked && record.Hub != "" && record.Hub != hub {
+		return
+	}
+
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		available := event.Object.available()
+		if !tracked {
+			if cp.clusters.Seed(recordFromManagedCluster(&event.Object, hub, "Discovered on the hub")) {
+				cp.logEvent(name, "watch", "success", fmt.Sprintf("Cluster %s discovered on the hub", name))
+			}
+			return
+		}
+		if record.State == StatePending {
+			cp.applyRegistration(hub, name, &event.Object)
+		} else if record.State == StateOnboarded && !available {
+			cp.setClusterState(name, StateUnavailable, "ManagedCluster is no longer available")
+			cp.logEvent(name, "watch", "warning", fmt.Sprintf("Cluster %s became unavailable", name))
+		} else if record.State == StateUnavailable && available {
+			cp.setClusterState(name, StateOnboarded, "ManagedCluster is available again")
+			cp.logEvent(name, "watch", "success", fmt.Sprintf("Cluster %s is available again", name))
+		}
+
+	case "DELETED":
+		if tracked && (record.State == StateOnboarded || record.State == StateUnavailable) {
+			cp.clusters.Delete(name)
+			cp.logEvent(name, "watch", "warning", fmt.Sprintf("ManagedCluster %s was deleted outside of the plugin", name))
+		}
+	}
+}
+
+func (cp *ClusterOpsPlugin) recordWatchError(hub string, err error) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.metrics["hub_watch_error"] = fmt.Sprintf("%s: %v", hub, err)
+	cp.logger.Warn("ManagedCluster watch failed", "hub", hub, "error", err)
+}
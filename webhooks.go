@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultWebhookRetries is used when the retries configuration is unset
+	defaultWebhookRetries = 3
+	webhookTimeout        = 10 * time.Second
+	webhookInitialBackoff = time.Second
+	// maxWebhookDeliveries is the number of deliveries kept per webhook
+	maxWebhookDeliveries = 100
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL notified of lifecycle events
+type Webhook struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"-"`
+	// Events limits the lifecycle event types delivered; empty means all
+	Events    []string  `json:"events,omitempty"`
+	HasSecret bool      `json:"hasSecret"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery tracks the delivery of one lifecycle event to a webhook
+type WebhookDelivery struct {
+	ID            string     `json:"id"`
+	WebhookID     string     `json:"webhookId"`
+	EventType     string     `json:"eventType"`
+	ClusterName   string     `json:"clusterName"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	StatusCode    int        `json:"statusCode,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt,omitempty"`
+}
+
+// WebhookRequest is the body of a webhook registration
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// webhookStore keeps registered webhooks and their recent deliveries
+type webhookStore struct {
+	webhooks   map[string]*Webhook
+	deliveries map[string][]*WebhookDelivery
+	client     *http.Client
+	mutex      sync.RWMutex
+}
+
+func newWebhookStore() *webhookStore {
+	return &webhookStore{
+		webhooks:   make(map[string]*Webhook),
+		deliveries: make(map[string][]*WebhookDelivery),
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func newWebhookID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// Add registers a webhook
+func (s *webhookStore) Add(hook Webhook) Webhook {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hook.ID = newWebhookID("wh-")
+	hook.HasSecret = hook.Secret != ""
+	hook.CreatedAt = time.Now()
+	s.webhooks[hook.ID] = &hook
+	return hook
+}
+
+// List returns all webhooks, oldest first
+func (s *webhookStore) List() []Webhook {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	hooks := make([]Webhook, 0, len(s.webhooks))
+	for _, hook := range s.webhooks {
+		hooks = append(hooks, *hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+// Delete removes a webhook and its delivery history. It returns false if the
+// webhook does not exist.
+func (s *webhookStore) Delete(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return false
+	}
+	delete(s.webhooks, id)
+	delete(s.deliveries, id)
+	return true
+}
+
+// Deliveries returns copies of the recent deliveries of a webhook, newest last
+func (s *webhookStore) Deliveries(id string) ([]WebhookDelivery, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return nil, false
+	}
+	deliveries := make([]WebhookDelivery, 0, len(s.deliveries[id]))
+	for _, delivery := range s.deliveries[id] {
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, true
+}
+
+// Dispatch starts delivering an event to every webhook subscribed to its
+// type, retrying failed attempts up to retries times. It never blocks.
+func (s *webhookStore) Dispatch(event LifecycleEvent, retries int) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, hook := range s.webhooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, event.Type) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			ID:          newWebhookID("dl-"),
+			WebhookID:   hook.ID,
+			EventType:   event.Type,
+			ClusterName: event.ClusterName,
+			Status:      DeliveryPending,
+			CreatedAt:   time.Now(),
+		}
+		deliveries := append(s.deliveries[hook.ID], delivery)
+		if len(deliveries) > maxWebhookDeliveries {
+			deliveries = deliveries[len(deliveries)-maxWebhookDeliveries:]
+		}
+		s.deliveries[hook.ID] = deliveries
+
+		go s.deliver(*hook, delivery, payload, retries)
+	}
+}
+
+// deliver posts a payload to a webhook, backing off exponentially between
+// attempts, and records the outcome on the delivery
+func (s *webhookStore) deliver(hook Webhook, delivery *WebhookDelivery, payload []byte, retries int) {
+	backoff := webhookInitialBackoff
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		statusCode, err := s.post(hook, delivery, payload)
+
+		s.mutex.Lock()
+		now := time.Now()
+		delivery.Attempts++
+		delivery.LastAttemptAt = &now
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		if err == nil {
+			delivery.Status = DeliverySucceeded
+			s.mutex.Unlock()
+			return
+		}
+		delivery.Error = err.Error()
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	delivery.Status = DeliveryFailed
+	s.mutex.Unlock()
+}
+
+func (s *webhookStore) post(hook Webhook, delivery *WebhookDelivery, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cluster-Ops-Event", delivery.EventType)
+	req.Header.Set("X-Cluster-Ops-Delivery", delivery.ID)
+	if hook.Secret != "" {
+		req.Header.Set("X-Cluster-Ops-Signature", "sha256="+signPayload(hook.Secret, payload))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload returns the hex HMAC-SHA256 of a payload, which receivers
+// recompute with the shared secret to authenticate deliveries
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (cp *ClusterOpsPlugin) CreateWebhookHandler(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid JSON payload", err.Error()))
+		return
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid url: must be an absolute http or https URL", nil))
+		return
+	}
+	for _, eventType := range req.Events {
+		if eventType != lifecycleStateChanged && eventType != lifecycleOperationCompleted {
+			c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid events", fmt.Sprintf("unsupported event type %q, expected %s or %s", eventType, lifecycleStateChanged, lifecycleOperationCompleted)))
+			return
+		}
+	}
+
+	hook := cp.webhooks.Add(Webhook{URL: req.URL, Secret: req.Secret, Events: req.Events})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": hook,
+		"plugin":  "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) ListWebhooksHandler(c *gin.Context) {
+	hooks := cp.webhooks.List()
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": hooks,
+		"count":    len(hooks),
+		"plugin":   "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) DeleteWebhookHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	if !cp.webhooks.Delete(id) {
+		c.JSON(http.StatusNotFound, errorResponse(codeNotFound, fmt.Sprintf("Webhook %s not found", id), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Webhook %s deleted", id),
+		"plugin":  "cluster-ops-plugin",
+	})
+}
+
+func (cp *ClusterOpsPlugin) ListWebhookDeliveriesHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	deliveries, ok := cp.webhooks.Deliveries(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse(codeNotFound, fmt.Sprintf("Webhook %s not found", id), nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhookId":  id,
+		"deliveries": deliveries,
+		"count":      len(deliveries),
+		"plugin":     "cluster-ops-plugin",
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	websocketGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketPingInterval = 30 * time.Second
+	websocketWriteTimeout = 10 * time.Second
+	websocketMaxFrameSize = 64 * 1024
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	// Close status codes of RFC 6455
+	wsCloseProtocolError = 1002
+	wsCloseTryAgainLater = 1013
+)
+
+// errUnmaskedFrame reports a client frame sent without a mask, which RFC 6455
+// requires of every client frame
+var errUnmaskedFrame = errors.New("client frame is not masked")
+
+// errHandshakeFailed reports a handshake that failed once the connection was
+// taken over from the HTTP server, when no response can be written any more
+var errHandshakeFailed = errors.New("failed to write handshake")
+
+// wsConn is a minimal server-side WebSocket connection (RFC 6455) that only
+// supports sending unfragmented frames and reading control frames
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+}
+
+// upgradeWebSocket performs the WebSocket handshake on a gin request and
+// takes over the underlying connection
+func upgradeWebSocket(c *gin.Context) (*wsConn, error) {
+	if !strings.EqualFold(c.GetHeader("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(c.GetHeader("Connection")), "upgrade") {
+		return nil, fmt.Errorf("request is not a websocket upgrade")
+	}
+	if c.GetHeader("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("unsupported websocket version %q", c.GetHeader("Sec-WebSocket-Version"))
+	}
+	key := c.GetHeader("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key header")
+	}
+
+	conn, rw, err := c.Writer.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack connection: %v", err)
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %v", errHandshakeFailed, err)
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %v", errHandshakeFailed, err)
+	}
+
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// writeFrame sends a single unmasked frame
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// close sends a close frame with a status code and reason
+func (ws *wsConn) close(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return ws.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// writeJSON sends v as a text frame
+func (ws *wsConn) writeJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(wsOpText, payload)
+}
+
+// readFrame reads a single client frame and unmasks its payload
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errUnmaskedFrame
+	}
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > websocketMaxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds limit", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// readLoop answers pings and closes until the client goes away or breaks the
+// protocol, then closes done
+func (ws *wsConn) readLoop(done chan<- struct{}) {
+	defer close(done)
+	for {
+		opcode, payload, err := ws.readFrame()
+		if errors.Is(err, errUnmaskedFrame) {
+			ws.close(wsCloseProtocolError, err.Error())
+		}
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return
+			}
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, nil)
+			return
+		}
+	}
+}
+
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}
+
+// websocketOriginAllowed reports whether a WebSocket upgrade may proceed.
+// Browsers cannot restrict cross-origin WebSockets themselves, so a request
+// with an Origin must come from the host serving the plugin or from an origin
+// allowed by cors_allowed_origins. Clients other than browsers send no Origin.
+func (cp *ClusterOpsPlugin) websocketOriginAllowed(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, c.Request.Host) {
+		return true
+	}
+	return cp.corsOrigin(origin) != ""
+}
+
+// StreamClusterEventsHandler upgrades the request to a WebSocket, replays the
+// event history of the cluster and then streams new events as they are logged.
+// A client reconnecting with afterId set to the last ID it received resumes
+// without receiving any event twice. A client too slow to keep up misses
+// events; the stream is then closed with status 1013 and a reason naming the
+// afterId to resume from.
+func (cp *ClusterOpsPlugin) StreamClusterEventsHandler(c *gin.Context) {
+	clusterName := c.Param("cluster")
+
+	if !cp.websocketOriginAllowed(c) {
+		c.JSON(http.StatusForbidden, errorResponse(codeForbidden, "WebSocket origin not allowed", c.GetHeader("Origin")))
+		return
+	}
+
+	afterID, err := parseAfterID(c.Query("afterId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "Invalid afterId", err.Error()))
+		return
+	}
+
+	ws, err := upgradeWebSocket(c)
+	if errors.Is(err, errHandshakeFailed) {
+		cp.logger.Warn("WebSocket handshake failed", "cluster", clusterName, "error", err)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(codeInvalidRequest, "WebSocket upgrade failed", err.Error()))
+		return
+	}
+	defer ws.Close()
+
+	history, events, unsubscribe := cp.events.Subscribe(clusterName)
+	defer unsubscribe()
+
+	lastID := afterID
+	for _, event := range eventsAfter(history, afterID) {
+		if err := ws.writeJSON(event); err != nil {
+			return
+		}
+		lastID = event.ID
+	}
+
+	done := make(chan struct{})
+	go ws.readLoop(done)
+
+	ticker := time.NewTicker(websocketPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			// Event IDs of a cluster are consecutive, so a gap means the
+			// subscriber buffer overflowed
+			if lastID > 0 && event.ID > lastID+1 {
+				ws.close(wsCloseTryAgainLater, "events dropped, resume with afterId="+strconv.FormatInt(lastID, 10))
+				return
+			}
+			if err := ws.writeJSON(event); err != nil {
+				return
+			}
+			lastID = event.ID
+		case <-ticker.C:
+			if err := ws.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}